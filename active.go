@@ -0,0 +1,143 @@
+package swig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errCancelledLocally is the cause of a job context cancelled with CancelActiveJob
+var errCancelledLocally = errors.New("job was cancelled on this instance")
+
+// errStopped is the cause of job contexts cancelled because Stop's grace period ran out
+var errStopped = errors.New("swig stopped before the job finished")
+
+// ActiveJob is a job running on this instance, as returned by ActiveJobs
+type ActiveJob struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Queue     string    `json:"queue"`
+	Attempt   int       `json:"attempt"`
+	StartedAt time.Time `json:"started_at"`
+	// WorkerID is the worker goroutine running the job, as listed by /debug/swig/workers.
+	// It's 0 for jobs run by ProcessUntilEmpty, whose goroutines aren't tracked.
+	WorkerID int `json:"worker_id,omitempty"`
+}
+
+// activeJob is a running job and the function that cancels it
+type activeJob struct {
+	ActiveJob
+	cancel context.CancelCauseFunc
+}
+
+// activeJobs tracks the jobs running on this instance. Every job's context is also
+// cancelled when processing is, which Stop does once its grace period runs out.
+type activeJobs struct {
+	mu         sync.Mutex
+	jobs       map[string]*activeJob
+	processing context.Context
+	stop       context.CancelCauseFunc
+}
+
+func newActiveJobs() *activeJobs {
+	processing, stop := context.WithCancelCause(context.Background())
+	return &activeJobs{jobs: make(map[string]*activeJob), processing: processing, stop: stop}
+}
+
+// start records job as running and returns the context to run it with, along with a
+// function to call with Process's error once it returns. That function stops tracking the
+// job and turns the error of a job cancelled with CancelActiveJob into a final failure,
+// and that of one cancelled by Stop into a retryable ErrorCodeShutdown one.
+func (a *activeJobs) start(ctx context.Context, job acquiredJob) (context.Context, func(error) error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stopWatching := context.AfterFunc(a.processing, func() {
+		cancel(context.Cause(a.processing))
+	})
+	workerID, _ := ctx.Value(workerSlotKey{}).(int)
+	a.mu.Lock()
+	a.jobs[job.ID] = &activeJob{
+		ActiveJob: ActiveJob{
+			ID:        job.ID,
+			Kind:      job.Kind,
+			Queue:     job.Queue,
+			Attempt:   job.Attempts,
+			StartedAt: time.Now(),
+			WorkerID:  workerID,
+		},
+		cancel: cancel,
+	}
+	a.mu.Unlock()
+
+	return ctx, func(err error) error {
+		a.mu.Lock()
+		delete(a.jobs, job.ID)
+		a.mu.Unlock()
+
+		stopWatching()
+		if err != nil && errors.Is(context.Cause(ctx), errCancelledLocally) {
+			err = &JobError{Code: ErrorCodeCancelled, Err: fmt.Errorf("%w: %w", errCancelledLocally, err), NoRetry: true}
+		}
+		if err != nil && errors.Is(context.Cause(ctx), errStopped) {
+			err = &JobError{Code: ErrorCodeShutdown, Err: fmt.Errorf("%w: %w", errStopped, err)}
+		}
+		cancel(nil)
+		return err
+	}
+}
+
+// list returns the running jobs, longest running first
+func (a *activeJobs) list() []ActiveJob {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	jobs := make([]ActiveJob, 0, len(a.jobs))
+	for _, job := range a.jobs {
+		jobs = append(jobs, job.ActiveJob)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].StartedAt.Before(jobs[j].StartedAt) })
+	return jobs
+}
+
+// cancel cancels the context of a running job, reporting whether it was running
+func (a *activeJobs) cancel(jobID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	job, ok := a.jobs[jobID]
+	if ok {
+		job.cancel(errCancelledLocally)
+	}
+	return ok
+}
+
+// cancelAll cancels the context of every running job, and of any started from now on,
+// with cause, returning how many were running
+func (a *activeJobs) cancelAll(cause error) int {
+	a.stop(cause)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.jobs)
+}
+
+// ActiveJobs returns the jobs currently running on this instance, longest running first.
+// It reads memory only, so it's cheap enough for a debug page to poll; see DebugHandler.
+func (s *Swig) ActiveJobs() []ActiveJob {
+	return s.active.list()
+}
+
+// CancelActiveJob cancels the context of a job running on this instance, such as a runaway
+// job found with ActiveJobs, and reports whether the job was running here. A job that
+// returns an error once cancelled fails for good with ErrorCodeCancelled rather than being
+// retried. Only the job's context is cancelled, so a worker that ignores it runs to the end.
+//
+// Example:
+//
+//	for _, job := range swig.ActiveJobs() {
+//	    if job.Kind == "rebuild_index" && time.Since(job.StartedAt) > time.Hour {
+//	        swig.CancelActiveJob(job.ID)
+//	    }
+//	}
+func (s *Swig) CancelActiveJob(jobID string) bool {
+	return s.active.cancel(jobID)
+}
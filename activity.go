@@ -0,0 +1,87 @@
+package swig
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// What a worker goroutine is doing
+const (
+	workerAcquiring  = "acquiring"  // Looking for a job
+	workerWaiting    = "waiting"    // Idle, waiting for a notification or the next poll
+	workerProcessing = "processing" // Running a job
+)
+
+// WorkerActivity is a snapshot of one worker goroutine, for debugging
+type WorkerActivity struct {
+	ID    int       `json:"id"`
+	Queue string    `json:"queue"`
+	State string    `json:"state"`
+	JobID string    `json:"job_id,omitempty"`
+	Kind  string    `json:"kind,omitempty"`
+	Since time.Time `json:"since"` // When the worker entered its current state
+}
+
+// workerSlotKey is the context key for the activity slot of the worker running a job
+type workerSlotKey struct{}
+
+// activityTracker records what every worker goroutine is doing
+type activityTracker struct {
+	mu      sync.Mutex
+	nextID  int
+	workers map[int]*WorkerActivity
+}
+
+func newActivityTracker() *activityTracker {
+	return &activityTracker{workers: make(map[int]*WorkerActivity)}
+}
+
+// register adds a worker for queue, returning a context that identifies it to set
+func (t *activityTracker) register(ctx context.Context, queue QueueTypes) (context.Context, func()) {
+	t.mu.Lock()
+	t.nextID++
+	id := t.nextID
+	t.workers[id] = &WorkerActivity{ID: id, Queue: string(queue), State: workerAcquiring, Since: time.Now()}
+	t.mu.Unlock()
+
+	return context.WithValue(ctx, workerSlotKey{}, id), func() {
+		t.mu.Lock()
+		delete(t.workers, id)
+		t.mu.Unlock()
+	}
+}
+
+// set records the state of the worker identified by ctx. Workers that aren't registered,
+// such as those of ProcessUntilEmpty, aren't tracked.
+func (t *activityTracker) set(ctx context.Context, state string, job *acquiredJob) {
+	id, ok := ctx.Value(workerSlotKey{}).(int)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	activity, ok := t.workers[id]
+	if !ok || (activity.State == state && job == nil) {
+		return
+	}
+	activity.State = state
+	activity.Since = time.Now()
+	activity.JobID, activity.Kind = "", ""
+	if job != nil {
+		activity.JobID, activity.Kind = job.ID, job.Kind
+	}
+}
+
+// snapshot returns every worker's activity, ordered by ID
+func (t *activityTracker) snapshot() []WorkerActivity {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	activities := make([]WorkerActivity, 0, len(t.workers))
+	for _, activity := range t.workers {
+		activities = append(activities, *activity)
+	}
+	sort.Slice(activities, func(i, j int) bool { return activities[i].ID < activities[j].ID })
+	return activities
+}
@@ -0,0 +1,597 @@
+package swig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrJobNotFound is returned when a job doesn't exist or isn't in a state the
+// operation applies to
+var ErrJobNotFound = errors.New("job not found")
+
+// Default page size for ListJobs
+const defaultListLimit = 100
+
+// isJobID reports whether id has the form of a job ID, a UUID in any form PostgreSQL
+// accepts. IDs that don't can't belong to a job, so lookups by them report ErrJobNotFound
+// instead of failing in the database.
+func isJobID(id string) bool {
+	id = strings.TrimSuffix(strings.TrimPrefix(id, "{"), "}")
+	id = strings.ReplaceAll(id, "-", "")
+	if len(id) != 32 {
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// JobRecord is a job as stored in swig_jobs
+type JobRecord struct {
+	ID           string          `json:"id"`
+	Kind         string          `json:"kind"`
+	Queue        string          `json:"queue"`
+	Status       string          `json:"status"`
+	Payload      json.RawMessage `json:"payload"`
+	Priority     int             `json:"priority"`
+	Attempts     int             `json:"attempts"`
+	MaxAttempts  int             `json:"max_attempts"`
+	CreatedAt    time.Time       `json:"created_at"`
+	ScheduledFor time.Time       `json:"scheduled_for"`
+	ExpiresAt    *time.Time      `json:"expires_at,omitempty"`
+	Deadline     *time.Time      `json:"deadline,omitempty"`
+	FinishedAt   *time.Time      `json:"finished_at,omitempty"`
+	DeletedAt    *time.Time      `json:"deleted_at,omitempty"`
+	LastError    string          `json:"last_error,omitempty"`
+	// LastErrorCode is the code of the last failure, see CodedError
+	LastErrorCode string `json:"last_error_code,omitempty"`
+	// Metadata is the labels attached when the job was enqueued
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// InstanceName is the name of the instance that last ran the job, see WithInstanceName
+	InstanceName string `json:"instance_name,omitempty"`
+	// LastErrorField is the payload field that failed to decode, when the last failure
+	// was an INVALID_PAYLOAD one caused by a field of the wrong type
+	LastErrorField string `json:"last_error_field,omitempty"`
+	// ShadowOf is the ID of the production job a shadow job mirrors, see WithShadow
+	ShadowOf string `json:"shadow_of,omitempty"`
+	// ShadowMismatch says how a compared shadow job's outcome differed from its production
+	// job's: ShadowMismatchStatus, ShadowMismatchResult, or empty when they agreed
+	ShadowMismatch string `json:"shadow_mismatch,omitempty"`
+	// Result is the output of a completed job, see workers.ResultProvider
+	Result json.RawMessage `json:"result,omitempty"`
+	// Notes are what operators recorded about the job, oldest first, see AnnotateJob
+	Notes []JobNote `json:"notes,omitempty"`
+	// CollapsedCount is how many duplicate pending jobs were collapsed into this one, see
+	// WithDuplicateCompaction
+	CollapsedCount int `json:"collapsed_count,omitempty"`
+	// ClonedFrom is the ID of the job this one is a copy of, see CloneJob
+	ClonedFrom string `json:"cloned_from,omitempty"`
+	// PayloadChanges is how the payload differs from the one the job was cloned from. Only
+	// GetJob fills it in.
+	PayloadChanges []PayloadChange `json:"payload_changes,omitempty"`
+}
+
+// JobNote is a note an operator left on a job
+type JobNote struct {
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// KindSchema is the payload schema of a registered job kind
+type KindSchema struct {
+	Kind   string                 `json:"kind"`
+	Schema map[string]interface{} `json:"schema"`
+}
+
+// JobFilter narrows the jobs returned by ListJobs. Zero fields don't filter.
+type JobFilter struct {
+	Queue    QueueTypes
+	Kind     string
+	Statuses []string
+	// IncludeDeleted includes soft-deleted jobs when Statuses is empty.
+	// They're hidden by default.
+	IncludeDeleted bool
+
+	// Time ranges. After is inclusive and Before exclusive, so consecutive ranges don't overlap.
+	CreatedAfter    time.Time
+	CreatedBefore   time.Time
+	ScheduledAfter  time.Time
+	ScheduledBefore time.Time
+	FinishedAfter   time.Time
+	FinishedBefore  time.Time
+
+	MinAttempts int      // Only jobs that have been attempted at least this many times
+	ErrorCodes  []string // Only jobs whose last failure had one of these codes, see CodedError
+
+	Sort   JobSort // Defaults to SortCreatedDesc
+	Limit  int     // Defaults to 100
+	Offset int
+}
+
+// JobSort orders the jobs returned by ListJobs
+type JobSort string
+
+const (
+	SortCreatedDesc   JobSort = "created_desc" // Newest first
+	SortCreatedAsc    JobSort = "created_asc"
+	SortScheduledAsc  JobSort = "scheduled_asc" // Next to run first
+	SortScheduledDesc JobSort = "scheduled_desc"
+	SortFinishedDesc  JobSort = "finished_desc" // Most recently finished first, unfinished last
+	SortAttemptsDesc  JobSort = "attempts_desc" // Most attempted first
+	SortPriorityDesc  JobSort = "priority_desc"
+	SortDeadlineAsc   JobSort = "deadline_asc" // Nearest deadline first, jobs without one last
+)
+
+// jobSortOrders maps each JobSort to its ORDER BY clause. The ID breaks ties so pages
+// don't overlap.
+var jobSortOrders = map[JobSort]string{
+	SortCreatedDesc:   "created_at DESC, id",
+	SortCreatedAsc:    "created_at, id",
+	SortScheduledAsc:  "scheduled_for, id",
+	SortScheduledDesc: "scheduled_for DESC, id",
+	SortFinishedDesc:  "finished_at DESC NULLS LAST, id",
+	SortAttemptsDesc:  "attempts DESC, created_at DESC, id",
+	SortPriorityDesc:  "priority DESC, created_at DESC, id",
+	SortDeadlineAsc:   "deadline NULLS LAST, id",
+}
+
+// ErrInvalidSort is returned by ListJobs for a JobSort it doesn't know
+var ErrInvalidSort = errors.New("invalid sort")
+
+// jobColumns are the swig_jobs columns scanned into a JobRecord by scanJob
+const jobColumns = `id, kind, queue, status, payload, priority, attempts, max_attempts,
+	created_at, scheduled_for, expires_at, finished_at, deleted_at, last_error, last_error_code, metadata, instance_name,
+	last_error_details->>'field', deadline, shadow_of, shadow_mismatch, result, notes, collapsed_count, cloned_from`
+
+// rowScanner is satisfied by both drivers.Row and drivers.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanJob scans a row selected with jobColumns
+func scanJob(row rowScanner) (*JobRecord, error) {
+	var job JobRecord
+	var payload, metadata []byte
+	var lastError, lastErrorCode, instanceName, lastErrorField, shadowOf, shadowMismatch, clonedFrom *string
+	var result, notes []byte
+	err := row.Scan(&job.ID, &job.Kind, &job.Queue, &job.Status, &payload, &job.Priority,
+		&job.Attempts, &job.MaxAttempts, &job.CreatedAt, &job.ScheduledFor, &job.ExpiresAt,
+		&job.FinishedAt, &job.DeletedAt, &lastError, &lastErrorCode, &metadata, &instanceName,
+		&lastErrorField, &job.Deadline, &shadowOf, &shadowMismatch, &result, &notes, &job.CollapsedCount, &clonedFrom)
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &job.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode metadata of job %s: %w", job.ID, err)
+		}
+	}
+	job.Payload = json.RawMessage(payload)
+	if lastError != nil {
+		job.LastError = *lastError
+	}
+	if lastErrorCode != nil {
+		job.LastErrorCode = *lastErrorCode
+	}
+	if instanceName != nil {
+		job.InstanceName = *instanceName
+	}
+	if lastErrorField != nil {
+		job.LastErrorField = *lastErrorField
+	}
+	if shadowOf != nil {
+		job.ShadowOf = *shadowOf
+	}
+	if shadowMismatch != nil {
+		job.ShadowMismatch = *shadowMismatch
+	}
+	if clonedFrom != nil {
+		job.ClonedFrom = *clonedFrom
+	}
+	if len(result) > 0 {
+		job.Result = json.RawMessage(result)
+	}
+	if len(notes) > 0 {
+		if err := json.Unmarshal(notes, &job.Notes); err != nil {
+			return nil, fmt.Errorf("failed to decode notes of job %s: %w", job.ID, err)
+		}
+	}
+	return &job, nil
+}
+
+// GetJob returns a single job by ID, including soft-deleted jobs. For a job cloned with
+// CloneJob it also lists how its payload differs from the original's.
+func (s *Swig) GetJob(ctx context.Context, jobID string) (*JobRecord, error) {
+	if !isJobID(jobID) {
+		return nil, ErrJobNotFound
+	}
+	row := s.driver.QueryRow(ctx, `SELECT `+jobColumns+` FROM swig_jobs WHERE id = $1`, jobID)
+	job, err := scanJob(row)
+	if isNoRows(err) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	if job.ClonedFrom != "" {
+		if job.PayloadChanges, err = s.payloadChanges(ctx, job); err != nil {
+			return nil, fmt.Errorf("failed to get job: %w", err)
+		}
+	}
+	return job, nil
+}
+
+// ArgsSchemas returns the payload schema of every kind registered with this instance,
+// including aliases, for enqueue UIs and producers that don't share the worker types.
+// See workers.ArgsSchema for how schemas are built.
+func (s *Swig) ArgsSchemas() []KindSchema {
+	var schemas []KindSchema
+	for _, kind := range s.Workers.Kinds() {
+		if schema, ok := s.Workers.ArgsSchema(kind); ok {
+			schemas = append(schemas, KindSchema{Kind: kind, Schema: schema})
+		}
+	}
+	return schemas
+}
+
+// ListJobs returns jobs matching filter, newest first unless filter.Sort says otherwise.
+// Soft-deleted jobs are hidden unless they're asked for by status or with IncludeDeleted.
+//
+// Example:
+//
+//	// Everything that failed more than twice since Friday's deploy
+//	jobs, err := swig.ListJobs(ctx, JobFilter{
+//	    Statuses:     []string{"failed", "retryable"},
+//	    MinAttempts:  3,
+//	    CreatedAfter: deployedAt,
+//	    Sort:         SortAttemptsDesc,
+//	})
+func (s *Swig) ListJobs(ctx context.Context, filter JobFilter) ([]JobRecord, error) {
+	order := jobSortOrders[SortCreatedDesc]
+	if filter.Sort != "" {
+		var ok bool
+		if order, ok = jobSortOrders[filter.Sort]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSort, filter.Sort)
+		}
+	}
+
+	conditions, args := filterConditions(filter)
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	args = append(args, limit, filter.Offset)
+
+	listSQL := fmt.Sprintf(`
+		SELECT %s
+		FROM swig_jobs
+		%s
+		ORDER BY %s
+		LIMIT $%d OFFSET $%d`, jobColumns, where, order, len(args)-1, len(args))
+
+	rows, err := s.driver.Query(ctx, listSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []JobRecord
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, nil
+}
+
+// filterConditions builds the WHERE conditions and their arguments for the job fields of
+// filter, ignoring its sort and paging
+func filterConditions(filter JobFilter) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Queue != "" {
+		addCondition("queue = $%d", string(filter.Queue))
+	}
+	if filter.Kind != "" {
+		addCondition("kind = $%d", filter.Kind)
+	}
+	if len(filter.Statuses) > 0 {
+		addCondition("status = ANY($%d::text[]::swig_job_status[])", knownStatuses(filter.Statuses))
+	} else if !filter.IncludeDeleted {
+		conditions = append(conditions, "status <> 'deleted'")
+	}
+	addRange := func(column string, after, before time.Time) {
+		if !after.IsZero() {
+			addCondition(column+" >= $%d", after)
+		}
+		if !before.IsZero() {
+			addCondition(column+" < $%d", before)
+		}
+	}
+	addRange("created_at", filter.CreatedAfter, filter.CreatedBefore)
+	addRange("scheduled_for", filter.ScheduledAfter, filter.ScheduledBefore)
+	addRange("finished_at", filter.FinishedAfter, filter.FinishedBefore)
+	if filter.MinAttempts > 0 {
+		addCondition("attempts >= $%d", filter.MinAttempts)
+	}
+	if len(filter.ErrorCodes) > 0 {
+		addCondition("last_error_code = ANY($%d)", filter.ErrorCodes)
+	}
+	return conditions, args
+}
+
+// DeleteJob removes a job that isn't currently being processed. With WithSoftDelete the
+// job is only marked as deleted, hidden from processing and ListJobs, and can be brought
+// back with RestoreJob until the undo window passes and the pruner removes it.
+// Returns ErrJobNotFound if there's no such job or it's being processed.
+func (s *Swig) DeleteJob(ctx context.Context, jobID string) error {
+	deleted, err := s.deleteJobs(ctx, []string{jobID}, []string{
+		"pending", "retryable", "scheduled", "completed", "failed", "expired",
+	})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// CancelMany removes the given jobs if they haven't started yet, returning how many were
+// cancelled. Jobs that are processing or already finished are left alone.
+// With WithSoftDelete the jobs can be brought back with RestoreJob during the undo window,
+// which protects against fat-fingered bulk operations.
+func (s *Swig) CancelMany(ctx context.Context, jobIDs []string) (int, error) {
+	if len(jobIDs) == 0 {
+		return 0, nil
+	}
+	return s.deleteJobs(ctx, jobIDs, []string{"pending", "retryable", "scheduled"})
+}
+
+// deleteJobs soft or hard deletes the jobs among jobIDs whose status is one of statuses.
+// IDs that aren't job IDs match nothing.
+func (s *Swig) deleteJobs(ctx context.Context, jobIDs []string, statuses []string) (int, error) {
+	valid := make([]string, 0, len(jobIDs))
+	for _, id := range jobIDs {
+		if isJobID(id) {
+			valid = append(valid, id)
+		}
+	}
+	if len(valid) == 0 {
+		return 0, nil
+	}
+
+	deleteSQL := `
+		DELETE FROM swig_jobs
+		WHERE id = ANY($1::uuid[])
+			AND status = ANY($2::text[]::swig_job_status[])
+		RETURNING id`
+	if s.softDeleteWindow > 0 {
+		deleteSQL = `
+			UPDATE swig_jobs
+			SET status_before_delete = status,
+				status = 'deleted',
+				deleted_at = NOW(),
+				next_retry_at = NULL
+			WHERE id = ANY($1::uuid[])
+				AND status = ANY($2::text[]::swig_job_status[])
+			RETURNING id`
+	}
+
+	rows, err := s.driver.Query(ctx, deleteSQL, valid, statuses)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete jobs: %w", err)
+	}
+	defer rows.Close()
+
+	deleted := 0
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return deleted, fmt.Errorf("failed to scan job ID: %w", err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// RestoreJob undoes a soft delete, putting the job back in the state it was deleted from.
+// Returns ErrJobNotFound if the job isn't soft-deleted or its undo window has passed.
+func (s *Swig) RestoreJob(ctx context.Context, jobID string) error {
+	if !isJobID(jobID) {
+		return ErrJobNotFound
+	}
+	restoreSQL := `
+		UPDATE swig_jobs
+		SET status = status_before_delete,
+			next_retry_at = CASE
+				WHEN status_before_delete = 'retryable' THEN NOW()
+				ELSE NULL
+			END,
+			status_before_delete = NULL,
+			deleted_at = NULL
+		WHERE id = $1
+			AND status = 'deleted'
+			AND status_before_delete IS NOT NULL
+			AND deleted_at > NOW() - (interval '1 second' * $2)
+		RETURNING status, queue, kind`
+
+	var status, queue, kind string
+	err := s.driver.QueryRow(ctx, restoreSQL, jobID, s.softDeleteWindow.Seconds()).Scan(&status, &queue, &kind)
+	if isNoRows(err) {
+		return ErrJobNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore job: %w", err)
+	}
+
+	// Wake a worker for jobs that are ready to run again
+	if status == "pending" {
+		if err := s.notifyJob(ctx, jobID, queue, kind); err != nil {
+			log.Printf("Failed to notify restored job %s: %v", jobID, err)
+		}
+	}
+	return nil
+}
+
+// BumpJob changes the priority of a job that hasn't started yet. With runNow it is also
+// released immediately, skipping any remaining schedule delay or retry backoff, and a worker
+// is notified so it's picked up straight away. Returns ErrJobNotFound if there's no such job
+// or it has already started.
+//
+// Example:
+//
+//	// Support escalated this export
+//	err := swig.BumpJob(ctx, jobID, 100, true)
+func (s *Swig) BumpJob(ctx context.Context, jobID string, newPriority int, runNow bool) error {
+	if !isJobID(jobID) {
+		return ErrJobNotFound
+	}
+	bumpSQL := `
+		UPDATE swig_jobs
+		SET priority = $2,
+			status = CASE WHEN $3 THEN 'pending' ELSE status END,
+			scheduled_for = CASE WHEN $3 THEN NOW() ELSE scheduled_for END,
+			next_retry_at = CASE WHEN $3 THEN NULL ELSE next_retry_at END
+		WHERE id = $1
+			AND status IN ('pending', 'retryable', 'scheduled')
+		RETURNING status, queue, kind, scheduled_for <= NOW()`
+
+	var status, queue, kind string
+	var ready bool
+	err := s.driver.QueryRow(ctx, bumpSQL, jobID, newPriority, runNow).Scan(&status, &queue, &kind, &ready)
+	if isNoRows(err) {
+		return ErrJobNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to bump job: %w", err)
+	}
+
+	// Let a worker reconsider the job now that it outranks or has caught up with others
+	if status == "pending" && ready {
+		if err := s.notifyJob(ctx, jobID, queue, kind); err != nil {
+			log.Printf("Failed to notify bumped job %s: %v", jobID, err)
+		}
+	}
+	return nil
+}
+
+// AnnotateJob records a note on a job, such as what an operator did about it during an
+// incident, so the context travels with the job. Notes are returned with the job by GetJob
+// and ListJobs, and can be added to jobs in any status, including soft-deleted ones.
+// Returns ErrJobNotFound if there's no such job.
+//
+// Example:
+//
+//	err := swig.AnnotateJob(ctx, jobID, "retried after fixing SMTP creds - alice")
+func (s *Swig) AnnotateJob(ctx context.Context, jobID string, note string) error {
+	if strings.TrimSpace(note) == "" {
+		return fmt.Errorf("note must not be empty")
+	}
+	if !isJobID(jobID) {
+		return ErrJobNotFound
+	}
+	annotateSQL := `
+		UPDATE swig_jobs
+		SET notes = notes || jsonb_build_array(jsonb_build_object('note', $2::text, 'created_at', NOW()))
+		WHERE id = $1
+		RETURNING id`
+	var id string
+	err := s.driver.QueryRow(ctx, annotateSQL, jobID, note).Scan(&id)
+	if isNoRows(err) {
+		return ErrJobNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to annotate job: %w", err)
+	}
+	return nil
+}
+
+// pruneDeletedJobs permanently removes soft-deleted jobs whose undo window has passed
+func (s *Swig) pruneDeletedJobs(ctx context.Context) error {
+	pruneSQL := `
+		DELETE FROM swig_jobs
+		WHERE id IN (
+			SELECT id
+			FROM swig_jobs
+			WHERE status = 'deleted'
+				AND deleted_at <= NOW() - (interval '1 second' * $1)
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id`
+
+	rows, err := s.driver.Query(ctx, pruneSQL, s.softDeleteWindow.Seconds(), maintenanceBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to prune deleted jobs: %w", err)
+	}
+	defer rows.Close()
+
+	pruned := 0
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("failed to scan job ID: %w", err)
+		}
+		pruned++
+	}
+
+	addMaintenanceRows(ctx, pruned)
+	if pruned > 0 {
+		log.Printf("Pruned %d deleted jobs past their undo window", pruned)
+	}
+	return nil
+}
+
+// CountErrorCodes counts jobs by the code of their last failure, for dashboards and for
+// deciding what to bulk-retry. Only jobs in the given statuses are counted; by default
+// those that failed or are waiting to be retried.
+func (s *Swig) CountErrorCodes(ctx context.Context, statuses ...string) (map[string]int, error) {
+	if len(statuses) == 0 {
+		statuses = []string{"failed", "retryable"}
+	}
+
+	countSQL := `
+		SELECT last_error_code, COUNT(*)
+		FROM swig_jobs
+		WHERE status = ANY($1::text[]::swig_job_status[])
+			AND last_error_code IS NOT NULL
+		GROUP BY last_error_code`
+
+	rows, err := s.driver.Query(ctx, countSQL, knownStatuses(statuses))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count error codes: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var code string
+		var count int
+		if err := rows.Scan(&code, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan error code count: %w", err)
+		}
+		counts[code] = count
+	}
+	return counts, nil
+}
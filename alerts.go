@@ -0,0 +1,250 @@
+package swig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How often the leader evaluates alert thresholds unless configured otherwise
+const defaultAlertInterval = time.Minute
+
+// How far back failures are counted towards the failure rate unless configured otherwise
+const defaultFailureWindow = 15 * time.Minute
+
+// Alert types reported in Alert.Type
+const (
+	AlertQueueDepth     = "queue_depth"
+	AlertOldestPending  = "oldest_pending"
+	AlertFailureRate    = "failure_rate"
+	AlertMissedDeadline = "missed_deadline"
+)
+
+// AlertThresholds configures when the leader raises alerts. Zero fields are not checked.
+// Thresholds are evaluated per queue.
+type AlertThresholds struct {
+	QueueDepth    int           // Alert when more than this many jobs are ready to run
+	OldestPending time.Duration // Alert when a ready job has waited longer than this
+	FailureRate   float64       // Alert when more than this fraction (0-1) of attempts fail
+	FailureWindow time.Duration // Window the failure rate and missed deadlines are measured over. Defaults to 15 minutes.
+	// MissedDeadlines alerts when any job misses its JobOptions.Deadline, either by
+	// still waiting past it or by finishing after it within FailureWindow
+	MissedDeadlines bool
+	Interval        time.Duration // How often thresholds are evaluated. Defaults to 1 minute.
+}
+
+// Alert describes a threshold that started or stopped being exceeded
+type Alert struct {
+	Type      string     `json:"type"` // One of the Alert* constants
+	Queue     QueueTypes `json:"queue"`
+	Value     float64    `json:"value"`
+	Threshold float64    `json:"threshold"`
+	Resolved  bool       `json:"resolved"` // True once the value is back under the threshold
+	Message   string     `json:"message"`
+	At        time.Time  `json:"at"`
+}
+
+// Notifier delivers alerts, for example to Slack, PagerDuty or a webhook.
+// An alert is sent once when a threshold is first exceeded and once when it resolves,
+// not on every evaluation.
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// NotifierFunc adapts a function to the Notifier interface
+type NotifierFunc func(ctx context.Context, alert Alert) error
+
+// Notify calls f
+func (f NotifierFunc) Notify(ctx context.Context, alert Alert) error {
+	return f(ctx, alert)
+}
+
+// WebhookNotifier POSTs each alert as JSON to URL
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client // Defaults to http.DefaultClient
+}
+
+// Notify sends alert to the webhook
+func (w WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// alerting holds the alert configuration and which alerts are currently firing
+type alerting struct {
+	thresholds AlertThresholds
+	notifier   Notifier
+
+	mu     sync.Mutex
+	firing map[string]bool // Keyed by alert type and queue
+}
+
+// queueStats is a snapshot of a queue used to evaluate alert thresholds
+type queueStats struct {
+	queue         string
+	ready         int
+	oldestPending time.Duration
+	failures      int
+	completions   int
+	// Jobs past their deadline that are still waiting or running, or that finished late
+	// within the failure window
+	missedDeadlines int
+}
+
+// collectQueueStats returns the state of every queue with recent activity
+func (s *Swig) collectQueueStats(ctx context.Context, failureWindow time.Duration) ([]queueStats, error) {
+	statsSQL := `
+		SELECT queue,
+			COUNT(*) FILTER (WHERE status = 'pending' AND scheduled_for <= NOW()),
+			COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(scheduled_for) FILTER (
+				WHERE status = 'pending' AND scheduled_for <= NOW()
+			)), 0)::float8,
+			COUNT(*) FILTER (WHERE last_error_at > NOW() - (interval '1 second' * $1)),
+			COUNT(*) FILTER (WHERE status = 'completed' AND finished_at > NOW() - (interval '1 second' * $1)),
+			COUNT(*) FILTER (
+				WHERE deadline < COALESCE(finished_at, NOW())
+				AND (finished_at IS NULL OR finished_at > NOW() - (interval '1 second' * $1))
+			)
+		FROM swig_jobs
+		WHERE status IN ('pending', 'retryable', 'processing', 'failed', 'completed')
+		GROUP BY queue`
+
+	rows, err := s.driver.Query(ctx, statsSQL, failureWindow.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect queue stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []queueStats
+	for rows.Next() {
+		var st queueStats
+		var oldestSeconds float64
+		if err := rows.Scan(&st.queue, &st.ready, &oldestSeconds, &st.failures, &st.completions, &st.missedDeadlines); err != nil {
+			return nil, fmt.Errorf("failed to scan queue stats: %w", err)
+		}
+		st.oldestPending = time.Duration(oldestSeconds * float64(time.Second))
+		stats = append(stats, st)
+	}
+	return stats, nil
+}
+
+// checkAlerts evaluates the configured thresholds against every queue and notifies
+// about alerts that started firing or resolved since the last evaluation
+func (s *Swig) checkAlerts(ctx context.Context) error {
+	a := s.alerts
+	failureWindow := a.thresholds.FailureWindow
+	if failureWindow <= 0 {
+		failureWindow = defaultFailureWindow
+	}
+
+	stats, err := s.collectQueueStats(ctx, failureWindow)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, st := range stats {
+		queue := QueueTypes(st.queue)
+
+		if a.thresholds.QueueDepth > 0 {
+			s.evaluateAlert(ctx, Alert{
+				Type:      AlertQueueDepth,
+				Queue:     queue,
+				Value:     float64(st.ready),
+				Threshold: float64(a.thresholds.QueueDepth),
+				Message:   fmt.Sprintf("%d jobs waiting in queue %s (threshold %d)", st.ready, st.queue, a.thresholds.QueueDepth),
+				At:        now,
+			})
+		}
+
+		if a.thresholds.OldestPending > 0 {
+			s.evaluateAlert(ctx, Alert{
+				Type:      AlertOldestPending,
+				Queue:     queue,
+				Value:     st.oldestPending.Seconds(),
+				Threshold: a.thresholds.OldestPending.Seconds(),
+				Message: fmt.Sprintf("oldest job in queue %s has waited %s (threshold %s)",
+					st.queue, st.oldestPending.Round(time.Second), a.thresholds.OldestPending),
+				At: now,
+			})
+		}
+
+		if a.thresholds.FailureRate > 0 {
+			rate := 0.0
+			if attempts := st.failures + st.completions; attempts > 0 {
+				rate = float64(st.failures) / float64(attempts)
+			}
+			s.evaluateAlert(ctx, Alert{
+				Type:      AlertFailureRate,
+				Queue:     queue,
+				Value:     rate,
+				Threshold: a.thresholds.FailureRate,
+				Message: fmt.Sprintf("%.1f%% of attempts in queue %s failed over the last %s (threshold %.1f%%)",
+					rate*100, st.queue, failureWindow, a.thresholds.FailureRate*100),
+				At: now,
+			})
+		}
+
+		if a.thresholds.MissedDeadlines {
+			s.evaluateAlert(ctx, Alert{
+				Type:      AlertMissedDeadline,
+				Queue:     queue,
+				Value:     float64(st.missedDeadlines),
+				Threshold: 0,
+				Message: fmt.Sprintf("%d jobs in queue %s missed their deadline over the last %s",
+					st.missedDeadlines, st.queue, failureWindow),
+				At: now,
+			})
+		}
+	}
+	return nil
+}
+
+// evaluateAlert notifies when alert changes between firing and resolved
+func (s *Swig) evaluateAlert(ctx context.Context, alert Alert) {
+	a := s.alerts
+	key := alert.Type + ":" + string(alert.Queue)
+	exceeded := alert.Value > alert.Threshold
+
+	a.mu.Lock()
+	wasFiring := a.firing[key]
+	a.firing[key] = exceeded
+	a.mu.Unlock()
+
+	if exceeded == wasFiring {
+		return
+	}
+	alert.Resolved = !exceeded
+	if err := a.notifier.Notify(ctx, alert); err != nil {
+		log.Printf("Failed to send %s alert for queue %s: %v", alert.Type, alert.Queue, err)
+	}
+}
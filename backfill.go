@@ -0,0 +1,78 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// How many rows EnqueueFromQuery fetches, and enqueues jobs for, at a time
+const backfillBatchSize = 1000
+
+// BackfillFunc builds the job for one row of an EnqueueFromQuery query. It scans the row
+// and returns the worker with its arguments, such as SendEmailWorker{UserID: id}, and the
+// job's options.
+type BackfillFunc func(row drivers.Row) (interface{}, JobOptions, error)
+
+// EnqueueFromQuery enqueues a job for every row query returns, for one-off backfills over
+// millions of rows. The query runs through a server-side cursor and rows are fetched in
+// batches, so only one batch is held in memory; each batch of jobs is inserted in its own
+// transaction, so workers start on the first jobs while later rows are still being read.
+//
+// It returns how many jobs were enqueued. If it fails partway, the batches before the
+// failure stay enqueued, so a query that skips rows already handled, for example by
+// ordering on a key and starting after the last one, can simply be run again.
+//
+// Example:
+//
+//	n, err := swig.EnqueueFromQuery(ctx,
+//	    `SELECT id, email FROM users WHERE created_at < $1 ORDER BY id`,
+//	    func(row drivers.Row) (interface{}, swig.JobOptions, error) {
+//	        var worker SendEmailWorker
+//	        err := row.Scan(&worker.UserID, &worker.To)
+//	        return worker, swig.JobOptions{Queue: swig.Default}, err
+//	    },
+//	    cutoff,
+//	)
+func (s *Swig) EnqueueFromQuery(ctx context.Context, query string, build BackfillFunc, args ...interface{}) (int, error) {
+	enqueued := 0
+	err := s.driver.WithTx(ctx, func(tx drivers.Transaction) error {
+		if err := tx.Exec(ctx, `DECLARE swig_backfill NO SCROLL CURSOR FOR `+query, args...); err != nil {
+			return fmt.Errorf("failed to open backfill query: %w", err)
+		}
+
+		for {
+			rows, err := tx.Query(ctx, fmt.Sprintf(`FETCH %d FROM swig_backfill`, backfillBatchSize))
+			if err != nil {
+				return fmt.Errorf("failed to read backfill rows: %w", err)
+			}
+			jobs := make([]drivers.BatchJob, 0, backfillBatchSize)
+			for rows.Next() {
+				worker, opts, err := build(rows)
+				if err != nil {
+					rows.Close()
+					return fmt.Errorf("failed to build job for row %d: %w", enqueued+len(jobs)+1, err)
+				}
+				if _, ok := worker.(interface{ JobName() string }); !ok {
+					rows.Close()
+					return fmt.Errorf("worker for row %d must implement JobName() string", enqueued+len(jobs)+1)
+				}
+				jobs = append(jobs, drivers.BatchJob{Worker: worker, Opts: opts.driverOptions(s.workerID)})
+			}
+			rows.Close()
+
+			if len(jobs) == 0 {
+				return nil
+			}
+			if err := s.insertJobs(ctx, jobs); err != nil {
+				return fmt.Errorf("failed to enqueue backfill batch after %d jobs: %w", enqueued, err)
+			}
+			enqueued += len(jobs)
+			if len(jobs) < backfillBatchSize {
+				return nil
+			}
+		}
+	})
+	return enqueued, err
+}
@@ -0,0 +1,129 @@
+package swig
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// FetchErrorClass groups the errors a worker can hit looking for and processing jobs by
+// how long it should wait before trying again
+type FetchErrorClass string
+
+const (
+	// FetchConflict is an acquisition that lost out to a concurrent transaction with a
+	// deadlock, serialization failure or lock timeout. Trying again almost always works.
+	FetchConflict FetchErrorClass = "conflict"
+	// FetchUnavailable is the database being unreachable, restarting or out of
+	// connections, which can last a while
+	FetchUnavailable FetchErrorClass = "unavailable"
+	// FetchOther is every other error
+	FetchOther FetchErrorClass = "other"
+)
+
+// FetchBackoff is how long a worker waits after consecutive errors of one class. The wait
+// starts at Initial and doubles with each error up to Max, with jitter so workers that
+// failed together don't retry together. A successful fetch resets it. A zero Initial
+// retries straight away.
+type FetchBackoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// defaultFetchBackoff is the backoff of each class unless WithFetchBackoff changes it
+var defaultFetchBackoff = map[FetchErrorClass]FetchBackoff{
+	FetchConflict:    {Initial: 10 * time.Millisecond, Max: time.Second},
+	FetchUnavailable: {Initial: time.Second, Max: 30 * time.Second},
+	FetchOther:       {Initial: 100 * time.Millisecond, Max: 10 * time.Second},
+}
+
+// SQLSTATEs and SQLSTATE classes of a database that can't serve requests right now
+var unavailableStates = []string{
+	"08",    // connection_exception
+	"53",    // insufficient_resources, including too_many_connections
+	"57P01", // admin_shutdown
+	"57P02", // crash_shutdown
+	"57P03", // cannot_connect_now
+}
+
+// classifyFetchError returns the class of an error from a worker's fetch loop
+func classifyFetchError(err error) FetchErrorClass {
+	if errors.Is(err, errAcquireConflict) || isConflict(err) {
+		return FetchConflict
+	}
+
+	if state := sqlState(err); state != "" {
+		for _, unavailable := range unavailableStates {
+			if strings.HasPrefix(state, unavailable) {
+				return FetchUnavailable
+			}
+		}
+		return FetchOther
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return FetchUnavailable
+	}
+	return FetchOther
+}
+
+// fetchBackoff tracks one worker's consecutive errors of each class
+type fetchBackoff struct {
+	policies map[FetchErrorClass]FetchBackoff
+	failures map[FetchErrorClass]int
+}
+
+func (s *Swig) newFetchBackoff() *fetchBackoff {
+	return &fetchBackoff{policies: s.fetchBackoff, failures: make(map[FetchErrorClass]int)}
+}
+
+// next counts another error of class and returns how long to wait before trying again:
+// the class's current backoff, with up to half of it taken off at random
+func (b *fetchBackoff) next(class FetchErrorClass) time.Duration {
+	policy, ok := b.policies[class]
+	if !ok {
+		policy = defaultFetchBackoff[class]
+	}
+	if policy.Initial <= 0 {
+		return 0
+	}
+	if policy.Max < policy.Initial {
+		policy.Max = policy.Initial
+	}
+
+	delay := policy.Initial
+	for i := 0; i < b.failures[class] && delay < policy.Max; i++ {
+		delay *= 2
+	}
+	delay = min(delay, policy.Max)
+	b.failures[class]++
+
+	half := int64(delay / 2)
+	return delay - time.Duration(rand.Int63n(half+1))
+}
+
+// reset clears the errors counted so far, after a fetch that succeeded
+func (b *fetchBackoff) reset() {
+	clear(b.failures)
+}
+
+// waitBackoff blocks for delay, or until ctx is done or Swig stops
+func (s *Swig) waitBackoff(ctx context.Context, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-s.shutdown:
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,69 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+)
+
+// RequireCapabilities restricts jobs of kind to instances started with all of the given
+// capability labels (see WithCapabilities). The requirement is stored in the database, so
+// every instance honours it regardless of how it was configured. Calling it with no
+// capabilities lifts the requirement.
+//
+// Example:
+//
+//	// Only instances started with WithCapabilities("gpu") run transcodes
+//	err := swig.RequireCapabilities(ctx, "transcode_video", "gpu")
+func (s *Swig) RequireCapabilities(ctx context.Context, kind string, capabilities ...string) error {
+	if len(capabilities) == 0 {
+		if err := s.driver.Exec(ctx, `DELETE FROM swig_kind_requirements WHERE kind = $1`, kind); err != nil {
+			return fmt.Errorf("failed to clear capability requirement for %s: %w", kind, err)
+		}
+		return nil
+	}
+
+	requireSQL := `
+		INSERT INTO swig_kind_requirements (kind, capabilities)
+		VALUES ($1, $2)
+		ON CONFLICT (kind) DO UPDATE SET capabilities = EXCLUDED.capabilities`
+	if err := s.driver.Exec(ctx, requireSQL, kind, capabilities); err != nil {
+		return fmt.Errorf("failed to set capability requirement for %s: %w", kind, err)
+	}
+	return nil
+}
+
+// registerInstance records this instance and its capabilities in swig_instances, where
+// the acquire query checks them against the requirements of each kind, along with the
+// versions the leader compares during rolling upgrades
+func (s *Swig) registerInstance(ctx context.Context) error {
+	capabilities := s.capabilities
+	if capabilities == nil {
+		capabilities = []string{}
+	}
+	registerSQL := `
+		INSERT INTO swig_instances (id, capabilities, region, name, swig_version, schema_version, notify_version, seen_at, queues, deployment_id)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, NOW(), $8, NULLIF($9, ''))
+		ON CONFLICT (id) DO UPDATE SET
+			capabilities = EXCLUDED.capabilities,
+			region = EXCLUDED.region,
+			name = EXCLUDED.name,
+			swig_version = EXCLUDED.swig_version,
+			schema_version = EXCLUDED.schema_version,
+			notify_version = EXCLUDED.notify_version,
+			seen_at = EXCLUDED.seen_at,
+			queues = EXCLUDED.queues,
+			deployment_id = EXCLUDED.deployment_id`
+	if err := s.driver.Exec(ctx, registerSQL, s.workerID, capabilities, s.region, s.instanceName,
+		Version, latestSchemaVersion(), notifyVersion, s.servedQueues(), s.deploymentID); err != nil {
+		return fmt.Errorf("failed to register instance: %w", err)
+	}
+	return nil
+}
+
+// unregisterInstance removes this instance from swig_instances on shutdown
+func (s *Swig) unregisterInstance(ctx context.Context) error {
+	if err := s.driver.Exec(ctx, `DELETE FROM swig_instances WHERE id = $1`, s.workerID); err != nil {
+		return fmt.Errorf("failed to unregister instance: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package swig
+
+import "sync"
+
+// How many recently claimed job IDs an instance remembers
+const claimedCacheSize = 4096
+
+// claimedJobs remembers the IDs of jobs recently claimed, by this instance or, as far as a
+// missed specific acquire tells, by another one. A notification naming one of them can't
+// be acted on, so the worker it wakes skips straight to a general acquire instead of
+// spending an UPDATE on a job that's already gone.
+type claimedJobs struct {
+	mu   sync.Mutex
+	ids  map[string]struct{}
+	ring []string // IDs in the order they were added, overwritten oldest first
+	next int
+}
+
+func newClaimedJobs() *claimedJobs {
+	return &claimedJobs{ids: make(map[string]struct{}), ring: make([]string, claimedCacheSize)}
+}
+
+// add remembers id, forgetting the oldest ID once the cache is full
+func (c *claimedJobs) add(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.ids[id]; ok {
+		return
+	}
+	if oldest := c.ring[c.next]; oldest != "" {
+		delete(c.ids, oldest)
+	}
+	c.ring[c.next] = id
+	c.ids[id] = struct{}{}
+	c.next = (c.next + 1) % len(c.ring)
+}
+
+// contains reports whether id was claimed recently
+func (c *claimedJobs) contains(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.ids[id]
+	return ok
+}
@@ -0,0 +1,81 @@
+// Command swig inspects a database used by Swig. Its doctor subcommand checks the swig_
+// tables, columns, indexes, constraints, triggers and functions against what this version
+// of Swig expects, and prints each difference with the SQL that fixes it:
+//
+//	go run github.com/glamboyosa/swig/cmd/swig doctor -database-url postgres://localhost/app
+//
+// The database URL defaults to $DATABASE_URL. doctor exits with status 1 when the schema
+// differs, so it can gate a deploy.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/glamboyosa/swig"
+	"github.com/glamboyosa/swig/drivers"
+	"github.com/glamboyosa/swig/workers"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("swig: ")
+
+	if len(os.Args) < 2 || os.Args[1] != "doctor" {
+		fmt.Fprintln(os.Stderr, "usage: swig doctor [-database-url url] [-without-triggers]")
+		os.Exit(2)
+	}
+	os.Exit(doctor(os.Args[2:]))
+}
+
+// doctor verifies the schema and returns the exit status
+func doctor(args []string) int {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	databaseURL := flags.String("database-url", os.Getenv("DATABASE_URL"), "database to check; defaults to $DATABASE_URL")
+	withoutTriggers := flags.Bool("without-triggers", false, "the database runs in trigger-less mode, so skip functions and triggers")
+	timeout := flags.Duration("timeout", time.Minute, "how long to wait for the database")
+	flags.Parse(args)
+
+	if *databaseURL == "" {
+		log.Fatal("no database given; set -database-url or DATABASE_URL")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	pool, err := pgxpool.New(ctx, *databaseURL)
+	if err != nil {
+		log.Fatalf("failed to connect: %v", err)
+	}
+	defer pool.Close()
+	driver, err := drivers.NewPgxDriver(pool)
+	if err != nil {
+		log.Fatalf("failed to create driver: %v", err)
+	}
+
+	var opts []swig.Option
+	if *withoutTriggers {
+		opts = append(opts, swig.WithoutTriggers())
+	}
+	client := swig.NewSwig(driver, nil, *workers.NewWorkerRegistry(), opts...)
+	diffs, err := client.VerifySchema(ctx)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+
+	if len(diffs) == 0 {
+		fmt.Printf("Schema matches Swig %s\n", swig.Version)
+		return 0
+	}
+	for _, diff := range diffs {
+		fmt.Printf("%s\n\n", diff)
+	}
+	fmt.Printf("%d differences from the schema Swig %s expects\n", len(diffs), swig.Version)
+	return 1
+}
@@ -0,0 +1,103 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// How often the leader collapses duplicate pending jobs
+const compactionInterval = time.Minute
+
+// compactDuplicates collapses pending jobs that are exact duplicates, the same kind and
+// payload in the same queue for the same tenant, region and concurrency key, into the one
+// due soonest. The job that's kept counts the jobs folded into it in collapsed_count, and
+// takes on the highest priority, the nearest deadline and the latest expiry among them,
+// so collapsing never makes the work run later or expire sooner than it would have.
+// Only jobs that have never started are collapsed; unique jobs and shadow jobs are left
+// alone. Duplicates are locked before they're deleted, so a job a worker is acquiring is
+// never touched, and each pass works in batches until it's caught up.
+func (s *Swig) compactDuplicates(ctx context.Context) error {
+	compactSQL := `
+		WITH candidates AS (
+			SELECT id, kind, queue, payload_hash, tenant, region, concurrency_key, scheduled_for, created_at
+			FROM swig_jobs j
+			WHERE status = 'pending'
+				AND started_at IS NULL
+				AND unique_key IS NULL
+				AND shadow_of IS NULL
+				AND ($1 OR kind = ANY($2))
+				AND EXISTS (
+					SELECT 1 FROM swig_jobs d
+					WHERE d.kind = j.kind
+						AND d.payload_hash = j.payload_hash
+						AND d.queue = j.queue
+						AND d.status = 'pending'
+						AND d.id <> j.id
+				)
+			ORDER BY kind, payload_hash
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		),
+		ranked AS (
+			SELECT id, first_value(id) OVER (
+				PARTITION BY kind, queue, payload_hash, tenant, region, concurrency_key
+				ORDER BY scheduled_for, created_at, id
+			) AS keep_id
+			FROM candidates
+		),
+		removed AS (
+			DELETE FROM swig_jobs
+			USING ranked
+			WHERE swig_jobs.id = ranked.id
+				AND ranked.id <> ranked.keep_id
+			RETURNING swig_jobs.id, ranked.keep_id, swig_jobs.priority, swig_jobs.deadline,
+				swig_jobs.expires_at, swig_jobs.collapsed_count
+		),
+		kept AS (
+			UPDATE swig_jobs
+			SET collapsed_count = swig_jobs.collapsed_count + folded.jobs,
+				priority = GREATEST(swig_jobs.priority, folded.priority),
+				deadline = LEAST(swig_jobs.deadline, folded.deadline),
+				expires_at = CASE
+					WHEN swig_jobs.expires_at IS NULL OR folded.never_expires THEN NULL
+					ELSE GREATEST(swig_jobs.expires_at, folded.expires_at)
+				END
+			FROM (
+				SELECT keep_id, SUM(collapsed_count + 1) AS jobs, MAX(priority) AS priority,
+					MIN(deadline) AS deadline, MAX(expires_at) AS expires_at,
+					bool_or(expires_at IS NULL) AS never_expires
+				FROM removed
+				GROUP BY keep_id
+			) folded
+			WHERE swig_jobs.id = folded.keep_id
+		)
+		SELECT id::text FROM removed`
+
+	kinds := make([]string, 0, len(s.compactKinds))
+	for kind := range s.compactKinds {
+		if kind != "" {
+			kinds = append(kinds, kind)
+		}
+	}
+
+	collapsed := 0
+	for {
+		n, err := s.countRows(ctx, compactSQL, s.compactKinds[""], kinds, maintenanceBatchSize)
+		collapsed += n
+		if err != nil {
+			return fmt.Errorf("failed to collapse duplicate jobs: %w", err)
+		}
+		// A batch that collapsed nothing was all jobs whose duplicates were locked or fell
+		// outside it; they're tried again next pass
+		if n == 0 || ctx.Err() != nil {
+			break
+		}
+	}
+
+	if collapsed > 0 {
+		log.Printf("Collapsed %d duplicate pending jobs", collapsed)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package swig
+
+import (
+	"errors"
+)
+
+// SQLSTATEs of errors caused by concurrent transactions getting in each other's way
+// rather than by anything wrong with the statement, which succeed when simply retried
+var conflictStates = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"55P03": true, // lock_not_available, from a lock_timeout
+}
+
+// errAcquireConflict marks an acquisition that lost out to a concurrent transaction
+var errAcquireConflict = errors.New("acquisition conflict")
+
+// isConflict reports whether err is a deadlock, serialization failure or lock timeout
+func isConflict(err error) bool {
+	return conflictStates[sqlState(err)]
+}
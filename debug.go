@@ -0,0 +1,247 @@
+package swig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// queueDebug is the live state of one queue, served by /debug/swig/queues
+type queueDebug struct {
+	Queue          string  `json:"queue"`
+	Workers        int     `json:"workers"`
+	Processing     int     `json:"processing"`
+	Waiting        int     `json:"waiting"`
+	PendingWakeups int     `json:"pending_wakeups"`          // Notifications handed over but not yet taken by a worker
+	Ready          *int    `json:"ready,omitempty"`          // Pending jobs that are due, from the database
+	OldestPending  *string `json:"oldest_pending,omitempty"` // Wait of the oldest due job, from the database
+	// Jobs waiting or running past their deadline, or finished late in the last 15 minutes
+	MissedDeadlines *int `json:"missed_deadlines,omitempty"`
+}
+
+// instanceDebug is the body of /debug/swig/queues
+type instanceDebug struct {
+	InstanceID    string                 `json:"instance_id"`
+	InstanceName  string                 `json:"instance_name,omitempty"`
+	DeploymentID  string                 `json:"deployment_id,omitempty"`
+	Leader        bool                   `json:"leader"`
+	PollingMode   bool                   `json:"polling_mode"`
+	Listener      drivers.ListenerHealth `json:"listener"`
+	InFlightBytes int64                  `json:"in_flight_bytes"`
+	DatabaseError string                 `json:"database_error,omitempty"`
+	Queues        []queueDebug           `json:"queues"`
+}
+
+// DebugHandler returns handlers for debugging a production instance:
+//
+//   - /metrics serves job counters, worker states and queue depths in the Prometheus text format
+//   - /debug/swig/queues dumps each queue's workers and backlog, the listener's health and leadership
+//   - /debug/swig/workers lists every worker goroutine and the job it's running
+//   - /debug/swig/jobs lists the jobs running on this instance, longest running first
+//
+// The endpoints aren't authenticated, so serve them on an internal port.
+//
+// Example:
+//
+//	go http.ListenAndServe("localhost:9090", swig.DebugHandler())
+func (s *Swig) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", s.serveMetrics)
+	mux.HandleFunc("GET /debug/swig/queues", s.serveQueues)
+	mux.HandleFunc("GET /debug/swig/workers", s.serveWorkers)
+	mux.HandleFunc("GET /debug/swig/jobs", s.serveActiveJobs)
+	return mux
+}
+
+func (s *Swig) serveWorkers(w http.ResponseWriter, r *http.Request) {
+	writeDebugJSON(w, s.activity.snapshot())
+}
+
+func (s *Swig) serveActiveJobs(w http.ResponseWriter, r *http.Request) {
+	writeDebugJSON(w, s.ActiveJobs())
+}
+
+func (s *Swig) serveQueues(w http.ResponseWriter, r *http.Request) {
+	debug := instanceDebug{
+		InstanceID:    s.workerID,
+		InstanceName:  s.instanceName,
+		DeploymentID:  s.deploymentID,
+		Leader:        s.isLeader(),
+		PollingMode:   s.pollingMode.Load(),
+		InFlightBytes: s.inFlightBytes(),
+	}
+	if reporter, ok := s.driver.(drivers.ListenerHealthReporter); ok {
+		debug.Listener = reporter.ListenerHealth()
+	}
+
+	queues := make(map[string]*queueDebug)
+	queue := func(name string) *queueDebug {
+		if _, ok := queues[name]; !ok {
+			queues[name] = &queueDebug{Queue: name}
+		}
+		return queues[name]
+	}
+	for name, ch := range s.jobWake {
+		queue(string(name)).PendingWakeups = len(ch)
+	}
+	for _, activity := range s.activity.snapshot() {
+		q := queue(activity.Queue)
+		q.Workers++
+		switch activity.State {
+		case workerProcessing:
+			q.Processing++
+		case workerWaiting:
+			q.Waiting++
+		}
+	}
+
+	stats, err := s.collectQueueStats(r.Context(), defaultFailureWindow)
+	if err != nil {
+		debug.DatabaseError = err.Error()
+	}
+	for _, st := range stats {
+		ready, oldest, missed := st.ready, st.oldestPending.String(), st.missedDeadlines
+		q := queue(st.queue)
+		q.Ready, q.OldestPending, q.MissedDeadlines = &ready, &oldest, &missed
+	}
+
+	for _, q := range queues {
+		debug.Queues = append(debug.Queues, *q)
+	}
+	sort.Slice(debug.Queues, func(i, j int) bool { return debug.Queues[i].Queue < debug.Queues[j].Queue })
+	writeDebugJSON(w, debug)
+}
+
+func (s *Swig) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	m := s.Metrics()
+	writeCounter(w, "swig_jobs_completed_total", "Jobs completed by this instance.", "kind", m.Completed)
+	writeCounter(w, "swig_jobs_failed_total", "Failed attempts by this instance, including ones that will be retried.", "kind", m.Failed)
+	writeCounter(w, "swig_jobs_slow_total", "Jobs that ran past their expected duration.", "kind", m.SlowJobs)
+	writeCounter(w, "swig_acquire_conflicts_total", "Acquisitions retried after a deadlock, serialization failure or lock timeout.", "queue", m.AcquireConflicts)
+	writeCounter(w, "swig_jobs_reconciled_total", "Jobs found by an idle worker's poll because their notification was lost.", "queue", m.Reconciled)
+
+	states := make(map[string]int64)
+	for _, activity := range s.activity.snapshot() {
+		states[fmt.Sprintf(`queue="%s",state="%s"`, escapeLabel(activity.Queue), activity.State)]++
+	}
+	writeMetricHeader(w, "swig_workers", "Worker goroutines by queue and state.", "gauge")
+	for _, labels := range sortedKeys(states) {
+		fmt.Fprintf(w, "swig_workers{%s} %d\n", labels, states[labels])
+	}
+
+	writeGauge(w, "swig_in_flight_bytes", "Memory budget taken by jobs being processed.", float64(s.inFlightBytes()))
+	writeGauge(w, "swig_leader", "Whether this instance is the leader.", boolGauge(s.isLeader()))
+	if reporter, ok := s.driver.(drivers.ListenerHealthReporter); ok {
+		listener := reporter.ListenerHealth()
+		writeGauge(w, "swig_listener_connected", "Whether the notification listener is connected.", boolGauge(listener.Connected))
+		writeMetricHeader(w, "swig_listener_reconnects_total", "Times the notification listener reconnected.", "counter")
+		fmt.Fprintf(w, "swig_listener_reconnects_total %d\n", listener.Reconnects)
+	}
+
+	stats, err := s.collectQueueStats(r.Context(), defaultFailureWindow)
+	writeGauge(w, "swig_database_up", "Whether the last queue stats query succeeded.", boolGauge(err == nil))
+	if err != nil {
+		log.Printf("Failed to collect queue stats for metrics: %v", err)
+		return
+	}
+	ready := make(map[string]int64, len(stats))
+	missed := make(map[string]int64, len(stats))
+	writeMetricHeader(w, "swig_queue_oldest_pending_seconds", "How long the oldest due job has been waiting.", "gauge")
+	for _, st := range stats {
+		ready[st.queue] = int64(st.ready)
+		missed[st.queue] = int64(st.missedDeadlines)
+		fmt.Fprintf(w, "swig_queue_oldest_pending_seconds{queue=\"%s\"} %g\n", escapeLabel(st.queue), st.oldestPending.Seconds())
+	}
+	writeMetricHeader(w, "swig_queue_ready", "Pending jobs that are due.", "gauge")
+	for _, queue := range sortedKeys(ready) {
+		fmt.Fprintf(w, "swig_queue_ready{queue=\"%s\"} %d\n", escapeLabel(queue), ready[queue])
+	}
+	writeMetricHeader(w, "swig_queue_missed_deadlines", "Jobs past their deadline that are unfinished or finished late in the last 15 minutes.", "gauge")
+	for _, queue := range sortedKeys(missed) {
+		fmt.Fprintf(w, "swig_queue_missed_deadlines{queue=\"%s\"} %d\n", escapeLabel(queue), missed[queue])
+	}
+
+	signals, err := s.ScalingSignals(r.Context())
+	if err != nil {
+		log.Printf("Failed to collect scaling signals for metrics: %v", err)
+		return
+	}
+	gauges := []struct {
+		name, help string
+		value      func(q QueueScalingSignals) float64
+	}{
+		{"swig_queue_growth_rate", "Jobs per second the backlog grew by over the last 5 minutes.", func(q QueueScalingSignals) float64 { return q.GrowthRate }},
+		{"swig_queue_average_wait_seconds", "Average wait of jobs started in the last 5 minutes.", func(q QueueScalingSignals) float64 { return q.AverageWaitSeconds }},
+		{"swig_queue_saturation", "Share of this instance's workers for the queue that are running a job.", func(q QueueScalingSignals) float64 { return q.Saturation }},
+	}
+	for _, gauge := range gauges {
+		writeMetricHeader(w, gauge.name, gauge.help, "gauge")
+		for _, q := range signals.Queues {
+			fmt.Fprintf(w, "%s{queue=\"%s\"} %g\n", gauge.name, escapeLabel(q.Queue), gauge.value(q))
+		}
+	}
+}
+
+// inFlightBytes returns the memory budget taken by jobs being processed
+func (s *Swig) inFlightBytes() int64 {
+	if s.inFlight == nil {
+		return 0
+	}
+	s.inFlight.mu.Lock()
+	defer s.inFlight.mu.Unlock()
+	return s.inFlight.inFlight
+}
+
+func writeMetricHeader(w io.Writer, name, help, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func writeCounter(w io.Writer, name, help, label string, values map[string]int64) {
+	writeMetricHeader(w, name, help, "counter")
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, escapeLabel(key), values[key])
+	}
+}
+
+func writeGauge(w io.Writer, name, help string, value float64) {
+	writeMetricHeader(w, name, help, "gauge")
+	fmt.Fprintf(w, "%s %g\n", name, value)
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeDebugJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(body); err != nil {
+		log.Printf("Failed to write debug response: %v", err)
+	}
+}
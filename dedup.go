@@ -0,0 +1,34 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+)
+
+// Name of the swig_settings row the insert trigger reads its deduplication window from
+const notifyDedupSetting = "notify_dedup_window"
+
+// configureNotifyDedup stores the WithNotifyDedup window where the insert trigger reads
+// it. A zero window turns deduplication off.
+func (s *Swig) configureNotifyDedup(ctx context.Context) error {
+	if s.notifyDedupWindow == nil {
+		return nil
+	}
+	window := *s.notifyDedupWindow
+	if window <= 0 {
+		if err := s.driver.Exec(ctx, `DELETE FROM swig_settings WHERE name = $1`, notifyDedupSetting); err != nil {
+			return fmt.Errorf("failed to turn off notification deduplication: %w", err)
+		}
+		return nil
+	}
+
+	settingSQL := `
+		INSERT INTO swig_settings (name, value)
+		VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value`
+	value := fmt.Sprintf("%f seconds", window.Seconds())
+	if err := s.driver.Exec(ctx, settingSQL, notifyDedupSetting, value); err != nil {
+		return fmt.Errorf("failed to configure notification deduplication: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// How often the leader checks whether a digest period has ended
+const digestCheckInterval = time.Minute
+
+// Digest periods kept in swig_digests once reported, so a new leader knows not to resend
+// them. Older rows are removed as new digests are sent.
+const digestHistory = 10
+
+// Digest summarizes a period of activity, see WithDigest
+type Digest struct {
+	From   time.Time     `json:"from"`
+	To     time.Time     `json:"to"`
+	Kinds  []KindDigest  `json:"kinds"`
+	Queues []QueueDigest `json:"queues"`
+}
+
+// KindDigest summarizes the jobs of one kind that finished or failed during a digest period
+type KindDigest struct {
+	Kind      string `json:"kind"`
+	Completed int    `json:"completed"`
+	// Failed counts jobs whose latest failed attempt was during the period, whether or
+	// not they'll be retried
+	Failed      int           `json:"failed"`
+	FailureRate float64       `json:"failure_rate"` // Failed over Completed plus Failed, 0-1
+	P95Duration time.Duration `json:"p95_duration"` // Of completed jobs' last attempt
+}
+
+// QueueDigest summarizes the backlog of one queue over a digest period
+type QueueDigest struct {
+	Queue    string `json:"queue"`
+	Enqueued int    `json:"enqueued"`
+	Finished int    `json:"finished"`
+	Backlog  int    `json:"backlog"` // Jobs waiting to run when the digest was built
+	// Trend is how much the backlog grew over the period, Enqueued minus Finished.
+	// It's negative when the queue caught up.
+	Trend int `json:"trend"`
+}
+
+// DigestHandler receives each digest, for example to post it to a chat channel
+type DigestHandler func(ctx context.Context, digest Digest)
+
+// String formats the digest for logs and chat messages
+func (d Digest) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Swig digest for %s to %s", d.From.UTC().Format("2006-01-02 15:04"), d.To.UTC().Format("2006-01-02 15:04 MST"))
+	if len(d.Kinds) == 0 && len(d.Queues) == 0 {
+		b.WriteString("\n  no jobs")
+	}
+	for _, kind := range d.Kinds {
+		fmt.Fprintf(&b, "\n  %s: %d completed, %d failed (%.1f%%), p95 %s",
+			kind.Kind, kind.Completed, kind.Failed, kind.FailureRate*100, kind.P95Duration.Round(time.Millisecond))
+	}
+	for _, queue := range d.Queues {
+		fmt.Fprintf(&b, "\n  queue %s: %d enqueued, %d finished, backlog %d (%+d)",
+			queue.Queue, queue.Enqueued, queue.Finished, queue.Backlog, queue.Trend)
+	}
+	return b.String()
+}
+
+// sendDigest builds and sends the digest for the period that ended most recently, unless
+// it was already sent. Periods are aligned to the zero time, so daily digests cover UTC
+// days and weekly ones start on Mondays.
+func (s *Swig) sendDigest(ctx context.Context) error {
+	to := s.dbNow().Truncate(s.digestPeriod)
+	from := to.Add(-s.digestPeriod)
+
+	var sent bool
+	err := s.driver.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM swig_digests WHERE period_start = $1)`, from).Scan(&sent)
+	if err != nil {
+		return fmt.Errorf("failed to check digest history: %w", err)
+	}
+	if sent {
+		return nil
+	}
+
+	digest, err := s.buildDigest(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	// Claim the period so only one leader sends it, even if leadership changes hands
+	claimSQL := `
+		INSERT INTO swig_digests (period_start)
+		VALUES ($1)
+		ON CONFLICT (period_start) DO NOTHING
+		RETURNING period_start`
+	var claimed time.Time
+	err = s.driver.QueryRow(ctx, claimSQL, from).Scan(&claimed)
+	if isNoRows(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record digest: %w", err)
+	}
+	if err := s.driver.Exec(ctx, `DELETE FROM swig_digests WHERE period_start < $1`,
+		from.Add(-digestHistory*s.digestPeriod)); err != nil {
+		log.Printf("Failed to prune digest history: %v", err)
+	}
+
+	log.Print(digest.String())
+	if s.digestHandler != nil {
+		s.digestHandler(ctx, *digest)
+	}
+	return nil
+}
+
+// buildDigest summarizes activity between from and to
+func (s *Swig) buildDigest(ctx context.Context, from, to time.Time) (*Digest, error) {
+	digest := &Digest{From: from, To: to}
+
+	kindSQL := `
+		SELECT kind,
+			COUNT(*) FILTER (WHERE status = 'completed' AND finished_at >= $1 AND finished_at < $2),
+			COUNT(*) FILTER (WHERE last_error_at >= $1 AND last_error_at < $2),
+			COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM finished_at - started_at)) FILTER (
+				WHERE status = 'completed' AND finished_at >= $1 AND finished_at < $2 AND started_at IS NOT NULL
+			), 0)::float8
+		FROM swig_jobs
+		WHERE (finished_at >= $1 AND finished_at < $2)
+			OR (last_error_at >= $1 AND last_error_at < $2)
+		GROUP BY kind
+		ORDER BY kind`
+
+	rows, err := s.driver.Query(ctx, kindSQL, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize kinds: %w", err)
+	}
+	for rows.Next() {
+		var kind KindDigest
+		var p95Seconds float64
+		if err := rows.Scan(&kind.Kind, &kind.Completed, &kind.Failed, &p95Seconds); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan kind summary: %w", err)
+		}
+		if attempts := kind.Completed + kind.Failed; attempts > 0 {
+			kind.FailureRate = float64(kind.Failed) / float64(attempts)
+		}
+		kind.P95Duration = time.Duration(p95Seconds * float64(time.Second))
+		digest.Kinds = append(digest.Kinds, kind)
+	}
+	rows.Close()
+
+	queueSQL := `
+		SELECT queue,
+			COUNT(*) FILTER (WHERE created_at >= $1 AND created_at < $2),
+			COUNT(*) FILTER (WHERE finished_at >= $1 AND finished_at < $2),
+			COUNT(*) FILTER (WHERE status IN ('pending', 'retryable', 'scheduled'))
+		FROM swig_jobs
+		WHERE (created_at >= $1 AND created_at < $2)
+			OR (finished_at >= $1 AND finished_at < $2)
+			OR status IN ('pending', 'retryable', 'scheduled')
+		GROUP BY queue
+		ORDER BY queue`
+
+	rows, err = s.driver.Query(ctx, queueSQL, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize queues: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var queue QueueDigest
+		if err := rows.Scan(&queue.Queue, &queue.Enqueued, &queue.Finished, &queue.Backlog); err != nil {
+			return nil, fmt.Errorf("failed to scan queue summary: %w", err)
+		}
+		queue.Trend = queue.Enqueued - queue.Finished
+		digest.Queues = append(digest.Queues, queue)
+	}
+	return digest, nil
+}
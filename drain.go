@@ -0,0 +1,89 @@
+package swig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// How long a drain worker waits for in-flight capacity before looking for jobs again
+const drainCapacityWait = 100 * time.Millisecond
+
+// ProcessUntilEmpty works through the jobs in queue that are ready to run and returns once
+// there are none left. It uses as many workers as the queue is configured with, and like a
+// regular worker for that queue it picks up priority jobs first. It's meant for batch-style
+// deploys, migrations and CI jobs that enqueue a workload and must wait for it to finish.
+//
+// Jobs that are scheduled for later, waiting out a retry backoff or being processed by
+// another instance aren't waited for. Failed jobs are recorded as usual and don't stop the
+// drain; it only returns an error if jobs can't be acquired or ctx is cancelled.
+//
+// Example:
+//
+//	if err := swig.ProcessUntilEmpty(ctx, swig.Default); err != nil {
+//	    log.Fatal(err)
+//	}
+func (s *Swig) ProcessUntilEmpty(ctx context.Context, queue QueueTypes) error {
+	workerCount := minWorkers
+	for _, config := range s.swigQueueConfig {
+		if config.QueueType == queue && config.MaxWorkers > workerCount {
+			workerCount = config.MaxWorkers
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var drainErr error
+
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.drainQueue(ctx, queue); err != nil {
+				errOnce.Do(func() {
+					drainErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	return drainErr
+}
+
+// drainQueue processes jobs one after another until none are ready
+func (s *Swig) drainQueue(ctx context.Context, queue QueueTypes) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.shutdown:
+			return errors.New("swig is shutting down")
+		default:
+		}
+
+		acquired, err := s.acquireAndProcessJob(ctx, queue, "")
+		if !acquired {
+			if err != nil {
+				return fmt.Errorf("failed to drain queue %s: %w", queue, err)
+			}
+			if _, _, limited := s.capacityFilter(); limited {
+				// Jobs may be waiting for in-flight capacity rather than done
+				time.Sleep(drainCapacityWait)
+				continue
+			}
+			return nil
+		}
+		if err != nil {
+			// The job itself was handed back or recorded; keep going with the rest
+			log.Printf("Error processing job: %v", err)
+		}
+	}
+}
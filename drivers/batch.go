@@ -0,0 +1,369 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/glamboyosa/swig/workers"
+)
+
+// Executor is anything that can execute a statement: a Driver or a Transaction
+type Executor interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) error
+}
+
+// insertColumns are the swig_jobs columns populated on enqueue, in the order of each row's values
+var insertColumns = []string{
+	"id",
+	"kind",
+	"queue",
+	"payload",
+	"priority",
+	"scheduled_for",
+	"expires_at",
+	"payload_version",
+	"preferred_instance_id",
+	"affinity_until",
+	"region",
+	"tenant",
+	"metadata",
+	"deadline",
+	"context_values",
+	"staging_token",
+	"staged_until",
+	"shadow_of",
+	"concurrency_key",
+	"unique_key",
+}
+
+// uniqueConflict is the ON CONFLICT target matching swig_jobs_unique_key_idx: a unique key
+// is taken while a job with it is waiting and hasn't started yet
+const uniqueConflict = `ON CONFLICT (unique_key)
+	WHERE unique_key IS NOT NULL AND status IN ('pending', 'scheduled', 'staged') AND started_at IS NULL`
+
+// conflictUpdates are what each ConflictResolution does to the existing job. Keeping it
+// still updates the row, a no-op, so the statement can return the existing job's ID.
+var conflictUpdates = map[ConflictResolution]string{
+	ConflictKeepExisting:      `unique_key = EXCLUDED.unique_key`,
+	ConflictReplacePayload:    `payload = EXCLUDED.payload, payload_version = EXCLUDED.payload_version`,
+	ConflictRescheduleEarlier: `scheduled_for = LEAST(swig_jobs.scheduled_for, EXCLUDED.scheduled_for)`,
+}
+
+// InsertJobs inserts jobs with a single INSERT ... SELECT FROM unnest through exec, apart
+// from jobs with a UniqueKey, which each get their own. It is the one place job rows are
+// written, shared by the drivers and Swig itself.
+func InsertJobs(ctx context.Context, exec Executor, jobs []BatchJob) error {
+	batched := 0
+	for _, job := range jobs {
+		// Unique jobs go in one by one, as a multi-row insert can't resolve two conflicts
+		// on the same key
+		if job.Opts.UniqueKey == "" {
+			batched++
+			continue
+		}
+		uniqueSQL, uniqueArgs, err := uniqueInsert(job)
+		if err != nil {
+			return err
+		}
+		if err := exec.Exec(ctx, uniqueSQL, uniqueArgs...); err != nil {
+			return err
+		}
+	}
+	if batched == 0 {
+		return nil
+	}
+	return insertJobArrays(ctx, exec, jobs)
+}
+
+// insertJobArrays inserts the jobs without a UniqueKey with an INSERT ... SELECT FROM unnest,
+// binding one array per column. The statement has the same twenty parameters however many
+// jobs there are, so it never runs into PostgreSQL's limit of 65,535 and its plan doesn't
+// grow with the batch, unlike a VALUES list. Scheduling is computed from the database's
+// clock as jobRow does.
+func insertJobArrays(ctx context.Context, exec Executor, jobs []BatchJob) error {
+	var (
+		ids, kinds, queues, payloads, runAts, expiresAts, preferredInstanceIDs []string
+		regions, tenants, metadata, deadlines, contextValues, stagingTokens    []string
+		shadowOfs, concurrencyKeys                                             []string
+		priorities, versions                                                   []int64
+		runIns, affinityTimeouts, stagingTimeouts                              []float64
+	)
+	timestamp := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339Nano)
+	}
+
+	for _, job := range jobs {
+		if job.Opts.UniqueKey != "" {
+			continue
+		}
+		kind, argsJSON, version, err := jobPayload(job)
+		if err != nil {
+			return err
+		}
+		jobMetadata, jobContextValues, err := jobJSON(job)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, job.Opts.ID)
+		kinds = append(kinds, kind)
+		queues = append(queues, job.Opts.Queue)
+		payloads = append(payloads, string(argsJSON))
+		priorities = append(priorities, int64(job.Opts.Priority))
+		runAts = append(runAts, timestamp(job.Opts.RunAt))
+		runIns = append(runIns, job.Opts.RunIn.Seconds())
+		expiresAts = append(expiresAts, timestamp(job.Opts.ExpiresAt))
+		versions = append(versions, int64(version))
+		preferredInstanceIDs = append(preferredInstanceIDs, job.Opts.PreferredInstanceID)
+		affinityTimeouts = append(affinityTimeouts, job.Opts.AffinityTimeout.Seconds())
+		regions = append(regions, job.Opts.Region)
+		tenants = append(tenants, job.Opts.Tenant)
+		metadata = append(metadata, string(jobMetadata))
+		deadlines = append(deadlines, timestamp(job.Opts.Deadline))
+		contextValues = append(contextValues, string(jobContextValues))
+		stagingTokens = append(stagingTokens, job.Opts.StagingToken)
+		stagingTimeouts = append(stagingTimeouts, job.Opts.StagingTimeout.Seconds())
+		shadowOfs = append(shadowOfs, job.Opts.ShadowOf)
+		concurrencyKeys = append(concurrencyKeys, job.Opts.ConcurrencyKey)
+	}
+
+	// Empty strings stand in for NULL, since text arrays can't carry NULLs through every driver
+	arraySQL := fmt.Sprintf(`
+		INSERT INTO swig_jobs (
+			%s,
+			status
+		)
+		SELECT
+			COALESCE(NULLIF(j.id, '')::uuid, gen_random_uuid()),
+			j.kind,
+			j.queue,
+			j.payload::jsonb,
+			j.priority,
+			j.scheduled_for,
+			NULLIF(j.expires_at, '')::timestamptz,
+			j.payload_version,
+			NULLIF(j.preferred_instance_id, '')::uuid,
+			CASE WHEN j.affinity_secs > 0
+				THEN GREATEST(j.scheduled_for, NOW()) + make_interval(secs => j.affinity_secs)
+			END,
+			NULLIF(j.region, ''),
+			NULLIF(j.tenant, ''),
+			j.metadata::jsonb,
+			NULLIF(j.deadline, '')::timestamptz,
+			NULLIF(j.context_values, '')::jsonb,
+			NULLIF(j.staging_token, '')::uuid,
+			CASE WHEN j.staging_token <> ''
+				THEN NOW() + make_interval(secs => j.staging_secs)
+			END,
+			NULLIF(j.shadow_of, '')::uuid,
+			NULLIF(j.concurrency_key, ''),
+			NULL,
+			CASE WHEN j.staging_token <> '' THEN 'staged' ELSE 'pending' END::swig_job_status
+		FROM (
+			SELECT u.*,
+				COALESCE(NULLIF(u.run_at, '')::timestamptz, NOW()) + make_interval(secs => u.run_in) AS scheduled_for
+			FROM unnest(
+				$1::text[], $2::text[], $3::text[], $4::text[], $5::int[], $6::text[], $7::float8[],
+				$8::text[], $9::int[], $10::text[], $11::float8[], $12::text[], $13::text[], $14::text[],
+				$15::text[], $16::text[], $17::text[], $18::float8[], $19::text[], $20::text[]
+			) AS u (id, kind, queue, payload, priority, run_at, run_in, expires_at, payload_version,
+				preferred_instance_id, affinity_secs, region, tenant, metadata, deadline,
+				context_values, staging_token, staging_secs, shadow_of, concurrency_key)
+		) j`, strings.Join(insertColumns, ",\n\t\t\t"))
+
+	return exec.Exec(ctx, arraySQL, ids, kinds, queues, payloads, priorities, runAts, runIns,
+		expiresAts, versions, preferredInstanceIDs, affinityTimeouts, regions, tenants, metadata,
+		deadlines, contextValues, stagingTokens, stagingTimeouts, shadowOfs, concurrencyKeys)
+}
+
+// InsertUniqueJob inserts a job with a UniqueKey through q and returns its ID, or, when a
+// job with the key is already waiting, resolves the conflict as the job's OnConflict says
+// and returns the existing job's ID. inserted reports which happened.
+func InsertUniqueJob(ctx context.Context, q interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) Row
+}, job BatchJob) (id string, inserted bool, err error) {
+	if job.Opts.UniqueKey == "" {
+		return "", false, fmt.Errorf("unique key is required")
+	}
+	uniqueSQL, args, err := uniqueInsert(job)
+	if err != nil {
+		return "", false, err
+	}
+	// xmax is only zero on a row version this statement inserted
+	if err := q.QueryRow(ctx, uniqueSQL+` RETURNING id::text, xmax = 0`, args...).Scan(&id, &inserted); err != nil {
+		return "", false, fmt.Errorf("failed to insert unique job: %w", err)
+	}
+	return id, inserted, nil
+}
+
+// uniqueInsert builds the statement inserting a unique job and resolving a conflict with
+// a waiting job of the same key
+func uniqueInsert(job BatchJob) (string, []interface{}, error) {
+	update, ok := conflictUpdates[job.Opts.OnConflict]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown conflict resolution %d", job.Opts.OnConflict)
+	}
+	var args []interface{}
+	row, err := jobRow(job, func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("%s\n\t\t%s DO UPDATE SET %s", insertSQL([]string{row}), uniqueConflict, update), args, nil
+}
+
+// insertSQL builds the INSERT for rows of values built by jobRow, as unique jobs are inserted
+func insertSQL(values []string) string {
+	return fmt.Sprintf(`
+		INSERT INTO swig_jobs (
+			%s,
+			status
+		) VALUES %s`, strings.Join(insertColumns, ",\n\t\t\t"), strings.Join(values, ","))
+}
+
+// jobRow builds the values of one job's row in insertColumns order, binding them with arg.
+// Scheduling is computed from the database's clock so that every instance agrees on when
+// jobs are due.
+func jobRow(job BatchJob, arg func(value interface{}) string) (string, error) {
+	kind, argsJSON, version, err := jobPayload(job)
+	if err != nil {
+		return "", err
+	}
+
+	var runAt, expiresAt, deadline, preferredInstanceID, region, tenant interface{}
+	if !job.Opts.RunAt.IsZero() {
+		runAt = job.Opts.RunAt
+	}
+	if !job.Opts.ExpiresAt.IsZero() {
+		expiresAt = job.Opts.ExpiresAt
+	}
+	if !job.Opts.Deadline.IsZero() {
+		deadline = job.Opts.Deadline
+	}
+	if job.Opts.PreferredInstanceID != "" {
+		preferredInstanceID = job.Opts.PreferredInstanceID
+	}
+	if job.Opts.Region != "" {
+		region = job.Opts.Region
+	}
+	if job.Opts.Tenant != "" {
+		tenant = job.Opts.Tenant
+	}
+	metadata, encodedContextValues, err := jobJSON(job)
+	if err != nil {
+		return "", err
+	}
+	var contextValues interface{}
+	if encodedContextValues != nil {
+		contextValues = encodedContextValues
+	}
+
+	scheduledFor := fmt.Sprintf("COALESCE(%s::timestamptz, NOW()) + make_interval(secs => %s::double precision)",
+		arg(runAt), arg(job.Opts.RunIn.Seconds()))
+	affinityUntil := "NULL"
+	if job.Opts.AffinityTimeout > 0 {
+		affinityUntil = fmt.Sprintf("GREATEST(%s, NOW()) + make_interval(secs => %s::double precision)",
+			scheduledFor, arg(job.Opts.AffinityTimeout.Seconds()))
+	}
+
+	status, stagingToken, stagedUntil := "'pending'", interface{}(nil), "NULL"
+	if job.Opts.StagingToken != "" {
+		status, stagingToken = "'staged'", job.Opts.StagingToken
+		stagedUntil = fmt.Sprintf("NOW() + make_interval(secs => %s::double precision)", arg(job.Opts.StagingTimeout.Seconds()))
+	}
+
+	var shadowOf, concurrencyKey, uniqueKey interface{}
+	if job.Opts.ConcurrencyKey != "" {
+		concurrencyKey = job.Opts.ConcurrencyKey
+	}
+	if job.Opts.ShadowOf != "" {
+		shadowOf = job.Opts.ShadowOf
+	}
+	if job.Opts.UniqueKey != "" {
+		uniqueKey = job.Opts.UniqueKey
+	}
+
+	id := "DEFAULT"
+	if job.Opts.ID != "" {
+		id = arg(job.Opts.ID)
+	}
+
+	row := []string{
+		id,
+		arg(kind),
+		arg(job.Opts.Queue),
+		arg(argsJSON),
+		arg(job.Opts.Priority),
+		scheduledFor,
+		arg(expiresAt),
+		arg(version),
+		arg(preferredInstanceID),
+		affinityUntil,
+		arg(region),
+		arg(tenant),
+		arg(metadata),
+		arg(deadline),
+		arg(contextValues),
+		arg(stagingToken),
+		stagedUntil,
+		arg(shadowOf),
+		arg(concurrencyKey),
+		arg(uniqueKey),
+	}
+	return fmt.Sprintf("(%s, %s)", strings.Join(row, ", "), status), nil
+}
+
+// jobJSON serializes a job's metadata, {} when it has none, and its context values, nil
+// when it has none
+func jobJSON(job BatchJob) (metadata, contextValues []byte, err error) {
+	if len(job.Opts.ContextValues) > 0 {
+		if contextValues, err = json.Marshal(job.Opts.ContextValues); err != nil {
+			return nil, nil, fmt.Errorf("failed to serialize job context values: %w", err)
+		}
+	}
+	metadata = []byte(`{}`)
+	if len(job.Opts.Metadata) > 0 {
+		if metadata, err = json.Marshal(job.Opts.Metadata); err != nil {
+			return nil, nil, fmt.Errorf("failed to serialize job metadata: %w", err)
+		}
+	}
+	return metadata, contextValues, nil
+}
+
+// jobPayload returns the kind, serialized payload and payload version for a job, either
+// taken as-is from Kind and Payload or derived from Worker
+func jobPayload(job BatchJob) (string, []byte, int, error) {
+	if job.Payload != nil {
+		if job.Kind == "" {
+			return "", nil, 0, fmt.Errorf("kind is required with a raw payload")
+		}
+		if !json.Valid(job.Payload) {
+			return "", nil, 0, fmt.Errorf("payload for %s is not valid JSON", job.Kind)
+		}
+		version := job.PayloadVersion
+		if version <= 0 {
+			version = 1
+		}
+		return job.Kind, job.Payload, version, nil
+	}
+
+	// Type assert to check if it implements Worker interface
+	worker, ok := job.Worker.(interface{ JobName() string })
+	if !ok {
+		return "", nil, 0, fmt.Errorf("worker must implement JobName() string")
+	}
+
+	// Serialize the worker's arguments, leaving out dependencies and runtime state
+	argsJSON, err := workers.MarshalArgs(job.Worker)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("failed to serialize job args: %w", err)
+	}
+	return worker.JobName(), argsJSON, workers.PayloadVersion(job.Worker), nil
+}
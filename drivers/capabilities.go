@@ -0,0 +1,46 @@
+package drivers
+
+// Capabilities describes which PostgreSQL features a driver's database supports, so Swig
+// can adapt to databases that only speak part of the protocol, such as PostgreSQL
+// compatible stores without LISTEN/NOTIFY
+type Capabilities struct {
+	// ListenNotify is whether LISTEN/NOTIFY delivers notifications. Without it workers
+	// poll for jobs.
+	ListenNotify bool
+	// AdvisoryLocks is whether pg_advisory_xact_lock and pg_try_advisory_xact_lock work.
+	// Without them leadership relies on the lease row alone, migrations lock the
+	// migrations table instead, and tenant quotas aren't serialised.
+	AdvisoryLocks bool
+	// SkipLocked is whether SELECT ... FOR UPDATE SKIP LOCKED works. Swig can't
+	// acquire jobs without it.
+	SkipLocked bool
+	// Copy is whether the driver can bulk load rows with COPY FROM STDIN
+	Copy bool
+}
+
+// FullPostgres is what a direct connection to PostgreSQL supports
+var FullPostgres = Capabilities{ListenNotify: true, AdvisoryLocks: true, SkipLocked: true, Copy: true}
+
+// CapabilityReporter is implemented by drivers that can say what their database supports.
+// Drivers that don't implement it are assumed to be connected to PostgreSQL.
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// CapabilitiesOf returns what d's database supports, FullPostgres unless d reports otherwise
+func CapabilitiesOf(d Driver) Capabilities {
+	if reporter, ok := d.(CapabilityReporter); ok {
+		return reporter.Capabilities()
+	}
+	return FullPostgres
+}
+
+// Capabilities reports that pgx connects to PostgreSQL, which supports everything
+func (d *PgxDriver) Capabilities() Capabilities {
+	return FullPostgres
+}
+
+// Capabilities reports that lib/pq connects to PostgreSQL, which supports everything
+func (d *SQLDriver) Capabilities() Capabilities {
+	return FullPostgres
+}
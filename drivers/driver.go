@@ -3,6 +3,7 @@ package drivers
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -64,15 +65,57 @@ type Notification struct {
 	Payload string
 }
 
-// BatchJob represents a job to be inserted in a batch operation
+// BatchJob represents a job to be inserted in a batch operation.
+// Either Worker is set and serialized as the payload, or Kind and Payload are set
+// for callers that already hold a serialized payload.
 type BatchJob struct {
-	Worker interface{}
-	Opts   JobOptions
+	Worker  interface{}
+	Opts    JobOptions
+	Kind    string          // Job name, used with Payload
+	Payload json.RawMessage // Pre-serialized JSON payload, inserted as-is
+	// Version of a pre-serialized Payload, defaults to 1. Payloads serialized from
+	// Worker take their version from its PayloadVersion method when it has one.
+	PayloadVersion int
 }
 
 // JobOptions represents options for a job
 type JobOptions struct {
+	ID       string // ID for the job; the database generates one when empty
 	Queue    string
 	Priority int
-	RunAt    time.Time
+	// The job becomes due at RunAt plus RunIn. A zero RunAt means the database's current
+	// time, so delays given with RunIn aren't thrown off by a skewed application clock.
+	RunAt     time.Time
+	RunIn     time.Duration
+	ExpiresAt time.Time // Zero means the job never expires
+	Deadline  time.Time // When the job should have finished by; zero for no deadline
+	// ConcurrencyKey names the concurrency limit the job counts against, empty for its kind
+	ConcurrencyKey string
+	// PreferredInstanceID is the Swig instance the job should run on, empty for any.
+	// Other instances can take it AffinityTimeout after it's due; zero means never.
+	PreferredInstanceID string
+	AffinityTimeout     time.Duration
+	Region              string            // Only instances in this region may run the job, empty for any
+	Tenant              string            // Customer the job is for, counted against their quota
+	Metadata            map[string]string // Free-form labels stored with the job, such as trace IDs
+	ContextValues       map[string]string // Values from the enqueuing context, restored into the Process context
+	// StagingToken inserts the job as staged, held back until the jobs with the token are
+	// released. Staged jobs that aren't released within StagingTimeout expire.
+	StagingToken   string
+	StagingTimeout time.Duration
+	ShadowOf       string // ID of the production job this job shadows, see swig.WithShadow
+	// UniqueKey makes the job unique among jobs that haven't started yet. Enqueueing it
+	// while one with the same key is waiting resolves the conflict as OnConflict says.
+	UniqueKey  string
+	OnConflict ConflictResolution
 }
+
+// ConflictResolution decides what enqueueing a unique job does when a job with the same
+// key is already waiting to start
+type ConflictResolution int
+
+const (
+	ConflictKeepExisting      ConflictResolution = iota // Leave the existing job as it is
+	ConflictReplacePayload                              // Give the existing job the new job's payload
+	ConflictRescheduleEarlier                           // Run the existing job at the new job's time if that's sooner
+)
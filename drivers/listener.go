@@ -0,0 +1,88 @@
+package drivers
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotificationsMissed is returned by WaitForNotification after the listener reconnected.
+// Notifications sent while it was disconnected are lost, so callers should check for work
+// rather than wait for the next notification.
+var ErrNotificationsMissed = errors.New("listener reconnected, notifications may have been missed")
+
+// ListenerHealth describes the state of a driver's notification listener
+type ListenerHealth struct {
+	Connected   bool
+	Channels    []string  // Channels the listener is subscribed to
+	ConnectedAt time.Time // When the current connection was established
+	Reconnects  int       // Times the listener had to reconnect after losing its connection
+	LastError   string    // Most recent listener error, if any
+	LastErrorAt time.Time
+}
+
+// ListenerHealthReporter is implemented by drivers that can report on their listener
+type ListenerHealthReporter interface {
+	ListenerHealth() ListenerHealth
+}
+
+// listenerState tracks the channels a listener must be subscribed to and its health.
+// It is shared by the driver implementations.
+type listenerState struct {
+	mu       sync.Mutex
+	channels []string
+	health   ListenerHealth
+}
+
+// addChannel records a channel, reporting whether it is new
+func (l *listenerState) addChannel(channel string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, c := range l.channels {
+		if c == channel {
+			return false
+		}
+	}
+	l.channels = append(l.channels, channel)
+	return true
+}
+
+// subscribed returns a copy of the channels to listen on
+func (l *listenerState) subscribed() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.channels...)
+}
+
+// setConnected records a (re)connection, reporting whether it replaced a lost connection
+func (l *listenerState) setConnected() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	reconnected := !l.health.ConnectedAt.IsZero()
+	if reconnected {
+		l.health.Reconnects++
+	}
+	l.health.Connected = true
+	l.health.ConnectedAt = time.Now()
+	return reconnected
+}
+
+// setDisconnected records the loss of the connection
+func (l *listenerState) setDisconnected(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.health.Connected = false
+	if err != nil {
+		l.health.LastError = err.Error()
+		l.health.LastErrorAt = time.Now()
+	}
+}
+
+// snapshot returns the current health
+func (l *listenerState) snapshot() ListenerHealth {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	health := l.health
+	health.Channels = append([]string(nil), l.channels...)
+	return health
+}
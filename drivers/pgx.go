@@ -2,17 +2,30 @@ package drivers
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
+	"sync"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// ListenerConfigFunc returns the connection config for a pgx driver's listener. It's called
+// each time the listener connects, so auth tokens that expire can be fetched fresh.
+type ListenerConfigFunc func(ctx context.Context) (*pgx.ConnConfig, error)
+
 type PgxDriver struct {
 	pool *pgxpool.Pool
+	// listenerConfig connects the listener on its own rather than with a connection taken
+	// from the pool, nil to use the pool
+	listenerConfig ListenerConfigFunc
+
+	// LISTEN is tied to a session, so notifications are received on a dedicated
+	// connection taken out of the pool rather than on whichever pooled connection is free
+	listenMu   sync.Mutex // Guards listenConn
+	waitMu     sync.Mutex // Held while waiting on listenConn, so Close doesn't race a wait
+	listenConn *pgx.Conn
+	listener   listenerState
 }
 
 type pgxTxAdapter struct {
@@ -78,6 +91,35 @@ func NewPgxDriver(pool interface{}) (Driver, error) {
 	return nil, errors.New("invalid pool type")
 }
 
+// NewPgxDriverWithListener creates a pgx driver whose listener connects with the config
+// from listenerConfig instead of taking a connection from the pool. Use it when the
+// listener needs credentials or TLS settings of its own, such as an IAM auth token that's
+// refreshed on every connection, a different host that supports session-mode LISTEN, or a
+// custom DialFunc. listenerConfig is called each time the listener connects or reconnects.
+// The config must come from pgx.ParseConfig; the driver connects with a copy of it.
+//
+// Example:
+//
+//	base, _ := pgx.ParseConfig("postgres://swig_listener@db.internal:5432/myapp?sslmode=verify-full")
+//	driver, err := NewPgxDriverWithListener(pool, func(ctx context.Context) (*pgx.ConnConfig, error) {
+//	    token, err := auth.BuildAuthToken(ctx, endpoint, region, "swig_listener", creds)
+//	    if err != nil {
+//	        return nil, err
+//	    }
+//	    config := base.Copy()
+//	    config.Password = token
+//	    return config, nil
+//	})
+func NewPgxDriverWithListener(pool *pgxpool.Pool, listenerConfig ListenerConfigFunc) (Driver, error) {
+	if pool == nil {
+		return nil, errors.New("nil pool")
+	}
+	if listenerConfig == nil {
+		return nil, errors.New("nil listener config function")
+	}
+	return &PgxDriver{pool: pool, listenerConfig: listenerConfig}, nil
+}
+
 func (d *PgxDriver) WithTx(ctx context.Context, fn func(tx Transaction) error) error {
 	pgxTx, err := d.pool.Begin(ctx)
 	if err != nil {
@@ -108,11 +150,73 @@ func (d *PgxDriver) QueryRow(ctx context.Context, sql string, args ...interface{
 	return d.pool.QueryRow(ctx, sql, args...)
 }
 
+// Listen subscribes the driver's listener connection to channel. The subscription is
+// restored automatically whenever the listener has to reconnect.
 func (d *PgxDriver) Listen(ctx context.Context, channel string) error {
-	_, err := d.pool.Exec(ctx, "LISTEN "+channel)
+	if !d.listener.addChannel(channel) {
+		return nil
+	}
+
+	d.listenMu.Lock()
+	defer d.listenMu.Unlock()
+	if d.listenConn == nil || d.listenConn.IsClosed() {
+		// Subscribed when the connection is (re)established
+		_, err := d.connectListener(ctx)
+		return err
+	}
+	_, err := d.listenConn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize())
 	return err
 }
 
+// connectListener establishes the listener connection and subscribes it to every channel.
+// It reports whether this replaced a lost connection. Callers must hold listenMu.
+func (d *PgxDriver) connectListener(ctx context.Context) (bool, error) {
+	if d.listenConn != nil {
+		d.listenConn.Close(ctx)
+		d.listenConn = nil
+	}
+
+	conn, err := d.openListenerConn(ctx)
+	if err != nil {
+		d.listener.setDisconnected(err)
+		return false, err
+	}
+
+	for _, channel := range d.listener.subscribed() {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			conn.Close(ctx)
+			d.listener.setDisconnected(err)
+			return false, fmt.Errorf("failed to listen on %s: %w", channel, err)
+		}
+	}
+
+	d.listenConn = conn
+	return d.listener.setConnected(), nil
+}
+
+// openListenerConn opens the connection for the listener, with the listener's own config
+// if it has one
+func (d *PgxDriver) openListenerConn(ctx context.Context) (*pgx.Conn, error) {
+	if d.listenerConfig != nil {
+		config, err := d.listenerConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get listener config: %w", err)
+		}
+		conn, err := pgx.ConnectConfig(ctx, config.Copy())
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect listener: %w", err)
+		}
+		return conn, nil
+	}
+
+	pooled, err := d.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire listener connection: %w", err)
+	}
+	// Take the connection out of the pool so it isn't handed to anyone else
+	return pooled.Hijack(), nil
+}
+
 func (d *PgxDriver) Notify(ctx context.Context, channel string, payload string) error {
 	_, err := d.pool.Exec(ctx, "SELECT pg_notify($1, $2)", channel, payload)
 	return err
@@ -126,17 +230,41 @@ func (d *PgxDriver) AddJobWithTx(ctx context.Context, tx interface{}) (Transacti
 	return nil, errors.New("invalid transaction type: expected pgx.Tx")
 }
 
-// WaitForNotification waits for a notification on any channel this connection is listening on
+// WaitForNotification waits for a notification on any channel the driver is listening on.
+// If the listener connection was lost it is re-established and resubscribed first, and
+// ErrNotificationsMissed is returned so the caller knows to check for work it missed.
 func (d *PgxDriver) WaitForNotification(ctx context.Context) (*Notification, error) {
-	conn, err := d.pool.Acquire(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	d.waitMu.Lock()
+	defer d.waitMu.Unlock()
+
+	d.listenMu.Lock()
+	conn := d.listenConn
+	if conn == nil || conn.IsClosed() {
+		reconnected, err := d.connectListener(ctx)
+		d.listenMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if reconnected {
+			return nil, ErrNotificationsMissed
+		}
+		d.listenMu.Lock()
+		conn = d.listenConn
 	}
-	defer conn.Release()
+	d.listenMu.Unlock()
 
 	// Wait for notification
-	pgxNotification, err := conn.Conn().WaitForNotification(ctx)
+	pgxNotification, err := conn.WaitForNotification(ctx)
 	if err != nil {
+		if ctx.Err() == nil {
+			d.listenMu.Lock()
+			if d.listenConn == conn {
+				conn.Close(context.Background())
+				d.listenConn = nil
+			}
+			d.listenMu.Unlock()
+			d.listener.setDisconnected(err)
+		}
 		return nil, fmt.Errorf("wait for notification error: %w", err)
 	}
 
@@ -146,7 +274,42 @@ func (d *PgxDriver) WaitForNotification(ctx context.Context) (*Notification, err
 	}, nil
 }
 
-// AddJobsWithTx adds multiple jobs as part of an existing transaction
+// DetectTransactionPooler reports whether the pool connects through a transaction-mode pooler
+func (d *PgxDriver) DetectTransactionPooler(ctx context.Context) (bool, error) {
+	conn, err := d.pool.Acquire(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	return probeSession(ctx, func(ctx context.Context, sql string, args ...interface{}) Row {
+		return conn.QueryRow(ctx, sql, args...)
+	})
+}
+
+// ListenerHealth reports the state of the listener connection
+func (d *PgxDriver) ListenerHealth() ListenerHealth {
+	return d.listener.snapshot()
+}
+
+// Close releases the listener connection once any pending wait has returned. The pool
+// passed to NewPgxDriver belongs to the caller and is left open.
+func (d *PgxDriver) Close() error {
+	d.waitMu.Lock()
+	defer d.waitMu.Unlock()
+	d.listenMu.Lock()
+	defer d.listenMu.Unlock()
+	if d.listenConn == nil {
+		return nil
+	}
+	err := d.listenConn.Close(context.Background())
+	d.listenConn = nil
+	d.listener.setDisconnected(nil)
+	return err
+}
+
+// AddJobsWithTx adds multiple jobs as part of an existing transaction, with a single
+// statement however large the batch
 func (d *PgxDriver) AddJobsWithTx(ctx context.Context, tx interface{}, jobs []BatchJob) error {
 	if len(jobs) == 0 {
 		return nil
@@ -158,48 +321,5 @@ func (d *PgxDriver) AddJobsWithTx(ctx context.Context, tx interface{}, jobs []Ba
 		return fmt.Errorf("invalid transaction for driver: %w", err)
 	}
 
-	// Build the values clause and args
-	var values []string
-	var args []interface{}
-	argCount := 1
-
-	for _, job := range jobs {
-		// Type assert to check if it implements Worker interface
-		if _, ok := job.Worker.(interface{ JobName() string }); !ok {
-			return fmt.Errorf("worker must implement JobName() string")
-		}
-
-		// Serialize the worker
-		argsJSON, err := json.Marshal(job.Worker)
-		if err != nil {
-			return fmt.Errorf("failed to serialize job args: %w", err)
-		}
-
-		// Add values for this job
-		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, 'pending')",
-			argCount, argCount+1, argCount+2, argCount+3, argCount+4))
-
-		args = append(args,
-			job.Worker.(interface{ JobName() string }).JobName(),
-			string(job.Opts.Queue),
-			argsJSON,
-			job.Opts.Priority,
-			job.Opts.RunAt,
-		)
-		argCount += 5
-	}
-
-	// Build and execute the insert query
-	insertSQL := fmt.Sprintf(`
-		INSERT INTO swig_jobs (
-			kind,
-			queue,
-			payload,
-			priority,
-			scheduled_for,
-			status
-		) VALUES %s
-	`, strings.Join(values, ","))
-
-	return txAdapter.Exec(ctx, insertSQL, args...)
+	return InsertJobs(ctx, txAdapter, jobs)
 }
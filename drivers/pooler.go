@@ -0,0 +1,58 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/glamboyosa/swig/pkg"
+)
+
+// How many statements the pooler probe runs on one client connection
+const poolerProbeRounds = 5
+
+// PoolerDetector is implemented by drivers that can tell whether they're connected through
+// a transaction-mode pooler such as PgBouncer. Behind one, consecutive statements on the
+// same client connection can run on different server sessions, so LISTEN, session-level
+// advisory locks and session settings don't behave as expected.
+type PoolerDetector interface {
+	DetectTransactionPooler(ctx context.Context) (bool, error)
+}
+
+// queryRowFunc runs a single-row query on one client connection
+type queryRowFunc func(ctx context.Context, sql string, args ...interface{}) Row
+
+// probeSession checks whether statements on a single client connection share a server
+// session. It sets a session-level setting and watches the backend PID; with a transaction
+// pooler in between, the setting disappears or the PID changes. A pooler that happens to
+// hand back the same server session every time can't be told apart from a direct connection.
+func probeSession(ctx context.Context, queryRow queryRowFunc) (bool, error) {
+	token := pkg.GenerateWorkerID()
+
+	var firstPID int
+	if err := queryRow(ctx, `SELECT pg_backend_pid()`).Scan(&firstPID); err != nil {
+		return false, fmt.Errorf("failed to read backend pid: %w", err)
+	}
+	var set string
+	if err := queryRow(ctx, `SELECT set_config('swig.session_probe', $1, false)`, token).Scan(&set); err != nil {
+		return false, fmt.Errorf("failed to set session probe: %w", err)
+	}
+
+	for i := 0; i < poolerProbeRounds; i++ {
+		var pid int
+		var value *string
+		err := queryRow(ctx, `SELECT pg_backend_pid(), current_setting('swig.session_probe', true)`).Scan(&pid, &value)
+		if err != nil {
+			return false, fmt.Errorf("failed to probe session: %w", err)
+		}
+		if pid != firstPID || value == nil || *value != token {
+			return true, nil
+		}
+	}
+
+	// Don't leave the probe behind on a connection that goes back into the pool
+	var reset string
+	if err := queryRow(ctx, `SELECT set_config('swig.session_probe', '', false)`).Scan(&reset); err != nil {
+		return false, fmt.Errorf("failed to reset session probe: %w", err)
+	}
+	return false, nil
+}
@@ -3,19 +3,41 @@ package drivers
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/lib/pq"
 )
 
+// How long a pq.Listener waits between reconnection attempts, backing off from min to max
+const (
+	listenerMinReconnect = 10 * time.Second
+	listenerMaxReconnect = time.Minute
+)
+
+// DSNFunc returns the connection string for the notification listener. It's called each
+// time the listener is built, so credentials can come from a secret store or an IAM token
+// provider and change between connections.
+type DSNFunc func(ctx context.Context) (string, error)
+
 type SQLDriver struct {
-	db      *sql.DB
-	connStr string
+	db          *sql.DB
+	listenerDSN DSNFunc
+	// With a DSNFunc, a failed connection attempt rebuilds the listener with a fresh
+	// connection string rather than retrying with the old one, which may hold an expired
+	// token. rotate is signalled by the failure and consumed by WaitForNotification.
+	rotating bool
+	rotate   chan struct{}
+
+	// LISTEN is tied to a session, so notifications are received through a pq.Listener
+	// with its own connection, which reconnects and resubscribes by itself
+	listenMu   sync.Mutex // Guards pqListener and builtAt
+	pqListener *pq.Listener
+	builtAt    time.Time
+	listener   listenerState
 }
 
 type sqlTxAdapter struct {
@@ -39,12 +61,12 @@ func (r *sqlRowsAdapter) Close() error {
 }
 
 func (tx *sqlTxAdapter) Exec(ctx context.Context, sql string, args ...interface{}) error {
-	_, err := tx.tx.ExecContext(ctx, sql, args...)
+	_, err := tx.tx.ExecContext(ctx, sql, convertArgs(args)...)
 	return err
 }
 
 func (tx *sqlTxAdapter) Query(ctx context.Context, sql string, args ...interface{}) (Rows, error) {
-	rows, err := tx.tx.QueryContext(ctx, sql, args...)
+	rows, err := tx.tx.QueryContext(ctx, sql, convertArgs(args)...)
 	if err != nil {
 		return nil, err
 	}
@@ -52,7 +74,22 @@ func (tx *sqlTxAdapter) Query(ctx context.Context, sql string, args ...interface
 }
 
 func (tx *sqlTxAdapter) QueryRow(ctx context.Context, sql string, args ...interface{}) Row {
-	return tx.tx.QueryRowContext(ctx, sql, args...)
+	return tx.tx.QueryRowContext(ctx, sql, convertArgs(args)...)
+}
+
+// convertArgs wraps slice arguments in pq.Array, since database/sql can't encode
+// Postgres arrays on its own and pgx handles them natively
+func convertArgs(args []interface{}) []interface{} {
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch arg.(type) {
+		case []string, []int64, []float64, []bool:
+			converted[i] = pq.Array(arg)
+		default:
+			converted[i] = arg
+		}
+	}
+	return converted
 }
 
 // NewSQLDriver creates a new database/sql driver implementation for PostgreSQL.
@@ -77,8 +114,42 @@ func NewSQLDriver(db *sql.DB, connStr string) (Driver, error) {
 		return nil, errors.New("nil database connection")
 	}
 	return &SQLDriver{
-		db:      db,
-		connStr: connStr,
+		db: db,
+		listenerDSN: func(ctx context.Context) (string, error) {
+			return connStr, nil
+		},
+		rotate: make(chan struct{}, 1),
+	}, nil
+}
+
+// NewSQLDriverWithDSNFunc creates a database/sql driver whose notification listener gets
+// its connection string from dsn rather than one fixed when the driver is created, so the
+// password isn't kept in the driver and rotated or short-lived credentials, such as RDS
+// IAM auth tokens, are picked up. dsn is called whenever the listener connects from
+// scratch, including after a failed connection attempt. TLS settings for the listener go
+// in the connection string it returns, as sslmode, sslrootcert and so on.
+//
+// Example:
+//
+//	driver, err := NewSQLDriverWithDSNFunc(db, func(ctx context.Context) (string, error) {
+//	    token, err := auth.BuildAuthToken(ctx, endpoint, region, user, creds)
+//	    if err != nil {
+//	        return "", err
+//	    }
+//	    return fmt.Sprintf("host=%s user=%s password=%s dbname=app sslmode=verify-full", host, user, token), nil
+//	})
+func NewSQLDriverWithDSNFunc(db *sql.DB, dsn DSNFunc) (Driver, error) {
+	if db == nil {
+		return nil, errors.New("nil database connection")
+	}
+	if dsn == nil {
+		return nil, errors.New("nil listener DSN function")
+	}
+	return &SQLDriver{
+		db:          db,
+		listenerDSN: dsn,
+		rotating:    true,
+		rotate:      make(chan struct{}, 1),
 	}, nil
 }
 
@@ -96,12 +167,12 @@ func (d *SQLDriver) WithTx(ctx context.Context, fn func(tx Transaction) error) e
 }
 
 func (d *SQLDriver) Exec(ctx context.Context, sql string, args ...interface{}) error {
-	_, err := d.db.ExecContext(ctx, sql, args...)
+	_, err := d.db.ExecContext(ctx, sql, convertArgs(args)...)
 	return err
 }
 
 func (d *SQLDriver) Query(ctx context.Context, sql string, args ...interface{}) (Rows, error) {
-	rows, err := d.db.QueryContext(ctx, sql, args...)
+	rows, err := d.db.QueryContext(ctx, sql, convertArgs(args)...)
 	if err != nil {
 		return nil, err
 	}
@@ -109,14 +180,95 @@ func (d *SQLDriver) Query(ctx context.Context, sql string, args ...interface{})
 }
 
 func (d *SQLDriver) QueryRow(ctx context.Context, sql string, args ...interface{}) Row {
-	return d.db.QueryRowContext(ctx, sql, args...)
+	return d.db.QueryRowContext(ctx, sql, convertArgs(args)...)
 }
 
+// Listen subscribes the driver's listener to channel. The subscription is restored
+// automatically whenever the listener has to reconnect.
 func (d *SQLDriver) Listen(ctx context.Context, channel string) error {
-	_, err := d.db.ExecContext(ctx, "LISTEN "+channel)
+	d.listener.addChannel(channel)
+	listener, err := d.getListener(ctx)
+	if err != nil {
+		return err
+	}
+	err = listener.Listen(channel)
+	if errors.Is(err, pq.ErrChannelAlreadyOpen) {
+		return nil
+	}
 	return err
 }
 
+// getListener returns the driver's pq.Listener, creating it on first use
+func (d *SQLDriver) getListener(ctx context.Context) (*pq.Listener, error) {
+	d.listenMu.Lock()
+	defer d.listenMu.Unlock()
+	if d.pqListener == nil {
+		dsn, err := d.listenerDSN(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get listener connection string: %w", err)
+		}
+		d.pqListener = pq.NewListener(dsn, listenerMinReconnect, listenerMaxReconnect, d.listenerEvent)
+		d.builtAt = time.Now()
+	}
+	return d.pqListener, nil
+}
+
+// rebuildListener replaces the listener with one connected with a fresh connection string
+// and subscribes it to the driver's channels. Rebuilds are spaced like pq's own reconnect
+// attempts, so a connection string that keeps failing isn't retried in a tight loop.
+func (d *SQLDriver) rebuildListener(ctx context.Context) error {
+	d.listenMu.Lock()
+	wait := listenerMinReconnect - time.Since(d.builtAt)
+	d.listenMu.Unlock()
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	d.listenMu.Lock()
+	old := d.pqListener
+	d.pqListener = nil
+	d.listenMu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+
+	listener, err := d.getListener(ctx)
+	if err != nil {
+		return err
+	}
+	for _, channel := range d.listener.subscribed() {
+		if err := listener.Listen(channel); err != nil && !errors.Is(err, pq.ErrChannelAlreadyOpen) {
+			return fmt.Errorf("failed to listen on %s: %w", channel, err)
+		}
+	}
+	return nil
+}
+
+// listenerEvent records pq.Listener connection events in the listener health
+func (d *SQLDriver) listenerEvent(ev pq.ListenerEventType, err error) {
+	switch ev {
+	case pq.ListenerEventConnected, pq.ListenerEventReconnected:
+		d.listener.setConnected()
+	case pq.ListenerEventDisconnected, pq.ListenerEventConnectionAttemptFailed:
+		d.listener.setDisconnected(err)
+		if err != nil {
+			log.Printf("Listener error: %v\n", err)
+		}
+		if ev == pq.ListenerEventConnectionAttemptFailed && d.rotating {
+			select {
+			case d.rotate <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
 func (d *SQLDriver) Notify(ctx context.Context, channel string, payload string) error {
 	_, err := d.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, payload)
 	return err
@@ -130,23 +282,30 @@ func (d *SQLDriver) AddJobWithTx(ctx context.Context, tx interface{}) (Transacti
 	return nil, errors.New("invalid transaction type: expected *sql.Tx")
 }
 
-// WaitForNotification waits for a notification on any channel this connection is listening on
+// WaitForNotification waits for a notification on any channel the driver is listening on.
+// After the listener reconnects ErrNotificationsMissed is returned, since notifications
+// sent while it was disconnected are lost.
 func (d *SQLDriver) WaitForNotification(ctx context.Context) (*Notification, error) {
-	listener := pq.NewListener(d.connStr,
-		10*time.Second, // Max reconnect wait
-		time.Minute,    // Max ping interval
-		func(ev pq.ListenerEventType, err error) {
-			if err != nil {
-				log.Printf("Listener error: %v\n", err)
-			}
-		})
-	defer listener.Close()
+	listener, err := d.getListener(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// Wait for notification or context cancellation
 	select {
-	case notification := <-listener.Notify:
+	case <-d.rotate:
+		// Notifications may have been sent while the listener couldn't connect
+		if err := d.rebuildListener(ctx); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotificationsMissed
+	case notification, ok := <-listener.Notify:
+		if !ok {
+			return nil, errors.New("listener closed")
+		}
 		if notification == nil {
-			return nil, fmt.Errorf("received nil notification")
+			// pq sends nil once it has reconnected and resubscribed
+			return nil, ErrNotificationsMissed
 		}
 		return &Notification{
 			Channel: notification.Channel,
@@ -157,7 +316,40 @@ func (d *SQLDriver) WaitForNotification(ctx context.Context) (*Notification, err
 	}
 }
 
-// AddJobsWithTx adds multiple jobs as part of an existing transaction
+// DetectTransactionPooler reports whether the database connects through a transaction-mode pooler
+func (d *SQLDriver) DetectTransactionPooler(ctx context.Context) (bool, error) {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	return probeSession(ctx, func(ctx context.Context, sql string, args ...interface{}) Row {
+		return conn.QueryRowContext(ctx, sql, convertArgs(args)...)
+	})
+}
+
+// ListenerHealth reports the state of the listener connection
+func (d *SQLDriver) ListenerHealth() ListenerHealth {
+	return d.listener.snapshot()
+}
+
+// Close closes the listener connection. The *sql.DB passed to NewSQLDriver belongs to the
+// caller and is left open.
+func (d *SQLDriver) Close() error {
+	d.listenMu.Lock()
+	defer d.listenMu.Unlock()
+	if d.pqListener == nil {
+		return nil
+	}
+	err := d.pqListener.Close()
+	d.pqListener = nil
+	d.listener.setDisconnected(nil)
+	return err
+}
+
+// AddJobsWithTx adds multiple jobs as part of an existing transaction, with a single
+// statement however large the batch
 func (d *SQLDriver) AddJobsWithTx(ctx context.Context, tx interface{}, jobs []BatchJob) error {
 	if len(jobs) == 0 {
 		return nil
@@ -169,48 +361,5 @@ func (d *SQLDriver) AddJobsWithTx(ctx context.Context, tx interface{}, jobs []Ba
 		return fmt.Errorf("invalid transaction for driver: %w", err)
 	}
 
-	// Build the values clause and args
-	var values []string
-	var args []interface{}
-	argCount := 1
-
-	for _, job := range jobs {
-		// Type assert to check if it implements Worker interface
-		if _, ok := job.Worker.(interface{ JobName() string }); !ok {
-			return fmt.Errorf("worker must implement JobName() string")
-		}
-
-		// Serialize the worker
-		argsJSON, err := json.Marshal(job.Worker)
-		if err != nil {
-			return fmt.Errorf("failed to serialize job args: %w", err)
-		}
-
-		// Add values for this job
-		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, 'pending')",
-			argCount, argCount+1, argCount+2, argCount+3, argCount+4))
-
-		args = append(args,
-			job.Worker.(interface{ JobName() string }).JobName(),
-			string(job.Opts.Queue),
-			argsJSON,
-			job.Opts.Priority,
-			job.Opts.RunAt,
-		)
-		argCount += 5
-	}
-
-	// Build and execute the insert query
-	insertSQL := fmt.Sprintf(`
-		INSERT INTO swig_jobs (
-			kind,
-			queue,
-			payload,
-			priority,
-			scheduled_for,
-			status
-		) VALUES %s
-	`, strings.Join(values, ","))
-
-	return txAdapter.Exec(ctx, insertSQL, args...)
+	return InsertJobs(ctx, txAdapter, jobs)
 }
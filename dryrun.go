@@ -0,0 +1,80 @@
+package swig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// errDryRun rolls back a dry-run insert once every check has passed
+var errDryRun = errors.New("dry run")
+
+// ValidateJob runs every check AddJob would for a job, including serialization, enqueue
+// middleware, tenant quotas and the database's own constraints, without enqueueing it.
+// The insert runs in a transaction that's always rolled back. It returns the error AddJob
+// would have returned, or nil if the job would have been enqueued.
+//
+// Example:
+//
+//	// Preflight check in a canary before switching producers over
+//	if err := swig.ValidateJob(ctx, &EmailWorker{To: to}, opts); err != nil {
+//	    return fmt.Errorf("email job would be rejected: %w", err)
+//	}
+func (s *Swig) ValidateJob(ctx context.Context, workerWithArgs interface{}, opts ...JobOptions) error {
+	if _, ok := workerWithArgs.(interface{ JobName() string }); !ok {
+		return fmt.Errorf("workerWithArgs must implement JobName() string")
+	}
+	jobOpts := DefaultJobOptions()
+	if len(opts) > 0 {
+		jobOpts = opts[0]
+	}
+
+	jobs, err := s.prepareJobs(ctx, []drivers.BatchJob{
+		{Worker: workerWithArgs, Opts: jobOpts.driverOptions(s.workerID)},
+	})
+	if err != nil {
+		return err
+	}
+	err = s.driver.WithTx(ctx, func(tx drivers.Transaction) error {
+		if err := s.checkTenantQuotas(ctx, tx, jobs); err != nil {
+			return err
+		}
+		if err := drivers.InsertJobs(ctx, tx, jobs); err != nil {
+			return err
+		}
+		return errDryRun
+	})
+	if errors.Is(err, errDryRun) {
+		return nil
+	}
+	return err
+}
+
+// insertJobsInTx checks tenant quotas and inserts jobs through a caller's transaction. In
+// dry-run mode the insert runs under a savepoint that's rolled back, leaving the rest of
+// the caller's transaction as it was.
+func (s *Swig) insertJobsInTx(ctx context.Context, tx drivers.Transaction, jobs []drivers.BatchJob) error {
+	if !s.dryRun {
+		if err := s.checkTenantQuotas(ctx, tx, jobs); err != nil {
+			return err
+		}
+		if err := drivers.InsertJobs(ctx, tx, jobs); err != nil {
+			return err
+		}
+		return s.announceJobs(ctx, tx, jobs, "")
+	}
+
+	if err := tx.Exec(ctx, `SAVEPOINT swig_dry_run`); err != nil {
+		return fmt.Errorf("failed to start dry run: %w", err)
+	}
+	err := s.checkTenantQuotas(ctx, tx, jobs)
+	if err == nil {
+		err = drivers.InsertJobs(ctx, tx, jobs)
+	}
+	if rollbackErr := tx.Exec(ctx, `ROLLBACK TO SAVEPOINT swig_dry_run`); rollbackErr != nil {
+		return fmt.Errorf("failed to roll back dry run: %w", rollbackErr)
+	}
+	return err
+}
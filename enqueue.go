@@ -0,0 +1,82 @@
+package swig
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// JobParams is a job about to be enqueued, as seen by enqueue middleware
+type JobParams struct {
+	Kind    string          // Job name, taken from the worker's JobName for typed jobs
+	Worker  interface{}     // Worker with its arguments, nil for raw payloads
+	Payload json.RawMessage // Serialized payload for raw jobs, nil when Worker is set
+	// Options can be changed freely, including Metadata, which is stored with the job.
+	Options *drivers.JobOptions
+}
+
+// EnqueueMiddleware runs for every job before it's enqueued. It can change the job's
+// options, attach metadata or reject the job by returning an error, which the enqueue
+// call returns unchanged.
+type EnqueueMiddleware func(ctx context.Context, params *JobParams) error
+
+// UseEnqueue adds middleware that runs, in the order added, for every job enqueued with
+// AddJob, AddJobs, EnqueueRaw and their transactional variants. It's the central place to
+// stamp trace IDs, fill in tenants or enforce naming conventions. Add middleware before
+// enqueueing jobs.
+//
+// Example:
+//
+//	swig.UseEnqueue(func(ctx context.Context, params *swig.JobParams) error {
+//	    if !strings.Contains(params.Kind, "_") {
+//	        return fmt.Errorf("job kind %q must be snake_case", params.Kind)
+//	    }
+//	    if params.Options.Metadata == nil {
+//	        params.Options.Metadata = make(map[string]string)
+//	    }
+//	    params.Options.Metadata["trace_id"] = traceIDFrom(ctx)
+//	    return nil
+//	})
+func (s *Swig) UseEnqueue(middleware EnqueueMiddleware) {
+	s.enqueueMu.Lock()
+	defer s.enqueueMu.Unlock()
+	s.enqueueMiddleware = append(s.enqueueMiddleware, middleware)
+}
+
+// prepareJobs captures propagated context values, assigns SLO priorities, runs the enqueue
+// middleware over jobs, checks their queues are processed, mirrors the ones being shadowed
+// and assigns their IDs. It works on
+// a copy, leaving the caller's slice untouched.
+func (s *Swig) prepareJobs(ctx context.Context, jobs []drivers.BatchJob) ([]drivers.BatchJob, error) {
+	s.enqueueMu.RLock()
+	middleware := s.enqueueMiddleware
+	s.enqueueMu.RUnlock()
+
+	if len(middleware) > 0 || s.slos != nil || len(s.propagators) > 0 {
+		prepared := make([]drivers.BatchJob, len(jobs))
+		for i, job := range jobs {
+			params := JobParams{Kind: job.Kind, Worker: job.Worker, Payload: job.Payload, Options: &job.Opts}
+			if named, ok := job.Worker.(interface{ JobName() string }); ok {
+				params.Kind = named.JobName()
+			}
+			s.captureContext(ctx, &job.Opts)
+			s.applySLOPriority(params.Kind, &job.Opts.Priority)
+			for _, mw := range middleware {
+				if err := mw(ctx, &params); err != nil {
+					return nil, err
+				}
+			}
+			prepared[i] = job
+		}
+		jobs = prepared
+	}
+	if err := s.checkJobQueues(ctx, jobs); err != nil {
+		return nil, err
+	}
+	jobs, err := s.mirrorJobs(jobs)
+	if err != nil {
+		return nil, err
+	}
+	return s.assignJobIDs(jobs), nil
+}
@@ -0,0 +1,66 @@
+package swig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"unicode/utf8"
+
+	"github.com/glamboyosa/swig/workers"
+)
+
+// Default size in bytes past which errors are truncated before they're stored, see
+// WithErrorLimit
+const defaultErrorLimit = 8 << 10
+
+// ErrorStore keeps the full text of an error too large to store with its job, for example
+// in object storage, and returns a reference to it such as a URL. The reference is
+// recorded in the job's error details alongside the truncated error.
+type ErrorStore func(ctx context.Context, job workers.JobInfo, fullError string) (string, error)
+
+// truncateError cuts text down to limit bytes on a character boundary, noting how much
+// was cut. A limit of zero or less keeps the whole text.
+func truncateError(text string, limit int) string {
+	if limit <= 0 || len(text) <= limit {
+		return text
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s... [truncated %d bytes]", text[:cut], len(text)-cut)
+}
+
+// describeFailure builds the stored form of a failed attempt of job: its error message,
+// details and their encoding. An error, message or stack larger than the error limit is
+// truncated, and handed in full to the ErrorStore first if there is one.
+func (s *Swig) describeFailure(ctx context.Context, job workers.JobInfo, err error, willRetry bool) (string, errorDetails, []byte) {
+	message := err.Error()
+	details, encoded := describeError(err, willRetry)
+	limit := s.errorLimit
+	if limit <= 0 || (len(message) <= limit && len(details.Message) <= limit && len(details.Stack) <= limit) {
+		return message, details, encoded
+	}
+
+	if s.errorStore != nil {
+		full := message
+		if details.Stack != "" {
+			full += "\n\n" + details.Stack
+		}
+		ref, storeErr := s.errorStore(ctx, job, full)
+		if storeErr != nil {
+			log.Printf("Failed to store full error of job %s: %v", job.ID, storeErr)
+		} else {
+			details.FullErrorRef = ref
+		}
+	}
+	details.Truncated = true
+	details.Message = truncateError(details.Message, limit)
+	details.Stack = truncateError(details.Stack, limit)
+	encoded, marshalErr := json.Marshal(details)
+	if marshalErr != nil {
+		encoded = []byte(`{}`)
+	}
+	return truncateError(message, limit), details, encoded
+}
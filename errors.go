@@ -0,0 +1,159 @@
+package swig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// Error codes Swig records for failures that don't come from a worker's own CodedError
+const (
+	ErrorCodeUnknown        = "UNKNOWN"          // The worker returned an error without a code
+	ErrorCodePanic          = "PANIC"            // The worker panicked
+	ErrorCodeWorkerNotFound = "WORKER_NOT_FOUND" // No worker is registered for the job's kind
+	ErrorCodeInvalidPayload = "INVALID_PAYLOAD"  // The payload couldn't be migrated or decoded
+	ErrorCodeInvalidWorker  = "INVALID_WORKER"   // The registered worker can't process jobs
+	ErrorCodeCancelled      = "CANCELLED"        // The job was cancelled while it ran, see CancelActiveJob
+	ErrorCodeShutdown       = "SHUTDOWN"         // Stop cancelled the job after its grace period ran out
+	// A subprocess worker's command exited with a non-zero status, or was killed after its
+	// timeout; see RegisterSubprocessWorker
+	ErrorCodeSubprocessExit    = "SUBPROCESS_EXIT"
+	ErrorCodeSubprocessTimeout = "SUBPROCESS_TIMEOUT"
+)
+
+// JobError is a job failure with a machine-readable code. Failures are stored with their
+// code so dashboards and bulk retries can group them; errors without one are recorded
+// as ErrorCodeUnknown.
+type JobError struct {
+	Code string
+	Err  error
+	// NoRetry fails the job for good, even if it has attempts left. Use it for errors
+	// that retrying can't fix, like a malformed address.
+	NoRetry bool
+	Stack   string // Optional stack trace, recorded with the failure
+}
+
+// CodedError attaches a code to a failure returned from Process.
+//
+// Example:
+//
+//	if errors.Is(err, smtp.ErrRateLimited) {
+//	    return swig.CodedError("SMTP_RATE_LIMIT", err)
+//	}
+func CodedError(code string, err error) error {
+	return &JobError{Code: code, Err: err}
+}
+
+func (e *JobError) Error() string {
+	if e.Err == nil {
+		return e.Code
+	}
+	return fmt.Sprintf("%s: %v", e.Code, e.Err)
+}
+
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// retryAfterError is a failure whose worker asked for the next attempt after a delay
+type retryAfterError struct {
+	err   error
+	delay time.Duration
+}
+
+// RetryAfter fails the attempt and asks for the next one no sooner than delay from now,
+// instead of after the queue's backoff, for workers that know when retrying makes sense,
+// like when an API sends a Retry-After header. It only changes when the job is retried:
+// a job out of attempts still fails, and err is recorded as the failure as usual, so it
+// can be a CodedError. Retries are promoted by the leader's retry pass, so the attempt
+// can start up to the retry interval after the delay.
+//
+// Example:
+//
+//	if resp.StatusCode == http.StatusTooManyRequests {
+//	    seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+//	    return swig.RetryAfter(swig.CodedError("RATE_LIMITED", errRateLimited), time.Duration(seconds)*time.Second)
+//	}
+func RetryAfter(err error, delay time.Duration) error {
+	if err == nil {
+		err = errors.New("retry requested")
+	}
+	return &retryAfterError{err: err, delay: max(delay, 0)}
+}
+
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// requestedRetryDelay returns the delay err asks for with RetryAfter, if it does
+func requestedRetryDelay(err error) (time.Duration, bool) {
+	var retryErr *retryAfterError
+	if errors.As(err, &retryErr) {
+		return retryErr.delay, true
+	}
+	return 0, false
+}
+
+// errorDetails is the structured form of a failure stored in last_error_details
+type errorDetails struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+	Stack     string `json:"stack,omitempty"`
+	Field     string `json:"field,omitempty"` // Payload field that failed to decode, if that's what failed
+	// Truncated is set when the error was cut to the error limit, see WithErrorLimit, and
+	// FullErrorRef points to the full error when an ErrorStore kept it
+	Truncated    bool   `json:"truncated,omitempty"`
+	FullErrorRef string `json:"full_error_ref,omitempty"`
+}
+
+// describeError builds the stored form of err. willRetry says whether the job gets
+// another attempt.
+func describeError(err error, willRetry bool) (errorDetails, []byte) {
+	details := errorDetails{
+		Code:      ErrorCodeUnknown,
+		Message:   err.Error(),
+		Retryable: willRetry,
+	}
+	var jobErr *JobError
+	if errors.As(err, &jobErr) {
+		if jobErr.Code != "" {
+			details.Code = jobErr.Code
+		}
+		if jobErr.Err != nil {
+			details.Message = jobErr.Err.Error()
+		}
+		details.Stack = jobErr.Stack
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		details.Field = typeErr.Field
+	}
+
+	encoded, marshalErr := json.Marshal(details)
+	if marshalErr != nil {
+		encoded = []byte(`{}`)
+	}
+	return details, encoded
+}
+
+// isNoRetry reports whether err asks for the job not to be retried
+func isNoRetry(err error) bool {
+	var jobErr *JobError
+	return errors.As(err, &jobErr) && jobErr.NoRetry
+}
+
+// recoverPanic turns a panic in a worker into a JobError carrying the stack
+func recoverPanic(r interface{}) error {
+	return &JobError{
+		Code:  ErrorCodePanic,
+		Err:   fmt.Errorf("panic: %v", r),
+		Stack: string(debug.Stack()),
+	}
+}
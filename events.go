@@ -0,0 +1,84 @@
+package swig
+
+import (
+	"sync"
+)
+
+// How many events a subscriber can fall behind by before further events are dropped
+const subscriberBuffer = 256
+
+// JobEvent reports an attempt at a job that this instance finished
+type JobEvent struct {
+	JobID    string
+	Kind     string
+	Queue    string
+	Status   string // "completed", "retryable" or "failed"
+	Attempts int    // Attempts so far, including this one
+	Err      error  // Why the attempt failed, nil when the job completed
+}
+
+// subscribers fans JobEvents out to the channels returned by Subscribe
+type subscribers struct {
+	mu     sync.Mutex
+	nextID int
+	chans  map[int]chan JobEvent
+}
+
+func newSubscribers() *subscribers {
+	return &subscribers{chans: make(map[int]chan JobEvent)}
+}
+
+// Subscribe returns a channel that receives an event whenever this instance finishes an
+// attempt at a job, once the result has been recorded. Events for jobs other instances
+// process aren't delivered. Events are dropped rather than holding up workers when the
+// channel's buffer is full, so read it promptly. Call the returned function to unsubscribe,
+// which closes the channel.
+//
+// Example:
+//
+//	events, unsubscribe := swig.Subscribe()
+//	defer unsubscribe()
+//	for event := range events {
+//	    log.Printf("job %s is %s", event.JobID, event.Status)
+//	}
+func (s *Swig) Subscribe() (<-chan JobEvent, func()) {
+	subs := s.subscribers
+	ch := make(chan JobEvent, subscriberBuffer)
+
+	subs.mu.Lock()
+	subs.nextID++
+	id := subs.nextID
+	subs.chans[id] = ch
+	subs.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			subs.mu.Lock()
+			delete(subs.chans, id)
+			subs.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publishResult tells subscribers how an attempt at job ended
+func (s *Swig) publishResult(job acquiredJob, err error) {
+	event := JobEvent{JobID: job.ID, Kind: job.Kind, Queue: job.Queue, Status: "completed", Attempts: job.Attempts, Err: err}
+	if err != nil {
+		event.Status = "failed"
+		if shouldRetry(job, err) {
+			event.Status = "retryable"
+		}
+	}
+
+	subs := s.subscribers
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+	for _, ch := range subs.chans {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
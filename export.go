@@ -0,0 +1,312 @@
+package swig
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// ExportFormat selects how ExportJobs writes jobs
+type ExportFormat string
+
+const (
+	// ExportJSON writes one JSON-encoded JobRecord per line
+	ExportJSON ExportFormat = "json"
+	// ExportCSV writes a header row followed by one row per job, with the payload as JSON
+	ExportCSV ExportFormat = "csv"
+)
+
+// How many jobs ExportJobs reads per query
+const exportPageSize = 500
+
+// csvColumns are the columns written by ExportCSV, in order
+var csvColumns = []string{
+	"id", "kind", "queue", "status", "priority", "attempts", "max_attempts",
+	"created_at", "scheduled_for", "expires_at", "finished_at", "deleted_at",
+	"last_error", "last_error_code", "payload",
+}
+
+// ExportJobs writes every job matching filter to w, for backups, moving jobs between
+// environments or analysing a failure backlog offline. filter.Limit and filter.Offset are
+// ignored; all matching jobs are exported. The output can be loaded with ImportJobs.
+//
+// Example:
+//
+//	f, _ := os.Create("failed.csv")
+//	defer f.Close()
+//	err := swig.ExportJobs(ctx, JobFilter{Statuses: []string{"failed"}}, f, ExportCSV)
+func (s *Swig) ExportJobs(ctx context.Context, filter JobFilter, w io.Writer, format ExportFormat) error {
+	var write func(job JobRecord) error
+	var flush func() error
+
+	switch format {
+	case ExportJSON:
+		buffered := bufio.NewWriter(w)
+		encoder := json.NewEncoder(buffered)
+		write = func(job JobRecord) error { return encoder.Encode(job) }
+		flush = buffered.Flush
+	case ExportCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvColumns); err != nil {
+			return fmt.Errorf("failed to write CSV header: %w", err)
+		}
+		write = func(job JobRecord) error { return writer.Write(jobToCSV(job)) }
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	default:
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+
+	filter.Limit = exportPageSize
+	filter.Offset = 0
+	for {
+		jobs, err := s.ListJobs(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			if err := write(job); err != nil {
+				return fmt.Errorf("failed to write job %s: %w", job.ID, err)
+			}
+		}
+		if len(jobs) < exportPageSize {
+			break
+		}
+		filter.Offset += len(jobs)
+	}
+
+	if err := flush(); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	return nil
+}
+
+// ImportJobs loads jobs written by ExportJobs in either format, keeping their IDs, status
+// and history. Jobs that already exist are skipped, so an import can safely be re-run.
+// Jobs exported while processing are imported as pending, since no instance owns them.
+// Returns the number of jobs imported.
+//
+// Example:
+//
+//	f, _ := os.Open("failed.csv")
+//	defer f.Close()
+//	imported, err := swig.ImportJobs(ctx, f)
+func (s *Swig) ImportJobs(ctx context.Context, r io.Reader) (int, error) {
+	buffered := bufio.NewReader(r)
+	jobs, err := readJobs(buffered)
+	if err != nil {
+		return 0, err
+	}
+	if len(jobs) == 0 {
+		return 0, nil
+	}
+
+	importSQL := `
+		INSERT INTO swig_jobs (
+			id, kind, queue, status, payload, priority, attempts, max_attempts,
+			created_at, scheduled_for, expires_at, finished_at, deleted_at, last_error, last_error_code,
+			metadata
+		) VALUES ($1, $2, $3, $4::text::swig_job_status, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (id) DO NOTHING
+		RETURNING id`
+
+	imported := 0
+	err = s.driver.WithTx(ctx, func(tx drivers.Transaction) error {
+		for _, job := range jobs {
+			status := job.Status
+			if status == "processing" {
+				status = "pending"
+			}
+			var lastError, lastErrorCode interface{}
+			if job.LastError != "" {
+				lastError = job.LastError
+			}
+			if job.LastErrorCode != "" {
+				lastErrorCode = job.LastErrorCode
+			}
+			metadata := []byte(`{}`)
+			if len(job.Metadata) > 0 {
+				var err error
+				if metadata, err = json.Marshal(job.Metadata); err != nil {
+					return fmt.Errorf("failed to encode metadata of job %s: %w", job.ID, err)
+				}
+			}
+
+			var id string
+			err := tx.QueryRow(ctx, importSQL,
+				job.ID, job.Kind, job.Queue, status, []byte(job.Payload), job.Priority, job.Attempts,
+				job.MaxAttempts, job.CreatedAt, job.ScheduledFor, job.ExpiresAt, job.FinishedAt,
+				job.DeletedAt, lastError, lastErrorCode, metadata).Scan(&id)
+			if isNoRows(err) {
+				continue // Already exists
+			}
+			if err != nil {
+				return fmt.Errorf("failed to import job %s: %w", job.ID, err)
+			}
+			imported++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return imported, nil
+}
+
+// readJobs decodes an export, telling JSON from CSV by its first character
+func readJobs(r *bufio.Reader) ([]JobRecord, error) {
+	for {
+		b, err := r.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read import: %w", err)
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			if b[0] == '{' {
+				return readJSONJobs(r)
+			}
+			return readCSVJobs(r)
+		}
+		r.ReadByte()
+	}
+}
+
+// readJSONJobs decodes a stream of JSON job records
+func readJSONJobs(r io.Reader) ([]JobRecord, error) {
+	var jobs []JobRecord
+	decoder := json.NewDecoder(r)
+	for {
+		var job JobRecord
+		err := decoder.Decode(&job)
+		if errors.Is(err, io.EOF) {
+			return jobs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode job %d: %w", len(jobs)+1, err)
+		}
+		jobs = append(jobs, job)
+	}
+}
+
+// readCSVJobs decodes CSV rows with a header naming the columns
+func readCSVJobs(r io.Reader) ([]JobRecord, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range csvColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV is missing column %q", name)
+		}
+	}
+
+	var jobs []JobRecord
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return jobs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV row %d: %w", len(jobs)+2, err)
+		}
+		job, err := csvToJob(row, columns)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV row %d: %w", len(jobs)+2, err)
+		}
+		jobs = append(jobs, job)
+	}
+}
+
+// jobToCSV formats a job as a row of csvColumns
+func jobToCSV(job JobRecord) []string {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339Nano)
+	}
+	return []string{
+		job.ID, job.Kind, job.Queue, job.Status,
+		strconv.Itoa(job.Priority), strconv.Itoa(job.Attempts), strconv.Itoa(job.MaxAttempts),
+		job.CreatedAt.Format(time.RFC3339Nano), job.ScheduledFor.Format(time.RFC3339Nano),
+		formatTime(job.ExpiresAt), formatTime(job.FinishedAt), formatTime(job.DeletedAt),
+		job.LastError, job.LastErrorCode, string(job.Payload),
+	}
+}
+
+// csvToJob parses a CSV row, with columns giving the index of each field
+func csvToJob(row []string, columns map[string]int) (JobRecord, error) {
+	field := func(name string) string {
+		if i := columns[name]; i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	var parseErr error
+	parseInt := func(name string) int {
+		n, err := strconv.Atoi(field(name))
+		if err != nil && parseErr == nil {
+			parseErr = fmt.Errorf("invalid %s: %w", name, err)
+		}
+		return n
+	}
+	parseTime := func(name string) *time.Time {
+		value := field(name)
+		if value == "" {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil && parseErr == nil {
+			parseErr = fmt.Errorf("invalid %s: %w", name, err)
+		}
+		return &t
+	}
+
+	job := JobRecord{
+		ID:          field("id"),
+		Kind:        field("kind"),
+		Queue:       field("queue"),
+		Status:      field("status"),
+		Payload:     json.RawMessage(field("payload")),
+		Priority:    parseInt("priority"),
+		Attempts:    parseInt("attempts"),
+		MaxAttempts: parseInt("max_attempts"),
+		ExpiresAt:   parseTime("expires_at"),
+		FinishedAt:  parseTime("finished_at"),
+		DeletedAt:   parseTime("deleted_at"),
+		LastError:   field("last_error"),
+	}
+	job.LastErrorCode = field("last_error_code")
+	if createdAt := parseTime("created_at"); createdAt != nil {
+		job.CreatedAt = *createdAt
+	}
+	if scheduledFor := parseTime("scheduled_for"); scheduledFor != nil {
+		job.ScheduledFor = *scheduledFor
+	}
+	if parseErr != nil {
+		return JobRecord{}, parseErr
+	}
+	if !json.Valid(job.Payload) {
+		return JobRecord{}, fmt.Errorf("payload of job %s is not valid JSON", job.ID)
+	}
+	return job, nil
+}
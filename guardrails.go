@@ -0,0 +1,81 @@
+package swig
+
+import (
+	"sort"
+	"sync"
+)
+
+// inFlightBudget caps the memory taken up by jobs being processed at once
+type inFlightBudget struct {
+	maxBytes    int64
+	memoryHints map[string]int64 // Per-kind estimate of the memory a job needs
+
+	mu       sync.Mutex
+	inFlight int64 // Cost of the jobs being processed
+}
+
+// jobCost is what a job counts against the in-flight budget: its payload size, or the
+// kind's memory hint if that's larger
+func (b *inFlightBudget) jobCost(kind string, payloadSize int) int64 {
+	cost := int64(payloadSize)
+	if hint := b.memoryHints[kind]; hint > cost {
+		cost = hint
+	}
+	return cost
+}
+
+// capacityFilter returns the largest payload that fits in the remaining in-flight budget
+// and the kinds whose memory hint doesn't fit. limited is false when there's no budget or
+// nothing is in flight; a job that's bigger than the whole budget still runs on its own.
+func (s *Swig) capacityFilter() (maxSize int64, excludedKinds []string, limited bool) {
+	b := s.inFlight
+	if b == nil || b.maxBytes <= 0 {
+		return 0, nil, false
+	}
+
+	b.mu.Lock()
+	inFlight := b.inFlight
+	b.mu.Unlock()
+	if inFlight == 0 {
+		return 0, nil, false
+	}
+
+	remaining := b.maxBytes - inFlight
+	if remaining < 0 {
+		remaining = 0
+	}
+	excludedKinds = []string{}
+	for kind, hint := range b.memoryHints {
+		if hint > remaining {
+			excludedKinds = append(excludedKinds, kind)
+		}
+	}
+	sort.Strings(excludedKinds)
+	return remaining, excludedKinds, true
+}
+
+// reserveCapacity counts an acquired job against the in-flight budget, returning its cost.
+// Workers filter candidates by the remaining budget before acquiring, so concurrent
+// acquisitions can overshoot it by at most one job each.
+func (s *Swig) reserveCapacity(kind string, payloadSize int) int64 {
+	b := s.inFlight
+	if b == nil {
+		return 0
+	}
+	cost := b.jobCost(kind, payloadSize)
+	b.mu.Lock()
+	b.inFlight += cost
+	b.mu.Unlock()
+	return cost
+}
+
+// releaseCapacity returns a finished job's cost to the in-flight budget
+func (s *Swig) releaseCapacity(cost int64) {
+	b := s.inFlight
+	if b == nil || cost == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.inFlight -= cost
+	b.mu.Unlock()
+}
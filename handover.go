@@ -0,0 +1,190 @@
+package swig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// How long a job handed over to a replacement instance waits for it before any instance
+// may take it
+const handoverAffinity = time.Minute
+
+// How long SaveCheckpoint keeps trying once the job's context is cancelled, so a worker
+// can save its progress on its way out
+const checkpointSaveTimeout = 5 * time.Second
+
+// checkpointKey is the context key for the job a worker saves checkpoints for
+type checkpointKey struct{}
+
+// jobCheckpoint identifies the running job SaveCheckpoint and LoadCheckpoint apply to
+type jobCheckpoint struct {
+	swig  *Swig
+	jobID string
+}
+
+// withCheckpoint lets Process save and load checkpoints of job
+func (s *Swig) withCheckpoint(ctx context.Context, job acquiredJob) context.Context {
+	return context.WithValue(ctx, checkpointKey{}, &jobCheckpoint{swig: s, jobID: job.ID})
+}
+
+// SaveCheckpoint records how far the running job has got, as v serialized to JSON, so a
+// later attempt can resume from there with LoadCheckpoint instead of starting over. The
+// checkpoint survives retries and handovers to a replacement instance, see
+// WithDeploymentID, and is cleared once the job completes. It returns ErrJobNotFound if
+// the job is no longer held by this instance. Once ctx is cancelled it still tries for a
+// few seconds, so a worker can save its progress as it stops.
+//
+// Example:
+//
+//	for i := w.start; i < len(w.Rows); i++ {
+//	    if err := importRow(ctx, w.Rows[i]); err != nil {
+//	        return err
+//	    }
+//	    if i%1000 == 0 {
+//	        swig.SaveCheckpoint(ctx, ImportProgress{Row: i})
+//	    }
+//	}
+func SaveCheckpoint(ctx context.Context, v interface{}) error {
+	cp, ok := ctx.Value(checkpointKey{}).(*jobCheckpoint)
+	if !ok {
+		return fmt.Errorf("checkpoints can only be saved by a running job")
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.WithoutCancel(ctx), checkpointSaveTimeout)
+		defer cancel()
+	}
+
+	saveSQL := `
+		UPDATE swig_jobs
+		SET checkpoint = $2
+		WHERE id = $1
+			AND instance_id = $3
+			AND status = 'processing'
+		RETURNING id::text`
+	var id string
+	err = cp.query(ctx).QueryRow(ctx, saveSQL, cp.jobID, encoded, cp.swig.workerID).Scan(&id)
+	if isNoRows(err) {
+		return fmt.Errorf("%w: %s is no longer running on this instance", ErrJobNotFound, cp.jobID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// LoadCheckpoint decodes the running job's last checkpoint into v, reporting whether it
+// had one. A job without one starts from the beginning.
+//
+// Example:
+//
+//	var progress ImportProgress
+//	if ok, err := swig.LoadCheckpoint(ctx, &progress); err != nil {
+//	    return err
+//	} else if ok {
+//	    w.start = progress.Row + 1
+//	}
+func LoadCheckpoint(ctx context.Context, v interface{}) (bool, error) {
+	cp, ok := ctx.Value(checkpointKey{}).(*jobCheckpoint)
+	if !ok {
+		return false, fmt.Errorf("checkpoints can only be loaded by a running job")
+	}
+
+	var checkpoint []byte
+	err := cp.query(ctx).QueryRow(ctx, `SELECT checkpoint FROM swig_jobs WHERE id = $1`, cp.jobID).Scan(&checkpoint)
+	if isNoRows(err) {
+		return false, fmt.Errorf("%w: %s", ErrJobNotFound, cp.jobID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+	if len(checkpoint) == 0 {
+		return false, nil
+	}
+	if err := json.Unmarshal(checkpoint, v); err != nil {
+		return false, fmt.Errorf("failed to decode checkpoint: %w", err)
+	}
+	return true, nil
+}
+
+// query is what checkpoints are read and written through: the job's transaction for
+// transactional kinds, whose row it holds locked, or else the driver
+func (cp *jobCheckpoint) query(ctx context.Context) drivers.Transaction {
+	if tx, ok := TxFromContext(ctx); ok {
+		return tx
+	}
+	return cp.swig.driver
+}
+
+// markStopping records that this instance is stopping, so other members of its
+// deployment don't hand their jobs over to it
+func (s *Swig) markStopping(ctx context.Context) {
+	if s.deploymentID == "" {
+		return
+	}
+	if err := s.driver.Exec(ctx, `UPDATE swig_instances SET stopping_at = NOW() WHERE id = $1`, s.workerID); err != nil {
+		log.Printf("Failed to mark instance as stopping: %v", err)
+	}
+}
+
+// handOver gives a checkpointed job that Stop interrupted to another running member of
+// this instance's deployment, reporting whether there was one to take it. The job goes
+// back to pending tied to that instance for a minute, without counting the interrupted
+// attempt and without a retry backoff, and keeps its checkpoint so the replacement
+// resumes it. Jobs without a checkpoint, or without a member to take them, are recorded
+// as usual.
+func (s *Swig) handOver(ctx context.Context, db drivers.Transaction, job acquiredJob) (bool, error) {
+	if s.deploymentID == "" {
+		return false, nil
+	}
+
+	handoverSQL := `
+		UPDATE swig_jobs
+		SET status = 'pending',
+			attempts = GREATEST($2::int - 1, 0),
+			preferred_instance_id = target.id,
+			affinity_until = NOW() + (interval '1 second' * $3),
+			instance_id = NULL,
+			worker_id = NULL,
+			locked_at = NULL
+		FROM (
+			SELECT id
+			FROM swig_instances
+			WHERE deployment_id = $4
+				AND id <> $5
+				AND stopping_at IS NULL
+				AND seen_at > NOW() - $6::interval
+			ORDER BY random()
+			LIMIT 1
+		) target
+		WHERE swig_jobs.id = $1
+			AND swig_jobs.instance_id = $5
+			AND swig_jobs.checkpoint IS NOT NULL
+		RETURNING target.id::text`
+
+	var target string
+	err := db.QueryRow(ctx, handoverSQL, job.ID, job.Attempts, handoverAffinity.Seconds(),
+		s.deploymentID, s.workerID, instanceStaleAfter.String()).Scan(&target)
+	if isNoRows(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to hand over job %s: %w", job.ID, err)
+	}
+
+	log.Printf("Handed over job %s (%s) to instance %s of deployment %s", job.ID, job.Kind, target, s.deploymentID)
+	if err := s.notifyJob(ctx, job.ID, job.Queue, job.Kind); err != nil {
+		log.Printf("Failed to notify the replacement of job %s: %v", job.ID, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,74 @@
+package swig
+
+import (
+	"context"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// Health is a point-in-time view of whether a Swig instance can do its work
+type Health struct {
+	InstanceID    string // Random ID of this run of the instance
+	InstanceName  string // Stable name set with WithInstanceName, empty when unnamed
+	Healthy       bool   // Database reachable, not draining and, unless polling, listener connected
+	Database      bool   // Whether the database answered a ping
+	DatabaseError string // Why the ping failed
+	Leader        bool   // Whether this instance currently holds the leader lease
+	PollingMode   bool   // Whether workers poll for jobs instead of listening for notifications
+	Draining      bool   // Whether the instance has stopped taking jobs after reaching WithMaxJobsPerWorker
+	// ClockSkew is how far the database's clock is ahead of this instance's. Jobs are
+	// scheduled with the database's clock, so skew only matters for times the application
+	// computes itself, like RunAt: time.Now().Add(d).
+	ClockSkew time.Duration
+	// Listener is the state of the driver's notification listener. It's the zero value
+	// for drivers that don't report on their listener.
+	Listener drivers.ListenerHealth
+}
+
+// Health checks the database connection and reports the state of the notification
+// listener and leadership. It's meant to back readiness probes and status pages.
+// A disconnected listener doesn't stop jobs from being processed, since idle workers
+// still poll, but new jobs will take longer to be picked up until it reconnects.
+//
+// Example:
+//
+//	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+//	    if !swig.Health(r.Context()).Healthy {
+//	        w.WriteHeader(http.StatusServiceUnavailable)
+//	    }
+//	})
+func (s *Swig) Health(ctx context.Context) Health {
+	health := Health{
+		InstanceID:   s.workerID,
+		InstanceName: s.instanceName,
+		Leader:       s.isLeader(),
+		PollingMode:  s.pollingMode.Load(),
+	}
+
+	var one int
+	if err := s.driver.QueryRow(ctx, `SELECT 1`).Scan(&one); err != nil {
+		health.DatabaseError = err.Error()
+	} else {
+		health.Database = true
+		if skew, err := s.measureClockSkew(ctx); err == nil {
+			health.ClockSkew = skew
+		}
+	}
+
+	// Nothing listens in polling mode, so the listener doesn't count
+	listenerOK := true
+	if reporter, ok := s.driver.(drivers.ListenerHealthReporter); ok && !health.PollingMode {
+		health.Listener = reporter.ListenerHealth()
+		listenerOK = health.Listener.Connected
+	}
+
+	select {
+	case <-s.draining:
+		health.Draining = true
+	default:
+	}
+
+	health.Healthy = health.Database && listenerOK && !health.Draining
+	return health
+}
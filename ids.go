@@ -0,0 +1,83 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/glamboyosa/swig/drivers"
+	"github.com/glamboyosa/swig/pkg"
+)
+
+// IDScheme selects how Swig generates job and instance IDs. All schemes produce values in
+// UUID form, so they fit the existing columns.
+type IDScheme int
+
+const (
+	IDSchemeUUIDv4 IDScheme = iota // Random UUIDs, the default
+	IDSchemeUUIDv7                 // Time-ordered UUIDs
+	IDSchemeULID                   // ULIDs in UUID form; render them with pkg.FormatULID
+)
+
+// Column defaults matching each scheme, for jobs inserted without an ID
+var idSchemeDefaults = map[IDScheme]string{
+	IDSchemeUUIDv4: "gen_random_uuid()",
+	IDSchemeUUIDv7: "swig_uuid_v7()",
+	IDSchemeULID:   "swig_uuid_v7()",
+}
+
+// idGenerators are the Go generators for each scheme
+var idGenerators = map[IDScheme]func() string{
+	IDSchemeUUIDv4: pkg.GenerateWorkerID,
+	IDSchemeUUIDv7: pkg.GenerateUUIDv7,
+	IDSchemeULID:   pkg.GenerateULID,
+}
+
+// generateID returns a new ID from the configured generator
+func (s *Swig) generateID() string {
+	if s.idGenerator != nil {
+		return s.idGenerator()
+	}
+	return pkg.GenerateWorkerID()
+}
+
+// assignJobIDs returns a copy of jobs with IDs from the configured generator, leaving the
+// caller's slice untouched. Without a generator the database assigns them, unless Swig is
+// in trigger-less mode and needs them to announce the jobs.
+func (s *Swig) assignJobIDs(jobs []drivers.BatchJob) []drivers.BatchJob {
+	if s.idGenerator == nil && !s.triggerless.Load() {
+		return jobs
+	}
+	assigned := make([]drivers.BatchJob, len(jobs))
+	for i, job := range jobs {
+		if job.Opts.ID == "" {
+			job.Opts.ID = s.generateID()
+		}
+		assigned[i] = job
+	}
+	return assigned
+}
+
+// configureIDDefault points the swig_jobs id column default at the WithIDScheme scheme, so
+// jobs inserted by other producers get the same kind of ID
+func (s *Swig) configureIDDefault(ctx context.Context) error {
+	if s.idDefault == "" {
+		return nil
+	}
+
+	var current string
+	currentSQL := `
+		SELECT COALESCE(column_default, '')
+		FROM information_schema.columns
+		WHERE table_name = 'swig_jobs' AND column_name = 'id'`
+	if err := s.driver.QueryRow(ctx, currentSQL).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read job ID default: %w", err)
+	}
+	if current == s.idDefault {
+		return nil
+	}
+
+	if err := s.driver.Exec(ctx, fmt.Sprintf(`ALTER TABLE swig_jobs ALTER COLUMN id SET DEFAULT %s`, s.idDefault)); err != nil {
+		return fmt.Errorf("failed to set job ID default: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package swig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// leaderReleasedEvent is sent on the jobs channel when a leader steps down so
+// followers can hold an election straight away instead of waiting for the lease to expire
+const leaderReleasedEvent = "leader_released"
+
+// How often followers contend for leadership and the leader renews its lease
+const electionInterval = leaderTTL / 3
+
+// errNotElected is returned by tryBecomeLeader when another instance holds leadership.
+// It is expected on every follower and is not worth logging.
+var errNotElected = errors.New("leadership held by another instance")
+
+// errLeadershipLost is returned when the leader fails to renew a lease someone else now owns
+var errLeadershipLost = errors.New("leadership lost")
+
+// tryBecomeLeader attempts to acquire leadership. Contenders are serialised with a
+// transaction-scoped advisory lock, so the lock can never leak onto a pooled connection,
+// and the lease in swig_leader is only taken over once it has been released or has expired.
+// On databases without advisory locks the conditional upsert of the lease row decides
+// alone, which is still atomic.
+func (s *Swig) tryBecomeLeader(ctx context.Context) error {
+	if s.isLeader() {
+		return nil
+	}
+
+	leaderID := s.generateID()
+	err := s.driver.WithTx(ctx, func(tx drivers.Transaction) error {
+		if s.driverCaps.AdvisoryLocks {
+			var acquired bool
+			if err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, leaderLockID).Scan(&acquired); err != nil {
+				return fmt.Errorf("failed to acquire leader lock: %w", err)
+			}
+			if !acquired {
+				return errNotElected
+			}
+		}
+
+		var holder string
+		err := tx.QueryRow(ctx, `
+			INSERT INTO swig_leader (id, leader_id, expires_at)
+			VALUES ($1, $2, NOW() + $3::interval)
+			ON CONFLICT (id) DO UPDATE
+			SET leader_id = EXCLUDED.leader_id,
+				expires_at = EXCLUDED.expires_at,
+				acquired_at = NOW()
+			WHERE swig_leader.expires_at <= NOW()
+			RETURNING leader_id`, leaderKey, leaderID, leaderTTL.String()).Scan(&holder)
+		if isNoRows(err) {
+			return errNotElected
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update leader record: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	s.leaderMu.Lock()
+	s.leaderID = leaderID
+	s.leaderCancel = cancel
+	s.leaderMu.Unlock()
+
+	log.Printf("Instance %s became leader", s.instanceLabel())
+	s.recordSystemEvent(ctx, SystemEvent{Type: EventLeaderElected})
+
+	// Start leader duties in background
+	go s.performLeaderDuties(leaderCtx)
+
+	return nil
+}
+
+// isLeader reports whether this instance currently holds the leader lease
+func (s *Swig) isLeader() bool {
+	s.leaderMu.Lock()
+	defer s.leaderMu.Unlock()
+	return s.leaderID != ""
+}
+
+// runElection keeps followers contending for leadership so a new leader is elected
+// when the current one stops or crashes. Elections happen every electionInterval, or
+// immediately when the previous leader announces it has stepped down.
+func (s *Swig) runElection(ctx context.Context) {
+	ticker := time.NewTicker(electionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+		case <-s.electNow:
+		}
+
+		if s.isLeader() {
+			continue
+		}
+		if err := s.tryBecomeLeader(ctx); err != nil && !errors.Is(err, errNotElected) {
+			// Don't report context cancellation as an error - this is normal during shutdown
+			if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+				log.Printf("Failed to become leader: %v", err)
+			}
+		}
+	}
+}
+
+// triggerElection asks the election loop to contend for leadership right away
+func (s *Swig) triggerElection() {
+	select {
+	case s.electNow <- struct{}{}:
+	default:
+		// An election is already pending
+	}
+}
+
+// renewLeadership extends the lease held by this instance
+func (s *Swig) renewLeadership(ctx context.Context) error {
+	s.leaderMu.Lock()
+	leaderID := s.leaderID
+	s.leaderMu.Unlock()
+
+	var holder string
+	err := s.driver.QueryRow(ctx, `
+		UPDATE swig_leader
+		SET expires_at = NOW() + $3::interval
+		WHERE id = $1 AND leader_id = $2
+		RETURNING leader_id`, leaderKey, leaderID, leaderTTL.String()).Scan(&holder)
+	if isNoRows(err) {
+		return errLeadershipLost
+	}
+	return err
+}
+
+// stepDown forgets local leadership and stops leader duties without touching the lease
+func (s *Swig) stepDown() string {
+	s.leaderMu.Lock()
+	defer s.leaderMu.Unlock()
+
+	leaderID := s.leaderID
+	if s.leaderCancel != nil {
+		s.leaderCancel()
+	}
+	s.leaderID = ""
+	s.leaderCancel = nil
+	return leaderID
+}
+
+// releaseLeadership gives up the lease, if we hold it, and notifies followers so one of
+// them can take over immediately rather than waiting for the lease to expire
+func (s *Swig) releaseLeadership(ctx context.Context) {
+	leaderID := s.stepDown()
+	if leaderID == "" {
+		return
+	}
+
+	unlockSQL := `
+		DELETE FROM swig_leader
+		WHERE id = $1 AND leader_id = $2
+	`
+	if err := s.driver.Exec(ctx, unlockSQL, leaderKey, leaderID); err != nil {
+		log.Printf("Failed to release leader lease: %v", err)
+		return
+	}
+	s.recordSystemEvent(ctx, SystemEvent{Type: EventLeaderReleased})
+	if !s.driverCaps.ListenNotify {
+		return
+	}
+
+	payload := fmt.Sprintf(`{"v":%d,"event":%q}`, notifyVersion, leaderReleasedEvent)
+	if err := s.driver.Notify(ctx, jobsChannel, payload); err != nil {
+		log.Printf("Failed to notify followers of leader release: %v", err)
+	}
+}
+
+// performLeaderDuties handles leader responsibilities like retrying failed jobs and
+// keeping the leader lease alive. Maintenance passes run on their own schedules and stop
+// with leader duties.
+func (s *Swig) performLeaderDuties(ctx context.Context) {
+	for _, task := range s.maintenanceTasks() {
+		go s.runMaintenance(ctx, task)
+	}
+
+	renew := time.NewTicker(electionInterval)
+	defer renew.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdown:
+			return
+		case <-renew.C:
+			if err := s.renewLeadership(ctx); err != nil {
+				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+					return
+				}
+				log.Printf("Stepping down as leader: %v", err)
+				s.stepDown()
+				s.recordSystemEvent(context.WithoutCancel(ctx), SystemEvent{Type: EventLeaderLost, Error: err.Error()})
+				return
+			}
+		}
+	}
+}
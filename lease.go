@@ -0,0 +1,253 @@
+package swig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// How often LeaseJob looks for a job while it waits for one
+const leasePollInterval = 500 * time.Millisecond
+
+// ErrLeaseLost is returned when a lease can't be extended, completed or failed because it
+// ran out, and the job was handed to someone else, or the token doesn't match
+var ErrLeaseLost = errors.New("lease lost")
+
+// Lease is a job handed to an external worker with LeaseJob. The worker owns the job until
+// ExpiresAt and keeps it with ExtendLease; the Token proves ownership when it reports back.
+type Lease struct {
+	JobID          string          `json:"job_id"`
+	Token          string          `json:"token"`
+	Kind           string          `json:"kind"`
+	Queue          string          `json:"queue"`
+	Payload        json.RawMessage `json:"payload"`
+	PayloadVersion int             `json:"payload_version"`
+	Attempts       int             `json:"attempts"` // Attempts so far, including this one
+	MaxAttempts    int             `json:"max_attempts"`
+	ExpiresAt      time.Time       `json:"expires_at"`
+}
+
+// LeaseJob hands the next due job of one of kinds to a worker outside this process, such
+// as one written in another language, for duration. It waits up to wait for a job to
+// become available and returns nil when none did. The job is processing until the worker
+// calls CompleteLease or FailLease; if the lease runs out first the leader hands the job
+// back to the queue, counting the attempt. Jobs are leased regardless of whether this
+// instance has workers for their kind. The swigremote package serves this over HTTP.
+//
+// Example:
+//
+//	lease, err := swig.LeaseJob(ctx, []string{"resize_image"}, time.Minute, 30*time.Second)
+//	if err != nil || lease == nil {
+//	    return err
+//	}
+//	err = swig.CompleteLease(ctx, lease.JobID, lease.Token, nil)
+func (s *Swig) LeaseJob(ctx context.Context, kinds []string, duration, wait time.Duration) (*Lease, error) {
+	if len(kinds) == 0 {
+		return nil, fmt.Errorf("at least one kind is required to lease a job")
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	ticker := time.NewTicker(leasePollInterval)
+	defer ticker.Stop()
+
+	for {
+		lease, err := s.tryLeaseJob(ctx, kinds, duration)
+		if err != nil || lease != nil {
+			return lease, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.shutdown:
+			return nil, nil
+		case <-timer.C:
+			return nil, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// tryLeaseJob leases the next due job of one of kinds, if there is one
+func (s *Swig) tryLeaseJob(ctx context.Context, kinds []string, duration time.Duration) (*Lease, error) {
+	leaseSQL := `
+		UPDATE swig_jobs
+		SET status = 'processing',
+			instance_id = NULL,
+			instance_name = NULL,
+			worker_id = $1,
+			locked_at = NOW(),
+			started_at = NOW(),
+			lease_expires_at = NOW() + make_interval(secs => $2),
+			attempts = attempts + 1,
+			holds_semaphore = FALSE
+		WHERE id = (
+			SELECT id
+			FROM swig_jobs
+			WHERE status = 'pending'
+				AND scheduled_for <= NOW()
+				AND (expires_at IS NULL OR expires_at > NOW())
+				AND kind = ANY($3)
+				AND (preferred_instance_id IS NULL OR affinity_until <= NOW())
+				AND (region IS NULL OR region = NULLIF($4, ''))
+				AND ` + semaphoreAvailable + `
+			ORDER BY
+				queue = 'priority' DESC,
+				priority DESC,
+				deadline ASC NULLS LAST,
+				scheduled_for,
+				created_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, kind, queue, payload, payload_version, attempts, max_attempts, lease_expires_at,
+			COALESCE(concurrency_key, kind),
+			EXISTS (SELECT 1 FROM swig_semaphores WHERE key = COALESCE(concurrency_key, kind))`
+
+	lease := Lease{Token: s.generateID()}
+	var job acquiredJob
+	var payload []byte
+	err := s.driver.QueryRow(ctx, leaseSQL, lease.Token, duration.Seconds(), kinds, s.region).Scan(
+		&lease.JobID, &lease.Kind, &lease.Queue, &payload, &lease.PayloadVersion, &lease.Attempts,
+		&lease.MaxAttempts, &lease.ExpiresAt, &job.concurrencyKey, &job.limited)
+	if isNoRows(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lease job: %w", err)
+	}
+	lease.Payload = json.RawMessage(payload)
+
+	// Hand the job back if its concurrency limit filled up since it was picked
+	if job.limited {
+		job.ID = lease.JobID
+		held, err := s.takeSemaphore(ctx, job)
+		if err != nil || !held {
+			if yieldErr := s.yieldJob(ctx, job); yieldErr != nil {
+				return nil, yieldErr
+			}
+			return nil, err
+		}
+	}
+	return &lease, nil
+}
+
+// ExtendLease keeps a leased job for another duration from now and returns when the lease
+// now runs out. Workers should call it well before the lease expires.
+func (s *Swig) ExtendLease(ctx context.Context, jobID, token string, duration time.Duration) (time.Time, error) {
+	extendSQL := `
+		UPDATE swig_jobs
+		SET lease_expires_at = NOW() + make_interval(secs => $3)
+		WHERE id = $1
+			AND worker_id = $2
+			AND status = 'processing'
+			AND lease_expires_at > NOW()
+		RETURNING lease_expires_at`
+
+	var expiresAt time.Time
+	err := s.driver.QueryRow(ctx, extendSQL, jobID, token, duration.Seconds()).Scan(&expiresAt)
+	if isNoRows(err) {
+		return time.Time{}, ErrLeaseLost
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to extend lease: %w", err)
+	}
+	return expiresAt, nil
+}
+
+// CompleteLease marks a leased job completed, storing result as its output if it's not nil
+func (s *Swig) CompleteLease(ctx context.Context, jobID, token string, result json.RawMessage) error {
+	if result != nil && !json.Valid(result) {
+		return fmt.Errorf("result of job %s is not valid JSON", jobID)
+	}
+	return s.finishLease(ctx, jobID, token, nil, result)
+}
+
+// FailLease records a failed attempt at a leased job, which is retried like any other
+// failure if it has attempts left. Wrap jobErr with CodedError, or return a JobError with
+// NoRetry set, to give the failure a code or rule out a retry.
+func (s *Swig) FailLease(ctx context.Context, jobID, token string, jobErr error) error {
+	if jobErr == nil {
+		jobErr = errors.New("external worker reported a failure")
+	}
+	return s.finishLease(ctx, jobID, token, jobErr, nil)
+}
+
+// finishLease records the outcome of a leased job, as long as the lease still holds
+func (s *Swig) finishLease(ctx context.Context, jobID, token string, jobErr error, result json.RawMessage) error {
+	var job acquiredJob
+	err := s.driver.WithTx(ctx, func(tx drivers.Transaction) error {
+		leaseSQL := `
+			SELECT id, kind, queue, attempts, max_attempts
+			FROM swig_jobs
+			WHERE id = $1
+				AND worker_id = $2
+				AND status = 'processing'
+				AND lease_expires_at > NOW()
+			FOR UPDATE`
+		err := tx.QueryRow(ctx, leaseSQL, jobID, token).Scan(&job.ID, &job.Kind, &job.Queue, &job.Attempts, &job.MaxAttempts)
+		if isNoRows(err) {
+			return ErrLeaseLost
+		}
+		if err != nil {
+			return fmt.Errorf("failed to look up lease: %w", err)
+		}
+		job.result = result
+		return s.recordResult(ctx, tx, job, jobErr)
+	})
+	if err != nil {
+		return err
+	}
+
+	if jobErr != nil {
+		s.metrics.increment(s.metrics.failed, job.Kind)
+	} else {
+		s.metrics.increment(s.metrics.completed, job.Kind)
+	}
+	s.publishResult(job, jobErr)
+	return nil
+}
+
+// expireLeases hands back leased jobs whose lease ran out, counting the attempt. Jobs out
+// of attempts fail.
+func (s *Swig) expireLeases(ctx context.Context) error {
+	expireSQL := `
+		UPDATE swig_jobs
+		SET status = CASE
+				WHEN attempts >= max_attempts THEN 'failed'
+				ELSE 'pending'
+			END::swig_job_status,
+			worker_id = NULL,
+			locked_at = NULL,
+			lease_expires_at = NULL,
+			last_error = 'Lease expired before the external worker reported back',
+			last_error_at = NOW(),
+			finished_at = CASE
+				WHEN attempts >= max_attempts THEN NOW()
+				ELSE NULL
+			END
+		WHERE id IN (
+			SELECT id
+			FROM swig_jobs
+			WHERE status = 'processing'
+				AND lease_expires_at <= NOW()
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id`
+
+	expired, err := s.countRows(ctx, expireSQL, maintenanceBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to expire leases: %w", err)
+	}
+	if expired > 0 {
+		log.Printf("Handed back %d leased jobs whose lease ran out", expired)
+	}
+	return nil
+}
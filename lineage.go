@@ -0,0 +1,173 @@
+package swig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidPayload is returned by CloneJob for a replacement payload that isn't valid JSON
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// PayloadChange is one difference between a cloned job's payload and the payload of the
+// job it was cloned from
+type PayloadChange struct {
+	// Path is the JSON Pointer of the value that changed, such as "/to" or "/items/2/sku",
+	// or "" when the payloads differ at the top level
+	Path string `json:"path"`
+	// Before is the value in the original job, absent when the clone added it
+	Before json.RawMessage `json:"before,omitempty"`
+	// After is the value in the clone, absent when the clone removed it
+	After json.RawMessage `json:"after,omitempty"`
+}
+
+// CloneJob enqueues a copy of a job, typically one that failed, so it runs again as a new
+// job with a fresh set of attempts while the original keeps its history. The copy keeps
+// the original's kind, queue, priority, metadata and routing, and takes payload in place
+// of the original's when it isn't nil; payload must be at the original's payload
+// version. The clone records the job it was cloned from and that job's payload at the
+// time, so GetJob can show exactly what changed even after the original is pruned.
+// Returns the clone's ID, or ErrJobNotFound if there's no such job.
+//
+// Example:
+//
+//	// Re-enqueue a failed import with the corrected bucket
+//	id, err := swig.CloneJob(ctx, failedID, json.RawMessage(`{"bucket":"imports-eu","key":"2024/06.csv"}`))
+func (s *Swig) CloneJob(ctx context.Context, jobID string, payload json.RawMessage) (string, error) {
+	if !isJobID(jobID) {
+		return "", ErrJobNotFound
+	}
+	var newPayload interface{}
+	if payload != nil {
+		if !json.Valid(payload) {
+			return "", fmt.Errorf("%w: not valid JSON", ErrInvalidPayload)
+		}
+		newPayload = []byte(payload)
+	}
+
+	cloneSQL := `
+		INSERT INTO swig_jobs (
+			kind, queue, payload, priority, max_attempts, payload_version, region, tenant,
+			metadata, context_values, concurrency_key, cloned_from, cloned_from_payload
+		)
+		SELECT kind, queue, COALESCE($2::jsonb, payload), priority, max_attempts, payload_version,
+			region, tenant, metadata, context_values, concurrency_key, id, payload
+		FROM swig_jobs
+		WHERE id = $1
+			AND status <> 'deleted'
+		RETURNING id::text, queue, kind`
+
+	var id, queue, kind string
+	err := s.driver.QueryRow(ctx, cloneSQL, jobID, newPayload).Scan(&id, &queue, &kind)
+	if isNoRows(err) {
+		return "", ErrJobNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to clone job: %w", err)
+	}
+
+	if err := s.notifyJob(ctx, id, queue, kind); err != nil {
+		log.Printf("Failed to notify cloned job %s: %v", id, err)
+	}
+	return id, nil
+}
+
+// payloadChanges returns how a cloned job's payload differs from the payload of the job
+// it was cloned from
+func (s *Swig) payloadChanges(ctx context.Context, job *JobRecord) ([]PayloadChange, error) {
+	var original []byte
+	err := s.driver.QueryRow(ctx, `SELECT cloned_from_payload FROM swig_jobs WHERE id = $1`, job.ID).Scan(&original)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load original payload: %w", err)
+	}
+	if len(original) == 0 {
+		return nil, nil
+	}
+	return diffPayloads(original, job.Payload)
+}
+
+// diffPayloads lists the values that differ between two JSON documents. Objects are
+// compared key by key, in key order, and arrays element by element; anything else that
+// differs is reported whole.
+func diffPayloads(before, after []byte) ([]PayloadChange, error) {
+	var b, a interface{}
+	if err := decodeJSON(before, &b); err != nil {
+		return nil, fmt.Errorf("failed to decode original payload: %w", err)
+	}
+	if err := decodeJSON(after, &a); err != nil {
+		return nil, fmt.Errorf("failed to decode payload: %w", err)
+	}
+	changes := []PayloadChange{}
+	diffValues("", b, a, true, true, &changes)
+	return changes, nil
+}
+
+// decodeJSON decodes data keeping numbers as written, so large integers that differ aren't
+// rounded to the same float64
+func decodeJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// diffValues appends the differences between b and a at path to changes. hasB and hasA
+// say whether the value exists on each side.
+func diffValues(path string, b, a interface{}, hasB, hasA bool, changes *[]PayloadChange) {
+	if hasB && hasA {
+		switch bv := b.(type) {
+		case map[string]interface{}:
+			if av, ok := a.(map[string]interface{}); ok {
+				keys := make(map[string]bool, len(bv)+len(av))
+				for key := range bv {
+					keys[key] = true
+				}
+				for key := range av {
+					keys[key] = true
+				}
+				for _, key := range sortedKeys(keys) {
+					before, inB := bv[key]
+					after, inA := av[key]
+					diffValues(path+"/"+escapePointer(key), before, after, inB, inA, changes)
+				}
+				return
+			}
+		case []interface{}:
+			if av, ok := a.([]interface{}); ok {
+				for i := 0; i < len(bv) || i < len(av); i++ {
+					var before, after interface{}
+					if i < len(bv) {
+						before = bv[i]
+					}
+					if i < len(av) {
+						after = av[i]
+					}
+					diffValues(path+"/"+strconv.Itoa(i), before, after, i < len(bv), i < len(av), changes)
+				}
+				return
+			}
+		}
+		if reflect.DeepEqual(b, a) {
+			return
+		}
+	}
+
+	change := PayloadChange{Path: path}
+	if hasB {
+		change.Before, _ = json.Marshal(b)
+	}
+	if hasA {
+		change.After, _ = json.Marshal(a)
+	}
+	*changes = append(*changes, change)
+}
+
+// escapePointer escapes an object key for use in a JSON Pointer
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
@@ -0,0 +1,234 @@
+package swig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// How often the leader expires jobs that passed their ExpiresAt without running
+const expiryInterval = 10 * time.Second
+
+// How often the leader redacts payloads that are past their retention
+const redactionInterval = time.Minute
+
+// How often the leader prunes soft-deleted jobs past their undo window
+const pruneInterval = time.Minute
+
+// Max rows a single maintenance pass touches, so one pass never holds locks on a huge set of rows
+const maintenanceBatchSize = 1000
+
+// MaintenanceStats describes one maintenance pass, for the hooks set with
+// WithMaintenanceHooks
+type MaintenanceStats struct {
+	Rows     int           // Rows the pass changed, such as jobs requeued, expired or pruned
+	Duration time.Duration // How long the pass took
+	Err      error         // Why the pass failed, nil if it didn't
+}
+
+// MaintenanceHooks are called around every maintenance pass the leader runs, such as
+// "retry", "rescue" or "prune". They run on the pass's goroutine, so keep them quick.
+type MaintenanceHooks struct {
+	OnMaintenanceStart func(name string)
+	OnMaintenanceEnd   func(name string, stats MaintenanceStats)
+}
+
+// maintenanceRowsKey is the context key under which a running pass counts the rows it changes
+type maintenanceRowsKey struct{}
+
+// addMaintenanceRows counts rows a maintenance pass changed towards its MaintenanceStats.
+// Outside a pass it does nothing.
+func addMaintenanceRows(ctx context.Context, n int) {
+	if rows, ok := ctx.Value(maintenanceRowsKey{}).(*int); ok {
+		*rows += n
+	}
+}
+
+// maintenanceTask is a periodic pass run by the leader
+type maintenanceTask struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+// maintenanceTasks lists the passes the leader runs while it holds the lease
+func (s *Swig) maintenanceTasks() []maintenanceTask {
+	tasks := []maintenanceTask{
+		{name: "retry", interval: s.retryInterval, run: s.retryFailedJobs},
+		{name: "expire", interval: expiryInterval, run: s.expireJobs},
+		{name: "expire staged", interval: expiryInterval, run: s.expireStagedJobs},
+		{name: "expire leases", interval: expiryInterval, run: s.expireLeases},
+		{name: "version check", interval: versionCheckInterval, run: s.checkInstanceVersions},
+		{name: "prune events", interval: systemEventPruneInterval, run: s.pruneSystemEvents},
+	}
+	if s.rescueAfter > 0 {
+		tasks = append(tasks, maintenanceTask{name: "rescue", interval: rescueInterval, run: s.rescueAbandonedJobs})
+	}
+	if len(s.payloadRetention) > 0 {
+		tasks = append(tasks, maintenanceTask{name: "redact", interval: redactionInterval, run: s.redactPayloads})
+	}
+	if s.softDeleteWindow > 0 {
+		tasks = append(tasks, maintenanceTask{name: "prune", interval: pruneInterval, run: s.pruneDeletedJobs})
+	}
+	if len(s.jobRetention) > 0 {
+		tasks = append(tasks, maintenanceTask{name: "retention", interval: retentionInterval, run: s.pruneFinishedJobs})
+	}
+	if len(s.compactKinds) > 0 {
+		tasks = append(tasks, maintenanceTask{name: "compact", interval: compactionInterval, run: s.compactDuplicates})
+	}
+	if len(s.shadows) > 0 || s.shadowHandler != nil {
+		tasks = append(tasks, maintenanceTask{name: "shadow", interval: shadowCompareInterval, run: s.compareShadowJobs})
+	}
+	if s.alerts != nil {
+		interval := s.alerts.thresholds.Interval
+		if interval <= 0 {
+			interval = defaultAlertInterval
+		}
+		tasks = append(tasks, maintenanceTask{name: "alert", interval: interval, run: s.checkAlerts})
+	}
+	if s.digestPeriod > 0 {
+		tasks = append(tasks, maintenanceTask{name: "digest", interval: digestCheckInterval, run: s.sendDigest})
+	}
+	return tasks
+}
+
+// runMaintenance runs a task on its interval until leadership ends or Swig shuts down
+func (s *Swig) runMaintenance(ctx context.Context, task maintenanceTask) {
+	ticker := time.NewTicker(task.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+			if err := s.runMaintenancePass(ctx, task); err != nil {
+				// Don't report context cancellation as an error - this is normal during shutdown
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("Error running %s pass: %v", task.name, err)
+			}
+		}
+	}
+}
+
+// runMaintenancePass runs one pass of task within the maintenance timeout, calling the
+// maintenance hooks around it and recording its outcome in swig_events
+func (s *Swig) runMaintenancePass(ctx context.Context, task maintenanceTask) error {
+	if s.maintenanceHooks.OnMaintenanceStart != nil {
+		s.maintenanceHooks.OnMaintenanceStart(task.name)
+	}
+	passCtx, cancel := withQueryTimeout(ctx, s.queryTimeouts.maintenance())
+	defer cancel()
+
+	var rows int
+	started := time.Now()
+	err := task.run(context.WithValue(passCtx, maintenanceRowsKey{}, &rows))
+	if err != nil && errors.Is(passCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		err = fmt.Errorf("%s pass timed out after %s: %w", task.name, s.queryTimeouts.maintenance(), err)
+	}
+	stats := MaintenanceStats{Rows: rows, Duration: time.Since(started), Err: err}
+	if s.maintenanceHooks.OnMaintenanceEnd != nil {
+		s.maintenanceHooks.OnMaintenanceEnd(task.name, stats)
+	}
+	s.recordMaintenancePass(ctx, task.name, stats)
+	return err
+}
+
+// expireJobs moves jobs that are still waiting to run after their expires_at to the
+// terminal 'expired' status
+func (s *Swig) expireJobs(ctx context.Context) error {
+	expireSQL := `
+		UPDATE swig_jobs
+		SET status = 'expired',
+			next_retry_at = NULL,
+			finished_at = NOW()
+		WHERE id IN (
+			SELECT id
+			FROM swig_jobs
+			WHERE status IN ('pending', 'retryable', 'scheduled')
+				AND expires_at <= NOW()
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id`
+
+	rows, err := s.driver.Query(ctx, expireSQL, maintenanceBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to expire jobs: %w", err)
+	}
+	defer rows.Close()
+
+	expired := 0
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("failed to scan job ID: %w", err)
+		}
+		expired++
+	}
+
+	addMaintenanceRows(ctx, expired)
+	if expired > 0 {
+		log.Printf("Expired %d jobs that passed their deadline before running", expired)
+	}
+	return nil
+}
+
+// redactPayloads scrubs the payload of finished jobs once they're older than their kind's
+// retention, along with everything else that can carry the same data: the result, labels,
+// propagated context values, checkpoint and error details. Soft-deleted jobs that had
+// finished are scrubbed too. The row itself is kept for stats.
+func (s *Swig) redactPayloads(ctx context.Context) error {
+	redactSQL := `
+		UPDATE swig_jobs
+		SET payload = '{}'::jsonb,
+			result = NULL,
+			cloned_from_payload = NULL,
+			metadata = '{}'::jsonb,
+			context_values = NULL,
+			checkpoint = NULL,
+			last_error_details = NULL,
+			payload_redacted_at = NOW()
+		WHERE id IN (
+			SELECT id
+			FROM swig_jobs
+			WHERE kind = $1
+				AND (status IN ('completed', 'failed', 'expired')
+					OR (status = 'deleted' AND status_before_delete IN ('completed', 'failed', 'expired')))
+				AND payload_redacted_at IS NULL
+				AND finished_at <= NOW() - (interval '1 second' * $2)
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id`
+
+	for kind, retention := range s.payloadRetention {
+		rows, err := s.driver.Query(ctx, redactSQL, kind, retention.Seconds(), maintenanceBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to redact %s payloads: %w", kind, err)
+		}
+
+		redacted := 0
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan job ID: %w", err)
+			}
+			redacted++
+		}
+		rows.Close()
+
+		addMaintenanceRows(ctx, redacted)
+		if redacted > 0 {
+			log.Printf("Redacted payloads of %d finished %s jobs", redacted, kind)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package swig
+
+import (
+	"sync"
+)
+
+// Metrics is a snapshot of what this instance has processed since it started, keyed by kind
+// unless noted otherwise
+type Metrics struct {
+	Completed        map[string]int64 // Jobs that completed successfully
+	Failed           map[string]int64 // Failed attempts, including ones that will be retried
+	SlowJobs         map[string]int64 // Jobs that ran past their expected duration
+	AcquireConflicts map[string]int64 // Acquisitions retried after a deadlock, serialization failure or lock timeout, by queue
+	Reconciled       map[string]int64 // Jobs an idle worker's poll found because their notification was lost, by queue
+}
+
+// metricsRecorder accumulates the counters behind Metrics
+type metricsRecorder struct {
+	mu               sync.Mutex
+	completed        map[string]int64
+	failed           map[string]int64
+	slowJobs         map[string]int64
+	acquireConflicts map[string]int64
+	reconciled       map[string]int64
+}
+
+func newMetricsRecorder() *metricsRecorder {
+	return &metricsRecorder{
+		completed:        make(map[string]int64),
+		failed:           make(map[string]int64),
+		slowJobs:         make(map[string]int64),
+		acquireConflicts: make(map[string]int64),
+		reconciled:       make(map[string]int64),
+	}
+}
+
+// increment adds one to kind in counter
+func (m *metricsRecorder) increment(counter map[string]int64, kind string) {
+	m.mu.Lock()
+	counter[kind]++
+	m.mu.Unlock()
+}
+
+// Metrics returns the job counters of this instance. They're kept in memory and reset
+// when the process restarts.
+func (s *Swig) Metrics() Metrics {
+	m := s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return Metrics{
+		Completed:        copyCounts(m.completed),
+		Failed:           copyCounts(m.failed),
+		SlowJobs:         copyCounts(m.slowJobs),
+		AcquireConflicts: copyCounts(m.acquireConflicts),
+		Reconciled:       copyCounts(m.reconciled),
+	}
+}
+
+func copyCounts(counts map[string]int64) map[string]int64 {
+	copied := make(map[string]int64, len(counts))
+	for k, v := range counts {
+		copied[k] = v
+	}
+	return copied
+}
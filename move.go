@@ -0,0 +1,106 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Statuses of jobs MoveJobs can move: those that haven't started yet
+var movableStatuses = []string{"pending", "retryable", "scheduled"}
+
+// Most notifications MoveJobs sends for one move. Each wakes an idle worker, which keeps
+// acquiring until the queue is empty, so more would only be dropped.
+const moveNotifyLimit = 100
+
+// MoveJobs forwards the jobs matching filter that haven't started yet to targetQueue and
+// returns how many were moved. It's meant for incidents, to shift a backlog onto a queue
+// with more workers or onto an overflow queue started for the purpose. Moved jobs that are
+// ready to run are announced so workers of targetQueue pick them up straight away.
+//
+// filter.Statuses narrows the move to some of pending, retryable and scheduled. Jobs being
+// processed, finished, staged or deleted are never moved. A zero filter.Limit moves every
+// matching job; Sort and Offset are ignored.
+//
+// Example:
+//
+//	// Drain the report backlog onto the overflow queue
+//	moved, err := swig.MoveJobs(ctx, swig.JobFilter{Queue: swig.Default, Kind: "generate_report"}, "overflow")
+func (s *Swig) MoveJobs(ctx context.Context, filter JobFilter, targetQueue QueueTypes) (int, error) {
+	if targetQueue == "" {
+		return 0, fmt.Errorf("a target queue is required to move jobs")
+	}
+
+	statuses := movableStatuses
+	if len(filter.Statuses) > 0 {
+		statuses = nil
+		for _, status := range filter.Statuses {
+			for _, movable := range movableStatuses {
+				if status == movable {
+					statuses = append(statuses, status)
+				}
+			}
+		}
+		if len(statuses) == 0 {
+			return 0, nil
+		}
+	}
+	filter.Statuses = statuses
+
+	conditions, args := filterConditions(filter)
+	conditions = append(conditions, fmt.Sprintf("queue <> $%d", len(args)+1))
+	args = append(args, string(targetQueue))
+
+	limit := ""
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		limit = fmt.Sprintf("LIMIT $%d", len(args))
+	}
+
+	moveSQL := fmt.Sprintf(`
+		UPDATE swig_jobs
+		SET queue = $%d
+		WHERE id IN (
+			SELECT id
+			FROM swig_jobs
+			WHERE %s
+			%s
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, status = 'pending' AND scheduled_for <= NOW()`,
+		len(args)+1, strings.Join(conditions, " AND "), limit)
+	args = append(args, string(targetQueue))
+
+	rows, err := s.driver.Query(ctx, moveSQL, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to move jobs: %w", err)
+	}
+	defer rows.Close()
+
+	type movedJob struct{ id, kind string }
+	var ready []movedJob
+	moved := 0
+	for rows.Next() {
+		var job movedJob
+		var isReady bool
+		if err := rows.Scan(&job.id, &job.kind, &isReady); err != nil {
+			return moved, fmt.Errorf("failed to scan moved job: %w", err)
+		}
+		moved++
+		if isReady && len(ready) < moveNotifyLimit {
+			ready = append(ready, job)
+		}
+	}
+	rows.Close()
+
+	for _, job := range ready {
+		if err := s.notifyJob(ctx, job.id, string(targetQueue), job.kind); err != nil {
+			log.Printf("Failed to notify moved job %s: %v", job.id, err)
+		}
+	}
+	if moved > 0 {
+		log.Printf("Moved %d jobs to queue %s", moved, targetQueue)
+	}
+	return moved, nil
+}
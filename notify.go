@@ -0,0 +1,371 @@
+package swig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// How long an idle worker waits for a notification before checking for jobs anyway, unless
+// WithReconcileInterval says otherwise. Jobs that become due without an insert, like
+// scheduled jobs and retries, are found this way, as are jobs whose notification was lost.
+const notifyPollInterval = 5 * time.Second
+
+// Why waitForJob returned
+type wokenBy int
+
+const (
+	wokenByNotification wokenBy = iota // A notification or a local wake-up
+	wokenByPoll                        // The poll interval passed
+	wokenToStop                        // The worker should stop
+)
+
+// polledKey marks the context of an acquisition made by an idle worker's poll
+type polledKey struct{}
+
+// polled reports whether ctx belongs to an acquisition made by an idle worker's poll
+func polled(ctx context.Context) bool {
+	p, _ := ctx.Value(polledKey{}).(bool)
+	return p
+}
+
+// How often idle workers check for jobs in polling mode, when there are no notifications
+const pollingModeInterval = time.Second
+
+// Each notification's claim token picks roughly one in claimGroups instances to go for the
+// job straight away. The rest wait claimDelay plus up to claimJitter first, by which time
+// the job has usually been taken and their acquire is a cheap miss rather than a lock fight.
+const (
+	claimGroups = 4
+	claimDelay  = 200 * time.Millisecond
+	claimJitter = 300 * time.Millisecond
+)
+
+// Notifications for jobs due within this much of our clock are treated as ready, so a
+// little clock skew between Postgres and this instance doesn't delay them until the next poll
+const notifyClockSkew = time.Second
+
+// Version of the jobNotification envelope this instance sends. Payloads without a version
+// are version 1, sent by the trigger before the envelope was versioned.
+//
+// Rolling upgrades mix instances of different versions on one channel, so the envelope only
+// ever grows: new versions may add fields, but never remove or change the meaning of
+// existing ones. Older instances ignore fields they don't know, and a payload they can't
+// decode at all still wakes the workers of its queue.
+const notifyVersion = 2
+
+// jobNotification is the payload sent on jobsChannel for new jobs and leader events
+type jobNotification struct {
+	Version      int    `json:"v,omitempty"`
+	ID           string `json:"id"`
+	Queue        string `json:"queue"`
+	Kind         string `json:"kind"`
+	ScheduledFor string `json:"scheduled_for"`
+	Origin       string `json:"origin"` // Instance that inserted the job, if it was a Swig instance
+	Claim        uint32 `json:"claim"`  // Random token choosing which instances try the job first
+	Event        string `json:"event"`
+}
+
+// newJobWake creates a notification channel for each configured queue, buffered so every
+// worker of the queue can have one pending wake-up
+func newJobWake(configs []SwigQueueConfig) map[QueueTypes]chan jobNotification {
+	wake := make(map[QueueTypes]chan jobNotification)
+	for _, config := range configs {
+		size := config.MaxWorkers
+		if size < minWorkers {
+			size = minWorkers
+		}
+		if existing, ok := wake[config.QueueType]; ok {
+			size += cap(existing)
+		}
+		wake[config.QueueType] = make(chan jobNotification, size)
+	}
+	return wake
+}
+
+// dispatchNotifications receives notifications for the whole instance and hands them to
+// idle workers of the queues they concern. Notifications that no worker here can act on
+// are dropped: jobs scheduled for later, queues this instance doesn't run, and jobs this
+// instance inserted itself, whose workers were already woken locally.
+// The driver's listener reconnects and resubscribes by itself when its connection drops;
+// since notifications are lost in the meantime, every worker is woken once it's back.
+func (s *Swig) dispatchNotifications(ctx context.Context) {
+	// Stop waiting on the listener as soon as Swig shuts down, so the driver can close it
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		err := s.driver.Listen(ctx, jobsChannel)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Failed to start listening: %v", err)
+		time.Sleep(time.Second)
+	}
+	// Workers went for the backlog as soon as they started, but jobs inserted before the
+	// subscription took effect notified nobody here; have idle workers check again now
+	// rather than at their next poll
+	s.wakeAll()
+
+	for {
+		notification, err := s.driver.WaitForNotification(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, drivers.ErrNotificationsMissed) {
+			log.Printf("Notification listener reconnected, checking all queues for missed jobs")
+			s.wakeAll()
+			continue
+		}
+		if err != nil {
+			log.Printf("Notification error: %v", err)
+			// Small backoff on error
+			time.Sleep(time.Second)
+			continue
+		}
+		if notification == nil || notification.Payload == "" {
+			continue
+		}
+
+		n, ok := decodeNotification(notification.Payload)
+		if !ok {
+			continue
+		}
+		s.routeNotification(n)
+	}
+}
+
+// decodeNotification parses a payload from jobsChannel. When a payload from a newer
+// version can't be decoded in full, the queue is all that's kept, which is enough to
+// wake a worker there; it reports false only for payloads that aren't usable at all.
+func decodeNotification(payload string) (jobNotification, bool) {
+	var n jobNotification
+	if err := json.Unmarshal([]byte(payload), &n); err == nil {
+		return n, true
+	}
+
+	var envelope struct {
+		Version int    `json:"v"`
+		Queue   string `json:"queue"`
+	}
+	if err := json.Unmarshal([]byte(payload), &envelope); err != nil || envelope.Version <= notifyVersion || envelope.Queue == "" {
+		return jobNotification{}, false
+	}
+	return jobNotification{Version: envelope.Version, Queue: envelope.Queue}, true
+}
+
+// routeNotification wakes workers that can act on n
+func (s *Swig) routeNotification(n jobNotification) {
+	if n.Event == leaderReleasedEvent {
+		// The leader stepped down, hold an election now rather than waiting for its lease to expire
+		s.triggerElection()
+		return
+	}
+	if n.Origin == s.workerID {
+		return
+	}
+	if n.ID == "" {
+		// A newer instance's job we could only make out the queue of
+		if n.Version > notifyVersion && n.Queue != "" {
+			s.wakeWorkers(QueueTypes(n.Queue), n)
+		}
+		return
+	}
+	if n.ScheduledFor != "" {
+		if scheduledFor, err := time.Parse(time.RFC3339Nano, n.ScheduledFor); err == nil &&
+			scheduledFor.After(s.dbNow().Add(notifyClockSkew)) {
+			// Not due yet; an idle worker's poll will find it when it is
+			return
+		}
+	}
+	if n.Claim != 0 && !s.claimsFirst(n.Claim) {
+		delay := claimDelay + time.Duration(rand.Int63n(int64(claimJitter)))
+		time.AfterFunc(delay, func() { s.wakeWorkers(QueueTypes(n.Queue), n) })
+		return
+	}
+	s.wakeWorkers(QueueTypes(n.Queue), n)
+}
+
+// claimToken returns a random nonzero claim token. Zero means the sender didn't pick one,
+// and every bit is left random so claimsFirst spreads first claims evenly.
+func claimToken() uint32 {
+	for {
+		if claim := rand.Uint32(); claim != 0 {
+			return claim
+		}
+	}
+}
+
+// claimsFirst reports whether this instance is among those a claim token picks to try
+// for the job without delay
+func (s *Swig) claimsFirst(claim uint32) bool {
+	h := fnv.New32a()
+	h.Write([]byte(s.workerID))
+	return (h.Sum32()^claim)%claimGroups == 0
+}
+
+// wakeWorkers hands n to an idle worker that would pick up jobs on queue. Workers of every
+// queue take priority jobs first, so those can wake any of them.
+func (s *Swig) wakeWorkers(queue QueueTypes, n jobNotification) {
+	if queue == Priority {
+		if s.wake(Priority, n) {
+			return
+		}
+		for q := range s.jobWake {
+			if s.wake(q, n) {
+				return
+			}
+		}
+		return
+	}
+	s.wake(queue, n)
+}
+
+// wake passes n to a worker of queue without blocking, reporting whether one can take it.
+// When all of them already have a pending wake-up they'll find the job on their next acquire.
+func (s *Swig) wake(queue QueueTypes, n jobNotification) bool {
+	ch, ok := s.jobWake[queue]
+	if !ok || (n.Kind != "" && !s.acceptsKind(queue, n.Kind)) {
+		return false
+	}
+	select {
+	case ch <- n:
+		return true
+	default:
+		return false
+	}
+}
+
+// wakeAll wakes every idle worker so they check their queues
+func (s *Swig) wakeAll() {
+	for queue := range s.jobWake {
+		for s.wake(queue, jobNotification{Queue: string(queue)}) {
+		}
+	}
+}
+
+// waitForJob blocks an idle worker until it's woken for a job or the poll interval passes.
+// It returns the notification it was woken with, if any, and what woke it.
+func (s *Swig) waitForJob(ctx context.Context, queue QueueTypes) (jobNotification, wokenBy) {
+	interval := s.pollInterval
+	if s.pollingMode.Load() {
+		interval = pollingModeInterval
+	}
+	// Spread polls by up to a fifth of the interval so idle workers don't poll in lockstep
+	interval += time.Duration(rand.Int63n(int64(interval / 5)))
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-s.shutdown:
+	case <-s.draining:
+	case <-timer.C:
+		return jobNotification{}, wokenByPoll
+	case n := <-s.jobWake[queue]:
+		return n, wokenByNotification
+	}
+	return jobNotification{}, wokenToStop
+}
+
+// detectTransactionPooler switches to polling mode when the driver finds it's connected
+// through a transaction-mode pooler, where a LISTEN only lasts as long as the statement that
+// ran it. Leadership and migrations only rely on transaction-scoped locks and the lease
+// table, so they work either way. It returns why LISTEN can't be relied on, if it can't.
+func (s *Swig) detectTransactionPooler(ctx context.Context) error {
+	if s.pollingMode.Load() {
+		return nil
+	}
+	detector, ok := s.driver.(drivers.PoolerDetector)
+	if !ok {
+		return nil
+	}
+	pooled, err := detector.DetectTransactionPooler(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check for a connection pooler: %w", err)
+	}
+	if pooled {
+		s.pollingMode.Store(true)
+		return fmt.Errorf("connected through a transaction-mode pooler such as PgBouncer. "+
+			"LISTEN/NOTIFY is unreliable there, so workers will poll for jobs every %s instead. "+
+			"Connect Swig directly or through a session-mode pool to get real-time notifications", pollingModeInterval)
+	}
+	return nil
+}
+
+// insertJobs inserts jobs on behalf of this instance. The insert trigger, or announceJobs
+// in trigger-less mode, tags their notifications with our ID so the dispatcher skips them,
+// and our own workers are woken directly once the jobs are committed.
+func (s *Swig) insertJobs(ctx context.Context, jobs []drivers.BatchJob) error {
+	jobs, err := s.prepareJobs(ctx, jobs)
+	if err != nil {
+		return err
+	}
+	err = s.driver.WithTx(ctx, func(tx drivers.Transaction) error {
+		if err := tx.Exec(ctx, `SELECT set_config('swig.origin', $1, true)`, s.workerID); err != nil {
+			return fmt.Errorf("failed to tag job origin: %w", err)
+		}
+		if err := s.checkTenantQuotas(ctx, tx, jobs); err != nil {
+			return err
+		}
+		if err := drivers.InsertJobs(ctx, tx, jobs); err != nil {
+			return err
+		}
+		if s.dryRun {
+			return errDryRun
+		}
+		return s.announceJobs(ctx, tx, jobs, s.workerID)
+	})
+	if errors.Is(err, errDryRun) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := s.dbNow().Add(notifyClockSkew)
+	for _, job := range jobs {
+		if job.Opts.StagingToken != "" || job.Opts.RunIn > notifyClockSkew || job.Opts.RunAt.Add(job.Opts.RunIn).After(now) {
+			continue
+		}
+		s.wakeWorkers(QueueTypes(job.Opts.Queue), jobNotification{Queue: job.Opts.Queue})
+	}
+	return nil
+}
+
+// notifyJob wakes workers for a job that became ready without being inserted, using the
+// same payload as the insert trigger. Where the database can't notify, workers find the
+// job when they poll.
+func (s *Swig) notifyJob(ctx context.Context, jobID, queue, kind string) error {
+	if !s.driverCaps.ListenNotify {
+		return nil
+	}
+	payload, err := json.Marshal(jobNotification{
+		Version: notifyVersion,
+		ID:      jobID,
+		Queue:   queue,
+		Kind:    kind,
+		Claim:   claimToken(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+	return s.driver.Notify(ctx, jobsChannel, string(payload))
+}
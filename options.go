@@ -0,0 +1,857 @@
+package swig
+
+import "time"
+
+// Option configures optional behaviour of a Swig instance. Options are passed to NewSwig
+// after the worker registry.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithRetryInterval(30*time.Second),
+//	    WithRetryBatchSize(500),
+//	)
+type Option func(*Swig)
+
+// WithRetryInterval sets how often the leader looks for failed jobs to retry.
+// Defaults to 5 seconds.
+func WithRetryInterval(interval time.Duration) Option {
+	return func(s *Swig) {
+		if interval > 0 {
+			s.retryInterval = interval
+		}
+	}
+}
+
+// WithRetryBatchSize caps how many failed jobs the leader requeues in a single retry pass.
+// Anything left over is picked up by the next pass. Defaults to 1000.
+func WithRetryBatchSize(size int) Option {
+	return func(s *Swig) {
+		if size > 0 {
+			s.retryBatchSize = size
+		}
+	}
+}
+
+// WithRetryRateLimit caps how many retries the leader promotes back to pending in each
+// window of per, on top of WithRetryBatchSize. After a mass failure, such as a bad deploy
+// or an outage of a dependency, retries then trickle back instead of arriving in waves
+// that knock the recovering dependency over again. Retries over the limit wait for a
+// later window.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithRetryRateLimit(200, time.Minute),
+//	    WithRetryJitter(30*time.Second),
+//	)
+func WithRetryRateLimit(limit int, per time.Duration) Option {
+	return func(s *Swig) {
+		if limit > 0 && per > 0 {
+			s.retryRateLimit = &retryRateLimit{limit: limit, per: per}
+		}
+	}
+}
+
+// WithRetryJitter delays each promoted retry by a random amount up to max past its
+// backoff, so jobs that failed together don't all retry at the same moment
+func WithRetryJitter(max time.Duration) Option {
+	return func(s *Swig) {
+		if max >= 0 {
+			s.retryJitter = max
+		}
+	}
+}
+
+// WithInstanceName gives this instance a stable name, such as its pod or host name, that
+// it keeps across restarts. The random instance ID still changes with every start; the name
+// is recorded next to it in swig_instances, on the jobs the instance runs and in its logs,
+// so restarts of the same deployment unit can be correlated. On Start, jobs still marked as
+// processing by an earlier run with the same name are released straight away instead of
+// waiting for the rescuer, since that run must have died without cleaning up. Names must be
+// unique among running instances.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithInstanceName(os.Getenv("POD_NAME")),
+//	)
+func WithInstanceName(name string) Option {
+	return func(s *Swig) {
+		s.instanceName = name
+	}
+}
+
+// WithRescueAfter sets how long a job can stay processing before the leader assumes the
+// instance running it crashed and releases it for another attempt. Set it well above your
+// longest job, or 0 to never rescue jobs. Defaults to 1 hour.
+func WithRescueAfter(timeout time.Duration) Option {
+	return func(s *Swig) {
+		if timeout >= 0 {
+			s.rescueAfter = timeout
+		}
+	}
+}
+
+// WithPayloadRetention redacts the payload of finished jobs of the given kind once they've
+// been in a terminal state (completed, failed or expired) for longer than retention.
+// The payload and metadata are replaced with empty JSON objects, and the result, context
+// values, checkpoint and error details are cleared, including on soft-deleted jobs; the
+// row itself is kept for stats.
+// Use it for kinds whose arguments carry personal data that mustn't be kept indefinitely.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithPayloadRetention("send_email", 30*24*time.Hour),
+//	)
+func WithPayloadRetention(kind string, retention time.Duration) Option {
+	return func(s *Swig) {
+		if s.payloadRetention == nil {
+			s.payloadRetention = make(map[string]time.Duration)
+		}
+		s.payloadRetention[kind] = retention
+	}
+}
+
+// WithSoftDelete makes DeleteJob and CancelMany mark jobs as deleted instead of removing
+// them. Deleted jobs aren't processed and are hidden from ListJobs, and can be brought back
+// with RestoreJob until undoWindow has passed, after which the leader prunes them for good.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithSoftDelete(24*time.Hour),
+//	)
+func WithSoftDelete(undoWindow time.Duration) Option {
+	return func(s *Swig) {
+		if undoWindow > 0 {
+			s.softDeleteWindow = undoWindow
+		}
+	}
+}
+
+// WithAlerts has the leader evaluate thresholds for every queue and tell notifier when one
+// is exceeded, and again when it recovers. Zero thresholds are not checked.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithAlerts(AlertThresholds{
+//	        QueueDepth:    10000,
+//	        OldestPending: 15 * time.Minute,
+//	        FailureRate:   0.2,
+//	    }, WebhookNotifier{URL: "https://hooks.slack.com/services/..."}),
+//	)
+func WithAlerts(thresholds AlertThresholds, notifier Notifier) Option {
+	return func(s *Swig) {
+		if notifier == nil {
+			return
+		}
+		s.alerts = &alerting{
+			thresholds: thresholds,
+			notifier:   notifier,
+			firing:     make(map[string]bool),
+		}
+	}
+}
+
+// WithPollingMode makes workers poll for jobs instead of relying on LISTEN/NOTIFY.
+// Swig switches to polling by itself when it detects a transaction-mode pooler such as
+// PgBouncer, but not every pooler can be detected; use this when Swig connects through one.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithPollingMode(),
+//	)
+func WithPollingMode() Option {
+	return func(s *Swig) {
+		s.pollingMode.Store(true)
+	}
+}
+
+// WithMaxInFlightBytes caps the combined size of the jobs this instance processes at once,
+// so a burst of huge jobs can't exhaust memory. A job counts as its payload size, or as its
+// kind's WithMemoryHint if that's larger. Jobs that don't fit stay pending until running
+// jobs finish; a job bigger than the whole budget still runs when nothing else is.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithMaxInFlightBytes(512<<20),
+//	    WithMemoryHint("render_video", 256<<20),
+//	)
+func WithMaxInFlightBytes(maxBytes int64) Option {
+	return func(s *Swig) {
+		if maxBytes <= 0 {
+			return
+		}
+		if s.inFlight == nil {
+			s.inFlight = &inFlightBudget{memoryHints: make(map[string]int64)}
+		}
+		s.inFlight.maxBytes = maxBytes
+	}
+}
+
+// WithMemoryHint estimates how much memory a job of the given kind needs while it runs,
+// for kinds whose small payload doesn't reflect their footprint, like a job that downloads
+// and transforms a large file. It's only used with WithMaxInFlightBytes.
+func WithMemoryHint(kind string, bytes int64) Option {
+	return func(s *Swig) {
+		if s.inFlight == nil {
+			s.inFlight = &inFlightBudget{memoryHints: make(map[string]int64)}
+		}
+		s.inFlight.memoryHints[kind] = bytes
+	}
+}
+
+// WithRegion tags the instance with the region or datacenter it runs in. Jobs enqueued with
+// JobOptions.Region only run on instances tagged with the same region; untagged instances
+// only run jobs without a region.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithRegion("eu-west-1"),
+//	)
+func WithRegion(region string) Option {
+	return func(s *Swig) {
+		s.region = region
+	}
+}
+
+// WithCapabilities labels this instance with capabilities, such as "gpu". Kinds declared
+// with RequireCapabilities only run on instances that have all the capabilities they need.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithCapabilities("gpu"),
+//	)
+func WithCapabilities(capabilities ...string) Option {
+	return func(s *Swig) {
+		s.capabilities = append(s.capabilities, capabilities...)
+	}
+}
+
+// WithExpectedDuration sets how long jobs of a kind normally take. A job still running after
+// that logs a warning, counts towards Metrics().SlowJobs and calls the WithSlowJobHandler
+// handler, while it carries on running. It catches regressions, like a report that got ten
+// times slower, before the queue backs up.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithExpectedDuration("generate_report", 2*time.Minute),
+//	)
+func WithExpectedDuration(kind string, expected time.Duration) Option {
+	return func(s *Swig) {
+		if s.expectedDurations == nil {
+			s.expectedDurations = make(map[string]time.Duration)
+		}
+		s.expectedDurations[kind] = expected
+	}
+}
+
+// WithSlowJobHandler sets a function to call when a job runs past its kind's
+// WithExpectedDuration, for example to page someone or emit a metric.
+func WithSlowJobHandler(handler SlowJobHandler) Option {
+	return func(s *Swig) {
+		s.slowJobHandler = handler
+	}
+}
+
+// WithMaxJobsPerWorker replaces each worker goroutine with a fresh one after it has
+// processed n jobs. It's a pragmatic mitigation for workers whose native libraries leak
+// memory or other per-thread state over long runs. Combine it with WithDrainOnMaxJobs to
+// restart the whole process instead.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithMaxJobsPerWorker(1000),
+//	)
+func WithMaxJobsPerWorker(n int) Option {
+	return func(s *Swig) {
+		if n > 0 {
+			s.maxJobsPerWorker = n
+		}
+	}
+}
+
+// WithDrainOnMaxJobs makes the first worker to reach its WithMaxJobsPerWorker limit drain
+// the whole instance rather than being replaced: every worker stops taking new jobs, and
+// once the running ones have finished onDrained is called. Use it to exit the process and
+// let the process manager start a clean one.
+//
+// Example:
+//
+//	drained := make(chan struct{})
+//	swig := NewSwig(driver, configs, workers,
+//	    WithMaxJobsPerWorker(1000),
+//	    WithDrainOnMaxJobs(func() { close(drained) }),
+//	)
+//	swig.Start(ctx)
+//
+//	<-drained
+//	swig.Stop(ctx)
+func WithDrainOnMaxJobs(onDrained func()) Option {
+	return func(s *Swig) {
+		s.onDrained = onDrained
+	}
+}
+
+// WithNotifyDedup skips the notification for a job when an identical job, with the same
+// kind and payload, was enqueued within window and is still pending. The earlier job's
+// notification already wakes a worker, so naive producers that enqueue the same job over
+// and over don't flood every instance with wake-ups. The duplicate is still inserted and
+// processed. The window is stored in the database on Start and applies to every producer,
+// including ones enqueueing inside their own transactions; a zero window turns it off.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithNotifyDedup(time.Minute),
+//	)
+func WithNotifyDedup(window time.Duration) Option {
+	return func(s *Swig) {
+		s.notifyDedupWindow = &window
+	}
+}
+
+// WithTransactionalKinds processes jobs of the given kinds inside the transaction that
+// acquired them, with the job's row locked until Process returns and the job is marked
+// completed in the same commit. Process can write through TxFromContext so its effects
+// commit with the job's completion, giving exactly-once-per-commit behaviour. Each running
+// job holds a connection for its whole run, so use it for short jobs that need the
+// strongest guarantee.
+//
+// A job whose Process fails is rolled back, then recorded as failed or retryable as usual.
+// One whose instance dies mid-run is rolled back by the database and runs again without
+// counting an attempt.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithTransactionalKinds("charge_invoice"),
+//	)
+func WithTransactionalKinds(kinds ...string) Option {
+	return func(s *Swig) {
+		if s.transactionalKinds == nil {
+			s.transactionalKinds = make(map[string]bool)
+		}
+		for _, kind := range kinds {
+			s.transactionalKinds[kind] = true
+		}
+	}
+}
+
+// WithTenantQuota caps how many pending jobs each tenant may have in a queue. Enqueueing a
+// job with a JobOptions.Tenant that's already at its limit fails with
+// ErrTenantQuotaExceeded, so one noisy customer can't degrade everyone else's latency.
+// Jobs without a tenant aren't limited. Use WithTenantQuotaFor to give particular tenants
+// a different limit.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithTenantQuota(1000),
+//	    WithTenantQuotaFor("enterprise-co", 10000),
+//	)
+func WithTenantQuota(limit int) Option {
+	return func(s *Swig) {
+		if s.tenantQuotas == nil {
+			s.tenantQuotas = &tenantQuotas{limits: make(map[string]int)}
+		}
+		s.tenantQuotas.defaultLimit = limit
+	}
+}
+
+// WithTenantQuotaFor sets the pending job quota per queue for one tenant, overriding
+// WithTenantQuota. A limit of 0 exempts the tenant.
+func WithTenantQuotaFor(tenant string, limit int) Option {
+	return func(s *Swig) {
+		if s.tenantQuotas == nil {
+			s.tenantQuotas = &tenantQuotas{limits: make(map[string]int)}
+		}
+		s.tenantQuotas.limits[tenant] = limit
+	}
+}
+
+// WithIDScheme sets how job and instance IDs are generated. Time-ordered schemes make IDs
+// sort by creation time, which improves index locality and lets you narrow down jobs by
+// ID range when debugging. On Start the swig_jobs id column default is switched to match,
+// so jobs inserted by other producers get the same kind of ID.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithIDScheme(IDSchemeUUIDv7),
+//	)
+func WithIDScheme(scheme IDScheme) Option {
+	return func(s *Swig) {
+		generator, ok := idGenerators[scheme]
+		if !ok {
+			return
+		}
+		s.idGenerator = generator
+		s.idDefault = idSchemeDefaults[scheme]
+		s.workerID = generator()
+	}
+}
+
+// WithIDGenerator generates job and instance IDs with a custom function. IDs must be valid
+// UUIDs. Unlike WithIDScheme it leaves the database default for job IDs alone.
+func WithIDGenerator(generator func() string) Option {
+	return func(s *Swig) {
+		if generator == nil {
+			return
+		}
+		s.idGenerator = generator
+		s.workerID = generator()
+	}
+}
+
+// WithLatencySLO sets how soon jobs of a kind should start once they're due, and lets
+// Swig choose their priority instead of callers hardcoding one. The instance measures how
+// long ready jobs of the kind have been waiting and gives new ones a priority that rises
+// as that approaches the SLO, overriding JobOptions.Priority. Enqueue middleware runs
+// afterwards and can still adjust it.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithLatencySLO("send_email", 30*time.Second),
+//	)
+func WithLatencySLO(kind string, target time.Duration) Option {
+	return func(s *Swig) {
+		if target <= 0 {
+			return
+		}
+		if s.slos == nil {
+			s.slos = &latencySLOs{targets: make(map[string]time.Duration)}
+		}
+		s.slos.targets[kind] = target
+	}
+}
+
+// WithDryRun makes the instance run every check on the jobs it's asked to enqueue without
+// ever enqueueing them: serialization, enqueue middleware, tenant quotas and the insert
+// itself, which is rolled back. Enqueue calls return the same errors they otherwise would.
+// Use it in tests and canaries to exercise producers against a real database. Jobs passed
+// to AddJobWithTx are rolled back to a savepoint, leaving the rest of the caller's
+// transaction alone.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithDryRun(),
+//	)
+func WithDryRun() Option {
+	return func(s *Swig) {
+		s.dryRun = true
+	}
+}
+
+// WithOptionalWorkers lets Start succeed when the Setup of these kinds' workers fails (see
+// workers.Setuper). The failure is logged and the instance leaves jobs of the kind to
+// instances where Setup succeeded. A failed Setup of any other worker fails Start.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithOptionalWorkers("generate_thumbnail"),
+//	)
+func WithOptionalWorkers(kinds ...string) Option {
+	return func(s *Swig) {
+		if s.optionalKinds == nil {
+			s.optionalKinds = make(map[string]bool)
+		}
+		for _, kind := range kinds {
+			s.optionalKinds[kind] = true
+		}
+	}
+}
+
+// WithContextPropagation carries values from the context a job is enqueued with into the
+// context its Process runs with, so logs and downstream calls keep request correlation
+// across the async boundary. Values are stored with the job, so only propagate what's
+// safe to persist.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithContextPropagation(
+//	        PropagateContextKey("request_id", requestIDKey{}),
+//	        PropagateContextKey("locale", localeKey{}),
+//	    ),
+//	)
+func WithContextPropagation(propagators ...ContextPropagator) Option {
+	return func(s *Swig) {
+		s.propagators = append(s.propagators, propagators...)
+	}
+}
+
+// WithStagingTimeout sets how long jobs added with AddStagedJob wait to be released
+// before the leader expires them. The default is an hour.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithStagingTimeout(10*time.Minute),
+//	)
+func WithStagingTimeout(timeout time.Duration) Option {
+	return func(s *Swig) {
+		if timeout > 0 {
+			s.stagingTimeout = timeout
+		}
+	}
+}
+
+// WithShadow mirrors a share of the jobs of kind to the worker registered as config.Kind,
+// for rolling out a rewritten worker against production traffic. Mirrored jobs get the same
+// payload and go to their own queue (Shadow unless config.Queue says otherwise), so
+// configure workers for it. The production job runs exactly as before; what the shadow
+// does is up to its worker, so it shouldn't have side effects of its own. Pair it with
+// WithShadowHandler to compare the two.
+//
+// Example:
+//
+//	swig := NewSwig(driver, append(configs, SwigQueueConfig{QueueType: Shadow, MaxWorkers: 2}), workers,
+//	    WithShadow("render_invoice", ShadowConfig{Kind: "render_invoice_v2", Percent: 10}),
+//	)
+func WithShadow(kind string, config ShadowConfig) Option {
+	return func(s *Swig) {
+		if config.Kind == "" || config.Percent <= 0 {
+			return
+		}
+		if s.shadows == nil {
+			s.shadows = make(map[string]ShadowConfig)
+		}
+		s.shadows[kind] = config
+	}
+}
+
+// WithShadowHandler sets the function the leader calls once both a production job and its
+// shadow have finished, with the two jobs as they're stored, for diffing their outcomes.
+// The shadow's ShadowMismatch is already set from comparing their statuses and results.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithShadowHandler(func(ctx context.Context, production, shadow *JobRecord) {
+//	        if production.Status != shadow.Status {
+//	            log.Printf("shadow %s %s where %s %s", shadow.Kind, shadow.Status, production.Kind, production.Status)
+//	        }
+//	    }),
+//	)
+func WithShadowHandler(handler ShadowHandler) Option {
+	return func(s *Swig) {
+		s.shadowHandler = handler
+	}
+}
+
+// WithAcquirePredicate adds a condition, written in SQL against swig_jobs, that jobs must
+// meet for this instance's workers to acquire them. It narrows acquisition without forking
+// the scheduler, for example to jobs labelled for this deployment. Values go in args and
+// are referred to as $1, $2 and so on, which are renumbered to fit the acquire query.
+// The predicate must be a single expression: statement separators, comments, dollar
+// quoting and unbalanced parentheses are rejected, as are placeholders that don't match
+// args, and Start fails on a rejected predicate. Several predicates must all hold.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithAcquirePredicate("metadata->>'region' = $1", os.Getenv("REGION")),
+//	)
+func WithAcquirePredicate(predicate string, args ...interface{}) Option {
+	return func(s *Swig) {
+		s.acquirePredicates = append(s.acquirePredicates, parseAcquirePredicate(predicate, args))
+	}
+}
+
+// WithDigest has the leader summarize each period of activity, for teams without a metrics
+// stack: jobs completed and failed per kind, failure rates, p95 durations and how each
+// queue's backlog moved. The digest is logged and, if handler isn't nil, passed to it.
+// Periods are aligned to UTC, so a 24 hour period covers calendar days and a week starts
+// on Monday. Each period is reported once across the fleet, shortly after it ends.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithDigest(24*time.Hour, func(ctx context.Context, digest Digest) {
+//	        postToSlack(ctx, digest.String())
+//	    }),
+//	)
+func WithDigest(period time.Duration, handler DigestHandler) Option {
+	return func(s *Swig) {
+		if period > 0 {
+			s.digestPeriod = period
+			s.digestHandler = handler
+		}
+	}
+}
+
+// WithoutTriggers migrates the schema without creating the notify_job_created function and
+// the insert trigger that calls it, for managed Postgres offerings that don't allow them.
+// Swig falls back to this on its own when creating them is refused. Without the trigger,
+// AddJob and its siblings send each job's notification themselves, in the transaction that
+// inserts it, so workers still pick jobs up in real time. Jobs inserted by other producers
+// are found by the next poll instead. Job IDs are generated in Go so they can be announced.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithoutTriggers(),
+//	)
+func WithoutTriggers() Option {
+	return func(s *Swig) {
+		s.withoutTriggers = true
+	}
+}
+
+// WithErrorLimit caps the size in bytes of the error stored with a failed job, so errors
+// that carry whole response bodies don't bloat swig_jobs. Longer errors, messages and
+// stack traces are cut and marked with how much was dropped, and the job's error details
+// are flagged as truncated. The default is 8KB; zero or less stores errors in full.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithErrorLimit(2048),
+//	)
+func WithErrorLimit(limit int) Option {
+	return func(s *Swig) {
+		s.errorLimit = limit
+	}
+}
+
+// WithErrorStore hands the full text of errors over the error limit to store, such as a
+// bucket in object storage, before they're truncated. The reference it returns is kept in
+// the job's error details as full_error_ref. If store fails, the error is logged and the
+// truncated error is recorded without a reference.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithErrorStore(func(ctx context.Context, job workers.JobInfo, fullError string) (string, error) {
+//	        key := fmt.Sprintf("swig-errors/%s/%d.txt", job.ID, job.Attempts)
+//	        return key, bucket.Put(ctx, key, []byte(fullError))
+//	    }),
+//	)
+func WithErrorStore(store ErrorStore) Option {
+	return func(s *Swig) {
+		s.errorStore = store
+	}
+}
+
+// WithJobRetention deletes finished jobs of kind once they're older than policy allows,
+// keeping completed and discarded (failed or expired) jobs for different lengths of time,
+// so swig_jobs doesn't grow forever. An empty kind sets the policy for every kind without
+// one of its own; kinds without any policy are kept forever.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithJobRetention("process_payment", swig.RetentionPolicy{Completed: 90 * 24 * time.Hour, Discarded: 180 * 24 * time.Hour}),
+//	    WithJobRetention("send_email", swig.RetentionPolicy{Completed: 24 * time.Hour, Discarded: 7 * 24 * time.Hour}),
+//	    WithJobRetention("", swig.RetentionPolicy{Completed: 30 * 24 * time.Hour}),
+//	)
+func WithJobRetention(kind string, policy RetentionPolicy) Option {
+	return func(s *Swig) {
+		if s.jobRetention == nil {
+			s.jobRetention = make(map[string]RetentionPolicy)
+		}
+		s.jobRetention[kind] = policy
+	}
+}
+
+// WithSLORebalancing lets a queue whose jobs are breaching their WithLatencySLO targets
+// borrow workers from queues that are within theirs, for incident backlogs that configured
+// weights don't account for. Each healthy queue lends up to lendShare of its workers,
+// keeping at least one, and the borrowed workers go back once the queue's latency is
+// under half its SLO. Running jobs aren't interrupted; lent workers stop taking jobs for
+// their own queue as they finish. lendShare is between 0 and 1 and defaults to 0.5.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithLatencySLO("charge_card", 10*time.Second),
+//	    WithSLORebalancing(0.5),
+//	)
+func WithSLORebalancing(lendShare float64) Option {
+	return func(s *Swig) {
+		s.rebalancer = newQueueRebalancer(lendShare)
+	}
+}
+
+// WithMaintenanceHooks sets hooks called before and after each maintenance pass the leader
+// runs, with how many rows the pass changed and how long it took, for alarming on passes
+// that slow down or fall behind. Passes are named as in the logs: "retry", "expire",
+// "rescue", "prune", "retention" and so on.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithMaintenanceHooks(swig.MaintenanceHooks{
+//	        OnMaintenanceEnd: func(name string, stats swig.MaintenanceStats) {
+//	            passDuration.WithLabelValues(name).Observe(stats.Duration.Seconds())
+//	            passRows.WithLabelValues(name).Add(float64(stats.Rows))
+//	        },
+//	    }),
+//	)
+func WithMaintenanceHooks(hooks MaintenanceHooks) Option {
+	return func(s *Swig) {
+		s.maintenanceHooks = hooks
+	}
+}
+
+// WithQueueRetryPolicy gives queue retry settings of its own. The leader promotes its
+// retries in a batch of their own before those of queues without a policy, so a flood of
+// failing low-value jobs elsewhere can't crowd out retries of a critical queue, and a noisy
+// queue can be held back or have its retries stopped entirely.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithQueueRetryPolicy(swig.Priority, swig.QueueRetryPolicy{
+//	        BatchSize: 500,
+//	        Backoff:   func(attempts int) time.Duration { return time.Duration(attempts) * 5 * time.Second },
+//	    }),
+//	    WithQueueRetryPolicy("reports", swig.QueueRetryPolicy{BatchSize: 20}),
+//	)
+func WithQueueRetryPolicy(queue QueueTypes, policy QueueRetryPolicy) Option {
+	return func(s *Swig) {
+		if s.queueRetry == nil {
+			s.queueRetry = make(map[string]QueueRetryPolicy)
+		}
+		s.queueRetry[string(queue)] = policy
+	}
+}
+
+// WithFetchBackoff changes how long workers wait after errors of class before they look
+// for jobs again. By default acquisition conflicts back off from 10ms to 1s, an
+// unavailable database from 1s to 30s and other errors from 100ms to 10s.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithFetchBackoff(swig.FetchUnavailable, swig.FetchBackoff{Initial: 5 * time.Second, Max: 2 * time.Minute}),
+//	)
+func WithFetchBackoff(class FetchErrorClass, backoff FetchBackoff) Option {
+	return func(s *Swig) {
+		if s.fetchBackoff == nil {
+			s.fetchBackoff = make(map[FetchErrorClass]FetchBackoff)
+		}
+		s.fetchBackoff[class] = backoff
+	}
+}
+
+// WithReconcileInterval sets how long an idle worker waits for a notification before it
+// checks its queue anyway. These polls are how jobs that become due without an insert,
+// like scheduled jobs and retries, are found, and they guarantee a job whose notification
+// was lost is still picked up. Jobs found because their notification was lost are counted
+// in Metrics().Reconciled. The default is 5 seconds; a longer interval means fewer
+// queries from idle workers but a later start for scheduled jobs. It has no effect in
+// polling mode.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers, WithReconcileInterval(30*time.Second))
+func WithReconcileInterval(interval time.Duration) Option {
+	return func(s *Swig) {
+		if interval > 0 {
+			s.pollInterval = interval
+		}
+	}
+}
+
+// WithUnknownQueues sets what enqueueing a job does when no running instance processes its
+// queue, which usually means a typo or a deploy that's missing a worker pool. By default a
+// warning is logged once per queue. The queues running instances process are cached for
+// 30 seconds.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers, WithUnknownQueues(swig.UnknownQueueReject))
+func WithUnknownQueues(policy UnknownQueuePolicy) Option {
+	return func(s *Swig) {
+		s.unknownQueues = policy
+	}
+}
+
+// WithOrphanQueueWorkers runs n workers on this instance that process jobs of queues no
+// running instance is configured for, so they don't sit forever. Jobs enqueued to any
+// queue count as processed while the instance runs.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers, WithOrphanQueueWorkers(2))
+func WithOrphanQueueWorkers(n int) Option {
+	return func(s *Swig) {
+		if n > 0 {
+			s.orphanWorkers = n
+		}
+	}
+}
+
+// WithDuplicateCompaction has the leader collapse exact-duplicate pending jobs of kinds,
+// or of every kind when none are given, into one job every minute. Jobs are duplicates
+// when they have the same kind, payload, queue, tenant, region and concurrency key; the
+// one due soonest is kept and records how many were folded into it in
+// JobRecord.CollapsedCount. It's meant for producers that can't easily set a UniqueKey
+// but generate lots of redundant work. The IDs of collapsed jobs stop existing, so don't
+// enable it for kinds whose job IDs are tracked elsewhere.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers, WithDuplicateCompaction("reindex_product", "refresh_cache"))
+func WithDuplicateCompaction(kinds ...string) Option {
+	return func(s *Swig) {
+		if s.compactKinds == nil {
+			s.compactKinds = make(map[string]bool)
+		}
+		if len(kinds) == 0 {
+			s.compactKinds[""] = true
+		}
+		for _, kind := range kinds {
+			s.compactKinds[kind] = true
+		}
+	}
+}
+
+// WithDeploymentID makes the instance a member of deployment id, shared by the instances
+// of one deploy and the replacements that take over from them, as in a blue/green deploy.
+// When Stop's grace period runs out on a job that saved a checkpoint, see SaveCheckpoint,
+// the job is handed over to another running member of the deployment that isn't stopping
+// itself: the interrupted attempt doesn't count, the job skips the retry backoff and the
+// replacement resumes it from its checkpoint. Start the replacements before stopping the
+// instances they replace.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithDeploymentID(os.Getenv("DEPLOYMENT_ID")),
+//	)
+func WithDeploymentID(id string) Option {
+	return func(s *Swig) {
+		s.deploymentID = id
+	}
+}
+
+// WithQueryTimeouts sets how long Swig's own acquire queries and maintenance passes may
+// run before they're cancelled, so a lock pile-up on swig_jobs can't hold connections
+// indefinitely. Zero fields keep their defaults of 30 seconds per acquire and 5 minutes
+// per maintenance pass; negative ones remove the limit.
+//
+// Example:
+//
+//	swig := NewSwig(driver, configs, workers,
+//	    WithQueryTimeouts(swig.QueryTimeouts{Acquire: 5 * time.Second, Maintenance: time.Minute}),
+//	)
+func WithQueryTimeouts(timeouts QueryTimeouts) Option {
+	return func(s *Swig) {
+		s.queryTimeouts = timeouts
+	}
+}
@@ -0,0 +1,118 @@
+package swig
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobOrder is the order in which a queue's workers take ready jobs of equal priority
+type JobOrder string
+
+const (
+	// OrderFIFO takes the job enqueued first. It's the default.
+	OrderFIFO JobOrder = "fifo"
+	// OrderScheduled takes the job scheduled earliest, breaking ties by ID, so jobs run in
+	// a stable order that doesn't depend on when they were inserted
+	OrderScheduled JobOrder = "scheduled"
+	// OrderLIFO takes the job enqueued last, for workloads where the newest work is the
+	// most valuable, like cache refreshes
+	OrderLIFO JobOrder = "lifo"
+)
+
+// jobOrderClauses are the ORDER BY terms each JobOrder adds after priority
+var jobOrderClauses = map[JobOrder]string{
+	OrderFIFO:      "created_at",
+	OrderScheduled: "scheduled_for, id",
+	OrderLIFO:      "created_at DESC, id DESC",
+}
+
+// jobOrder returns the ORDER BY terms for the configured order of queue
+func (s *Swig) jobOrder(queue QueueTypes) string {
+	for _, config := range s.swigQueueConfig {
+		if config.QueueType == queue && config.Order != "" {
+			if clause, ok := jobOrderClauses[config.Order]; ok {
+				return clause
+			}
+		}
+	}
+	return jobOrderClauses[OrderFIFO]
+}
+
+// How far off a deadline starts counting towards OrderWeights.Deadline, unless DeadlineHorizon is set
+const defaultDeadlineHorizon = time.Hour
+
+// OrderWeights ranks a queue's ready jobs by a weighted score when a single priority can't
+// express the scheduling policy. Workers take the job with the highest score, breaking ties
+// by the queue's Order. Weights that are zero leave their term out; negative weights count
+// against a job.
+type OrderWeights struct {
+	Priority float64 // Per point of priority
+	Age      float64 // Per second since the job became due
+	Deadline float64 // Per second a job's deadline is closer than DeadlineHorizon
+	// DeadlineHorizon is how far off a deadline starts counting. Defaults to an hour.
+	DeadlineHorizon time.Duration
+	// TenantRunning is per job of the same tenant already processing. A negative weight
+	// shares workers fairly between tenants, at the cost of a count per candidate job.
+	TenantRunning float64
+}
+
+// validate reports weights that can't be turned into SQL
+func (w OrderWeights) validate() error {
+	weights := []struct {
+		name   string
+		weight float64
+	}{{"Priority", w.Priority}, {"Age", w.Age}, {"Deadline", w.Deadline}, {"TenantRunning", w.TenantRunning}}
+	for _, weight := range weights {
+		if math.IsNaN(weight.weight) || math.IsInf(weight.weight, 0) {
+			return fmt.Errorf("weight %s is %v", weight.name, weight.weight)
+		}
+	}
+	if w.DeadlineHorizon < 0 {
+		return fmt.Errorf("DeadlineHorizon is negative")
+	}
+	return nil
+}
+
+// scoreSQL returns the SQL expression of a job's score, with the weights inlined as
+// numeric literals
+func (w OrderWeights) scoreSQL() string {
+	var terms []string
+	term := func(weight float64, expr string) {
+		if weight != 0 {
+			terms = append(terms, fmt.Sprintf("(%s) * %s", strconv.FormatFloat(weight, 'g', -1, 64), expr))
+		}
+	}
+
+	horizon := w.DeadlineHorizon
+	if horizon == 0 {
+		horizon = defaultDeadlineHorizon
+	}
+	term(w.Priority, "priority")
+	term(w.Age, "EXTRACT(EPOCH FROM NOW() - scheduled_for)")
+	term(w.Deadline, fmt.Sprintf("COALESCE(GREATEST(0, %s - EXTRACT(EPOCH FROM deadline - NOW())), 0)",
+		strconv.FormatFloat(horizon.Seconds(), 'g', -1, 64)))
+	term(w.TenantRunning, `(
+						SELECT COUNT(*) FROM swig_jobs running
+						WHERE running.tenant = swig_jobs.tenant AND running.status = 'processing'
+					)`)
+
+	if len(terms) == 0 {
+		return "0"
+	}
+	return strings.Join(terms, " + ")
+}
+
+// jobRanking returns the ORDER BY terms for the ready jobs of queue after the priority
+// queue's: priority, then deadline, then the queue's Order, unless the queue is ranked by
+// OrderWeights
+func (s *Swig) jobRanking(queue QueueTypes) string {
+	for _, config := range s.swigQueueConfig {
+		if config.QueueType == queue && config.Weights != nil {
+			return "(" + config.Weights.scoreSQL() + ") DESC, " + s.jobOrder(queue)
+		}
+	}
+	return "priority DESC, deadline ASC NULLS LAST, " + s.jobOrder(queue)
+}
@@ -0,0 +1,324 @@
+package swig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// ErrUnknownQueue is returned when enqueueing to a queue no running instance processes,
+// with WithUnknownQueues(UnknownQueueReject)
+var ErrUnknownQueue = errors.New("no running instance processes queue")
+
+// Queue an instance records when it runs orphan queue workers, which process every queue
+// no other instance does
+const anyQueue = "*"
+
+// How long the queues running instances process are cached for enqueue checks, and how
+// often orphan queue workers look for queues to adopt
+const (
+	queueDirectoryTTL   = 30 * time.Second
+	orphanCheckInterval = 30 * time.Second
+)
+
+// UnknownQueuePolicy is what enqueueing a job does when no running instance processes the
+// queue it's enqueued to, so it would wait until one starts
+type UnknownQueuePolicy int
+
+const (
+	// UnknownQueueWarn logs a warning, once per queue, and enqueues the job
+	UnknownQueueWarn UnknownQueuePolicy = iota
+	// UnknownQueueReject returns ErrUnknownQueue instead of enqueueing the job
+	UnknownQueueReject
+	// UnknownQueueAllow enqueues the job without checking, for queues whose workers are
+	// started separately
+	UnknownQueueAllow
+)
+
+// OrphanQueue is a queue with jobs waiting that no running instance processes
+type OrphanQueue struct {
+	Queue     string    `json:"queue"`
+	Jobs      int       `json:"jobs"`       // Pending, scheduled and retryable jobs
+	OldestJob time.Time `json:"oldest_job"` // When the longest waiting of them was created
+}
+
+// queueDirectory caches which queues running instances process, and which orphan queues
+// this instance's orphan workers have adopted
+type queueDirectory struct {
+	mu          sync.Mutex
+	served      map[string]bool // Queues recorded by running instances, anyQueue if any is served
+	refreshedAt time.Time
+	warned      map[string]bool // Unknown queues already warned about
+	orphans     []string        // Queues the orphan workers process
+}
+
+func newQueueDirectory() *queueDirectory {
+	return &queueDirectory{warned: make(map[string]bool)}
+}
+
+// servedQueues returns the queues this instance processes, as recorded in swig_instances
+func (s *Swig) servedQueues() []string {
+	seen := make(map[string]bool)
+	queues := []string{}
+	for _, config := range s.swigQueueConfig {
+		if !seen[string(config.QueueType)] {
+			seen[string(config.QueueType)] = true
+			queues = append(queues, string(config.QueueType))
+		}
+	}
+	if s.orphanWorkers > 0 {
+		queues = append(queues, anyQueue)
+	}
+	sort.Strings(queues)
+	return queues
+}
+
+// checkJobQueues applies the unknown queue policy to jobs enqueued to queues that no running
+// instance processes. Priority jobs are taken by workers of every queue, so any instance
+// will do for them.
+func (s *Swig) checkJobQueues(ctx context.Context, jobs []drivers.BatchJob) error {
+	if s.unknownQueues == UnknownQueueAllow {
+		return nil
+	}
+	for _, job := range jobs {
+		queue := job.Opts.Queue
+		if queue == "" || queue == string(Priority) || s.runsQueue(QueueTypes(queue)) {
+			continue
+		}
+		served, warn := s.queues.check(ctx, s.driver, queue)
+		if served {
+			continue
+		}
+		if s.unknownQueues == UnknownQueueReject {
+			return fmt.Errorf("%w %s", ErrUnknownQueue, queue)
+		}
+		if warn {
+			log.Printf("No running instance processes queue %s, so jobs enqueued to it wait until one does", queue)
+		}
+	}
+	return nil
+}
+
+// runsQueue reports whether this instance is configured with workers for queue
+func (s *Swig) runsQueue(queue QueueTypes) bool {
+	for _, config := range s.swigQueueConfig {
+		if config.QueueType == queue {
+			return true
+		}
+	}
+	return false
+}
+
+// check reports whether a running instance processes queue, refreshing the cache when it's
+// stale, and whether this is the first time it's been found not to. When the queues can't
+// be read, say because the schema predates them, every queue counts as processed.
+func (d *queueDirectory) check(ctx context.Context, q interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (drivers.Rows, error)
+}, queue string) (served bool, firstMiss bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if time.Since(d.refreshedAt) > queueDirectoryTTL {
+		d.refreshedAt = time.Now()
+		served, err := liveQueues(ctx, q)
+		if err != nil {
+			log.Printf("Failed to check which queues are processed: %v", err)
+			served = map[string]bool{anyQueue: true}
+		}
+		d.served = served
+	}
+	if d.served[queue] || d.served[anyQueue] {
+		return true, false
+	}
+	if d.warned[queue] {
+		return false, false
+	}
+	d.warned[queue] = true
+	return false, true
+}
+
+// liveQueues returns the queues processed by recently seen instances. An instance that
+// didn't record its queues could be processing any of them.
+func liveQueues(ctx context.Context, q interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (drivers.Rows, error)
+}) (map[string]bool, error) {
+	queuesSQL := `
+		SELECT DISTINCT unnest(COALESCE(queues, ARRAY[$2::text]))
+		FROM swig_instances
+		WHERE seen_at > NOW() - $1::interval
+			AND swig_version IS NOT NULL`
+	rows, err := q.Query(ctx, queuesSQL, instanceStaleAfter.String(), anyQueue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processed queues: %w", err)
+	}
+	defer rows.Close()
+
+	served := make(map[string]bool)
+	for rows.Next() {
+		var queue string
+		if err := rows.Scan(&queue); err != nil {
+			return nil, fmt.Errorf("failed to scan queue: %w", err)
+		}
+		served[queue] = true
+	}
+	return served, nil
+}
+
+// OrphanQueues lists the queues with jobs waiting that no running instance processes,
+// most jobs first. Until they're processed, those jobs sit where they are; enqueue them to
+// a queue that's processed, or start workers for the queue or orphan queue workers. The
+// list is empty while an instance that doesn't record its queues is running, since it
+// could be processing any of them. Queues adopted by orphan queue workers are still listed.
+//
+// Example:
+//
+//	orphans, err := swig.OrphanQueues(ctx)
+//	for _, orphan := range orphans {
+//	    log.Printf("queue %s has %d jobs and no workers", orphan.Queue, orphan.Jobs)
+//	}
+func (s *Swig) OrphanQueues(ctx context.Context) ([]OrphanQueue, error) {
+	orphansSQL := `
+		WITH live AS (
+			SELECT queues
+			FROM swig_instances
+			WHERE seen_at > NOW() - $2::interval
+				AND swig_version IS NOT NULL
+		)
+		SELECT queue, COUNT(*), MIN(created_at)
+		FROM swig_jobs
+		WHERE status = ANY($1::text[]::swig_job_status[])
+			AND queue <> $3
+			AND NOT EXISTS (SELECT 1 FROM live WHERE swig_jobs.queue = ANY(live.queues))
+			AND NOT EXISTS (SELECT 1 FROM live WHERE live.queues IS NULL)
+		GROUP BY queue
+		ORDER BY COUNT(*) DESC, queue`
+	rows, err := s.driver.Query(ctx, orphansSQL, []string{"pending", "scheduled", "retryable"},
+		instanceStaleAfter.String(), string(Priority))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orphan queues: %w", err)
+	}
+	defer rows.Close()
+
+	orphans := []OrphanQueue{}
+	for rows.Next() {
+		var orphan OrphanQueue
+		if err := rows.Scan(&orphan.Queue, &orphan.Jobs, &orphan.OldestJob); err != nil {
+			return nil, fmt.Errorf("failed to scan orphan queue: %w", err)
+		}
+		orphans = append(orphans, orphan)
+	}
+	return orphans, nil
+}
+
+// runOrphanCheck keeps the orphan queues this instance's orphan workers process up to
+// date until Swig shuts down
+func (s *Swig) runOrphanCheck(ctx context.Context) {
+	ticker := time.NewTicker(orphanCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.adoptOrphanQueues(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to check for orphan queues: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// adoptOrphanQueues hands the current orphan queues to the orphan workers
+func (s *Swig) adoptOrphanQueues(ctx context.Context) error {
+	orphans, err := s.OrphanQueues(ctx)
+	if err != nil {
+		return err
+	}
+	queues := make([]string, 0, len(orphans))
+	for _, orphan := range orphans {
+		queues = append(queues, orphan.Queue)
+	}
+
+	d := s.queues
+	d.mu.Lock()
+	adopted := make(map[string]bool, len(d.orphans))
+	for _, queue := range d.orphans {
+		adopted[queue] = true
+	}
+	d.orphans = queues
+	d.mu.Unlock()
+
+	for _, orphan := range orphans {
+		if !adopted[orphan.Queue] {
+			log.Printf("Processing orphan queue %s with orphan queue workers (%d jobs waiting)", orphan.Queue, orphan.Jobs)
+		}
+	}
+	return nil
+}
+
+// orphanQueues returns the queues the orphan workers process
+func (d *queueDirectory) orphanQueues() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.orphans
+}
+
+// runOrphanWorker processes jobs of orphan queues, a pass over all of them at a time, and
+// waits for the poll interval whenever a pass finds nothing
+func (s *Swig) runOrphanWorker(ctx context.Context) {
+	ctx, unregister := s.activity.register(ctx, anyQueue)
+	defer unregister()
+
+	backoff := s.newFetchBackoff()
+	for {
+		acquired := false
+		for _, queue := range s.queues.orphanQueues() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.shutdown:
+				return
+			case <-s.draining:
+				return
+			default:
+			}
+
+			s.activity.set(ctx, workerAcquiring, nil)
+			got, err := s.acquireAndProcessJob(ctx, QueueTypes(queue), "")
+			if err != nil {
+				log.Printf("Error processing job of orphan queue %s: %v", queue, err)
+				s.waitBackoff(ctx, backoff.next(classifyFetchError(err)))
+				continue
+			}
+			backoff.reset()
+			acquired = acquired || got
+		}
+		if acquired {
+			continue
+		}
+
+		s.activity.set(ctx, workerWaiting, nil)
+		timer := time.NewTimer(s.pollInterval + time.Duration(rand.Int63n(int64(s.pollInterval/5)+1)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.shutdown:
+			timer.Stop()
+			return
+		case <-s.draining:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
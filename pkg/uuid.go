@@ -1,10 +1,60 @@
 package pkg
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"time"
+
 	"github.com/google/uuid"
 )
 
+// Crockford's base32 alphabet used by ULIDs
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
 // GenerateWorkerID creates a unique identifier for a worker
 func GenerateWorkerID() string {
 	return uuid.New().String()
 }
+
+// GenerateUUIDv7 creates a time-ordered UUID (version 7), whose leading bits are the
+// creation time in milliseconds, so IDs sort by when they were made
+func GenerateUUIDv7() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.New().String()
+	}
+	return id.String()
+}
+
+// GenerateULID creates a ULID, a 48-bit millisecond timestamp followed by 80 random bits,
+// written in UUID form so it fits UUID columns. FormatULID renders it in the usual
+// 26-character form.
+func GenerateULID() string {
+	var id uuid.UUID
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixMilli()))
+	copy(id[:6], ms[2:])
+	if _, err := rand.Read(id[6:]); err != nil {
+		return uuid.New().String()
+	}
+	return id.String()
+}
+
+// FormatULID renders a UUID-form ID, such as one from GenerateULID, as a 26-character
+// Crockford base32 ULID
+func FormatULID(id string) (string, error) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse ID: %w", err)
+	}
+	n := new(big.Int).SetBytes(parsed[:])
+	mask := big.NewInt(31)
+	out := make([]byte, 26)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = ulidAlphabet[new(big.Int).And(n, mask).Int64()]
+		n.Rsh(n, 5)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,126 @@
+package swig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// acquirePredicate is a user-supplied condition on the jobs workers acquire, see
+// WithAcquirePredicate. The predicate is split around its placeholders so they can be
+// renumbered to follow the acquire query's own arguments.
+type acquirePredicate struct {
+	source string        // The predicate as given, for error messages
+	parts  []string      // Text around the placeholders; one more than refs
+	refs   []int         // Index into args of each placeholder, in order
+	args   []interface{} // Values of the placeholders
+	err    error         // Why the predicate was rejected, if it was
+}
+
+// parseAcquirePredicate checks that predicate is a single parameterized expression and
+// splits it around its placeholders. It accepts string literals and quoted identifiers,
+// such as JSON keys, but rejects statement separators, comments, dollar quoting and
+// backslashes, parentheses that would close the surrounding condition, and placeholders
+// that don't match args, so values have to be passed as arguments.
+func parseAcquirePredicate(predicate string, args []interface{}) acquirePredicate {
+	p := acquirePredicate{source: predicate, args: args}
+	if strings.TrimSpace(predicate) == "" {
+		p.err = fmt.Errorf("acquire predicate is empty")
+		return p
+	}
+
+	used := make([]bool, len(args))
+	var part strings.Builder
+	depth := 0
+	for i := 0; i < len(predicate); i++ {
+		c := predicate[i]
+		switch {
+		case c == '\'' || c == '"':
+			// Copy the literal or identifier through to its closing quote; doubled quotes escape
+			end := i + 1
+			for ; end < len(predicate); end++ {
+				if predicate[end] != c {
+					continue
+				}
+				if end+1 < len(predicate) && predicate[end+1] == c {
+					end++
+					continue
+				}
+				break
+			}
+			if end >= len(predicate) {
+				p.err = fmt.Errorf("acquire predicate %q has an unterminated quote", predicate)
+				return p
+			}
+			part.WriteString(predicate[i : end+1])
+			i = end
+			continue
+		case c == '\\':
+			p.err = fmt.Errorf("acquire predicate %q contains a backslash", predicate)
+		case c == ';':
+			p.err = fmt.Errorf("acquire predicate %q contains a statement separator", predicate)
+		case c == '-' && i+1 < len(predicate) && predicate[i+1] == '-',
+			c == '/' && i+1 < len(predicate) && predicate[i+1] == '*':
+			p.err = fmt.Errorf("acquire predicate %q contains a comment", predicate)
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth < 0 {
+				p.err = fmt.Errorf("acquire predicate %q has unbalanced parentheses", predicate)
+			}
+		case c == '$':
+			end := i + 1
+			for end < len(predicate) && predicate[end] >= '0' && predicate[end] <= '9' {
+				end++
+			}
+			if end == i+1 {
+				p.err = fmt.Errorf("acquire predicate %q uses dollar quoting", predicate)
+				return p
+			}
+			n, err := strconv.Atoi(predicate[i+1 : end])
+			if err != nil || n < 1 || n > len(args) {
+				p.err = fmt.Errorf("acquire predicate %q refers to $%s but has %d arguments", predicate, predicate[i+1:end], len(args))
+				return p
+			}
+			used[n-1] = true
+			p.parts = append(p.parts, part.String())
+			p.refs = append(p.refs, n-1)
+			part.Reset()
+			i = end - 1
+			continue
+		}
+		if p.err != nil {
+			return p
+		}
+		part.WriteByte(c)
+	}
+	p.parts = append(p.parts, part.String())
+
+	if depth != 0 {
+		p.err = fmt.Errorf("acquire predicate %q has unbalanced parentheses", predicate)
+		return p
+	}
+	for n, ok := range used {
+		if !ok {
+			p.err = fmt.Errorf("acquire predicate %q never uses argument $%d", predicate, n+1)
+			return p
+		}
+	}
+	return p
+}
+
+// render returns the predicate with its placeholders numbered after the len(args)
+// arguments already in the query, and args with the predicate's appended
+func (p acquirePredicate) render(args []interface{}) (string, []interface{}) {
+	offset := len(args)
+	args = append(args, p.args...)
+
+	var sql strings.Builder
+	for i, ref := range p.refs {
+		sql.WriteString(p.parts[i])
+		sql.WriteString("$" + strconv.Itoa(offset+ref+1))
+	}
+	sql.WriteString(p.parts[len(p.parts)-1])
+	return "(" + sql.String() + ")", args
+}
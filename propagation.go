@@ -0,0 +1,75 @@
+package swig
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// ContextPropagator carries one value from the context a job is enqueued with to the
+// context its Process runs with. See WithContextPropagation.
+type ContextPropagator struct {
+	Name string // Name the value is stored under with the job
+	// Extract reads the value from the enqueuing context, reporting whether there is one
+	Extract func(ctx context.Context) (string, bool)
+	// Inject returns a context for Process carrying value
+	Inject func(ctx context.Context, value string) context.Context
+}
+
+// PropagateContextKey propagates the string stored in the context under key, for values
+// set with context.WithValue.
+//
+// Example:
+//
+//	type requestIDKey struct{}
+//
+//	swig.PropagateContextKey("request_id", requestIDKey{})
+func PropagateContextKey(name string, key interface{}) ContextPropagator {
+	return ContextPropagator{
+		Name: name,
+		Extract: func(ctx context.Context) (string, bool) {
+			value, ok := ctx.Value(key).(string)
+			return value, ok
+		},
+		Inject: func(ctx context.Context, value string) context.Context {
+			return context.WithValue(ctx, key, value)
+		},
+	}
+}
+
+// captureContext stores the propagated values found in ctx in opts
+func (s *Swig) captureContext(ctx context.Context, opts *drivers.JobOptions) {
+	if len(s.propagators) == 0 {
+		return
+	}
+	values := make(map[string]string, len(s.propagators)+len(opts.ContextValues))
+	for name, value := range opts.ContextValues {
+		values[name] = value
+	}
+	for _, p := range s.propagators {
+		if value, ok := p.Extract(ctx); ok {
+			values[p.Name] = value
+		}
+	}
+	opts.ContextValues = values
+}
+
+// restoreContext returns ctx carrying the values captured when job was enqueued
+func (s *Swig) restoreContext(ctx context.Context, job acquiredJob) context.Context {
+	if len(s.propagators) == 0 || len(job.contextValues) == 0 {
+		return ctx
+	}
+	var values map[string]string
+	if err := json.Unmarshal(job.contextValues, &values); err != nil {
+		log.Printf("Failed to decode context values of job %s: %v", job.ID, err)
+		return ctx
+	}
+	for _, p := range s.propagators {
+		if value, ok := values[p.Name]; ok {
+			ctx = p.Inject(ctx, value)
+		}
+	}
+	return ctx
+}
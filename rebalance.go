@@ -0,0 +1,249 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A borrowing queue keeps the workers it was lent until its worst latency falls below this
+// share of the SLO, so capacity doesn't flap back and forth around the threshold
+const rebalanceRecoverRatio = 0.5
+
+// Share of a healthy queue's workers lent out when WithSLORebalancing gets an invalid one
+const defaultLendShare = 0.5
+
+// queueRebalancer moves worker slots from healthy queues to queues breaching their latency
+// SLOs. Each queue runs enough workers to take everything it could borrow, and a worker
+// only looks for jobs while it holds one of the queue's slots.
+type queueRebalancer struct {
+	lendShare  float64
+	configured map[QueueTypes]int // Workers each queue is configured with
+	lendable   map[QueueTypes]int // Workers each queue can lend out
+	totalLent  int                // Sum of lendable
+
+	mu        sync.Mutex
+	allowed   map[QueueTypes]int  // Slots each queue has right now
+	busy      map[QueueTypes]int  // Slots held by workers
+	borrowing map[QueueTypes]bool // Queues breaching their SLOs and borrowing slots
+	changed   chan struct{}       // Closed and replaced whenever a slot may have become free
+}
+
+func newQueueRebalancer(lendShare float64) *queueRebalancer {
+	if lendShare <= 0 || lendShare > 1 {
+		lendShare = defaultLendShare
+	}
+	return &queueRebalancer{lendShare: lendShare, changed: make(chan struct{})}
+}
+
+// configure sizes the rebalancer for the instance's queues. Every queue keeps at least one
+// worker of its own.
+func (r *queueRebalancer) configure(configs []SwigQueueConfig) {
+	r.configured = make(map[QueueTypes]int)
+	for _, config := range configs {
+		workers := config.MaxWorkers
+		if workers < minWorkers {
+			workers = minWorkers
+		}
+		r.configured[config.QueueType] += workers
+	}
+
+	r.lendable = make(map[QueueTypes]int)
+	r.allowed = make(map[QueueTypes]int)
+	r.busy = make(map[QueueTypes]int)
+	r.borrowing = make(map[QueueTypes]bool)
+	r.totalLent = 0
+	for queue, workers := range r.configured {
+		lend := int(float64(workers) * r.lendShare)
+		if lend > workers-1 {
+			lend = workers - 1
+		}
+		r.lendable[queue] = lend
+		r.totalLent += lend
+		r.allowed[queue] = workers
+	}
+}
+
+// spare is how many workers beyond its configured ones a queue needs to use everything it
+// could borrow
+func (r *queueRebalancer) spare(queue QueueTypes) int {
+	return r.totalLent - r.lendable[queue]
+}
+
+// takeSlot waits for a free slot of queue. It returns false without one if ctx is done
+// or Swig stops taking jobs first.
+func (s *Swig) takeSlot(ctx context.Context, queue QueueTypes) bool {
+	r := s.rebalancer
+	if r == nil {
+		return true
+	}
+	for {
+		r.mu.Lock()
+		if r.busy[queue] < r.allowed[queue] {
+			r.busy[queue]++
+			r.mu.Unlock()
+			return true
+		}
+		changed := r.changed
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-s.shutdown:
+			return false
+		case <-s.draining:
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// releaseSlot hands back a slot taken with takeSlot
+func (s *Swig) releaseSlot(queue QueueTypes) {
+	r := s.rebalancer
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.busy[queue]--
+	r.signal()
+	r.mu.Unlock()
+}
+
+// signal wakes workers waiting for a slot. Callers must hold mu.
+func (r *queueRebalancer) signal() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// runRebalancer re-measures queue latency against the SLOs and moves slots accordingly,
+// until Swig shuts down
+func (s *Swig) runRebalancer(ctx context.Context) {
+	ticker := time.NewTicker(sloRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+			if err := s.rebalanceQueues(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to rebalance queues: %v", err)
+			}
+		}
+	}
+}
+
+// rebalanceQueues measures how close each queue is to breaching the SLOs of the kinds
+// waiting in it, as the worst ratio of a kind's latency to its target, and reassigns slots
+func (s *Swig) rebalanceQueues(ctx context.Context) error {
+	kinds := make([]string, 0, len(s.slos.targets))
+	for kind := range s.slos.targets {
+		kinds = append(kinds, kind)
+	}
+
+	latencySQL := `
+		SELECT queue, kind, EXTRACT(EPOCH FROM NOW() - MIN(scheduled_for))
+		FROM swig_jobs
+		WHERE kind = ANY($1)
+			AND status = 'pending'
+			AND scheduled_for <= NOW()
+		GROUP BY queue, kind`
+	rows, err := s.driver.Query(ctx, latencySQL, kinds)
+	if err != nil {
+		return fmt.Errorf("failed to query queue latency: %w", err)
+	}
+	defer rows.Close()
+
+	ratios := make(map[QueueTypes]float64)
+	for rows.Next() {
+		var queue, kind string
+		var seconds float64
+		if err := rows.Scan(&queue, &kind, &seconds); err != nil {
+			return fmt.Errorf("failed to scan queue latency: %w", err)
+		}
+		ratio := seconds / s.slos.targets[kind].Seconds()
+		if ratio > ratios[QueueTypes(queue)] {
+			ratios[QueueTypes(queue)] = ratio
+		}
+	}
+
+	s.rebalancer.apply(ratios)
+	return nil
+}
+
+// apply marks queues over their SLOs as borrowing and queues that have recovered as done,
+// then splits the slots healthy queues can lend evenly between the borrowers
+func (r *queueRebalancer) apply(ratios map[QueueTypes]float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	queues := make([]QueueTypes, 0, len(r.configured))
+	for queue := range r.configured {
+		queues = append(queues, queue)
+	}
+	sort.Slice(queues, func(i, j int) bool { return queues[i] < queues[j] })
+
+	var borrowers, lenders []QueueTypes
+	for _, queue := range queues {
+		ratio := ratios[queue]
+		switch {
+		case ratio >= 1 && !r.borrowing[queue]:
+			r.borrowing[queue] = true
+			log.Printf("Queue %s is breaching its latency SLOs (%.1fx the target), lending it workers from healthy queues", queue, ratio)
+		case ratio < rebalanceRecoverRatio && r.borrowing[queue]:
+			delete(r.borrowing, queue)
+			log.Printf("Queue %s has recovered, restoring configured workers", queue)
+		}
+		if r.borrowing[queue] {
+			borrowers = append(borrowers, queue)
+		} else if ratio < 1 {
+			lenders = append(lenders, queue)
+		}
+	}
+
+	allowed := make(map[QueueTypes]int, len(queues))
+	for _, queue := range queues {
+		allowed[queue] = r.configured[queue]
+	}
+	if len(borrowers) > 0 {
+		lent := 0
+		for _, queue := range lenders {
+			allowed[queue] -= r.lendable[queue]
+			lent += r.lendable[queue]
+		}
+		for i, queue := range borrowers {
+			share := lent / len(borrowers)
+			if i < lent%len(borrowers) {
+				share++
+			}
+			allowed[queue] += share
+		}
+	}
+
+	changed := false
+	var moved []string
+	for _, queue := range queues {
+		if allowed[queue] != r.allowed[queue] {
+			changed = true
+		}
+		if allowed[queue] != r.configured[queue] {
+			moved = append(moved, fmt.Sprintf("%s %d", queue, allowed[queue]))
+		}
+	}
+	if !changed {
+		return
+	}
+	r.allowed = allowed
+	r.signal()
+	if len(moved) > 0 {
+		log.Printf("Rebalanced workers: %s", strings.Join(moved, ", "))
+	}
+}
@@ -0,0 +1,125 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// How long a job can stay processing before the leader assumes its instance died and
+// releases it. Graceful shutdowns release their jobs straight away; this catches crashes.
+const defaultRescueAfter = time.Hour
+
+// How often the leader looks for abandoned jobs
+const rescueInterval = time.Minute
+
+// recoverPreviousIncarnations releases jobs left processing by earlier runs of this
+// instance, recognised by the instance name recorded on them, and removes those runs from
+// swig_instances. A
+// process that was killed can't release its own jobs, so without a name they wait for the
+// rescuer's timeout.
+func (s *Swig) recoverPreviousIncarnations(ctx context.Context) error {
+	if s.instanceName == "" {
+		return nil
+	}
+
+	recoverSQL := `
+		WITH previous AS (
+			DELETE FROM swig_instances
+			WHERE name = $1 AND id <> $2
+		)
+		UPDATE swig_jobs
+		SET status = CASE
+				WHEN attempts >= max_attempts THEN 'failed'
+				ELSE 'pending'
+			END::swig_job_status,
+			instance_id = NULL,
+			worker_id = NULL,
+			locked_at = NULL,
+			last_error = CASE
+				WHEN attempts >= max_attempts THEN 'Job failed after its instance crashed'
+				ELSE last_error
+			END,
+			finished_at = CASE
+				WHEN attempts >= max_attempts THEN NOW()
+				ELSE NULL
+			END
+		WHERE status = 'processing'
+			AND instance_name = $1
+			AND instance_id <> $2
+		RETURNING id`
+
+	released, err := s.countRows(ctx, recoverSQL, s.instanceName, s.workerID)
+	if err != nil {
+		return fmt.Errorf("failed to release jobs of previous incarnations: %w", err)
+	}
+	if released > 0 {
+		log.Printf("Released %d jobs left processing by a previous run of instance %s", released, s.instanceName)
+	}
+	return nil
+}
+
+// rescueAbandonedJobs releases jobs that have been processing for longer than the rescue
+// timeout, at most maintenanceBatchSize per pass, on the assumption that the instance
+// running them is gone
+func (s *Swig) rescueAbandonedJobs(ctx context.Context) error {
+	rescueSQL := `
+		UPDATE swig_jobs
+		SET status = CASE
+				WHEN attempts >= max_attempts THEN 'failed'
+				ELSE 'pending'
+			END::swig_job_status,
+			instance_id = NULL,
+			worker_id = NULL,
+			locked_at = NULL,
+			last_error = CASE
+				WHEN attempts >= max_attempts THEN 'Job failed after it was abandoned by its instance'
+				ELSE last_error
+			END,
+			finished_at = CASE
+				WHEN attempts >= max_attempts THEN NOW()
+				ELSE NULL
+			END
+		WHERE id IN (
+			SELECT id
+			FROM swig_jobs
+			WHERE status = 'processing'
+				AND lease_expires_at IS NULL
+				AND locked_at <= NOW() - make_interval(secs => $1)
+			ORDER BY locked_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id`
+
+	rescued, err := s.countRows(ctx, rescueSQL, s.rescueAfter.Seconds(), maintenanceBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to rescue abandoned jobs: %w", err)
+	}
+	if rescued > 0 {
+		log.Printf("Rescued %d jobs processing for longer than %v", rescued, s.rescueAfter)
+	}
+	return nil
+}
+
+// countRows runs a statement returning one row per affected job and counts them, also
+// towards the maintenance pass it runs in, if any
+func (s *Swig) countRows(ctx context.Context, query string, args ...interface{}) (int, error) {
+	rows, err := s.driver.Query(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return count, fmt.Errorf("failed to scan job ID: %w", err)
+		}
+		count++
+	}
+	addMaintenanceRows(ctx, count)
+	return count, nil
+}
@@ -0,0 +1,34 @@
+package swig
+
+import (
+	"context"
+	"log"
+)
+
+// recycleWorker is called when a worker goroutine has processed its WithMaxJobsPerWorker
+// jobs and is about to exit. It starts a fresh goroutine for queue in its place, or, when
+// WithDrainOnMaxJobs is set, stops the instance taking jobs so it can be restarted.
+func (s *Swig) recycleWorker(ctx context.Context, queue QueueTypes) {
+	if s.onDrained != nil {
+		s.drainInstance()
+		return
+	}
+	s.activeWorkers.Add(1)
+	go func() {
+		defer s.activeWorkers.Done()
+		s.startWorker(ctx, queue)
+	}()
+}
+
+// drainInstance stops every worker taking new jobs and calls the WithDrainOnMaxJobs
+// handler once the jobs already running have finished
+func (s *Swig) drainInstance() {
+	s.drainOnce.Do(func() {
+		log.Printf("Worker reached its job limit, draining instance %s", s.instanceLabel())
+		close(s.draining)
+		go func() {
+			s.activeWorkers.Wait()
+			s.onDrained()
+		}()
+	})
+}
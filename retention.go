@@ -0,0 +1,85 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// How often the leader deletes finished jobs that are past their retention
+const retentionInterval = time.Minute
+
+// RetentionPolicy is how long finished jobs are kept before the leader deletes them.
+// Completed applies to jobs that succeeded, Discarded to jobs that failed for good or
+// expired without running. Zero keeps them forever.
+type RetentionPolicy struct {
+	Completed time.Duration
+	Discarded time.Duration
+}
+
+// retentionClasses are the statuses each half of a RetentionPolicy covers
+var retentionClasses = []struct {
+	name     string
+	statuses []string
+	after    func(RetentionPolicy) time.Duration
+}{
+	{"completed", []string{"completed"}, func(p RetentionPolicy) time.Duration { return p.Completed }},
+	{"discarded", []string{"failed", "expired"}, func(p RetentionPolicy) time.Duration { return p.Discarded }},
+}
+
+// pruneFinishedJobs deletes finished jobs older than their kind's retention. Jobs of kinds
+// without a policy of their own follow the default policy, if there is one. Each class of
+// each kind is deleted in batches until it's caught up, so a busy kind doesn't fall behind.
+func (s *Swig) pruneFinishedJobs(ctx context.Context) error {
+	pruneSQL := `
+		DELETE FROM swig_jobs
+		WHERE id IN (
+			SELECT id
+			FROM swig_jobs
+			WHERE status = ANY($1::text[]::swig_job_status[])
+				AND finished_at <= NOW() - (interval '1 second' * $2)
+				AND (kind = $3 OR ($3 = '' AND kind <> ALL($4::text[])))
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id`
+
+	kinds := make([]string, 0, len(s.jobRetention))
+	for kind := range s.jobRetention {
+		if kind != "" {
+			kinds = append(kinds, kind)
+		}
+	}
+
+	for _, kind := range sortedKeys(s.jobRetention) {
+		policy := s.jobRetention[kind]
+		for _, class := range retentionClasses {
+			after := class.after(policy)
+			if after <= 0 {
+				continue
+			}
+
+			pruned := 0
+			for {
+				n, err := s.countRows(ctx, pruneSQL, class.statuses, after.Seconds(), kind, kinds, maintenanceBatchSize)
+				pruned += n
+				if err != nil {
+					return fmt.Errorf("failed to prune %s jobs: %w", class.name, err)
+				}
+				if n < maintenanceBatchSize || ctx.Err() != nil {
+					break
+				}
+			}
+
+			if pruned > 0 {
+				name := kind
+				if name == "" {
+					name = "other"
+				}
+				log.Printf("Pruned %d %s %s jobs past their retention", pruned, class.name, name)
+			}
+		}
+	}
+	return nil
+}
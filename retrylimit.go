@@ -0,0 +1,35 @@
+package swig
+
+import (
+	"sync"
+	"time"
+)
+
+// retryRateLimit caps how many retries the leader promotes per window, so a mass failure
+// doesn't come back as one synchronized wave
+type retryRateLimit struct {
+	limit int
+	per   time.Duration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	promoted    int // Retries promoted since windowStart
+}
+
+// remaining returns how many more retries can be promoted in the current window
+func (r *retryRateLimit) remaining(now time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.windowStart) >= r.per {
+		r.windowStart = now
+		r.promoted = 0
+	}
+	return r.limit - r.promoted
+}
+
+// record counts promoted retries against the current window
+func (r *retryRateLimit) record(promoted int) {
+	r.mu.Lock()
+	r.promoted += promoted
+	r.mu.Unlock()
+}
@@ -0,0 +1,33 @@
+package swig
+
+import (
+	"math"
+	"time"
+
+	"github.com/glamboyosa/swig/workers"
+)
+
+// QueueRetryPolicy gives a queue retry settings of its own, isolated from the queues
+// sharing the default settings
+type QueueRetryPolicy struct {
+	// Disabled leaves the queue's retryable jobs waiting instead of promoting them, until an
+	// instance is started without it
+	Disabled bool
+	// BatchSize caps the retries promoted from the queue per pass; 0 uses WithRetryBatchSize
+	BatchSize int
+	// Backoff is the delay before a job that failed its attempts-th attempt is retried; nil
+	// for 2^attempts seconds
+	Backoff func(attempts int) time.Duration
+}
+
+// retryDelay is how long job waits before it's retried after failing with err: the delay
+// the worker asked for with RetryAfter, or else its queue's backoff
+func (s *Swig) retryDelay(job workers.JobInfo, err error) time.Duration {
+	if delay, ok := requestedRetryDelay(err); ok {
+		return delay
+	}
+	if policy, ok := s.queueRetry[job.Queue]; ok && policy.Backoff != nil {
+		return max(policy.Backoff(job.Attempts), 0)
+	}
+	return time.Duration(math.Pow(2, float64(job.Attempts)) * float64(time.Second))
+}
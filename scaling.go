@@ -0,0 +1,139 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Window over which ScalingSignals measures rates and waits. Long enough to smooth over
+// bursts, short enough that an autoscaler reacts within a few minutes.
+const scalingWindow = 5 * time.Minute
+
+// QueueScalingSignals are the autoscaling signals of one queue. Rates are in jobs per
+// second over the signals' window, so they can be fed straight to an autoscaler.
+type QueueScalingSignals struct {
+	Queue   string `json:"queue"`
+	Backlog int    `json:"backlog"` // Pending jobs that are due
+	// ArrivalRate is how fast jobs were enqueued, and CompletionRate how fast they finished
+	ArrivalRate    float64 `json:"arrival_rate"`
+	CompletionRate float64 `json:"completion_rate"`
+	// GrowthRate is how fast the backlog is growing, ArrivalRate minus CompletionRate. It's
+	// negative while workers are catching up.
+	GrowthRate float64 `json:"growth_rate"`
+	// AverageWaitSeconds is how long jobs that started during the window waited to be
+	// picked up once they were due
+	AverageWaitSeconds float64 `json:"average_wait_seconds"`
+	// Saturation is the share of this instance's workers for the queue that are running a
+	// job, 0-1. With load spread evenly it's a fair estimate for every instance.
+	Saturation float64 `json:"saturation"`
+}
+
+// ScalingSignals are the signals an autoscaler needs to size a worker deployment, read
+// from the database so every instance reports the same backlog, rates and waits
+type ScalingSignals struct {
+	WindowSeconds float64               `json:"window_seconds"`
+	Queues        []QueueScalingSignals `json:"queues"`
+}
+
+// ScalingSignals measures the backlog of each queue, how fast it's growing and how long
+// jobs wait, for scaling workers on Swig's own data rather than CPU. See ScalingHandler to
+// serve them to KEDA or a Kubernetes external metrics adapter.
+//
+// Example:
+//
+//	signals, err := swig.ScalingSignals(ctx)
+//	for _, queue := range signals.Queues {
+//	    if queue.GrowthRate > 0 && queue.Saturation > 0.9 {
+//	        log.Printf("queue %s is falling behind", queue.Queue)
+//	    }
+//	}
+func (s *Swig) ScalingSignals(ctx context.Context) (*ScalingSignals, error) {
+	signalsSQL := `
+		SELECT queue,
+			COUNT(*) FILTER (WHERE status = 'pending' AND scheduled_for <= NOW()),
+			COUNT(*) FILTER (WHERE created_at > NOW() - (interval '1 second' * $1)),
+			COUNT(*) FILTER (WHERE finished_at > NOW() - (interval '1 second' * $1)),
+			COALESCE(AVG(EXTRACT(EPOCH FROM started_at - scheduled_for)) FILTER (
+				WHERE started_at > NOW() - (interval '1 second' * $1) AND started_at >= scheduled_for
+			), 0)::float8
+		FROM swig_jobs
+		WHERE (status = 'pending' AND scheduled_for <= NOW())
+			OR created_at > NOW() - (interval '1 second' * $1)
+			OR finished_at > NOW() - (interval '1 second' * $1)
+			OR started_at > NOW() - (interval '1 second' * $1)
+		GROUP BY queue
+		ORDER BY queue`
+
+	window := scalingWindow.Seconds()
+	rows, err := s.driver.Query(ctx, signalsSQL, window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure scaling signals: %w", err)
+	}
+	defer rows.Close()
+
+	workers := make(map[string]int)
+	busy := make(map[string]int)
+	for _, activity := range s.activity.snapshot() {
+		workers[activity.Queue]++
+		if activity.State == workerProcessing {
+			busy[activity.Queue]++
+		}
+	}
+
+	signals := &ScalingSignals{WindowSeconds: window, Queues: []QueueScalingSignals{}}
+	for rows.Next() {
+		var queue QueueScalingSignals
+		var enqueued, finished int
+		if err := rows.Scan(&queue.Queue, &queue.Backlog, &enqueued, &finished, &queue.AverageWaitSeconds); err != nil {
+			return nil, fmt.Errorf("failed to scan scaling signals: %w", err)
+		}
+		queue.ArrivalRate = float64(enqueued) / window
+		queue.CompletionRate = float64(finished) / window
+		queue.GrowthRate = queue.ArrivalRate - queue.CompletionRate
+		if workers[queue.Queue] > 0 {
+			queue.Saturation = float64(busy[queue.Queue]) / float64(workers[queue.Queue])
+		}
+		signals.Queues = append(signals.Queues, queue)
+	}
+	return signals, nil
+}
+
+// ScalingHandler serves ScalingSignals as JSON for KEDA's metrics-api scaler or a
+// Kubernetes external metrics adapter. With a queue parameter only that queue's signals
+// are served, as a flat object, so a scaler can point straight at a field such as
+// growth_rate; a queue without recent activity reports zeros. Like DebugHandler it isn't
+// authenticated, so serve it on an internal port.
+//
+// Example:
+//
+//	http.Handle("/scaling", swig.ScalingHandler())
+//
+//	// KEDA trigger metadata:
+//	//   url: http://worker.default.svc:9090/scaling?queue=default
+//	//   valueLocation: backlog
+func (s *Swig) ScalingHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signals, err := s.ScalingSignals(r.Context())
+		if err != nil {
+			log.Printf("Failed to serve scaling signals: %v", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		queue := r.URL.Query().Get("queue")
+		if queue == "" {
+			writeDebugJSON(w, signals)
+			return
+		}
+		for _, q := range signals.Queues {
+			if q.Queue == queue {
+				writeDebugJSON(w, q)
+				return
+			}
+		}
+		writeDebugJSON(w, QueueScalingSignals{Queue: queue})
+	})
+}
@@ -0,0 +1,817 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// Arbitrary number for the advisory lock that serialises schema migrations
+const migrationLockID = 7654321
+
+// migration is a numbered, forward-only change to Swig's schema. Migrations are applied
+// in order by Start and recorded in swig_migrations so each one runs exactly once.
+type migration struct {
+	version int
+	sql     string
+	// functions creates or replaces functions and triggers. It's skipped in trigger-less
+	// mode, for databases where Swig isn't allowed to create them.
+	functions string
+	// breaking marks a migration older instances can't run against. It isn't applied
+	// while instances that don't know it are still running, see checkUpgradeSafety.
+	breaking bool
+}
+
+// migrations lists every schema change Swig has made. Append new migrations to the end;
+// never edit one that has been released.
+var migrations = []migration{
+	{
+		// Baseline schema. Written to be idempotent so databases created before
+		// migrations were tracked are adopted as-is.
+		version: 1,
+		sql: `
+		CREATE TABLE IF NOT EXISTS swig_jobs (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			kind VARCHAR NOT NULL,
+			queue VARCHAR NOT NULL,
+			payload JSONB NOT NULL,
+			status VARCHAR NOT NULL DEFAULT 'pending',
+			priority INTEGER NOT NULL DEFAULT 0,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			max_attempts INTEGER NOT NULL DEFAULT 3,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			scheduled_for TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			instance_id UUID,           -- ID of the Swig instance
+			worker_id UUID,             -- ID of the specific worker
+			locked_at TIMESTAMPTZ,
+			last_error TEXT,
+			last_error_at TIMESTAMPTZ,  -- When the last error occurred
+
+			CONSTRAINT valid_status CHECK (status IN (
+				'pending', 'processing', 'completed', 'failed', 'scheduled'
+			))
+		);
+
+		CREATE TABLE IF NOT EXISTS swig_leader (
+			id TEXT PRIMARY KEY,          -- Usually 'queue_leader'
+			leader_id UUID NOT NULL,      -- Unique ID of current leader
+			expires_at TIMESTAMPTZ NOT NULL,
+			acquired_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+			-- Ensure expires_at is always in the future
+			CONSTRAINT leader_expires_future CHECK (expires_at > NOW())
+		);
+
+		-- Unlogged for better performance since this is temporary state
+		ALTER TABLE swig_leader SET UNLOGGED;
+
+		-- Keeps the leader's retry pass and job acquisition off full table scans
+		CREATE INDEX IF NOT EXISTS swig_jobs_status_scheduled_idx
+			ON swig_jobs (status, scheduled_for);`,
+		functions: `
+		-- Create notification trigger for real-time job processing
+		CREATE OR REPLACE FUNCTION notify_job_created()
+			RETURNS trigger AS $$
+		BEGIN
+			PERFORM pg_notify(
+				'swig_jobs',
+				json_build_object(
+					'id', NEW.id,
+					'queue', NEW.queue,
+					'kind', NEW.kind
+				)::text
+			);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS swig_jobs_notify_trigger ON swig_jobs;
+		CREATE TRIGGER swig_jobs_notify_trigger
+			AFTER INSERT ON swig_jobs
+			FOR EACH ROW
+			EXECUTE FUNCTION notify_job_created();`,
+	},
+	{
+		// Retryable jobs get their own status and carry the time of their next attempt,
+		// so 'failed' is only ever a terminal state.
+		version: 2,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS next_retry_at TIMESTAMPTZ;
+
+		ALTER TABLE swig_jobs DROP CONSTRAINT IF EXISTS valid_status;
+		ALTER TABLE swig_jobs ADD CONSTRAINT valid_status CHECK (status IN (
+			'pending', 'processing', 'completed', 'retryable', 'failed', 'scheduled'
+		));
+
+		-- Failed jobs that still had attempts left were waiting for the old retry pass
+		UPDATE swig_jobs
+		SET status = 'retryable',
+			next_retry_at = COALESCE(last_error_at, NOW()) + (interval '1 second' * pow(2, attempts))
+		WHERE status = 'failed'
+			AND attempts < max_attempts;
+
+		CREATE INDEX IF NOT EXISTS swig_jobs_retryable_idx
+			ON swig_jobs (next_retry_at)
+			WHERE status = 'retryable';`,
+	},
+	{
+		// Jobs can carry a deadline after which they're expired instead of run
+		version: 3,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+		ALTER TABLE swig_jobs DROP CONSTRAINT IF EXISTS valid_status;
+		ALTER TABLE swig_jobs ADD CONSTRAINT valid_status CHECK (status IN (
+			'pending', 'processing', 'completed', 'retryable', 'failed', 'scheduled', 'expired'
+		));
+
+		CREATE INDEX IF NOT EXISTS swig_jobs_expires_at_idx
+			ON swig_jobs (expires_at)
+			WHERE expires_at IS NOT NULL;`,
+	},
+	{
+		// Track when jobs reach a terminal state, and when their payload was redacted
+		version: 4,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS finished_at TIMESTAMPTZ;
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS payload_redacted_at TIMESTAMPTZ;
+
+		CREATE INDEX IF NOT EXISTS swig_jobs_kind_finished_idx
+			ON swig_jobs (kind, finished_at)
+			WHERE finished_at IS NOT NULL;`,
+	},
+	{
+		// Soft-deleted jobs keep the status they were deleted from so they can be restored
+		version: 5,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS status_before_delete VARCHAR;
+
+		ALTER TABLE swig_jobs DROP CONSTRAINT IF EXISTS valid_status;
+		ALTER TABLE swig_jobs ADD CONSTRAINT valid_status CHECK (status IN (
+			'pending', 'processing', 'completed', 'retryable', 'failed', 'scheduled', 'expired', 'deleted'
+		));
+
+		CREATE INDEX IF NOT EXISTS swig_jobs_deleted_at_idx
+			ON swig_jobs (deleted_at)
+			WHERE status = 'deleted';`,
+	},
+	{
+		// Payloads record the version of the worker arguments they were serialized from
+		version: 6,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS payload_version INTEGER NOT NULL DEFAULT 1;`,
+	},
+	{
+		// Notifications say when the job is due and which instance inserted it, so
+		// listeners can skip jobs that aren't ready and ones they inserted themselves
+		version: 7,
+		functions: `
+		CREATE OR REPLACE FUNCTION notify_job_created()
+			RETURNS trigger AS $$
+		BEGIN
+			PERFORM pg_notify(
+				'swig_jobs',
+				json_build_object(
+					'id', NEW.id,
+					'queue', NEW.queue,
+					'kind', NEW.kind,
+					'scheduled_for', to_char(NEW.scheduled_for AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
+					'origin', current_setting('swig.origin', true)
+				)::text
+			);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;`,
+	},
+	{
+		// Jobs can be tied to the instance that enqueued them, for a while or for good
+		version: 8,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS preferred_instance_id UUID;
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS affinity_until TIMESTAMPTZ;`,
+	},
+	{
+		// Jobs can be restricted to instances in a region
+		version: 9,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS region VARCHAR;`,
+	},
+	{
+		// Failures are stored with a code and structured details next to the message
+		version: 10,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS last_error_code VARCHAR;
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS last_error_details JSONB;
+
+		CREATE INDEX IF NOT EXISTS swig_jobs_last_error_code_idx
+			ON swig_jobs (last_error_code, status)
+			WHERE last_error_code IS NOT NULL;`,
+	},
+	{
+		// Notifications carry a random claim token that picks which instances go for the
+		// job first, so they don't all race for it at once
+		version: 11,
+		functions: `
+		CREATE OR REPLACE FUNCTION notify_job_created()
+			RETURNS trigger AS $$
+		BEGIN
+			PERFORM pg_notify(
+				'swig_jobs',
+				json_build_object(
+					'id', NEW.id,
+					'queue', NEW.queue,
+					'kind', NEW.kind,
+					'scheduled_for', to_char(NEW.scheduled_for AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
+					'origin', current_setting('swig.origin', true),
+					'claim', 1 + floor(random() * 4294967294)::bigint
+				)::text
+			);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;`,
+	},
+	{
+		// Registry of running instances and the capabilities each kind needs, so mixed
+		// fleets can keep kinds on the instances able to run them
+		version: 12,
+		sql: `
+		CREATE TABLE IF NOT EXISTS swig_instances (
+			id UUID PRIMARY KEY,
+			capabilities TEXT[] NOT NULL DEFAULT '{}',
+			region VARCHAR,
+			started_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		CREATE TABLE IF NOT EXISTS swig_kind_requirements (
+			kind VARCHAR PRIMARY KEY,
+			capabilities TEXT[] NOT NULL
+		);`,
+	},
+	{
+		// Optional deduplication of notifications: a job identical to one still pending,
+		// enqueued within the window in swig_settings, is inserted without a notification
+		version: 13,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS payload_hash TEXT
+			GENERATED ALWAYS AS (md5(payload::text)) STORED;
+
+		CREATE INDEX IF NOT EXISTS idx_swig_jobs_dedup
+			ON swig_jobs (kind, payload_hash)
+			WHERE status = 'pending';
+
+		CREATE TABLE IF NOT EXISTS swig_settings (
+			name VARCHAR PRIMARY KEY,
+			value TEXT NOT NULL
+		);`,
+		functions: `
+		CREATE OR REPLACE FUNCTION notify_job_created()
+			RETURNS trigger AS $$
+		DECLARE
+			dedup_window INTERVAL;
+		BEGIN
+			SELECT value::interval INTO dedup_window
+			FROM swig_settings
+			WHERE name = 'notify_dedup_window';
+
+			-- An earlier identical job is still pending, and its notification covers this one
+			IF dedup_window IS NOT NULL AND EXISTS (
+				SELECT 1 FROM swig_jobs
+				WHERE kind = NEW.kind
+					AND payload_hash = NEW.payload_hash
+					AND status = 'pending'
+					AND id <> NEW.id
+					AND created_at > NOW() - dedup_window
+					AND (created_at < NEW.created_at OR (created_at = NEW.created_at AND id < NEW.id))
+			) THEN
+				RETURN NEW;
+			END IF;
+
+			PERFORM pg_notify(
+				'swig_jobs',
+				json_build_object(
+					'id', NEW.id,
+					'queue', NEW.queue,
+					'kind', NEW.kind,
+					'scheduled_for', to_char(NEW.scheduled_for AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
+					'origin', current_setting('swig.origin', true),
+					'claim', 1 + floor(random() * 4294967294)::bigint
+				)::text
+			);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;`,
+	},
+	{
+		// Tenant of each job, so pending jobs can be capped per customer
+		version: 14,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS tenant VARCHAR;
+
+		CREATE INDEX IF NOT EXISTS idx_swig_jobs_tenant_pending
+			ON swig_jobs (tenant, queue)
+			WHERE status = 'pending' AND tenant IS NOT NULL;`,
+	},
+	{
+		// Time-ordered UUIDs (version 7) for use as the job ID default
+		version: 15,
+		sql:     uuidV7Function,
+	},
+	{
+		// Free-form labels attached when a job is enqueued, such as trace IDs
+		version: 16,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';`,
+	},
+	{
+		// Stable instance names, so a restarted instance can release jobs its previous
+		// incarnation was processing when it died
+		version: 17,
+		sql: `
+		ALTER TABLE swig_instances ADD COLUMN IF NOT EXISTS name VARCHAR;
+
+		CREATE INDEX IF NOT EXISTS idx_swig_instances_name
+			ON swig_instances (name)
+			WHERE name IS NOT NULL;`,
+	},
+	{
+		// Name of the instance that last ran each job, kept after it finishes so runs can be
+		// traced to a deployment unit across restarts
+		version: 18,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS instance_name VARCHAR;`,
+	},
+	{
+		// Keeps ListJobs' time range filters and sorts off full table scans
+		version: 19,
+		sql: `
+		CREATE INDEX IF NOT EXISTS swig_jobs_created_at_idx
+			ON swig_jobs (created_at);
+
+		CREATE INDEX IF NOT EXISTS swig_jobs_status_created_idx
+			ON swig_jobs (status, created_at);
+
+		CREATE INDEX IF NOT EXISTS swig_jobs_finished_at_idx
+			ON swig_jobs (finished_at)
+			WHERE finished_at IS NOT NULL;`,
+	},
+	{
+		// Delivery deadlines, which order jobs within a priority and are reported when missed
+		version: 20,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS deadline TIMESTAMPTZ;
+
+		CREATE INDEX IF NOT EXISTS swig_jobs_deadline_idx
+			ON swig_jobs (deadline)
+			WHERE deadline IS NOT NULL AND status IN ('pending', 'retryable', 'processing');`,
+	},
+	{
+		// Request-scoped values carried from the enqueuing context into Process
+		version: 21,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS context_values JSONB;`,
+	},
+	{
+		// Staged jobs are held back until released with their staging token, and don't
+		// notify workers until then
+		version: 22,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS staging_token UUID;
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS staged_until TIMESTAMPTZ;
+
+		ALTER TABLE swig_jobs DROP CONSTRAINT IF EXISTS valid_status;
+		ALTER TABLE swig_jobs ADD CONSTRAINT valid_status CHECK (status IN (
+			'pending', 'processing', 'completed', 'retryable', 'failed', 'scheduled', 'expired', 'deleted', 'staged'
+		));
+
+		CREATE INDEX IF NOT EXISTS swig_jobs_staging_token_idx
+			ON swig_jobs (staging_token)
+			WHERE staging_token IS NOT NULL;
+
+		CREATE INDEX IF NOT EXISTS swig_jobs_staged_until_idx
+			ON swig_jobs (staged_until)
+			WHERE status = 'staged';`,
+		functions: `
+		CREATE OR REPLACE FUNCTION notify_job_created()
+			RETURNS trigger AS $$
+		DECLARE
+			dedup_window INTERVAL;
+		BEGIN
+			-- Staged jobs are announced when they're released
+			IF NEW.status = 'staged' THEN
+				RETURN NEW;
+			END IF;
+
+			SELECT value::interval INTO dedup_window
+			FROM swig_settings
+			WHERE name = 'notify_dedup_window';
+
+			-- An earlier identical job is still pending, and its notification covers this one
+			IF dedup_window IS NOT NULL AND EXISTS (
+				SELECT 1 FROM swig_jobs
+				WHERE kind = NEW.kind
+					AND payload_hash = NEW.payload_hash
+					AND status = 'pending'
+					AND id <> NEW.id
+					AND created_at > NOW() - dedup_window
+					AND (created_at < NEW.created_at OR (created_at = NEW.created_at AND id < NEW.id))
+			) THEN
+				RETURN NEW;
+			END IF;
+
+			PERFORM pg_notify(
+				'swig_jobs',
+				json_build_object(
+					'id', NEW.id,
+					'queue', NEW.queue,
+					'kind', NEW.kind,
+					'scheduled_for', to_char(NEW.scheduled_for AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
+					'origin', current_setting('swig.origin', true),
+					'claim', 1 + floor(random() * 4294967294)::bigint
+				)::text
+			);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;`,
+	},
+	{
+		// Shadow jobs point at the production job they mirror, and record when the leader
+		// handed the pair to the shadow handler
+		version: 23,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS shadow_of UUID;
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS shadow_compared_at TIMESTAMPTZ;
+
+		CREATE INDEX IF NOT EXISTS swig_jobs_shadow_pending_idx
+			ON swig_jobs (shadow_of)
+			WHERE shadow_of IS NOT NULL AND shadow_compared_at IS NULL;`,
+	},
+	{
+		// Output of completed jobs, and how a shadow job's outcome differed from its
+		// production job's
+		version: 24,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS result JSONB;
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS shadow_mismatch VARCHAR;`,
+	},
+	{
+		// Concurrency limits per key. A job holds a slot while it's processing with
+		// holds_semaphore set, so slots free themselves however the job ends.
+		version: 25,
+		sql: `
+		CREATE TABLE IF NOT EXISTS swig_semaphores (
+			key VARCHAR PRIMARY KEY,
+			max_concurrency INTEGER NOT NULL CHECK (max_concurrency > 0)
+		);
+
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS concurrency_key VARCHAR;
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS holds_semaphore BOOLEAN NOT NULL DEFAULT FALSE;
+
+		CREATE INDEX IF NOT EXISTS swig_jobs_semaphore_holders_idx
+			ON swig_jobs ((COALESCE(concurrency_key, kind)))
+			WHERE holds_semaphore AND status = 'processing';`,
+	},
+	{
+		// Jobs leased to external workers are processing until their lease runs out
+		version: 26,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
+
+		CREATE INDEX IF NOT EXISTS swig_jobs_lease_expires_at_idx
+			ON swig_jobs (lease_expires_at)
+			WHERE status = 'processing' AND lease_expires_at IS NOT NULL;`,
+	},
+	{
+		// When each attempt started, kept once the job finishes so digests can report how
+		// long jobs take, and the digest periods already reported, so a new leader doesn't
+		// send one twice
+		version: 27,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS started_at TIMESTAMPTZ;
+
+		CREATE TABLE IF NOT EXISTS swig_digests (
+			period_start TIMESTAMPTZ PRIMARY KEY,
+			sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);`,
+	},
+	{
+		// Notifications carry the version of their envelope, so fields can be added
+		// without confusing instances that haven't been upgraded yet
+		version: 28,
+		functions: `
+		CREATE OR REPLACE FUNCTION notify_job_created()
+			RETURNS trigger AS $$
+		DECLARE
+			dedup_window INTERVAL;
+		BEGIN
+			-- Staged jobs are announced when they're released
+			IF NEW.status = 'staged' THEN
+				RETURN NEW;
+			END IF;
+
+			SELECT value::interval INTO dedup_window
+			FROM swig_settings
+			WHERE name = 'notify_dedup_window';
+
+			-- An earlier identical job is still pending, and its notification covers this one
+			IF dedup_window IS NOT NULL AND EXISTS (
+				SELECT 1 FROM swig_jobs
+				WHERE kind = NEW.kind
+					AND payload_hash = NEW.payload_hash
+					AND status = 'pending'
+					AND id <> NEW.id
+					AND created_at > NOW() - dedup_window
+					AND (created_at < NEW.created_at OR (created_at = NEW.created_at AND id < NEW.id))
+			) THEN
+				RETURN NEW;
+			END IF;
+
+			PERFORM pg_notify(
+				'swig_jobs',
+				json_build_object(
+					'v', 2,
+					'id', NEW.id,
+					'queue', NEW.queue,
+					'kind', NEW.kind,
+					'scheduled_for', to_char(NEW.scheduled_for AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
+					'origin', current_setting('swig.origin', true),
+					'claim', 1 + floor(random() * 4294967294)::bigint
+				)::text
+			);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;`,
+	},
+	{
+		// Library and schema versions of each instance, and when it was last seen, so
+		// mixed versions can be detected during a rolling upgrade
+		version: 29,
+		sql: `
+		ALTER TABLE swig_instances ADD COLUMN IF NOT EXISTS swig_version VARCHAR;
+		ALTER TABLE swig_instances ADD COLUMN IF NOT EXISTS schema_version INTEGER;
+		ALTER TABLE swig_instances ADD COLUMN IF NOT EXISTS notify_version INTEGER;
+		ALTER TABLE swig_instances ADD COLUMN IF NOT EXISTS seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW();`,
+	},
+	{
+		// Notes operators leave on jobs, see AnnotateJob
+		version: 30,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS notes JSONB NOT NULL DEFAULT '[]';`,
+	},
+	{
+		// Statuses are stored as an enum rather than text, which takes 4 bytes per row and
+		// index entry. The column is rewritten, which locks swig_jobs while it runs, and
+		// instances that bind statuses as text can't query it afterwards. Partial indexes
+		// are recreated so their predicates compare enums and still match queries.
+		version:  31,
+		breaking: true,
+		sql: `
+		DO $$
+		BEGIN
+			CREATE TYPE swig_job_status AS ENUM (
+				'pending', 'processing', 'completed', 'retryable', 'failed', 'scheduled', 'expired', 'deleted', 'staged'
+			);
+		EXCEPTION WHEN duplicate_object THEN NULL;
+		END $$;
+
+		ALTER TABLE swig_jobs DROP CONSTRAINT IF EXISTS valid_status;
+
+		DROP INDEX IF EXISTS swig_jobs_retryable_idx;
+		DROP INDEX IF EXISTS swig_jobs_deleted_at_idx;
+		DROP INDEX IF EXISTS idx_swig_jobs_dedup;
+		DROP INDEX IF EXISTS idx_swig_jobs_tenant_pending;
+		DROP INDEX IF EXISTS swig_jobs_deadline_idx;
+		DROP INDEX IF EXISTS swig_jobs_staged_until_idx;
+		DROP INDEX IF EXISTS swig_jobs_semaphore_holders_idx;
+		DROP INDEX IF EXISTS swig_jobs_lease_expires_at_idx;
+
+		ALTER TABLE swig_jobs ALTER COLUMN status DROP DEFAULT;
+		ALTER TABLE swig_jobs
+			ALTER COLUMN status TYPE swig_job_status USING status::swig_job_status,
+			ALTER COLUMN status_before_delete TYPE swig_job_status USING status_before_delete::swig_job_status;
+		ALTER TABLE swig_jobs ALTER COLUMN status SET DEFAULT 'pending';
+
+		CREATE INDEX swig_jobs_retryable_idx
+			ON swig_jobs (next_retry_at)
+			WHERE status = 'retryable';
+
+		CREATE INDEX swig_jobs_deleted_at_idx
+			ON swig_jobs (deleted_at)
+			WHERE status = 'deleted';
+
+		CREATE INDEX idx_swig_jobs_dedup
+			ON swig_jobs (kind, payload_hash)
+			WHERE status = 'pending';
+
+		CREATE INDEX idx_swig_jobs_tenant_pending
+			ON swig_jobs (tenant, queue)
+			WHERE status = 'pending' AND tenant IS NOT NULL;
+
+		CREATE INDEX swig_jobs_deadline_idx
+			ON swig_jobs (deadline)
+			WHERE deadline IS NOT NULL AND status IN ('pending', 'retryable', 'processing');
+
+		CREATE INDEX swig_jobs_staged_until_idx
+			ON swig_jobs (staged_until)
+			WHERE status = 'staged';
+
+		CREATE INDEX swig_jobs_semaphore_holders_idx
+			ON swig_jobs ((COALESCE(concurrency_key, kind)))
+			WHERE holds_semaphore AND status = 'processing';
+
+		CREATE INDEX swig_jobs_lease_expires_at_idx
+			ON swig_jobs (lease_expires_at)
+			WHERE status = 'processing' AND lease_expires_at IS NOT NULL;`,
+	},
+	{
+		// Unique jobs. A key is taken while a job with it is waiting and hasn't started;
+		// started_at stays set when a job is handed back to pending, so a job that has run
+		// never takes its key back from one enqueued since.
+		version: 32,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS unique_key TEXT;
+
+		CREATE UNIQUE INDEX IF NOT EXISTS swig_jobs_unique_key_idx
+			ON swig_jobs (unique_key)
+			WHERE unique_key IS NOT NULL AND status IN ('pending', 'scheduled', 'staged') AND started_at IS NULL;`,
+	},
+	{
+		// Finished jobs by kind and age, for the retention pass
+		version: 33,
+		sql: `
+		CREATE INDEX IF NOT EXISTS swig_jobs_finished_idx
+			ON swig_jobs (kind, finished_at)
+			WHERE status IN ('completed', 'failed', 'expired');`,
+	},
+	{
+		// Instances record the queues they process, so jobs enqueued to queues nobody
+		// processes can be spotted. NULL for instances that predate it.
+		version: 34,
+		sql: `
+		ALTER TABLE swig_instances ADD COLUMN IF NOT EXISTS queues TEXT[];`,
+	},
+	{
+		// How many duplicate pending jobs were collapsed into each job, see
+		// WithDuplicateCompaction
+		version: 35,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS collapsed_count INTEGER NOT NULL DEFAULT 0;`,
+	},
+	{
+		// Checkpoints saved by running jobs, and the deployment each instance belongs to,
+		// so jobs interrupted by a deploy are handed to a replacement that resumes them
+		version: 36,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS checkpoint JSONB;
+		ALTER TABLE swig_instances ADD COLUMN IF NOT EXISTS deployment_id VARCHAR;
+		ALTER TABLE swig_instances ADD COLUMN IF NOT EXISTS stopping_at TIMESTAMPTZ;`,
+	},
+	{
+		// History of leader transitions and maintenance passes, see ListSystemEvents
+		version: 37,
+		sql: `
+		CREATE TABLE IF NOT EXISTS swig_events (
+			id BIGSERIAL PRIMARY KEY,
+			type VARCHAR NOT NULL,
+			instance_id UUID NOT NULL,
+			instance_name VARCHAR,
+			task VARCHAR,
+			row_count INTEGER,
+			duration_ms BIGINT,
+			error TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		CREATE INDEX IF NOT EXISTS swig_events_created_at_idx
+			ON swig_events (created_at);`,
+	},
+	{
+		// Lineage of jobs cloned from another, with the original's payload so the
+		// difference can be shown after the original is pruned, see CloneJob
+		version: 38,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS cloned_from UUID;
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS cloned_from_payload JSONB;
+
+		CREATE INDEX IF NOT EXISTS swig_jobs_cloned_from_idx
+			ON swig_jobs (cloned_from)
+			WHERE cloned_from IS NOT NULL;`,
+	},
+	{
+		// How often each job was handed back without reaching its worker, so jobs no
+		// instance can run eventually fail instead of cycling forever
+		version: 39,
+		sql: `
+		ALTER TABLE swig_jobs ADD COLUMN IF NOT EXISTS release_count INTEGER NOT NULL DEFAULT 0;`,
+	},
+	{
+		// Databases migrated without triggers skipped swig_uuid_v7 in version 15, when it
+		// was applied with the trigger functions
+		version: 40,
+		sql:     uuidV7Function,
+	},
+}
+
+// uuidV7Function creates swig_uuid_v7(), which generates time-ordered UUIDs (version 7).
+// It's plain SQL rather than a trigger, so it's created in trigger-less mode too.
+const uuidV7Function = `
+		CREATE OR REPLACE FUNCTION swig_uuid_v7()
+			RETURNS uuid AS $$
+			SELECT encode(
+				set_bit(
+					set_bit(
+						overlay(uuid_send(gen_random_uuid())
+							PLACING substring(int8send(floor(extract(epoch FROM clock_timestamp()) * 1000)::bigint) FROM 3)
+							FROM 1 FOR 6),
+						52, 1),
+					53, 1),
+				'hex')::uuid;
+		$$ LANGUAGE sql VOLATILE;`
+
+// schemaVersion returns the latest migration applied to the database
+func (s *Swig) schemaVersion(ctx context.Context) (int, error) {
+	var version int
+	if err := s.driver.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM swig_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrate brings the database schema up to date. Instances starting at the same time
+// are serialised with an advisory lock, and all pending migrations apply in one transaction.
+// If the role isn't allowed to create functions or triggers, the migrations are applied
+// again without them and Swig runs in trigger-less mode, see WithoutTriggers. Databases
+// without LISTEN/NOTIFY are always migrated without them, since the trigger notifies.
+func (s *Swig) migrate(ctx context.Context) error {
+	if !s.driverCaps.ListenNotify {
+		s.withoutTriggers = true
+	}
+	err := s.applyMigrations(ctx)
+	if err != nil && !s.withoutTriggers && sqlState(err) == insufficientPrivilege {
+		log.Printf("Not allowed to create the notification trigger, migrating without it: %v", err)
+		s.withoutTriggers = true
+		err = s.applyMigrations(ctx)
+	}
+	return err
+}
+
+// migrationsTableSQL creates the table recording which migrations were applied
+const migrationsTableSQL = `
+	CREATE TABLE IF NOT EXISTS swig_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+
+// applyMigrations applies the migrations the database hasn't had yet
+func (s *Swig) applyMigrations(ctx context.Context) error {
+	return s.driver.WithTx(ctx, func(tx drivers.Transaction) error {
+		if s.driverCaps.AdvisoryLocks {
+			if err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+				return fmt.Errorf("failed to acquire migration lock: %w", err)
+			}
+		}
+
+		if err := tx.Exec(ctx, migrationsTableSQL); err != nil {
+			return fmt.Errorf("failed to create migrations table: %w", err)
+		}
+
+		// Without advisory locks, the migrations table itself serialises instances
+		if !s.driverCaps.AdvisoryLocks {
+			if err := tx.Exec(ctx, `LOCK TABLE swig_migrations IN EXCLUSIVE MODE`); err != nil {
+				return fmt.Errorf("failed to acquire migration lock: %w", err)
+			}
+		}
+
+		var current int
+		if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM swig_migrations`).Scan(&current); err != nil {
+			return fmt.Errorf("failed to read schema version: %w", err)
+		}
+
+		for _, m := range migrations {
+			if m.version <= current {
+				continue
+			}
+			if m.breaking {
+				if err := checkUpgradeSafety(ctx, tx, m.version, s.workerID); err != nil {
+					return err
+				}
+			}
+			if m.sql != "" {
+				if err := tx.Exec(ctx, m.sql); err != nil {
+					return fmt.Errorf("failed to apply migration %d: %w", m.version, err)
+				}
+			}
+			if m.functions != "" && !s.withoutTriggers {
+				if err := tx.Exec(ctx, m.functions); err != nil {
+					return fmt.Errorf("failed to apply functions of migration %d: %w", m.version, err)
+				}
+			}
+			if err := tx.Exec(ctx, `INSERT INTO swig_migrations (version) VALUES ($1)`, m.version); err != nil {
+				return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,108 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// How long a job handed back because its concurrency limit was full waits before it's
+// tried again
+const semaphoreRetryDelay = time.Second
+
+// semaphoreAvailable is an acquire condition leaving jobs whose concurrency limit is full
+const semaphoreAvailable = `NOT EXISTS (
+					SELECT 1 FROM swig_semaphores sem
+					WHERE sem.key = COALESCE(swig_jobs.concurrency_key, swig_jobs.kind)
+					AND sem.max_concurrency <= (
+						SELECT COUNT(*) FROM swig_jobs holder
+						WHERE holder.holds_semaphore
+						AND holder.status = 'processing'
+						AND COALESCE(holder.concurrency_key, holder.kind) = sem.key))`
+
+// SetConcurrencyLimit caps how many jobs with the concurrency key run at once across all
+// instances. Jobs count against their JobOptions.ConcurrencyKey, or their kind when it's
+// empty. Jobs over the limit stay pending without holding up other jobs in their queue.
+// The limit is stored in the database, so every instance honours it; a limit of 0 or less
+// lifts it. Limits don't apply to kinds processed with WithTransactionalKinds.
+//
+// Example:
+//
+//	// At most 3 syncs with provider X at a time
+//	err := swig.SetConcurrencyLimit(ctx, "sync_provider_x", 3)
+func (s *Swig) SetConcurrencyLimit(ctx context.Context, key string, limit int) error {
+	if limit <= 0 {
+		if err := s.driver.Exec(ctx, `DELETE FROM swig_semaphores WHERE key = $1`, key); err != nil {
+			return fmt.Errorf("failed to clear concurrency limit for %s: %w", key, err)
+		}
+		return nil
+	}
+
+	limitSQL := `
+		INSERT INTO swig_semaphores (key, max_concurrency)
+		VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET max_concurrency = EXCLUDED.max_concurrency`
+	if err := s.driver.Exec(ctx, limitSQL, key, limit); err != nil {
+		return fmt.Errorf("failed to set concurrency limit for %s: %w", key, err)
+	}
+	return nil
+}
+
+// takeSemaphore claims a slot under job's concurrency limit and reports whether there was
+// one. The limit's row is locked while the holders are counted, so instances claiming
+// slots for the same key take turns.
+func (s *Swig) takeSemaphore(ctx context.Context, job acquiredJob) (bool, error) {
+	held := false
+	err := s.driver.WithTx(ctx, func(tx drivers.Transaction) error {
+		var limit int
+		err := tx.QueryRow(ctx, `SELECT max_concurrency FROM swig_semaphores WHERE key = $1 FOR UPDATE`, job.concurrencyKey).Scan(&limit)
+		if isNoRows(err) {
+			// The limit was lifted since the job was acquired
+			held = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		countSQL := `
+			SELECT COUNT(*) FROM swig_jobs
+			WHERE holds_semaphore
+				AND status = 'processing'
+				AND COALESCE(concurrency_key, kind) = $1`
+		var holders int
+		if err := tx.QueryRow(ctx, countSQL, job.concurrencyKey).Scan(&holders); err != nil {
+			return err
+		}
+		if holders >= limit {
+			return nil
+		}
+
+		held = true
+		return tx.Exec(ctx, `UPDATE swig_jobs SET holds_semaphore = TRUE WHERE id = $1`, job.ID)
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to take concurrency slot for %s: %w", job.concurrencyKey, err)
+	}
+	return held, nil
+}
+
+// yieldJob hands back a job that couldn't get a concurrency slot, undoing the attempt taken
+// at acquisition without recording a failure
+func (s *Swig) yieldJob(ctx context.Context, job acquiredJob) error {
+	yieldSQL := `
+		UPDATE swig_jobs
+		SET status = 'pending',
+			attempts = GREATEST(attempts - 1, 0),
+			instance_id = NULL,
+			worker_id = NULL,
+			locked_at = NULL,
+			scheduled_for = NOW() + make_interval(secs => $2)
+		WHERE id = $1`
+	if err := s.driver.Exec(ctx, yieldSQL, job.ID, semaphoreRetryDelay.Seconds()); err != nil {
+		return fmt.Errorf("failed to hand back job %s: %w", job.ID, err)
+	}
+	return nil
+}
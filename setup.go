@@ -0,0 +1,59 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/glamboyosa/swig/workers"
+)
+
+// setupWorkers calls Setup on every registered worker that has one, in order of job name.
+// If a worker's Setup fails and its kind isn't optional, the workers already set up are
+// torn down again and the error is returned, failing Start.
+func (s *Swig) setupWorkers(ctx context.Context) error {
+	registered := s.Workers.Registered()
+	kinds := make([]string, 0, len(registered))
+	for kind := range registered {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	s.setUpKinds, s.unavailableKinds = nil, nil
+	for _, kind := range kinds {
+		if setuper, ok := registered[kind].(workers.Setuper); ok {
+			if err := setuper.Setup(ctx); err != nil {
+				if s.optionalKinds[kind] {
+					log.Printf("Failed to set up optional worker %s, leaving its jobs to other instances: %v", kind, err)
+					s.unavailableKinds = append(s.unavailableKinds, kind)
+					continue
+				}
+				s.teardownWorkers(ctx)
+				return fmt.Errorf("failed to set up worker %s: %w", kind, err)
+			}
+		}
+		s.setUpKinds = append(s.setUpKinds, kind)
+	}
+
+	// Aliases run on their target's worker, so they're unavailable along with it
+	for _, kind := range s.unavailableKinds {
+		for _, alias := range s.Workers.Kinds() {
+			if alias != kind && s.Workers.Resolve(alias) == kind {
+				s.unavailableKinds = append(s.unavailableKinds, alias)
+			}
+		}
+	}
+	return nil
+}
+
+// teardownWorkers calls Teardown on the workers set up by setupWorkers, in reverse order
+func (s *Swig) teardownWorkers(ctx context.Context) {
+	registered := s.Workers.Registered()
+	for i := len(s.setUpKinds) - 1; i >= 0; i-- {
+		if teardowner, ok := registered[s.setUpKinds[i]].(workers.Teardowner); ok {
+			teardowner.Teardown(ctx)
+		}
+	}
+	s.setUpKinds = nil
+}
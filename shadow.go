@@ -0,0 +1,233 @@
+package swig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"reflect"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+	"github.com/glamboyosa/swig/workers"
+)
+
+// How often the leader looks for finished production and shadow job pairs
+const shadowCompareInterval = 10 * time.Second
+
+// How a shadow job's outcome differed from its production job's, see JobRecord.ShadowMismatch
+const (
+	ShadowMismatchStatus = "status" // One completed and the other didn't
+	ShadowMismatchResult = "result" // Both completed with different results
+)
+
+// ShadowConfig describes how a kind is mirrored, see WithShadow
+type ShadowConfig struct {
+	Kind    string     // JobName of the worker that runs the mirrored jobs
+	Queue   QueueTypes // Queue mirrored jobs go to. Defaults to Shadow.
+	Percent float64    // Share of jobs to mirror, from 0 to 100
+	// CompareResults reports whether the results of a production job and its shadow
+	// agree, for outputs that legitimately differ, like generated IDs or timestamps.
+	// By default they must be equal as JSON, ignoring key order and whitespace.
+	CompareResults func(production, shadow json.RawMessage) bool
+}
+
+// queue returns the queue mirrored jobs go to
+func (c ShadowConfig) queue() QueueTypes {
+	if c.Queue == "" {
+		return Shadow
+	}
+	return c.Queue
+}
+
+// ShadowHandler receives a production job and its shadow once both have finished
+type ShadowHandler func(ctx context.Context, production, shadow *JobRecord)
+
+// mirrorJobs appends a shadow job for each job of a shadowed kind picked for mirroring.
+// Mirrored production jobs are given an ID up front so their shadow can point at them.
+func (s *Swig) mirrorJobs(jobs []drivers.BatchJob) ([]drivers.BatchJob, error) {
+	if len(s.shadows) == 0 {
+		return jobs, nil
+	}
+
+	mirrored := make([]drivers.BatchJob, 0, len(jobs))
+	var shadows []drivers.BatchJob
+	for _, job := range jobs {
+		kind := job.Kind
+		if named, ok := job.Worker.(interface{ JobName() string }); ok {
+			kind = named.JobName()
+		}
+		config, ok := s.shadows[kind]
+		if !ok || rand.Float64()*100 >= config.Percent {
+			mirrored = append(mirrored, job)
+			continue
+		}
+
+		payload, version := []byte(job.Payload), job.PayloadVersion
+		if job.Payload == nil {
+			var err error
+			if payload, err = workers.MarshalArgs(job.Worker); err != nil {
+				return nil, fmt.Errorf("failed to serialize job arguments: %w", err)
+			}
+			version = workers.PayloadVersion(job.Worker)
+		}
+		if job.Opts.ID == "" {
+			job.Opts.ID = s.generateID()
+		}
+
+		shadow := drivers.BatchJob{Kind: config.Kind, Payload: payload, PayloadVersion: version, Opts: job.Opts}
+		shadow.Opts.ID = ""
+		shadow.Opts.Queue = string(config.queue())
+		shadow.Opts.ShadowOf = job.Opts.ID
+		mirrored = append(mirrored, job)
+		shadows = append(shadows, shadow)
+	}
+	return append(mirrored, shadows...), nil
+}
+
+// compareShadowJobs hands production jobs whose shadow has also finished to the shadow
+// handler, marking each pair so it's handed over once
+func (s *Swig) compareShadowJobs(ctx context.Context) error {
+	compareSQL := `
+		UPDATE swig_jobs shadow
+		SET shadow_compared_at = NOW()
+		FROM swig_jobs production
+		WHERE production.id = shadow.shadow_of
+			AND production.status IN ('completed', 'failed', 'expired')
+			AND shadow.id IN (
+				SELECT id
+				FROM swig_jobs
+				WHERE shadow_of IS NOT NULL
+					AND shadow_compared_at IS NULL
+					AND status IN ('completed', 'failed', 'expired')
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+		RETURNING production.id, shadow.id`
+
+	rows, err := s.driver.Query(ctx, compareSQL, maintenanceBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to find finished shadow jobs: %w", err)
+	}
+	var pairs [][2]string
+	for rows.Next() {
+		var pair [2]string
+		if err := rows.Scan(&pair[0], &pair[1]); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan shadow job pair: %w", err)
+		}
+		pairs = append(pairs, pair)
+	}
+	rows.Close()
+	addMaintenanceRows(ctx, len(pairs))
+
+	for _, pair := range pairs {
+		production, err := s.GetJob(ctx, pair[0])
+		if err != nil {
+			log.Printf("Failed to load production job %s for comparison: %v", pair[0], err)
+			continue
+		}
+		shadow, err := s.GetJob(ctx, pair[1])
+		if err != nil {
+			log.Printf("Failed to load shadow job %s for comparison: %v", pair[1], err)
+			continue
+		}
+
+		shadow.ShadowMismatch = s.shadowMismatch(production, shadow)
+		if shadow.ShadowMismatch != "" {
+			log.Printf("Shadow job %s (%s) disagrees with job %s (%s) on %s",
+				shadow.ID, shadow.Kind, production.ID, production.Kind, shadow.ShadowMismatch)
+			mismatchSQL := `UPDATE swig_jobs SET shadow_mismatch = $2 WHERE id = $1`
+			if err := s.driver.Exec(ctx, mismatchSQL, shadow.ID, shadow.ShadowMismatch); err != nil {
+				return fmt.Errorf("failed to record shadow mismatch: %w", err)
+			}
+		}
+		if s.shadowHandler != nil {
+			s.shadowHandler(ctx, production, shadow)
+		}
+	}
+	return nil
+}
+
+// shadowMismatch compares the outcome of a production job and its shadow, returning how
+// they differ or an empty string when they agree
+func (s *Swig) shadowMismatch(production, shadow *JobRecord) string {
+	if production.Status != shadow.Status {
+		return ShadowMismatchStatus
+	}
+	if production.Status != "completed" {
+		return ""
+	}
+	compare := s.shadows[production.Kind].CompareResults
+	if compare == nil {
+		compare = equalJSON
+	}
+	if !compare(production.Result, shadow.Result) {
+		return ShadowMismatchResult
+	}
+	return ""
+}
+
+// equalJSON reports whether two JSON documents hold the same values
+func equalJSON(a, b json.RawMessage) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var decodedA, decodedB interface{}
+	if json.Unmarshal(a, &decodedA) != nil || json.Unmarshal(b, &decodedB) != nil {
+		return false
+	}
+	return reflect.DeepEqual(decodedA, decodedB)
+}
+
+// ShadowSummary is how the shadow jobs of a kind compared with their production jobs
+type ShadowSummary struct {
+	Kind             string `json:"kind"`        // Production kind
+	ShadowKind       string `json:"shadow_kind"` // Kind of the worker shadowing it
+	Compared         int    `json:"compared"`
+	StatusMismatches int    `json:"status_mismatches"`
+	ResultMismatches int    `json:"result_mismatches"`
+}
+
+// ShadowReport summarizes every comparison of a shadow job with its production job so
+// far, by kind. Use it to decide when a rewritten worker is ready to take over; the jobs
+// behind a mismatch can be found with ListJobs and their ShadowMismatch.
+//
+// Example:
+//
+//	report, err := swig.ShadowReport(ctx)
+//	for _, summary := range report {
+//	    fmt.Printf("%s -> %s: %d compared, %d mismatched\n", summary.Kind, summary.ShadowKind,
+//	        summary.Compared, summary.StatusMismatches+summary.ResultMismatches)
+//	}
+func (s *Swig) ShadowReport(ctx context.Context) ([]ShadowSummary, error) {
+	reportSQL := `
+		SELECT production.kind, shadow.kind,
+			COUNT(*),
+			COUNT(*) FILTER (WHERE shadow.shadow_mismatch = 'status'),
+			COUNT(*) FILTER (WHERE shadow.shadow_mismatch = 'result')
+		FROM swig_jobs shadow
+		JOIN swig_jobs production ON production.id = shadow.shadow_of
+		WHERE shadow.shadow_compared_at IS NOT NULL
+		GROUP BY production.kind, shadow.kind
+		ORDER BY production.kind, shadow.kind`
+
+	rows, err := s.driver.Query(ctx, reportSQL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize shadow jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var report []ShadowSummary
+	for rows.Next() {
+		var summary ShadowSummary
+		if err := rows.Scan(&summary.Kind, &summary.ShadowKind, &summary.Compared,
+			&summary.StatusMismatches, &summary.ResultMismatches); err != nil {
+			return nil, fmt.Errorf("failed to scan shadow summary: %w", err)
+		}
+		report = append(report, summary)
+	}
+	return report, nil
+}
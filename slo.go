@@ -0,0 +1,118 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// How often the latency behind SLO priorities is re-measured
+const sloRefreshInterval = 10 * time.Second
+
+// Bounds of priorities assigned from SLOs. A kind waiting exactly as long as its SLO
+// allows gets sloPriorityScale plus the minimum.
+const (
+	sloPriorityMin   = 1
+	sloPriorityMax   = 100
+	sloPriorityScale = 10
+)
+
+// latencySLOs assigns priorities to kinds from how close their queue latency is to their SLO
+type latencySLOs struct {
+	targets map[string]time.Duration // Per-kind time within which a job should start
+
+	mu         sync.RWMutex
+	priorities map[string]int // Last computed priority per kind
+}
+
+// priority returns the priority for a new job of kind, and whether kind has an SLO
+func (l *latencySLOs) priority(kind string) (int, bool) {
+	if _, ok := l.targets[kind]; !ok {
+		return 0, false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if priority, ok := l.priorities[kind]; ok {
+		return priority, true
+	}
+	return sloPriorityMin, true
+}
+
+// sloPriority scales latency against target into a priority: the closer a kind's
+// backlog is to breaching its SLO, the sooner new jobs of that kind run
+func sloPriority(latency, target time.Duration) int {
+	priority := sloPriorityMin + int(float64(latency)/float64(target)*sloPriorityScale)
+	if priority > sloPriorityMax {
+		return sloPriorityMax
+	}
+	return priority
+}
+
+// applySLOPriority sets the priority of a job of kind from its SLO, if it has one
+func (s *Swig) applySLOPriority(kind string, priority *int) {
+	if s.slos == nil {
+		return
+	}
+	if p, ok := s.slos.priority(kind); ok {
+		*priority = p
+	}
+}
+
+// monitorSLOs periodically measures how long the oldest ready job of each SLO kind has
+// been waiting, which is the latency a new job of that kind can expect
+func (s *Swig) monitorSLOs(ctx context.Context) {
+	ticker := time.NewTicker(sloRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.refreshSLOPriorities(ctx); err != nil {
+			log.Printf("Failed to refresh SLO priorities: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshSLOPriorities recomputes the priority of each SLO kind from its current latency
+func (s *Swig) refreshSLOPriorities(ctx context.Context) error {
+	kinds := make([]string, 0, len(s.slos.targets))
+	for kind := range s.slos.targets {
+		kinds = append(kinds, kind)
+	}
+
+	latencySQL := `
+		SELECT kind, EXTRACT(EPOCH FROM NOW() - MIN(scheduled_for))
+		FROM swig_jobs
+		WHERE kind = ANY($1)
+			AND status = 'pending'
+			AND scheduled_for <= NOW()
+		GROUP BY kind`
+	rows, err := s.driver.Query(ctx, latencySQL, kinds)
+	if err != nil {
+		return fmt.Errorf("failed to query queue latency: %w", err)
+	}
+	defer rows.Close()
+
+	priorities := make(map[string]int, len(kinds))
+	for rows.Next() {
+		var kind string
+		var seconds float64
+		if err := rows.Scan(&kind, &seconds); err != nil {
+			return fmt.Errorf("failed to scan queue latency: %w", err)
+		}
+		latency := time.Duration(seconds * float64(time.Second))
+		priorities[kind] = sloPriority(latency, s.slos.targets[kind])
+	}
+
+	s.slos.mu.Lock()
+	s.slos.priorities = priorities
+	s.slos.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,42 @@
+package swig
+
+import (
+	"log"
+	"time"
+
+	"github.com/glamboyosa/swig/workers"
+)
+
+// SlowJobHandler is called when a job has been running for longer than its kind's expected
+// duration. The job keeps running; the handler is for alerting and telemetry.
+type SlowJobHandler func(job workers.JobInfo, expected time.Duration)
+
+// watchSlowJob starts watching a job against its kind's expected duration. The returned
+// function stops the watch once the job is done and logs how long a slow job took.
+func (s *Swig) watchSlowJob(job workers.JobInfo) func() {
+	expected := s.expectedDurations[job.Kind]
+	if expected <= 0 {
+		return func() {}
+	}
+
+	started := time.Now()
+	timer := time.AfterFunc(expected, func() {
+		log.Printf("WARNING: job %s (%s) has been running for longer than the expected %s", job.ID, job.Kind, expected)
+		s.metrics.increment(s.metrics.slowJobs, job.Kind)
+		if s.slowJobHandler != nil {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Slow job handler for job %s (%s) panicked: %v", job.ID, job.Kind, r)
+				}
+			}()
+			s.slowJobHandler(job, expected)
+		}
+	})
+
+	return func() {
+		if !timer.Stop() {
+			log.Printf("Slow job %s (%s) finished after %s, expected %s",
+				job.ID, job.Kind, time.Since(started).Round(time.Millisecond), expected)
+		}
+	}
+}
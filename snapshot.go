@@ -0,0 +1,203 @@
+package swig
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// snapshotFormat identifies the layout of a Snapshot stream
+const snapshotFormat = "swig-snapshot/1"
+
+// snapshotHeader is the first line of a Snapshot stream
+type snapshotHeader struct {
+	Format        string    `json:"format"`
+	SchemaVersion int       `json:"schema_version"`
+	Columns       []string  `json:"columns"` // Writable swig_jobs columns when the snapshot was taken
+	TakenAt       time.Time `json:"taken_at"`
+}
+
+// Statuses of jobs that still have work to do, which are the ones a snapshot carries
+var snapshotStatuses = []string{"pending", "processing", "retryable", "scheduled", "staged"}
+
+// Snapshot writes every job that hasn't finished yet to w, with all of its columns, for
+// moving a live backlog to a new database or environment. The stream is a header line,
+// recording the schema version, followed by one JSON object per job, all read in a single
+// query so the snapshot is consistent. Load it with Restore.
+//
+// Example:
+//
+//	// Stop producers and workers on the old database, then
+//	err := oldSwig.Snapshot(ctx, f)
+//	...
+//	restored, err := newSwig.Restore(ctx, f)
+func (s *Swig) Snapshot(ctx context.Context, w io.Writer) error {
+	version, err := s.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	columns, err := s.jobColumnNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	header := snapshotHeader{Format: snapshotFormat, SchemaVersion: version, Columns: columns, TakenAt: time.Now()}
+	if err := encoder.Encode(header); err != nil {
+		return fmt.Errorf("failed to write snapshot header: %w", err)
+	}
+
+	snapshotSQL := `
+		SELECT to_jsonb(j)
+		FROM swig_jobs j
+		WHERE status = ANY($1::text[]::swig_job_status[])
+		ORDER BY created_at, id`
+	rows, err := s.driver.Query(ctx, snapshotSQL, snapshotStatuses)
+	if err != nil {
+		return fmt.Errorf("failed to read jobs for snapshot: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var job []byte
+		if err := rows.Scan(&job); err != nil {
+			return fmt.Errorf("failed to scan job for snapshot: %w", err)
+		}
+		if _, err := buffered.Write(append(job, '\n')); err != nil {
+			return fmt.Errorf("failed to write snapshot: %w", err)
+		}
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// Restore loads a stream written by Snapshot in a single transaction, returning the number
+// of jobs restored. Jobs that already exist are skipped, so a restore can safely be re-run,
+// and jobs that were processing when the snapshot was taken are restored as pending. The
+// snapshot's schema version must not be newer than this database's; columns added since
+// it was taken get their defaults.
+func (s *Swig) Restore(ctx context.Context, r io.Reader) (int, error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	var header snapshotHeader
+	if err := decoder.Decode(&header); err != nil {
+		return 0, fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	if header.Format != snapshotFormat {
+		return 0, fmt.Errorf("unsupported snapshot format %q", header.Format)
+	}
+
+	version, err := s.schemaVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if header.SchemaVersion > version {
+		return 0, fmt.Errorf("snapshot is from schema version %d, newer than this database's %d; migrate it first",
+			header.SchemaVersion, version)
+	}
+	known, err := s.jobColumnNames(ctx)
+	if err != nil {
+		return 0, err
+	}
+	columns := intersectColumns(header.Columns, known)
+
+	list := strings.Join(columns, ", ")
+	restoreSQL := fmt.Sprintf(`
+		INSERT INTO swig_jobs (%s)
+		SELECT %s FROM jsonb_populate_record(NULL::swig_jobs, $1::jsonb)
+		ON CONFLICT (id) DO NOTHING
+		RETURNING id`, list, list)
+
+	restored := 0
+	err = s.driver.WithTx(ctx, func(tx drivers.Transaction) error {
+		for line := 1; ; line++ {
+			var job map[string]json.RawMessage
+			err := decoder.Decode(&job)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to decode snapshot job %d: %w", line, err)
+			}
+
+			// No instance owns a job once it's been moved
+			if string(job["status"]) == `"processing"` {
+				job["status"] = json.RawMessage(`"pending"`)
+				job["instance_id"], job["worker_id"], job["locked_at"] = nil, nil, nil
+			}
+			record, err := json.Marshal(job)
+			if err != nil {
+				return fmt.Errorf("failed to encode snapshot job %d: %w", line, err)
+			}
+
+			var id string
+			err = tx.QueryRow(ctx, restoreSQL, string(record)).Scan(&id)
+			if isNoRows(err) {
+				continue // Already exists
+			}
+			if err != nil {
+				return fmt.Errorf("failed to restore snapshot job %d: %w", line, err)
+			}
+			restored++
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if restored > 0 {
+		s.wakeAll()
+	}
+	return restored, nil
+}
+
+// jobColumnNames returns the swig_jobs columns that can be written, leaving out generated ones
+func (s *Swig) jobColumnNames(ctx context.Context) ([]string, error) {
+	columnsSQL := `
+		SELECT column_name
+		FROM information_schema.columns
+		WHERE table_schema = current_schema()
+			AND table_name = 'swig_jobs'
+			AND is_generated = 'NEVER'
+		ORDER BY ordinal_position`
+	rows, err := s.driver.Query(ctx, columnsSQL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job columns: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, fmt.Errorf("failed to scan job column: %w", err)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// intersectColumns returns the columns of a snapshot that also exist in this database
+func intersectColumns(snapshot, known []string) []string {
+	exists := make(map[string]bool, len(known))
+	for _, column := range known {
+		exists[column] = true
+	}
+	var columns []string
+	for _, column := range snapshot {
+		if exists[column] {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
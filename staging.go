@@ -0,0 +1,166 @@
+package swig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// How long staged jobs wait for ReleaseJobs before they expire, unless configured otherwise
+const defaultStagingTimeout = time.Hour
+
+// ErrStagingExpired is returned by ReleaseJobs when the staged jobs expired before they
+// were released
+var ErrStagingExpired = errors.New("staged jobs expired before they were released")
+
+// AddStagedJob enqueues a job that's held in the 'staged' status until ReleaseJobs is
+// called with the returned token. Use it when the go/no-go decision for a job happens
+// outside the database transaction, such as after capturing a payment with an external
+// provider. Jobs that aren't released within the staging timeout (see WithStagingTimeout)
+// expire; DiscardStagedJobs drops them straight away.
+//
+// Example:
+//
+//	token, err := swig.AddStagedJob(ctx, &FulfilOrderWorker{OrderID: id})
+//	if err != nil {
+//	    return err
+//	}
+//	if err := payments.Capture(ctx, paymentID); err != nil {
+//	    swig.DiscardStagedJobs(ctx, token)
+//	    return err
+//	}
+//	_, err = swig.ReleaseJobs(ctx, token)
+func (s *Swig) AddStagedJob(ctx context.Context, workerWithArgs interface{}, opts ...JobOptions) (string, error) {
+	// Type assert to check if it implements Worker interface
+	if _, ok := workerWithArgs.(interface{ JobName() string }); !ok {
+		return "", fmt.Errorf("workerWithArgs must implement JobName() string")
+	}
+	// Use default options if none provided
+	jobOpts := DefaultJobOptions()
+	if len(opts) > 0 {
+		jobOpts = opts[0]
+	}
+
+	return s.AddStagedJobs(ctx, []drivers.BatchJob{
+		{Worker: workerWithArgs, Opts: jobOpts.driverOptions(s.workerID)},
+	})
+}
+
+// AddStagedJobs enqueues several jobs under one staging token, so they're released or
+// discarded together. See AddStagedJob.
+func (s *Swig) AddStagedJobs(ctx context.Context, jobs []drivers.BatchJob) (string, error) {
+	token := s.generateID()
+	staged := make([]drivers.BatchJob, len(jobs))
+	for i, job := range jobs {
+		job.Opts.StagingToken = token
+		job.Opts.StagingTimeout = s.stagingTimeout
+		staged[i] = job
+	}
+	if err := s.insertJobs(ctx, staged); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ReleaseJobs makes the jobs staged under token available to workers and returns how many
+// were released. It returns ErrStagingExpired if they expired first, and ErrJobNotFound if
+// there are no staged jobs with the token, for example because they were already released.
+func (s *Swig) ReleaseJobs(ctx context.Context, token string) (int, error) {
+	releaseSQL := `
+		UPDATE swig_jobs
+		SET status = 'pending',
+			staged_until = NULL
+		WHERE staging_token = $1
+			AND status = 'staged'
+			AND staged_until > NOW()
+		RETURNING id, queue, kind, scheduled_for <= NOW()`
+
+	rows, err := s.driver.Query(ctx, releaseSQL, token)
+	if err != nil {
+		return 0, fmt.Errorf("failed to release staged jobs: %w", err)
+	}
+	type releasedJob struct {
+		id, queue, kind string
+		ready           bool
+	}
+	var released []releasedJob
+	for rows.Next() {
+		var job releasedJob
+		if err := rows.Scan(&job.id, &job.queue, &job.kind, &job.ready); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan released job: %w", err)
+		}
+		released = append(released, job)
+	}
+	rows.Close()
+
+	if len(released) == 0 {
+		var expired bool
+		expiredSQL := `SELECT EXISTS (SELECT 1 FROM swig_jobs WHERE staging_token = $1 AND status IN ('staged', 'expired'))`
+		if err := s.driver.QueryRow(ctx, expiredSQL, token).Scan(&expired); err != nil {
+			return 0, fmt.Errorf("failed to check staged jobs: %w", err)
+		}
+		if expired {
+			return 0, ErrStagingExpired
+		}
+		return 0, ErrJobNotFound
+	}
+
+	// Released jobs weren't announced when they were inserted, so wake workers for them now
+	for _, job := range released {
+		if !job.ready {
+			continue
+		}
+		if err := s.notifyJob(ctx, job.id, job.queue, job.kind); err != nil {
+			log.Printf("Failed to notify released job %s: %v", job.id, err)
+		}
+	}
+	return len(released), nil
+}
+
+// DiscardStagedJobs deletes the jobs staged under token without running them and returns
+// how many there were
+func (s *Swig) DiscardStagedJobs(ctx context.Context, token string) (int, error) {
+	discardSQL := `
+		DELETE FROM swig_jobs
+		WHERE staging_token = $1
+			AND status = 'staged'
+		RETURNING id`
+
+	discarded, err := s.countRows(ctx, discardSQL, token)
+	if err != nil {
+		return 0, fmt.Errorf("failed to discard staged jobs: %w", err)
+	}
+	return discarded, nil
+}
+
+// expireStagedJobs moves staged jobs that weren't released within the staging timeout to
+// the terminal 'expired' status. They keep their token so ReleaseJobs can tell the caller.
+func (s *Swig) expireStagedJobs(ctx context.Context) error {
+	expireSQL := `
+		UPDATE swig_jobs
+		SET status = 'expired',
+			finished_at = NOW()
+		WHERE id IN (
+			SELECT id
+			FROM swig_jobs
+			WHERE status = 'staged'
+				AND staged_until <= NOW()
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id`
+
+	expired, err := s.countRows(ctx, expireSQL, maintenanceBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to expire staged jobs: %w", err)
+	}
+	if expired > 0 {
+		log.Printf("Expired %d staged jobs that were never released", expired)
+	}
+	return nil
+}
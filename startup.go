@@ -0,0 +1,261 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// Clock skew against the database beyond which Start warns. Eligibility is decided with the
+// database's clock, so a skewed application clock makes RunAt and ExpiresAt land early or late.
+const maxClockSkew = time.Second
+
+// StartupCheck names a check Start runs before starting workers
+type StartupCheck string
+
+const (
+	CheckSchema        StartupCheck = "schema"         // Migrations applied and schema version understood
+	CheckQueues        StartupCheck = "queues"         // Queue configs are valid
+	CheckWorkers       StartupCheck = "workers"        // The worker registry has workers to run
+	CheckListen        StartupCheck = "listen"         // LISTEN/NOTIFY can be relied on
+	CheckAdvisoryLocks StartupCheck = "advisory_locks" // Advisory locks used for leadership and migrations work
+	CheckClockSkew     StartupCheck = "clock_skew"     // The application clock agrees with the database's
+	CheckPredicates    StartupCheck = "predicates"     // Acquire predicates are single parameterized expressions
+	CheckDriver        StartupCheck = "driver"         // The driver's database supports what Swig needs
+)
+
+// StartupProblem is something Start found wrong. Warnings are logged and Start carries on;
+// anything else stops it starting workers.
+type StartupProblem struct {
+	Check   StartupCheck
+	Err     error
+	Warning bool
+}
+
+// StartupReport lists the problems Start found. Start returns it as its error when any
+// problem isn't a warning.
+//
+// Example:
+//
+//	if err := swig.Start(ctx); err != nil {
+//	    var report *StartupReport
+//	    if errors.As(err, &report) {
+//	        for _, problem := range report.Problems {
+//	            log.Printf("%s: %v", problem.Check, problem.Err)
+//	        }
+//	    }
+//	    os.Exit(1)
+//	}
+type StartupReport struct {
+	Problems []StartupProblem
+}
+
+func (r *StartupReport) Error() string {
+	messages := make([]string, 0, len(r.Problems))
+	for _, problem := range r.Problems {
+		messages = append(messages, fmt.Sprintf("%s: %v", problem.Check, problem.Err))
+	}
+	return "swig failed startup checks: " + strings.Join(messages, "; ")
+}
+
+// Fatal reports whether any problem should stop Start
+func (r *StartupReport) Fatal() bool {
+	for _, problem := range r.Problems {
+		if !problem.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *StartupReport) add(check StartupCheck, err error) {
+	r.Problems = append(r.Problems, StartupProblem{Check: check, Err: err})
+}
+
+func (r *StartupReport) warn(check StartupCheck, err error) {
+	r.Problems = append(r.Problems, StartupProblem{Check: check, Err: err, Warning: true})
+}
+
+// validateStartup migrates the schema and checks everything Start relies on
+func (s *Swig) validateStartup(ctx context.Context) *StartupReport {
+	report := &StartupReport{}
+
+	if err := s.migrate(ctx); err != nil {
+		report.add(CheckSchema, fmt.Errorf("failed to migrate schema: %w", err))
+	} else {
+		s.checkSchemaVersion(ctx, report)
+		if err := s.checkNotifyTrigger(ctx); err != nil && s.driverCaps.ListenNotify {
+			report.warn(CheckListen, err)
+		}
+	}
+	s.checkQueues(report)
+	if len(s.Workers.Kinds()) == 0 {
+		report.add(CheckWorkers, fmt.Errorf("no workers are registered"))
+	}
+	for _, predicate := range s.acquirePredicates {
+		if predicate.err != nil {
+			report.add(CheckPredicates, predicate.err)
+		}
+	}
+	s.checkDriverCapabilities(report)
+	if s.driverCaps.AdvisoryLocks {
+		s.checkAdvisoryLocks(ctx, report)
+	}
+	if err := s.detectTransactionPooler(ctx); err != nil {
+		report.warn(CheckListen, err)
+	}
+	if skew, err := s.measureClockSkew(ctx); err != nil {
+		report.warn(CheckClockSkew, err)
+	} else if skew > maxClockSkew || skew < -maxClockSkew {
+		report.warn(CheckClockSkew, fmt.Errorf("application clock is %s off the database's", skew))
+	}
+
+	return report
+}
+
+// checkSchemaVersion compares the database's schema version with the migrations we know
+func (s *Swig) checkSchemaVersion(ctx context.Context, report *StartupReport) {
+	version, err := s.schemaVersion(ctx)
+	if err != nil {
+		report.add(CheckSchema, err)
+		return
+	}
+	latest := latestSchemaVersion()
+	switch {
+	case version < latest:
+		report.add(CheckSchema, fmt.Errorf("schema is at version %d, expected %d", version, latest))
+	case version > latest:
+		report.warn(CheckSchema, fmt.Errorf("schema is at version %d, newer than the %d this instance knows; upgrade Swig", version, latest))
+	}
+}
+
+// checkQueues validates the queue configs
+func (s *Swig) checkQueues(report *StartupReport) {
+	if len(s.swigQueueConfig) == 0 {
+		report.add(CheckQueues, fmt.Errorf("no queues are configured"))
+	}
+	seen := make(map[QueueTypes]bool)
+	for _, config := range s.swigQueueConfig {
+		if config.QueueType == "" {
+			report.add(CheckQueues, fmt.Errorf("a queue has no QueueType"))
+		}
+		if seen[config.QueueType] {
+			report.warn(CheckQueues, fmt.Errorf("queue %s is configured more than once", config.QueueType))
+		}
+		seen[config.QueueType] = true
+		if _, ok := jobOrderClauses[config.Order]; config.Order != "" && !ok {
+			report.add(CheckQueues, fmt.Errorf("queue %s has unknown Order %q", config.QueueType, config.Order))
+		}
+		if config.Weights != nil {
+			if err := config.Weights.validate(); err != nil {
+				report.add(CheckQueues, fmt.Errorf("queue %s has invalid Weights: %w", config.QueueType, err))
+			}
+		}
+		if config.MaxWorkers < 0 {
+			report.add(CheckQueues, fmt.Errorf("queue %s has negative MaxWorkers %d", config.QueueType, config.MaxWorkers))
+		}
+		for _, kind := range config.Kinds {
+			if _, ok := s.Workers.GetWorker(kind); !ok {
+				report.warn(CheckQueues, fmt.Errorf("queue %s lists kind %s, which has no registered worker", config.QueueType, kind))
+			}
+		}
+	}
+	for kind, shadow := range s.shadows {
+		if !seen[shadow.queue()] {
+			report.warn(CheckQueues, fmt.Errorf("%s is shadowed into queue %s, which has no workers configured", kind, shadow.queue()))
+		}
+		if _, ok := s.Workers.GetWorker(shadow.Kind); !ok {
+			report.warn(CheckQueues, fmt.Errorf("%s is shadowed by %s, which has no registered worker", kind, shadow.Kind))
+		}
+	}
+}
+
+// checkDriverCapabilities adapts to what the driver says its database supports, and
+// reports what Swig can't do without
+func (s *Swig) checkDriverCapabilities(report *StartupReport) {
+	caps := s.driverCaps
+	if !caps.SkipLocked {
+		report.add(CheckDriver, fmt.Errorf("the database doesn't support FOR UPDATE SKIP LOCKED, which acquiring jobs relies on"))
+	}
+	if !caps.ListenNotify && !s.pollingMode.Load() {
+		s.pollingMode.Store(true)
+		report.warn(CheckListen, fmt.Errorf("the database doesn't support LISTEN/NOTIFY, so workers will poll for jobs every %s instead", pollingModeInterval))
+	}
+	if !caps.AdvisoryLocks && s.tenantQuotas != nil {
+		report.warn(CheckDriver, fmt.Errorf("the database doesn't support advisory locks, so concurrent producers can take a tenant past its quota"))
+	}
+}
+
+// checkAdvisoryLocks makes sure transaction-scoped advisory locks can be taken
+func (s *Swig) checkAdvisoryLocks(ctx context.Context, report *StartupReport) {
+	err := s.driver.WithTx(ctx, func(tx drivers.Transaction) error {
+		var acquired bool
+		return tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, leaderLockID).Scan(&acquired)
+	})
+	if err != nil {
+		report.add(CheckAdvisoryLocks, fmt.Errorf("failed to take an advisory lock: %w", err))
+	}
+}
+
+// measureClockSkew measures how far the database's clock is ahead of ours, allowing for the round
+// trip, and remembers it for dbNow
+func (s *Swig) measureClockSkew(ctx context.Context) (time.Duration, error) {
+	var dbNow time.Time
+	before := time.Now()
+	if err := s.driver.QueryRow(ctx, `SELECT clock_timestamp()`).Scan(&dbNow); err != nil {
+		return 0, fmt.Errorf("failed to read database time: %w", err)
+	}
+	after := time.Now()
+	skew := dbNow.Sub(before.Add(after.Sub(before) / 2))
+	s.clockSkew.Store(int64(skew))
+	return skew, nil
+}
+
+// dbNow estimates the database's current time from our clock and the last measured skew
+func (s *Swig) dbNow() time.Time {
+	return time.Now().Add(time.Duration(s.clockSkew.Load()))
+}
+
+// logWarnings logs the report's warnings
+func (r *StartupReport) logWarnings() {
+	for _, problem := range r.Problems {
+		if problem.Warning {
+			log.Printf("WARNING: startup check %s: %v", problem.Check, problem.Err)
+		}
+	}
+}
+
+// logBacklog logs how many jobs are ready in each of the instance's queues as it starts,
+// in one query for all of them. Every worker goes for a job as soon as it starts, so a
+// freshly deployed instance drains the backlog straight away rather than waiting for a
+// notification.
+func (s *Swig) logBacklog(ctx context.Context) error {
+	queues := s.servedQueues()
+	backlogSQL := `
+		SELECT queue, COUNT(*)
+		FROM swig_jobs
+		WHERE queue = ANY($1)
+			AND status = 'pending'
+			AND scheduled_for <= NOW()
+		GROUP BY queue
+		ORDER BY queue`
+	rows, err := s.driver.Query(ctx, backlogSQL, append(queues, string(Priority)))
+	if err != nil {
+		return fmt.Errorf("failed to count ready jobs: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var queue string
+		var ready int
+		if err := rows.Scan(&queue, &ready); err != nil {
+			return fmt.Errorf("failed to scan ready jobs: %w", err)
+		}
+		log.Printf("Starting with %d jobs ready in queue %s", ready, queue)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package swig
+
+// jobStatuses lists every status a job can have. The status column is the swig_job_status
+// enum, so statuses are bound as text[] and cast to it, and knownStatuses drops any the
+// enum doesn't have before they reach the database.
+var jobStatuses = map[string]bool{
+	"pending":    true,
+	"processing": true,
+	"completed":  true,
+	"retryable":  true,
+	"failed":     true,
+	"scheduled":  true,
+	"expired":    true,
+	"deleted":    true,
+	"staged":     true,
+}
+
+// knownStatuses returns the statuses among statuses that a job can have. An unknown status
+// matches no jobs, as it did when statuses were stored as text, rather than failing the cast.
+func knownStatuses(statuses []string) []string {
+	known := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		if jobStatuses[status] {
+			known = append(known, status)
+		}
+	}
+	return known
+}
@@ -0,0 +1,179 @@
+package swig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// How long a killed subprocess has to release its output pipes before Process returns
+// anyway, in case it started children of its own that are still holding them
+const subprocessWaitDelay = 5 * time.Second
+
+// How much of a failed subprocess's stderr is kept in the job's error
+const subprocessStderrLimit = 4 << 10
+
+// subprocessWorker runs jobs of one kind in a child process, see RegisterSubprocessWorker
+type subprocessWorker struct {
+	kind    string
+	args    []*template.Template
+	timeout time.Duration
+	payload json.RawMessage
+	stdout  []byte
+}
+
+func (w *subprocessWorker) JobName() string {
+	return w.kind
+}
+
+// UnmarshalJSON keeps the job's payload as it is, to be written to the command's stdin
+func (w *subprocessWorker) UnmarshalJSON(payload []byte) error {
+	w.payload = append(json.RawMessage(nil), payload...)
+	return nil
+}
+
+// Result is what the command wrote to stdout, if that's JSON
+func (w *subprocessWorker) Result() interface{} {
+	if len(bytes.TrimSpace(w.stdout)) == 0 || !json.Valid(w.stdout) {
+		return nil
+	}
+	return json.RawMessage(w.stdout)
+}
+
+// ArgsSchema accepts any JSON object, since the command decides what it needs
+func (w *subprocessWorker) ArgsSchema() map[string]interface{} {
+	return map[string]interface{}{"type": "object"}
+}
+
+// Process runs the command for the job and waits for it to exit. The command is killed if
+// the timeout passes or the job's context is cancelled.
+func (w *subprocessWorker) Process(ctx context.Context) error {
+	// Numbers are kept as written, so large IDs aren't formatted as floats
+	var data interface{}
+	decoder := json.NewDecoder(bytes.NewReader(w.payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return CodedError(ErrorCodeInvalidPayload, fmt.Errorf("failed to decode payload for command: %w", err))
+	}
+	argv := make([]string, len(w.args))
+	for i, arg := range w.args {
+		var b strings.Builder
+		if err := arg.Execute(&b, data); err != nil {
+			return &JobError{Code: ErrorCodeInvalidPayload, Err: fmt.Errorf("failed to build command: %w", err), NoRetry: true}
+		}
+		argv[i] = b.String()
+	}
+
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = bytes.NewReader(w.payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = subprocessWaitDelay
+
+	err := cmd.Run()
+	if err == nil {
+		w.stdout = stdout.Bytes()
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return CodedError(ErrorCodeSubprocessTimeout, fmt.Errorf("%s was killed after %s%s", argv[0], w.timeout, stderrTail(&stderr)))
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s was killed: %w", argv[0], ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return CodedError(ErrorCodeSubprocessExit, fmt.Errorf("%s exited with status %d%s", argv[0], exitErr.ExitCode(), stderrTail(&stderr)))
+	}
+	return fmt.Errorf("failed to run %s: %w", argv[0], err)
+}
+
+// stderrTail formats the end of a subprocess's stderr for an error message
+func stderrTail(stderr *bytes.Buffer) string {
+	output := bytes.TrimSpace(stderr.Bytes())
+	if len(output) == 0 {
+		return ""
+	}
+	if len(output) > subprocessStderrLimit {
+		output = output[len(output)-subprocessStderrLimit:]
+	}
+	return ": " + string(output)
+}
+
+// RegisterSubprocessWorker registers a worker for kind that runs each job in a child
+// process, for CPU-bound work that can't honor context cancellation. Unlike a goroutine,
+// the process is killed when timeout passes, or when the job is cancelled or Swig shuts
+// down, and its exit status is the attempt's result: zero completes the job, anything
+// else fails the attempt with ErrorCodeSubprocessExit and the end of its stderr, and a
+// killed process fails it with ErrorCodeSubprocessTimeout. A zero timeout means none.
+// JSON the command writes to stdout is stored as the job's result.
+//
+// cmdTemplate is split on spaces outside {{ }} into the program and its arguments, and
+// each part is a text/template executed with the job's decoded payload. No shell is
+// involved, so arguments can't inject commands. The payload is also written to the
+// command's stdin as JSON. Only the process itself is killed, so commands that start
+// children of their own should exec into the long-running one.
+//
+// Example:
+//
+//	err := swig.RegisterSubprocessWorker("render_video",
+//	    "/usr/local/bin/render --input {{.input}} --preset {{.preset}}", 10*time.Minute)
+//
+//	err = swig.EnqueueRaw(ctx, "render_video", []byte(`{"input":"s3://clips/1.mov","preset":"1080p"}`))
+func (s *Swig) RegisterSubprocessWorker(kind, cmdTemplate string, timeout time.Duration) error {
+	fields := splitCommand(cmdTemplate)
+	if len(fields) == 0 {
+		return fmt.Errorf("command for %s is empty", kind)
+	}
+	worker := &subprocessWorker{kind: kind, timeout: timeout}
+	for i, field := range fields {
+		arg, err := template.New(fmt.Sprintf("%s[%d]", kind, i)).Option("missingkey=error").Parse(field)
+		if err != nil {
+			return fmt.Errorf("failed to parse command for %s: %w", kind, err)
+		}
+		worker.args = append(worker.args, arg)
+	}
+	if err := s.Workers.RegisterWorker(worker); err != nil {
+		return fmt.Errorf("failed to register subprocess worker: %w", err)
+	}
+	return nil
+}
+
+// splitCommand splits a command template into arguments on whitespace, leaving template
+// actions such as {{ .input }} whole
+func splitCommand(cmdTemplate string) []string {
+	var fields []string
+	var field strings.Builder
+	inAction := false
+	for i := 0; i < len(cmdTemplate); i++ {
+		switch {
+		case strings.HasPrefix(cmdTemplate[i:], "{{"):
+			inAction = true
+		case strings.HasPrefix(cmdTemplate[i:], "}}"):
+			inAction = false
+		case !inAction && (cmdTemplate[i] == ' ' || cmdTemplate[i] == '\t' || cmdTemplate[i] == '\n'):
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+			continue
+		}
+		field.WriteByte(cmdTemplate[i])
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
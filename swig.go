@@ -9,6 +9,7 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/glamboyosa/swig/drivers"
@@ -21,11 +22,14 @@ type QueueTypes string
 const (
 	Default  QueueTypes = "default"
 	Priority QueueTypes = "priority"
+	Shadow   QueueTypes = "shadow" // Mirrored jobs for workers being rolled out, see WithShadow
 
-	leaderLockID  = 1234567 // Arbitrary number for advisory lock
-	leaderKey     = "queue_leader"
-	leaderTTL     = 30 * time.Second
-	retryInterval = 5 * time.Second
+	leaderLockID = 1234567 // Arbitrary number for advisory lock
+	leaderKey    = "queue_leader"
+	leaderTTL    = 30 * time.Second
+
+	// Channel used for job notifications and leader events
+	jobsChannel = "swig_jobs"
 )
 
 // minimum number of workers to start
@@ -34,18 +38,140 @@ const minWorkers = 3
 // Default timeout for graceful shutdown
 const defaultShutdownTimeout = 30 * time.Second
 
+// How long Stop waits for workers to return once it has cancelled their jobs' contexts,
+// and then for the jobs left behind to be cleaned up
+const shutdownCancelWait = 5 * time.Second
+
+// How long a job that couldn't be handed to its worker waits before it's eligible again
+const releaseDelay = 30 * time.Second
+
+// How many times a job can be handed back without reaching its worker before it fails
+const maxReleases = 20
+
+// Defaults for the leader's retry pass
+const (
+	defaultRetryInterval  = 5 * time.Second
+	defaultRetryBatchSize = 1000
+)
+
 type SwigQueueConfig struct {
 	QueueType  QueueTypes
 	MaxWorkers int
+	// Kinds limits the queue's workers to jobs of these kinds. By default they take any
+	// kind registered with the instance's WorkerRegistry, so jobs meant for workers that
+	// only run on another service are left for the instances that have them.
+	Kinds []string
+	// Order is the order the queue's workers take ready jobs of equal priority in.
+	// Defaults to OrderFIFO.
+	Order JobOrder
+	// Weights, when set, rank the queue's ready jobs by a weighted score of priority, age,
+	// deadline and tenant load instead of by priority then deadline. Order breaks ties.
+	Weights *OrderWeights
 }
 type Swig struct {
 	swigQueueConfig []SwigQueueConfig
 	driver          drivers.Driver
+	driverCaps      drivers.Capabilities // What the driver's database supports
 	Workers         workers.WorkerRegistry
 	activeWorkers   sync.WaitGroup // Track active workers
 	shutdown        chan struct{}  // Signal for graceful shutdown
-	leaderID        string         // Current leader ID if we're the leader
 	workerID        string         // Unique ID for this worker instance
+	instanceName    string         // Stable name shared by restarts of this instance, recorded on the jobs it runs
+	deploymentID    string         // Deployment this instance belongs to, whose members take over its interrupted jobs
+
+	leaderMu     sync.Mutex         // Guards leaderID and leaderCancel
+	leaderID     string             // Current leader ID if we're the leader
+	leaderCancel context.CancelFunc // Stops leader duties when we step down
+	electNow     chan struct{}      // Signal to hold an election immediately
+
+	jobWake     map[QueueTypes]chan jobNotification // Hands notifications to idle workers of each queue
+	pollingMode atomic.Bool                         // Poll for jobs instead of listening for notifications
+	triggerless atomic.Bool                         // No insert trigger; Swig announces the jobs it inserts
+	clockSkew   atomic.Int64                        // Last measured offset of the database clock from ours, in nanoseconds
+
+	versionMu      sync.Mutex // Guards lastVersionMix
+	lastVersionMix string     // Versions of running instances at the last check, so changes are logged once
+
+	notifyDedupWindow *time.Duration // Deduplication window to configure on Start, nil to leave as is
+
+	pollInterval   time.Duration // How often idle workers check for jobs nothing woke them for
+	retryInterval  time.Duration // How often the leader retries failed jobs
+	retryBatchSize int           // Max failed jobs requeued per retry pass
+	rescueAfter    time.Duration // How long a job can be processing before it's released; 0 never
+
+	retryJitter    time.Duration               // Max random delay added to each promoted retry
+	retryRateLimit *retryRateLimit             // Cap on retries promoted per window, nil when unlimited
+	queueRetry     map[string]QueueRetryPolicy // Retry settings of queues isolated from the rest, by queue
+
+	fetchBackoff map[FetchErrorClass]FetchBackoff // Worker backoff after errors, by class, where it's not the default
+
+	queryTimeouts QueryTimeouts // Limits on how long acquire queries and maintenance passes run
+
+	payloadRetention map[string]time.Duration   // Per-kind time before finished payloads are redacted
+	softDeleteWindow time.Duration              // How long deleted jobs can be restored; 0 deletes immediately
+	jobRetention     map[string]RetentionPolicy // Per-kind time before finished jobs are deleted, "" for other kinds
+	compactKinds     map[string]bool            // Kinds whose duplicate pending jobs are collapsed, "" for every kind; nil when off
+
+	alerts   *alerting       // Alert thresholds and notifier, nil when alerting is off
+	inFlight *inFlightBudget // Memory guardrails for jobs being processed, nil when unlimited
+
+	region       string   // Region this instance runs in, empty when it isn't tagged
+	capabilities []string // Capability labels advertised in swig_instances
+
+	expectedDurations map[string]time.Duration // Per-kind run time after which a job counts as slow
+	slowJobHandler    SlowJobHandler           // Called when a job runs past its expected duration
+	metrics           *metricsRecorder         // In-process job counters
+	activity          *activityTracker         // What each worker goroutine is doing
+	active            *activeJobs              // Jobs running on this instance, see ActiveJobs
+	claimed           *claimedJobs             // Recently claimed job IDs, so their notifications are skipped
+
+	queues        *queueDirectory    // Queues processed by running instances, and orphan queues adopted here
+	unknownQueues UnknownQueuePolicy // What enqueueing to a queue no instance processes does
+	orphanWorkers int                // Workers processing orphan queues, 0 for none
+	subscribers   *subscribers       // Receivers of JobEvents, see Subscribe
+
+	transactionalKinds map[string]bool  // Kinds acquired, processed and completed in one transaction
+	tenantQuotas       *tenantQuotas    // Caps on pending jobs per tenant and queue, nil when unlimited
+	slos               *latencySLOs     // Per-kind start latency targets that set priorities, nil when unused
+	rebalancer         *queueRebalancer // Moves workers to queues breaching their SLOs, nil when off
+	maintenanceHooks   MaintenanceHooks // Called around each maintenance pass
+
+	idGenerator func() string // Generates job and instance IDs; nil leaves job IDs to the database
+	idDefault   string        // SQL default to give swig_jobs.id on Start, empty to leave as is
+
+	enqueueMu         sync.RWMutex        // Guards enqueueMiddleware
+	enqueueMiddleware []EnqueueMiddleware // Run for every job before it's enqueued
+
+	templatesMu sync.RWMutex           // Guards templates
+	templates   map[string]JobTemplate // Job templates registered with RegisterTemplate, by name
+
+	dryRun bool // Run every enqueue check but never insert jobs
+
+	propagators []ContextPropagator // Context values carried from AddJob to Process
+
+	stagingTimeout time.Duration // How long staged jobs wait for ReleaseJobs before they expire
+
+	shadows       map[string]ShadowConfig // Kinds mirrored to a shadow worker, by production kind
+	shadowHandler ShadowHandler           // Called with each production job and its finished shadow
+
+	optionalKinds    map[string]bool // Kinds whose Setup may fail without failing Start
+	setUpKinds       []string        // Kinds whose Setup succeeded, in the order it ran, for Teardown
+	unavailableKinds []string        // Optional kinds whose Setup failed, left for other instances
+
+	acquirePredicates []acquirePredicate // Extra conditions on the jobs workers acquire
+
+	withoutTriggers bool // Migrate without creating functions and triggers
+
+	digestPeriod  time.Duration // How often the leader sends a digest; 0 for never
+	digestHandler DigestHandler // Receives each digest besides the log
+
+	errorLimit int        // Size in bytes past which stored errors are truncated, 0 for no limit
+	errorStore ErrorStore // Keeps the full text of truncated errors, if set
+
+	maxJobsPerWorker int           // Jobs a worker goroutine processes before it's replaced; 0 for no limit
+	onDrained        func()        // When set, reaching maxJobsPerWorker drains the instance and calls it
+	draining         chan struct{} // Closed when the instance stops taking jobs so it can be restarted
+	drainOnce        sync.Once
 }
 
 // NewSwig creates a new job queue instance with the specified database driver,
@@ -66,102 +192,125 @@ type Swig struct {
 //	}
 //
 //	swig := NewSwig(driver, configs, workers)
-func NewSwig(driver drivers.Driver, swigQueueConfig []SwigQueueConfig, workers workers.WorkerRegistry) *Swig {
-	return &Swig{
+//
+// Optional behaviour such as the retry cadence can be tuned with Options:
+//
+//	swig := NewSwig(driver, configs, workers, WithRetryInterval(time.Minute))
+func NewSwig(driver drivers.Driver, swigQueueConfig []SwigQueueConfig, workers workers.WorkerRegistry, opts ...Option) *Swig {
+	s := &Swig{
 		driver:          driver,
 		swigQueueConfig: swigQueueConfig,
 		Workers:         workers,
 		shutdown:        make(chan struct{}),
 		workerID:        pkg.GenerateWorkerID(),
+		electNow:        make(chan struct{}, 1),
+		draining:        make(chan struct{}),
+		driverCaps:      drivers.CapabilitiesOf(driver),
+		jobWake:         newJobWake(swigQueueConfig),
+		metrics:         newMetricsRecorder(),
+		subscribers:     newSubscribers(),
+		activity:        newActivityTracker(),
+		active:          newActiveJobs(),
+		claimed:         newClaimedJobs(),
+		queues:          newQueueDirectory(),
+		pollInterval:    notifyPollInterval,
+		retryInterval:   defaultRetryInterval,
+		retryBatchSize:  defaultRetryBatchSize,
+		rescueAfter:     defaultRescueAfter,
+		stagingTimeout:  defaultStagingTimeout,
+		errorLimit:      defaultErrorLimit,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-// tryBecomeLeader attempts to acquire leadership using advisory locks
-func (s *Swig) tryBecomeLeader(ctx context.Context) error {
-	// Try to acquire advisory lock
-	var acquired bool
-	err := s.driver.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, leaderLockID).Scan(&acquired)
-	if err != nil || !acquired {
-		return fmt.Errorf("failed to acquire leader lock: %w", err)
+// retryFailedJobs promotes retryable jobs whose next attempt is due back to pending,
+// at most retryBatchSize per pass and within the retry rate limit, if there is one. The
+// backoff was already applied when the attempt failed, so this is a simple promotion,
+// spread out by up to retryJitter so a burst of failures doesn't retry all at once.
+// Queues with a retry policy of their own are promoted first, each in its own batch, so a
+// flood of retries in other queues can't crowd them out; every other queue shares the last.
+func (s *Swig) retryFailedJobs(ctx context.Context) error {
+	if s.retryRateLimit != nil && s.retryRateLimit.remaining(time.Now()) <= 0 {
+		return nil
 	}
 
-	// If we got the lock, update the leader table
-	s.leaderID = pkg.GenerateWorkerID()
-	err = s.driver.Exec(ctx, `
-		INSERT INTO swig_leader (id, leader_id, expires_at)
-		VALUES ($1, $2, NOW() + $3::interval)
-		ON CONFLICT (id) DO UPDATE
-		SET leader_id = $2,
-			expires_at = NOW() + $3::interval
-	`, leaderKey, s.leaderID, leaderTTL.String())
-
+	// Skip the pass entirely when nothing is due so an idle table only costs a cheap read
+	var eligible bool
+	err := s.driver.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM swig_jobs
+			WHERE status = 'retryable' AND next_retry_at <= NOW()
+		)`).Scan(&eligible)
 	if err != nil {
-		// Release the advisory lock if we couldn't update the table
-		s.driver.Exec(ctx, `SELECT pg_advisory_unlock($1)`, leaderLockID)
-		return fmt.Errorf("failed to update leader record: %w", err)
+		// Don't report context cancellation as an error - this is normal during shutdown
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil
+		}
+		return fmt.Errorf("failed to check for retryable jobs: %w", err)
+	}
+	if !eligible {
+		return nil
 	}
 
-	// Start leader duties in background
-	go s.performLeaderDuties(ctx)
-
-	return nil
-}
-
-// performLeaderDuties handles leader responsibilities like retrying failed jobs
-func (s *Swig) performLeaderDuties(ctx context.Context) {
-	ticker := time.NewTicker(retryInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-s.shutdown:
-			return
-		case <-ticker.C:
-			if err := s.retryFailedJobs(ctx); err != nil {
-				log.Printf("Error retrying failed jobs: %v", err)
+	isolated := sortedKeys(s.queueRetry)
+	queues := append(append([]string(nil), isolated...), "")
+	for _, queue := range queues {
+		batchSize := s.retryBatchSize
+		if policy, ok := s.queueRetry[queue]; ok {
+			if policy.Disabled {
+				continue
+			}
+			if policy.BatchSize > 0 {
+				batchSize = policy.BatchSize
 			}
 		}
+		if s.retryRateLimit != nil {
+			if remaining := s.retryRateLimit.remaining(time.Now()); remaining < batchSize {
+				batchSize = remaining
+			}
+			if batchSize <= 0 {
+				return nil
+			}
+		}
+		if err := s.promoteRetries(ctx, queue, isolated, batchSize); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-// retryFailedJobs finds failed jobs that can be retried and requeues them
-func (s *Swig) retryFailedJobs(ctx context.Context) error {
-	// Find failed jobs that haven't exceeded max attempts and apply backoff
+// promoteRetries promotes up to batchSize due retries of queue, or of every queue not in
+// isolated when queue is empty
+func (s *Swig) promoteRetries(ctx context.Context, queue string, isolated []string, batchSize int) error {
 	retrySQL := `
 		UPDATE swig_jobs
 		SET status = 'pending',
-			instance_id = NULL,
-			worker_id = NULL,
-			locked_at = NULL,
-			scheduled_for = CASE 
-				-- Apply exponential backoff: 2^attempts seconds
-				WHEN attempts > 0 THEN NOW() + (interval '1 second' * pow(2, attempts))
-				ELSE NOW()
-			END
-		WHERE status = 'failed'
-			AND attempts < max_attempts
-			AND (
-				instance_id IS NULL 
-				OR locked_at < NOW() - interval '5 minutes'
-			)
-			-- Only retry jobs that have waited their backoff period
-			AND (
-				last_error IS NULL 
-				OR last_error_at < NOW() - (interval '1 second' * pow(2, attempts))
-			)
+			scheduled_for = next_retry_at + make_interval(secs => random() * $2),
+			next_retry_at = NULL
+		WHERE id IN (
+			SELECT id
+			FROM swig_jobs
+			WHERE status = 'retryable'
+				AND next_retry_at <= NOW()
+				AND (queue = $3 OR ($3 = '' AND queue <> ALL($4::text[])))
+			ORDER BY next_retry_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
 		RETURNING id, attempts`
 
 	var jobIDs []string
 	var totalAttempts int
-	rows, err := s.driver.Query(ctx, retrySQL)
+	rows, err := s.driver.Query(ctx, retrySQL, batchSize, s.retryJitter.Seconds(), queue, isolated)
 	if err != nil {
 		// Don't report context cancellation as an error - this is normal during shutdown
 		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 			return nil
 		}
-		return fmt.Errorf("failed to query failed jobs: %w", err)
+		return fmt.Errorf("failed to promote retryable jobs: %w", err)
 	}
 	defer rows.Close()
 
@@ -175,88 +324,102 @@ func (s *Swig) retryFailedJobs(ctx context.Context) error {
 		totalAttempts += attempts
 	}
 
+	addMaintenanceRows(ctx, len(jobIDs))
+	if s.retryRateLimit != nil {
+		s.retryRateLimit.record(len(jobIDs))
+	}
 	if len(jobIDs) > 0 {
-		log.Printf("Requeued %d failed jobs for retry (avg attempts: %.1f)",
-			len(jobIDs), float64(totalAttempts)/float64(len(jobIDs)))
+		from := ""
+		if queue != "" {
+			from = " from queue " + queue
+		}
+		log.Printf("Requeued %d retryable jobs%s (avg attempts: %.1f)",
+			len(jobIDs), from, float64(totalAttempts)/float64(len(jobIDs)))
 	}
 
 	return nil
 }
 
-// Start initializes the Swig queue and creates the necessary tables
-func (s *Swig) Start(ctx context.Context) {
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS swig_jobs (
-		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-		kind VARCHAR NOT NULL,
-		queue VARCHAR NOT NULL,
-		payload JSONB NOT NULL,
-		status VARCHAR NOT NULL DEFAULT 'pending',
-		priority INTEGER NOT NULL DEFAULT 0,
-		attempts INTEGER NOT NULL DEFAULT 0,
-		max_attempts INTEGER NOT NULL DEFAULT 3,
-		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-		scheduled_for TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-		instance_id UUID,           -- ID of the Swig instance
-		worker_id UUID,             -- ID of the specific worker
-		locked_at TIMESTAMPTZ,
-		last_error TEXT,
-		last_error_at TIMESTAMPTZ,  -- When the last error occurred
-		
-		CONSTRAINT valid_status CHECK (status IN (
-			'pending', 'processing', 'completed', 'failed', 'scheduled'
-		))
-	);
-
-	-- Create notification trigger for real-time job processing
-	CREATE OR REPLACE FUNCTION notify_job_created()
-		RETURNS trigger AS $$
-	BEGIN
-		PERFORM pg_notify(
-			'swig_jobs',
-			json_build_object(
-				'id', NEW.id,
-				'queue', NEW.queue,
-				'kind', NEW.kind
-			)::text
-		);
-		RETURN NEW;
-	END;
-	$$ LANGUAGE plpgsql;
-
-	CREATE TRIGGER swig_jobs_notify_trigger
-		AFTER INSERT ON swig_jobs
-		FOR EACH ROW
-		EXECUTE FUNCTION notify_job_created();`
-
-	createLeaderTableSQL := `
-	CREATE TABLE IF NOT EXISTS swig_leader (
-		id TEXT PRIMARY KEY,          -- Usually 'queue_leader'
-		leader_id UUID NOT NULL,      -- Unique ID of current leader
-		expires_at TIMESTAMPTZ NOT NULL,
-		acquired_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-		
-		-- Ensure expires_at is always in the future
-		CONSTRAINT leader_expires_future CHECK (expires_at > NOW())
-	);
-	
-	-- Unlogged for better performance since this is temporary state
-	ALTER TABLE swig_leader SET UNLOGGED;`
-
-	s.driver.Exec(ctx, createTableSQL)
-	s.driver.Exec(ctx, createLeaderTableSQL)
-
-	// Try to become leader
-	if err := s.tryBecomeLeader(ctx); err != nil {
+// Start initializes the Swig queue, bringing the database schema up to date, and starts
+// the worker pools. It first checks the schema, queue configs, worker registry, LISTEN
+// support, advisory locks and clock skew. Warnings are logged; if anything would stop the
+// instance working, nothing is started and a *StartupReport listing the problems is returned.
+func (s *Swig) Start(ctx context.Context) error {
+	report := s.validateStartup(ctx)
+	report.logWarnings()
+	if report.Fatal() {
+		return report
+	}
+	if err := s.setupWorkers(ctx); err != nil {
+		return err
+	}
+
+	// Jobs inserted by other producers would get IDs of the wrong scheme
+	if err := s.configureIDDefault(ctx); err != nil {
+		return fmt.Errorf("failed to configure job IDs: %w", err)
+	}
+
+	if err := s.recoverPreviousIncarnations(ctx); err != nil {
+		log.Printf("Failed to recover jobs of previous incarnations: %v", err)
+	}
+	if err := s.registerInstance(ctx); err != nil {
+		log.Printf("Failed to register instance: %v", err)
+	}
+	if err := s.configureNotifyDedup(ctx); err != nil {
+		log.Printf("Failed to configure notification deduplication: %v", err)
+	}
+
+	// Try to become leader, then keep contending in case the leader goes away
+	if err := s.tryBecomeLeader(ctx); err != nil && !errors.Is(err, errNotElected) {
 		log.Printf("Failed to become leader: %v", err)
 	}
+	go s.runElection(ctx)
+	go s.runInstanceHeartbeat(ctx)
+
+	if s.slos != nil {
+		go s.monitorSLOs(ctx)
+	}
+	if s.rebalancer != nil {
+		s.rebalancer.configure(s.swigQueueConfig)
+		if s.slos != nil {
+			go s.runRebalancer(ctx)
+		}
+	}
+
+	if err := s.logBacklog(ctx); err != nil {
+		log.Printf("Failed to check the backlog: %v", err)
+	}
+
+	// A single listener per instance routes job notifications to idle workers, unless
+	// LISTEN can't be relied on and workers poll instead
+	if !s.pollingMode.Load() {
+		go s.dispatchNotifications(ctx)
+	}
+
+	// Orphan queue workers take jobs of queues no instance is configured for
+	if s.orphanWorkers > 0 {
+		go s.runOrphanCheck(ctx)
+		for i := 0; i < s.orphanWorkers; i++ {
+			s.activeWorkers.Add(1)
+			go func() {
+				defer s.activeWorkers.Done()
+				s.runOrphanWorker(ctx)
+			}()
+		}
+	}
 
 	// Start worker pools for each queue
+	spared := make(map[QueueTypes]bool)
 	for _, config := range s.swigQueueConfig {
 		workers := config.MaxWorkers
 		if workers < minWorkers {
 			workers = minWorkers
 		}
+		if s.rebalancer != nil && !spared[config.QueueType] {
+			// Parked until the queue borrows slots from healthy ones
+			workers += s.rebalancer.spare(config.QueueType)
+			spared[config.QueueType] = true
+		}
 
 		// Start worker pool for this queue
 		for i := 0; i < workers; i++ {
@@ -267,9 +430,12 @@ func (s *Swig) Start(ctx context.Context) {
 			}(config.QueueType)
 		}
 	}
+	return nil
 }
 
-// Wait for active workers to finish and release any leader locks we might be holding
+// Wait for active workers to finish and hand over leadership if we hold it. Jobs still
+// running when ctx is done have their contexts cancelled, and workers get a few more
+// seconds to return before the jobs left behind are released.
 func (s *Swig) Stop(ctx context.Context) error {
 	if _, ok := ctx.Deadline(); !ok {
 		// No timeout set, use default
@@ -278,6 +444,9 @@ func (s *Swig) Stop(ctx context.Context) error {
 		defer cancel()
 	}
 
+	// Keep other members of the deployment from handing jobs over to us
+	s.markStopping(ctx)
+
 	// Signal all workers to stop
 	close(s.shutdown)
 
@@ -293,11 +462,25 @@ func (s *Swig) Stop(ctx context.Context) error {
 	case <-done:
 		log.Printf("All workers gracefully shutdown")
 	case <-ctx.Done():
-		log.Printf("Shutdown timed out after %v, some workers may still be running", defaultShutdownTimeout)
-		// Even if we timeout, try to cleanup any jobs this instance was processing
-		if err := s.cleanupInstanceJobs(ctx); err != nil {
+		// Cancel the jobs still running, so workers that honor their context record a
+		// retryable failure rather than being abandoned when the process exits
+		running := s.active.cancelAll(errStopped)
+		log.Printf("Shutdown grace period ran out, cancelled %d running jobs", running)
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownCancelWait)
+		defer cancel()
+		select {
+		case <-done:
+			log.Printf("All workers stopped after their jobs were cancelled")
+		case <-cleanupCtx.Done():
+			log.Printf("Some workers ignored cancellation and may still be running")
+		}
+
+		// Clean up whatever jobs this instance was still processing
+		if err := s.cleanupInstanceJobs(cleanupCtx); err != nil {
 			log.Printf("Failed to cleanup instance jobs: %v", err)
 		}
+		s.releaseLeadership(cleanupCtx)
+		s.teardownWorkers(cleanupCtx)
 		return fmt.Errorf("shutdown timed out: %w", ctx.Err())
 	}
 
@@ -306,20 +489,12 @@ func (s *Swig) Stop(ctx context.Context) error {
 		log.Printf("Failed to cleanup instance jobs: %v", err)
 	}
 
-	// Release any leader locks we might be holding
-	if s.leaderID != "" {
-		unlockSQL := `
-			DELETE FROM swig_leader
-			WHERE leader_id = $1
-		`
-		if err := s.driver.Exec(ctx, unlockSQL, s.leaderID); err != nil {
-			log.Printf("Failed to release leader lock: %v", err)
-		}
+	// Hand over leadership so a follower can take over immediately
+	s.releaseLeadership(ctx)
+	s.teardownWorkers(ctx)
 
-		// Also release the advisory lock
-		if err := s.driver.Exec(ctx, `SELECT pg_advisory_unlock($1)`, leaderLockID); err != nil {
-			log.Printf("Failed to release advisory lock: %v", err)
-		}
+	if err := s.unregisterInstance(ctx); err != nil {
+		log.Printf("Failed to unregister instance: %v", err)
 	}
 
 	// Close database connections cleanly
@@ -332,6 +507,14 @@ func (s *Swig) Stop(ctx context.Context) error {
 	return nil
 }
 
+// instanceLabel identifies this instance in logs by its name, when it has one, and its ID
+func (s *Swig) instanceLabel() string {
+	if s.instanceName == "" {
+		return s.workerID
+	}
+	return fmt.Sprintf("%s (%s)", s.instanceName, s.workerID)
+}
+
 // cleanupInstanceJobs resets any jobs that were being processed by this instance
 func (s *Swig) cleanupInstanceJobs(ctx context.Context) error {
 	cleanupSQL := `
@@ -339,13 +522,17 @@ func (s *Swig) cleanupInstanceJobs(ctx context.Context) error {
 		SET status = CASE 
 				WHEN attempts >= max_attempts THEN 'failed'
 				ELSE 'pending'
-			END,
+			END::swig_job_status,
 			instance_id = NULL,
 			worker_id = NULL,
 			locked_at = NULL,
 			last_error = CASE 
 				WHEN attempts >= max_attempts THEN 'Job failed due to instance shutdown'
 				ELSE last_error
+			END,
+			finished_at = CASE 
+				WHEN attempts >= max_attempts THEN NOW()
+				ELSE NULL
 			END
 		WHERE instance_id = $1
 		RETURNING id`
@@ -366,7 +553,7 @@ func (s *Swig) cleanupInstanceJobs(ctx context.Context) error {
 	}
 
 	if len(jobIDs) > 0 {
-		log.Printf("Cleaned up %d jobs during shutdown", len(jobIDs))
+		log.Printf("Cleaned up %d jobs of instance %s during shutdown", len(jobIDs), s.instanceLabel())
 	}
 
 	return nil
@@ -376,18 +563,112 @@ func (s *Swig) cleanupInstanceJobs(ctx context.Context) error {
 type JobOptions struct {
 	Queue    QueueTypes
 	Priority int
-	RunAt    time.Time
+	// RunAt schedules the job for a point in time; zero means as soon as it's enqueued.
+	// RunIn delays the job from the database's current time, which unlike
+	// time.Now().Add(d) isn't thrown off by a skewed application clock.
+	RunAt time.Time
+	RunIn time.Duration
+	// ExpiresAt is a deadline after which a job that hasn't run is pointless. The leader
+	// moves such jobs to the terminal 'expired' status. Zero means the job never expires.
+	ExpiresAt time.Time
+	// Deadline is when the job should have finished by, for work with a delivery SLA.
+	// Within a priority, jobs with the nearest deadline run first and jobs without one
+	// run last. A missed deadline doesn't stop the job; it's reported by the
+	// MissedDeadlines alert and the swig_queue_missed_deadlines metric.
+	Deadline time.Time
+	// ConcurrencyKey is the key whose limit, set with SetConcurrencyLimit, caps how many
+	// jobs run at once. Empty means the job's kind.
+	ConcurrencyKey string
+	// Affinity ties the job to the instance that enqueued it, for jobs that need something
+	// local to it such as a file written during the same request. With AffinityPrefer other
+	// instances take the job after AffinityTimeout (30 seconds by default). AffinityRequire
+	// never lets it move, so pair it with ExpiresAt in case the instance goes away.
+	Affinity        Affinity
+	AffinityTimeout time.Duration
+	// Region restricts the job to instances started with WithRegion(Region), for data that
+	// must stay in a particular region. Empty means any instance may run it.
+	Region string
+	// Tenant is the customer the job is for in a multi-tenant app. Jobs with a tenant count
+	// towards its WithTenantQuota.
+	Tenant string
+	// Metadata is free-form labels stored with the job, such as trace IDs
+	Metadata map[string]string
+	// UniqueKey makes the job unique among jobs that haven't started yet, such as
+	// "sync_account:42". Enqueueing it while one with the same key is pending, scheduled or
+	// staged doesn't add a job; OnConflict decides what happens to the existing one, which
+	// by default is kept as it is. Once a job has started, a new one with its key can be
+	// enqueued. See EnqueueUnique to learn which job the key resolved to.
+	UniqueKey  string
+	OnConflict ConflictResolution
 }
 
+// ConflictResolution decides what enqueueing a job with a UniqueKey does when a job with
+// the same key is already waiting to start
+type ConflictResolution = drivers.ConflictResolution
+
+const (
+	// ConflictKeepExisting leaves the existing job as it is and drops the new one
+	ConflictKeepExisting = drivers.ConflictKeepExisting
+	// ConflictReplacePayload gives the existing job the new job's payload, for producers
+	// where the latest arguments win, such as syncing a record that changed again
+	ConflictReplacePayload = drivers.ConflictReplacePayload
+	// ConflictRescheduleEarlier moves the existing job to the new job's run time if that's
+	// sooner, for debounced work that's suddenly needed now
+	ConflictRescheduleEarlier = drivers.ConflictRescheduleEarlier
+)
+
+// Affinity controls whether a job has to run on the instance that enqueued it
+type Affinity int
+
+const (
+	AffinityNone    Affinity = iota // Any instance may run the job
+	AffinityPrefer                  // Run on the enqueuing instance, or any after the timeout
+	AffinityRequire                 // Only ever run on the enqueuing instance
+)
+
+// How long a job enqueued with AffinityPrefer waits for its instance by default
+const defaultAffinityTimeout = 30 * time.Second
+
 // DefaultJobOptions provides default settings
 func DefaultJobOptions() JobOptions {
 	return JobOptions{
 		Queue:    Default,
 		Priority: 1,
-		RunAt:    time.Now(),
 	}
 }
 
+// driverOptions converts JobOptions to the driver representation for a job enqueued by
+// the instance instanceID
+func (o JobOptions) driverOptions(instanceID string) drivers.JobOptions {
+	opts := drivers.JobOptions{
+		Queue:          string(o.Queue),
+		Priority:       o.Priority,
+		RunAt:          o.RunAt,
+		RunIn:          o.RunIn,
+		ExpiresAt:      o.ExpiresAt,
+		Deadline:       o.Deadline,
+		ConcurrencyKey: o.ConcurrencyKey,
+		Region:         o.Region,
+		Tenant:         o.Tenant,
+		Metadata:       o.Metadata,
+		UniqueKey:      o.UniqueKey,
+		OnConflict:     o.OnConflict,
+	}
+
+	switch o.Affinity {
+	case AffinityPrefer:
+		timeout := o.AffinityTimeout
+		if timeout <= 0 {
+			timeout = defaultAffinityTimeout
+		}
+		opts.PreferredInstanceID = instanceID
+		opts.AffinityTimeout = timeout
+	case AffinityRequire:
+		opts.PreferredInstanceID = instanceID
+	}
+	return opts
+}
+
 // AddJob enqueues a new job for processing. The workerWithArgs must be a struct that:
 //  1. Implements JobName() string to identify the worker type
 //  2. Implements Process(context.Context) error for job execution
@@ -404,7 +685,7 @@ func DefaultJobOptions() JobOptions {
 //	    Subject: "Welcome!",
 //	}, swig.JobOptions{
 //	    Queue: swig.Priority,
-//	    RunAt: time.Now().Add(time.Hour),
+//	    RunIn: time.Hour,
 //	})
 func (s *Swig) AddJob(ctx context.Context, workerWithArgs interface{}, opts ...JobOptions) error {
 	// Type assert to check if it implements Worker interface
@@ -417,32 +698,32 @@ func (s *Swig) AddJob(ctx context.Context, workerWithArgs interface{}, opts ...J
 		jobOpts = opts[0]
 	}
 
-	// Serialize the worker (which contains the args)
-	argsJSON, err := json.Marshal(workerWithArgs)
-	if err != nil {
-		return fmt.Errorf("failed to serialize job args: %w", err)
-	}
-
-	insertSQL := `
-		INSERT INTO swig_jobs (
-			kind,
-			queue,
-			payload,
-			priority,
-			scheduled_for,
-			status
-		) VALUES ($1, $2, $3, $4, $5, 'pending')
-	`
+	return s.insertJobs(ctx, []drivers.BatchJob{
+		{Worker: workerWithArgs, Opts: jobOpts.driverOptions(s.workerID)},
+	})
+}
+
+// EnqueueRaw enqueues a job from an already serialized JSON payload. It's meant for callers
+// that don't have the worker type at hand, such as services enqueueing work for a kind
+// registered elsewhere, or pipelines that already hold the payload bytes.
+// The payload must decode into the worker registered under kind.
+//
+// Example:
+//
+//	payload := []byte(`{"to":"user@example.com","subject":"Welcome!"}`)
+//	err := swig.EnqueueRaw(ctx, "send_email", payload, swig.JobOptions{
+//	    Queue: swig.Priority,
+//	})
+func (s *Swig) EnqueueRaw(ctx context.Context, kind string, payload []byte, opts ...JobOptions) error {
+	// Use default options if none provided
+	jobOpts := DefaultJobOptions()
+	if len(opts) > 0 {
+		jobOpts = opts[0]
+	}
 
-	return s.driver.Exec(
-		ctx,
-		insertSQL,
-		workerWithArgs.(interface{ JobName() string }).JobName(),
-		string(jobOpts.Queue),
-		argsJSON,
-		jobOpts.Priority,
-		jobOpts.RunAt,
-	)
+	return s.insertJobs(ctx, []drivers.BatchJob{
+		{Kind: kind, Payload: payload, Opts: jobOpts.driverOptions(s.workerID)},
+	})
 }
 
 // AddJobWithTx enqueues a new job as part of an existing transaction. The transaction must be
@@ -494,211 +775,577 @@ func (s *Swig) AddJobWithTx(ctx context.Context, tx interface{}, workerWithArgs
 		jobOpts = opts[0]
 	}
 
-	// Serialize the worker (which contains the args)
-	argsJSON, err := json.Marshal(workerWithArgs)
+	jobs, err := s.prepareJobs(ctx, []drivers.BatchJob{
+		{Worker: workerWithArgs, Opts: jobOpts.driverOptions(s.workerID)},
+	})
 	if err != nil {
-		return fmt.Errorf("failed to serialize job args: %w", err)
-	}
-
-	insertSQL := `
-		INSERT INTO swig_jobs (
-			kind,
-			queue,
-			payload,
-			priority,
-			scheduled_for,
-			status
-		) VALUES ($1, $2, $3, $4, $5, 'pending')
-	`
-
-	return txAdapter.Exec(
-		ctx,
-		insertSQL,
-		workerWithArgs.(interface{ JobName() string }).JobName(),
-		string(jobOpts.Queue),
-		argsJSON,
-		jobOpts.Priority,
-		jobOpts.RunAt,
-	)
+		return err
+	}
+	return s.insertJobsInTx(ctx, txAdapter, jobs)
 }
 
 // startWorker runs a worker goroutine that:
-// 1. Listens for notifications about new jobs
-// 2. Attempts to acquire and process jobs using SELECT FOR UPDATE SKIP LOCKED
-// 3. Handles job completion and failure
+// 1. Attempts to acquire and process jobs using SELECT FOR UPDATE SKIP LOCKED
+// 2. Handles job completion and failure
+// 3. Waits to be woken by the notification dispatcher when the queue is empty
 func (s *Swig) startWorker(ctx context.Context, queueType QueueTypes) {
-	// Start listening for notifications
-	if err := s.driver.Listen(ctx, "swig_jobs"); err != nil {
-		log.Printf("Failed to start listening: %v", err)
-		return
-	}
+	ctx, unregister := s.activity.register(ctx, queueType)
+	defer unregister()
 
+	processed := 0
+	backoff := s.newFetchBackoff()
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-s.shutdown:
+			return
+		case <-s.draining:
+			return
 		default:
+			if !s.takeSlot(ctx, queueType) {
+				return
+			}
 			// Try to acquire and process a job
-			if err := s.processNextJob(ctx, queueType); err != nil {
-				log.Printf("Error processing job: %v", err)
-				// Small backoff on error
-				time.Sleep(time.Second)
+			acquired, err := s.processNextJob(ctx, queueType)
+			s.releaseSlot(queueType)
+			if err != nil {
+				class := classifyFetchError(err)
+				switch {
+				case errors.Is(err, errAcquireConflict):
+					// Lost a race with another worker's transaction, which is expected under load
+					s.metrics.increment(s.metrics.acquireConflicts, string(queueType))
+				case class == FetchUnavailable:
+					log.Printf("Database unavailable, backing off: %v", err)
+				default:
+					log.Printf("Error processing job: %v", err)
+				}
+				s.waitBackoff(ctx, backoff.next(class))
+			} else {
+				backoff.reset()
+			}
+			if acquired {
+				processed++
+				if s.maxJobsPerWorker > 0 && processed >= s.maxJobsPerWorker {
+					s.recycleWorker(ctx, queueType)
+					return
+				}
 			}
 		}
 	}
 }
 
-// processNextJob attempts to acquire and process the next available job using SKIP LOCKED
-func (s *Swig) processNextJob(ctx context.Context, queueType QueueTypes) error {
+// processNextJob attempts to acquire and process the next available job using SKIP LOCKED.
+// It reports whether a job was acquired.
+func (s *Swig) processNextJob(ctx context.Context, queueType QueueTypes) (bool, error) {
+	// First try to acquire and process any job, going straight on to the next one if we got one
+	s.activity.set(ctx, workerAcquiring, nil)
+	acquired, err := s.acquireAndProcessJob(ctx, queueType, "")
+	if err != nil || acquired {
+		return acquired, err
+	}
+
+	// The queue is empty, wait for a new job
+	s.activity.set(ctx, workerWaiting, nil)
+	notification, woken := s.waitForJob(ctx, queueType)
+	switch woken {
+	case wokenByPoll:
+		// Nothing woke us, so check for jobs whose notification never arrived
+		s.activity.set(ctx, workerAcquiring, nil)
+		return s.acquireAndProcessJob(context.WithValue(ctx, polledKey{}, true), queueType, "")
+	case wokenToStop:
+		return false, nil
+	}
+	if notification.ID == "" || s.claimed.contains(notification.ID) {
+		// Woken for jobs this instance inserted, or for a job that's already been claimed;
+		// the next acquire picks up whatever is left
+		return false, nil
+	}
+	s.activity.set(ctx, workerAcquiring, nil)
+
+	// Try to acquire and process the specific job from the notification
+	return s.acquireAndProcessJob(ctx, queueType, notification.ID)
+}
+
+// acquireAndProcessJob acquires a job, the given one or the next available for queueType,
+// and processes it. It reports whether a job was acquired; errors after acquisition are
+// returned with acquired set.
+func (s *Swig) acquireAndProcessJob(ctx context.Context, queueType QueueTypes, specificJobID string) (bool, error) {
+	if len(s.transactionalKinds) > 0 {
+		return s.acquireAndProcessInTx(ctx, queueType, specificJobID)
+	}
+	job, acquired, err := s.acquireJob(ctx, s.driver, queueType, specificJobID)
+	if !acquired {
+		return false, err
+	}
+	return true, s.processJob(ctx, job)
+}
+
+// acquiredJob is a job claimed by acquireJob, with its payload as stored
+type acquiredJob struct {
+	workers.JobInfo
+	payload        []byte
+	payloadVersion int
+	contextValues  []byte // Values captured from the enqueuing context, see WithContextPropagation
+	result         []byte // Output of a completed job, see workers.ResultProvider
+	concurrencyKey string // Key of the concurrency limit the job counts against
+	limited        bool   // Whether a limit is set for concurrencyKey, see SetConcurrencyLimit
+	immediate      bool   // First attempt of a job due as soon as it was inserted, which announced it
+}
+
+// acquireJob marks a job, the given one or the next available for queueType, as processing
+// by this instance through db. It reports whether there was a job to acquire.
+func (s *Swig) acquireJob(ctx context.Context, db drivers.Transaction, queueType QueueTypes, specificJobID string) (acquiredJob, bool, error) {
 	// Generate unique worker ID for this job acquisition
-	workerID := pkg.GenerateWorkerID()
+	workerID := s.generateID()
+
+	var acquireSQL string
+	var args []interface{}
+
+	if specificJobID != "" {
+		// Try to acquire a specific job first (from notification)
+		acquireSQL = `
+			UPDATE swig_jobs
+			SET status = 'processing',
+				instance_id = $1,
+				instance_name = NULLIF($4, ''),
+				worker_id = $2,
+				locked_at = NOW(),
+				started_at = NOW(),
+				attempts = attempts + 1,
+				holds_semaphore = FALSE,
+				lease_expires_at = NULL
+			WHERE id = $3
+				AND status = 'pending'
+				AND scheduled_for <= NOW()
+				AND (expires_at IS NULL OR expires_at > NOW())%s
+			RETURNING id, kind, queue, payload, payload_version, attempts, max_attempts, context_values,
+				COALESCE(concurrency_key, kind),
+				EXISTS (SELECT 1 FROM swig_semaphores WHERE key = COALESCE(concurrency_key, kind)),
+				attempts = 1 AND scheduled_for <= created_at;`
+		args = []interface{}{s.workerID, workerID, specificJobID, s.instanceName}
+	} else {
+		// Otherwise try to acquire any job with priority handling
+		acquireSQL = `
+			UPDATE swig_jobs
+			SET status = 'processing',
+				instance_id = $1,
+				instance_name = NULLIF($4, ''),
+				worker_id = $2,
+				locked_at = NOW(),
+				started_at = NOW(),
+				attempts = attempts + 1,
+				holds_semaphore = FALSE,
+				lease_expires_at = NULL
+			WHERE id = (
+				SELECT id
+				FROM swig_jobs
+				WHERE status = 'pending'
+					AND scheduled_for <= NOW()
+					AND (expires_at IS NULL OR expires_at > NOW())
+					AND (
+						(queue = 'priority' AND EXISTS (
+							SELECT 1 FROM swig_jobs 
+							WHERE queue = 'priority' 
+							AND status = 'pending'
+							AND scheduled_for <= NOW()
+							AND (expires_at IS NULL OR expires_at > NOW())%[1]s
+						))
+						OR (queue = $3 AND NOT EXISTS (
+							SELECT 1 FROM swig_jobs 
+							WHERE queue = 'priority' 
+							AND status = 'pending'
+							AND scheduled_for <= NOW()
+							AND (expires_at IS NULL OR expires_at > NOW())%[1]s
+						))
+					)%[1]s
+				ORDER BY 
+					queue = 'priority' DESC,
+					` + s.jobRanking(queueType) + `
+				FOR UPDATE SKIP LOCKED
+				LIMIT 1
+			)
+			RETURNING id, kind, queue, payload, payload_version, attempts, max_attempts, context_values,
+				COALESCE(concurrency_key, kind),
+				EXISTS (SELECT 1 FROM swig_semaphores WHERE key = COALESCE(concurrency_key, kind)),
+				attempts = 1 AND scheduled_for <= created_at;`
+		args = []interface{}{s.workerID, workerID, string(queueType), s.instanceName}
+	}
+
+	// Narrow the candidates, and the priority jobs that hold them back, to jobs this
+	// instance can take on right now
+	filter, args := s.acquireFilter(queueType, args)
+	acquireSQL = fmt.Sprintf(acquireSQL, filter)
 
-	// Check for "no rows" errors from both database/sql and pgx
-	acquireAndProcessJob := func(ctx context.Context, queueType QueueTypes, specificJobID string) error {
-		var acquireSQL string
-		var args []interface{}
+	queryCtx, cancel := withQueryTimeout(ctx, s.queryTimeouts.acquire())
+	defer cancel()
 
+	var job acquiredJob
+	err := db.QueryRow(queryCtx, acquireSQL, args...).Scan(
+		&job.ID, &job.Kind, &job.Queue, &job.payload, &job.payloadVersion, &job.Attempts, &job.MaxAttempts,
+		&job.contextValues, &job.concurrencyKey, &job.limited, &job.immediate)
+	if isNoRows(err) {
 		if specificJobID != "" {
-			// Try to acquire a specific job first (from notification)
-			acquireSQL = `
-				UPDATE swig_jobs
-				SET status = 'processing',
-					instance_id = $1,
-					worker_id = $2,
-					locked_at = NOW(),
-					attempts = attempts + 1
-				WHERE id = $3
-					AND status = 'pending'
-					AND scheduled_for <= NOW()
-				RETURNING id, kind, payload;`
-			args = []interface{}{s.workerID, workerID, specificJobID}
-		} else {
-			// Otherwise try to acquire any job with priority handling
-			acquireSQL = `
-				UPDATE swig_jobs
-				SET status = 'processing',
-					instance_id = $1,
-					worker_id = $2,
-					locked_at = NOW(),
-					attempts = attempts + 1
-				WHERE id = (
-					SELECT id
-					FROM swig_jobs
-					WHERE status = 'pending'
-						AND scheduled_for <= NOW()
-						AND (
-							(queue = 'priority' AND EXISTS (
-								SELECT 1 FROM swig_jobs 
-								WHERE queue = 'priority' 
-								AND status = 'pending'
-								AND scheduled_for <= NOW()
-							))
-							OR (queue = $3 AND NOT EXISTS (
-								SELECT 1 FROM swig_jobs 
-								WHERE queue = 'priority' 
-								AND status = 'pending'
-								AND scheduled_for <= NOW()
-							))
-						)
-					ORDER BY 
-						queue = 'priority' DESC,
-						priority DESC,
-						created_at
-					FOR UPDATE SKIP LOCKED
-					LIMIT 1
-				)
-				RETURNING id, kind, payload;`
-			args = []interface{}{s.workerID, workerID, string(queueType)}
-		}
-
-		var jobID string
-		var kind string
-		var payload []byte
-
-		err := s.driver.QueryRow(ctx, acquireSQL, args...).Scan(&jobID, &kind, &payload)
-		if err == sql.ErrNoRows || err != nil && (err.Error() == "no rows in result set" || err.Error() == "no rows in result") {
-			return nil // No job available
-		}
-		if err != nil {
-			return fmt.Errorf("failed to acquire job: %w", err)
+			// Most likely claimed by someone else first; further notifications for it go
+			// straight to a general acquire
+			s.claimed.add(specificJobID)
 		}
+		return acquiredJob{}, false, nil // No job available
+	}
+	if isConflict(err) {
+		return acquiredJob{}, false, fmt.Errorf("failed to acquire job: %w: %w", errAcquireConflict, err)
+	}
+	if err != nil && errors.Is(queryCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return acquiredJob{}, false, fmt.Errorf("failed to acquire job: timed out after %s: %w", s.queryTimeouts.acquire(), err)
+	}
+	if err != nil {
+		return acquiredJob{}, false, fmt.Errorf("failed to acquire job: %w", err)
+	}
+	if job.immediate && polled(ctx) && !s.pollingMode.Load() {
+		// Its insert should have woken a worker; the notification was lost on the way
+		s.metrics.increment(s.metrics.reconciled, job.Queue)
+	}
+	s.claimed.add(job.ID)
+	return job, true, nil
+
+}
 
-		// Find the worker implementation
-		worker, ok := s.Workers.GetWorker(kind)
-		if !ok {
-			return fmt.Errorf("no worker registered for job type: %s", kind)
+// processJob runs an acquired job and records the result
+func (s *Swig) processJob(ctx context.Context, job acquiredJob) error {
+	// Hand the job back if its concurrency limit filled up since it was acquired
+	if job.limited {
+		held, err := s.takeSemaphore(ctx, job)
+		if err != nil || !held {
+			if yieldErr := s.yieldJob(ctx, job); yieldErr != nil {
+				return yieldErr
+			}
+			return err
 		}
+	}
+
+	// Count the job against the in-flight budget until we're done with it
+	cost := s.reserveCapacity(job.Kind, len(job.payload))
+	defer s.releaseCapacity(cost)
+
+	// Attempts are counted at acquisition so that runs interrupted by a crash still count.
+	// Anything that goes wrong before Process runs hands the attempt back instead.
+	worker, processor, cause := s.prepareWorker(job)
+	if cause != nil {
+		return s.releaseJob(ctx, s.driver, job.ID, cause)
+	}
 
-		// Unmarshal the payload
-		if err := json.Unmarshal(payload, worker); err != nil {
-			return fmt.Errorf("failed to unmarshal job payload: %w", err)
+	err := s.runJob(ctx, job, processor)
+
+	// Let the worker capture its own failure before the retry is scheduled
+	if err != nil {
+		if handler, ok := worker.(workers.ErrorHandler); ok {
+			s.callErrorHandler(ctx, handler, job.JobInfo, err)
 		}
+	}
+	if err == nil {
+		job.result = jobResult(job, worker)
+	}
+	if recordErr := s.recordResult(ctx, s.driver, job, err); recordErr != nil {
+		return recordErr
+	}
+	s.publishResult(job, err)
+	return nil
+}
 
-		// Process the job
-		err = worker.(interface{ Process(context.Context) error }).Process(ctx)
+// prepareWorker finds the worker for job and decodes its payload into it. The error
+// explains why the job can't be handed to a worker.
+func (s *Swig) prepareWorker(job acquiredJob) (interface{}, interface{ Process(context.Context) error }, error) {
+	// Get a worker of its own for the job, so concurrent jobs don't share arguments
+	worker, ok := s.Workers.NewWorker(job.Kind)
+	if !ok {
+		return nil, nil, CodedError(ErrorCodeWorkerNotFound, fmt.Errorf("no worker registered for job type: %s", job.Kind))
+	}
 
-		// Update job status based on processing result
+	// Bring payloads enqueued by an older version of the worker up to date
+	payload := job.payload
+	if current := workers.PayloadVersion(worker); job.payloadVersion < current {
+		jobName := job.Kind
+		if named, ok := worker.(interface{ JobName() string }); ok {
+			jobName = named.JobName()
+		}
+		var err error
+		payload, err = s.Workers.UpgradePayload(jobName, job.payloadVersion, current, payload)
 		if err != nil {
-			updateSQL := `
-				UPDATE swig_jobs
-				SET status = CASE 
-						WHEN attempts >= max_attempts THEN 'failed'
-						ELSE 'pending'
-					END,
-					last_error = $2,
-					last_error_at = NOW(),
-					instance_id = NULL,
-					worker_id = NULL,
-					locked_at = NULL
-				WHERE id = $1`
-			if err := s.driver.Exec(ctx, updateSQL, jobID, err.Error()); err != nil {
-				return fmt.Errorf("failed to update failed job: %w", err)
-			}
-		} else {
-			updateSQL := `
-				UPDATE swig_jobs
-				SET status = 'completed',
-					instance_id = NULL,
-					worker_id = NULL,
-					locked_at = NULL
-				WHERE id = $1`
-			if err := s.driver.Exec(ctx, updateSQL, jobID); err != nil {
-				return fmt.Errorf("failed to update completed job: %w", err)
-			}
+			return nil, nil, CodedError(ErrorCodeInvalidPayload, err)
 		}
+	}
 
-		return nil
+	// Unmarshal the payload
+	if err := workers.UnmarshalArgs(payload, worker); err != nil {
+		return nil, nil, CodedError(ErrorCodeInvalidPayload, fmt.Errorf("failed to unmarshal job payload: %w", err))
 	}
 
-	// First try to acquire and process any job
-	err := acquireAndProcessJob(ctx, queueType, "")
+	processor, ok := worker.(interface{ Process(context.Context) error })
+	if !ok {
+		return nil, nil, CodedError(ErrorCodeInvalidWorker, fmt.Errorf("worker for job type %s must implement Process(context.Context) error", job.Kind))
+	}
+	return worker, processor, nil
+}
+
+// jobResult serializes the output of a worker that completed job, if it has any
+func jobResult(job acquiredJob, worker interface{}) []byte {
+	provider, ok := worker.(workers.ResultProvider)
+	if !ok {
+		return nil
+	}
+	result, err := json.Marshal(provider.Result())
 	if err != nil {
-		return err
+		log.Printf("Failed to serialize result of job %s: %v", job.ID, err)
+		return nil
 	}
+	return result
+}
 
-	// If no job was available, wait for notification
-	notification, err := s.driver.WaitForNotification(ctx)
+// runJob runs Process for job, flagging it if it runs longer than expected and counting
+// the outcome
+func (s *Swig) runJob(ctx context.Context, job acquiredJob, processor interface{ Process(context.Context) error }) error {
+	s.activity.set(ctx, workerProcessing, &job)
+	ctx = s.withCheckpoint(s.restoreContext(ctx, job), job)
+	ctx, finish := s.active.start(ctx, job)
+	doneWatching := s.watchSlowJob(job.JobInfo)
+	err := finish(s.runProcess(ctx, processor))
+	doneWatching()
 	if err != nil {
-		// Don't report context cancellation as an error - this is normal during shutdown
-		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		s.metrics.increment(s.metrics.failed, job.Kind)
+	} else {
+		s.metrics.increment(s.metrics.completed, job.Kind)
+	}
+	return err
+}
+
+// recordResult updates job's status through db based on processing result. Jobs with
+// attempts left become retryable with their next attempt delayed by as long as the worker
+// asked for with RetryAfter, or else by their queue's backoff, 2^attempts seconds unless
+// a QueueRetryPolicy says otherwise; the rest, and those whose error rules out a retry,
+// fail. Checkpointed jobs interrupted by Stop are handed over to another member of the
+// deployment instead, when there is one. The attempt count is set from job so it stands
+// even if the acquisition was rolled back.
+func (s *Swig) recordResult(ctx context.Context, db drivers.Transaction, job acquiredJob, err error) error {
+	if err != nil && errors.Is(err, errStopped) {
+		handedOver, handoverErr := s.handOver(ctx, db, job)
+		if handoverErr != nil {
+			log.Printf("Failed to hand over job %s, recording it as interrupted: %v", job.ID, handoverErr)
+		}
+		if handedOver {
 			return nil
 		}
-		return fmt.Errorf("notification error: %w", err)
+	}
+	if err != nil {
+		willRetry := shouldRetry(job, err)
+		message, details, detailsJSON := s.describeFailure(ctx, job.JobInfo, err, willRetry)
+		updateSQL := `
+			UPDATE swig_jobs
+			SET status = CASE 
+					WHEN NOT $5 THEN 'failed'
+					ELSE 'retryable'
+				END::swig_job_status,
+				attempts = $6,
+				next_retry_at = CASE 
+					WHEN NOT $5 THEN NULL
+					ELSE NOW() + (interval '1 second' * $7)
+				END,
+				finished_at = CASE 
+					WHEN NOT $5 THEN NOW()
+					ELSE NULL
+				END,
+				last_error = $2,
+				last_error_code = $3,
+				last_error_details = $4,
+				last_error_at = NOW(),
+				instance_id = NULL,
+				worker_id = NULL,
+				locked_at = NULL
+			WHERE id = $1`
+		if err := db.Exec(ctx, updateSQL, job.ID, message, details.Code, detailsJSON, willRetry, job.Attempts, s.retryDelay(job.JobInfo, err).Seconds()); err != nil {
+			return fmt.Errorf("failed to update failed job: %w", err)
+		}
+		return nil
 	}
 
-	// Process the notification if we received one
-	if notification != nil && notification.Payload != "" {
-		// Try to parse the notification payload (should be JSON with job ID)
-		var notificationData struct {
-			ID string `json:"id"`
+	updateSQL := `
+		UPDATE swig_jobs
+		SET status = 'completed',
+			finished_at = NOW(),
+			result = $2,
+			checkpoint = NULL,
+			instance_id = NULL,
+			worker_id = NULL,
+			locked_at = NULL
+		WHERE id = $1`
+	var result interface{}
+	if job.result != nil {
+		result = job.result
+	}
+	if err := db.Exec(ctx, updateSQL, job.ID, result); err != nil {
+		return fmt.Errorf("failed to update completed job: %w", err)
+	}
+	return nil
+}
+
+// shouldRetry reports whether job gets another attempt after failing with err
+func shouldRetry(job acquiredJob, err error) bool {
+	return job.Attempts < job.MaxAttempts && !isNoRetry(err)
+}
+
+// acquireFilter returns extra conditions for the acquire query, with their arguments
+// appended to args
+func (s *Swig) acquireFilter(queueType QueueTypes, args []interface{}) (string, []interface{}) {
+	var conditions []string
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	// Only take kinds this instance has workers for
+	addCondition("kind = ANY($%d)", s.queueKinds(queueType))
+
+	// Leave kinds whose workers failed to set up to instances where they did
+	if len(s.unavailableKinds) > 0 {
+		addCondition("NOT (kind = ANY($%d))", s.unavailableKinds)
+	}
+
+	// Leave jobs whose concurrency limit is full
+	conditions = append(conditions, semaphoreAvailable)
+
+	// Skip jobs tied to another instance that hasn't given them up yet
+	addCondition("(preferred_instance_id IS NULL OR preferred_instance_id = $%d OR affinity_until <= NOW())", s.workerID)
+
+	// Only run region-bound jobs in their region
+	if s.region != "" {
+		addCondition("(region IS NULL OR region = $%d)", s.region)
+	} else {
+		conditions = append(conditions, "region IS NULL")
+	}
+
+	// Leave kinds that need capabilities this instance doesn't have
+	addCondition(`NOT EXISTS (
+					SELECT 1 FROM swig_kind_requirements r
+					WHERE r.kind = swig_jobs.kind
+					AND NOT r.capabilities <@ COALESCE(
+						(SELECT i.capabilities FROM swig_instances i WHERE i.id = $%d), '{}'))`, s.workerID)
+
+	// Apply the conditions given with WithAcquirePredicate. One that was rejected matches
+	// nothing rather than being dropped, so workers never take jobs it was meant to exclude.
+	for _, predicate := range s.acquirePredicates {
+		if predicate.err != nil {
+			conditions = append(conditions, "FALSE")
+			continue
 		}
-		if err := json.Unmarshal([]byte(notification.Payload), &notificationData); err == nil && notificationData.ID != "" {
-			// Try to acquire and process the specific job from the notification
-			return acquireAndProcessJob(ctx, queueType, notificationData.ID)
+		var condition string
+		condition, args = predicate.render(args)
+		conditions = append(conditions, condition)
+	}
+
+	// Leave jobs that don't fit in the remaining in-flight budget for later
+	if maxSize, excludedKinds, limited := s.capacityFilter(); limited {
+		addCondition("octet_length(payload::text) <= $%d", maxSize)
+		if len(excludedKinds) > 0 {
+			addCondition("NOT (kind = ANY($%d))", excludedKinds)
 		}
 	}
 
-	return nil
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "\n\t\t\t\tAND " + strings.Join(conditions, "\n\t\t\t\tAND "), args
+}
+
+// queueKinds returns the job kinds workers of queue process: the Kinds configured for it,
+// or every kind in the worker registry
+func (s *Swig) queueKinds(queue QueueTypes) []string {
+	var kinds []string
+	for _, config := range s.swigQueueConfig {
+		if config.QueueType == queue {
+			kinds = append(kinds, config.Kinds...)
+		}
+	}
+	if len(kinds) == 0 {
+		return s.Workers.Kinds()
+	}
+	return kinds
+}
+
+// acceptsKind reports whether workers of queue process jobs of kind
+func (s *Swig) acceptsKind(queue QueueTypes, kind string) bool {
+	for _, k := range s.queueKinds(queue) {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// runProcess runs a job's Process method, turning a panic into a failure that records
+// the stack instead of crashing the instance
+func (s *Swig) runProcess(ctx context.Context, processor interface{ Process(context.Context) error }) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverPanic(r)
+		}
+	}()
+	return processor.Process(ctx)
+}
+
+// callErrorHandler runs a worker's OnError hook. A panicking hook is logged rather than
+// allowed to take down the worker goroutine or skip recording the failure.
+func (s *Swig) callErrorHandler(ctx context.Context, handler workers.ErrorHandler, job workers.JobInfo, jobErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("OnError hook for job %s (%s) panicked: %v", job.ID, job.Kind, r)
+		}
+	}()
+	handler.OnError(ctx, job, jobErr)
+}
+
+// releaseJob hands an acquired job back to the queue when it couldn't be processed for
+// reasons unrelated to the job itself, such as a missing worker or an undecodable payload.
+// The attempt taken at acquisition is rolled back and the job is delayed by
+// releaseDelay so this instance doesn't spin on it. A job released maxReleases times
+// fails instead, since a payload no instance can decode would otherwise cycle forever.
+// The cause is recorded in last_error and returned.
+func (s *Swig) releaseJob(ctx context.Context, db drivers.Transaction, jobID string, cause error) error {
+	var releases int
+	if err := db.QueryRow(ctx, `SELECT release_count FROM swig_jobs WHERE id = $1`, jobID).Scan(&releases); err != nil {
+		return fmt.Errorf("failed to release job after %v: %w", cause, err)
+	}
+	giveUp := releases+1 >= maxReleases
+	if giveUp {
+		cause = fmt.Errorf("%w (handed back %d times without reaching a worker, giving up)", cause, releases+1)
+	}
+
+	releaseSQL := `
+		UPDATE swig_jobs
+		SET status = CASE WHEN $6 THEN 'failed' ELSE 'pending' END::swig_job_status,
+			attempts = GREATEST(attempts - 1, 0),
+			release_count = release_count + 1,
+			instance_id = NULL,
+			worker_id = NULL,
+			locked_at = NULL,
+			scheduled_for = CASE WHEN $6 THEN scheduled_for ELSE NOW() + $3::interval END,
+			finished_at = CASE WHEN $6 THEN NOW() ELSE NULL END,
+			last_error = $2,
+			last_error_code = $4,
+			last_error_details = $5,
+			last_error_at = NOW()
+		WHERE id = $1`
+	message, details, detailsJSON := s.describeFailure(ctx, workers.JobInfo{ID: jobID}, cause, !giveUp)
+	if err := db.Exec(ctx, releaseSQL, jobID, message, releaseDelay.String(), details.Code, detailsJSON, giveUp); err != nil {
+		return fmt.Errorf("failed to release job after %v: %w", cause, err)
+	}
+	return cause
+}
+
+// isNoRows checks for "no rows" errors from both database/sql and pgx
+func isNoRows(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, sql.ErrNoRows) || err.Error() == "no rows in result set" || err.Error() == "no rows in result"
 }
 
 // Close drops all Swig-related tables from the database. This is a destructive operation
@@ -708,6 +1355,7 @@ func (s *Swig) processNextJob(ctx context.Context, queueType QueueTypes) error {
 // This method will:
 // 1. Drop the swig_jobs table (including all jobs, history, and triggers)
 // 2. Drop the swig_leader table (removing leader election state)
+// 3. Drop the swig_migrations table so the schema is recreated on the next Start
 //
 // Note: This is different from Stop() which gracefully shuts down workers.
 // Close() is for complete cleanup of database objects.
@@ -729,10 +1377,19 @@ func (s *Swig) Close(ctx context.Context) error {
 		return fmt.Errorf("failed to drop trigger and function: %w", err)
 	}
 
-	// Drop the tables
+	// Drop the tables, then the types and functions their columns used
 	dropTablesSQL := `
 		DROP TABLE IF EXISTS swig_jobs;
 		DROP TABLE IF EXISTS swig_leader;
+		DROP TABLE IF EXISTS swig_semaphores;
+		DROP TABLE IF EXISTS swig_digests;
+		DROP TABLE IF EXISTS swig_instances;
+		DROP TABLE IF EXISTS swig_kind_requirements;
+		DROP TABLE IF EXISTS swig_settings;
+		DROP TABLE IF EXISTS swig_events;
+		DROP TABLE IF EXISTS swig_migrations;
+		DROP TYPE IF EXISTS swig_job_status;
+		DROP FUNCTION IF EXISTS swig_uuid_v7();
 	`
 	if err := s.driver.Exec(ctx, dropTablesSQL); err != nil {
 		return fmt.Errorf("failed to drop tables: %w", err)
@@ -748,56 +1405,20 @@ func (s *Swig) AddJobs(ctx context.Context, jobs []drivers.BatchJob) error {
 		return nil
 	}
 
-	// Start a transaction for atomic batch insertion
-	return s.driver.WithTx(ctx, func(tx drivers.Transaction) error {
-		// Build the values clause and args
-		var values []string
-		var args []interface{}
-		argCount := 1
-
-		for _, job := range jobs {
-			// Type assert to check if it implements Worker interface
-			if _, ok := job.Worker.(interface{ JobName() string }); !ok {
-				return fmt.Errorf("worker must implement JobName() string")
-			}
-
-			// Serialize the worker
-			argsJSON, err := json.Marshal(job.Worker)
-			if err != nil {
-				return fmt.Errorf("failed to serialize job args: %w", err)
-			}
-
-			// Add values for this job
-			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, 'pending')",
-				argCount, argCount+1, argCount+2, argCount+3, argCount+4))
-
-			args = append(args,
-				job.Worker.(interface{ JobName() string }).JobName(),
-				string(job.Opts.Queue),
-				argsJSON,
-				job.Opts.Priority,
-				job.Opts.RunAt,
-			)
-			argCount += 5
-		}
-
-		// Build and execute the insert query
-		insertSQL := fmt.Sprintf(`
-			INSERT INTO swig_jobs (
-				kind,
-				queue,
-				payload,
-				priority,
-				scheduled_for,
-				status
-			) VALUES %s
-		`, strings.Join(values, ","))
-
-		return tx.Exec(ctx, insertSQL, args...)
-	})
+	// Jobs are inserted in a single transaction, so the batch is atomic
+	return s.insertJobs(ctx, jobs)
 }
 
 // AddJobsWithTx adds multiple jobs as part of an existing transaction
 func (s *Swig) AddJobsWithTx(ctx context.Context, tx interface{}, jobs []drivers.BatchJob) error {
-	return s.driver.AddJobsWithTx(ctx, tx, jobs)
+	jobs, err := s.prepareJobs(ctx, jobs)
+	if err != nil {
+		return err
+	}
+
+	txAdapter, err := s.driver.AddJobWithTx(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("invalid transaction for driver: %w", err)
+	}
+	return s.insertJobsInTx(ctx, txAdapter, jobs)
 }
@@ -0,0 +1,482 @@
+// Package swigadmin serves the Swig job administration API over REST, so ops tooling and
+// internal dashboards can inspect and manage jobs without Go bindings. The API is
+// described by an OpenAPI 3 spec served at /openapi.json.
+package swigadmin
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glamboyosa/swig"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Largest page ListJobs requests may ask for
+const maxPageSize = 1000
+
+// Server serves the admin API for a Swig client. Every endpoint except the spec requires
+// one of the API keys given with WithAPIKeys, sent as "Authorization: Bearer <key>" or in
+// an X-API-Key header. A server without keys rejects every request.
+type Server struct {
+	client  *swig.Swig
+	apiKeys [][]byte
+	mux     *http.ServeMux
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithAPIKeys sets the keys accepted by the server. Several keys allow rotating them
+// without downtime.
+func WithAPIKeys(keys ...string) Option {
+	return func(s *Server) {
+		for _, key := range keys {
+			if key != "" {
+				s.apiKeys = append(s.apiKeys, []byte(key))
+			}
+		}
+	}
+}
+
+// NewServer creates an admin server for client. Mount it under a prefix with
+// http.StripPrefix to serve it alongside other handlers.
+//
+// Example:
+//
+//	admin := swigadmin.NewServer(swigClient, swigadmin.WithAPIKeys(os.Getenv("SWIG_ADMIN_KEY")))
+//	http.Handle("/swig/", http.StripPrefix("/swig", admin))
+func NewServer(client *swig.Swig, opts ...Option) *Server {
+	s := &Server{client: client, mux: http.NewServeMux()}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.mux.HandleFunc("GET /openapi.json", s.handleSpec)
+	s.mux.Handle("GET /jobs", s.authenticated(s.handleListJobs))
+	s.mux.Handle("GET /jobs/{id}", s.authenticated(s.handleGetJob))
+	s.mux.Handle("DELETE /jobs/{id}", s.authenticated(s.handleDeleteJob))
+	s.mux.Handle("POST /jobs/{id}/restore", s.authenticated(s.handleRestoreJob))
+	s.mux.Handle("POST /jobs/{id}/bump", s.authenticated(s.handleBumpJob))
+	s.mux.Handle("POST /jobs/{id}/notes", s.authenticated(s.handleAnnotateJob))
+	s.mux.Handle("POST /jobs/{id}/clone", s.authenticated(s.handleCloneJob))
+	s.mux.Handle("POST /jobs/cancel", s.authenticated(s.handleCancelJobs))
+	s.mux.Handle("GET /errors", s.authenticated(s.handleErrorCodes))
+	s.mux.Handle("GET /kinds", s.authenticated(s.handleListKinds))
+	s.mux.Handle("GET /kinds/{kind}/schema", s.authenticated(s.handleKindSchema))
+	s.mux.Handle("GET /queues/orphaned", s.authenticated(s.handleOrphanQueues))
+	s.mux.Handle("GET /templates", s.authenticated(s.handleListTemplates))
+	s.mux.Handle("POST /templates/{name}/enqueue", s.authenticated(s.handleEnqueueTemplate))
+	s.mux.Handle("GET /events", s.authenticated(s.handleListEvents))
+	s.mux.Handle("GET /health", s.authenticated(s.handleHealth))
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// authenticated wraps handler so it only runs for requests carrying a valid API key
+func (s *Server) authenticated(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if !s.validKey(key) {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid API key"))
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// validKey compares key against every configured key in constant time
+func (s *Server) validKey(key string) bool {
+	valid := false
+	for _, apiKey := range s.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), apiKey) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func (s *Server) handleSpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// jobList is a page of jobs. NextOffset is set when there may be more.
+type jobList struct {
+	Jobs       []swig.JobRecord `json:"jobs"`
+	NextOffset *int             `json:"next_offset,omitempty"`
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := swig.JobFilter{
+		Queue:          swig.QueueTypes(query.Get("queue")),
+		Kind:           query.Get("kind"),
+		Statuses:       query["status"],
+		IncludeDeleted: query.Get("include_deleted") == "true",
+		ErrorCodes:     query["error_code"],
+		Sort:           swig.JobSort(query.Get("sort")),
+	}
+
+	var err error
+	ranges := []struct {
+		param string
+		dest  *time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+		{"scheduled_after", &filter.ScheduledAfter},
+		{"scheduled_before", &filter.ScheduledBefore},
+		{"finished_after", &filter.FinishedAfter},
+		{"finished_before", &filter.FinishedBefore},
+	}
+	for _, rng := range ranges {
+		if *rng.dest, err = timeParam(query.Get(rng.param)); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%s must be an RFC 3339 timestamp", rng.param))
+			return
+		}
+	}
+	if filter.MinAttempts, err = intParam(query.Get("min_attempts"), 0); err != nil || filter.MinAttempts < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("min_attempts must be a non-negative integer"))
+		return
+	}
+	if filter.Limit, err = intParam(query.Get("limit"), 100); err != nil || filter.Limit < 1 || filter.Limit > maxPageSize {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxPageSize))
+		return
+	}
+	if filter.Offset, err = intParam(query.Get("offset"), 0); err != nil || filter.Offset < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("offset must be a non-negative integer"))
+		return
+	}
+
+	jobs, err := s.client.ListJobs(r.Context(), filter)
+	if errors.Is(err, swig.ErrInvalidSort) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	page := jobList{Jobs: jobs}
+	if page.Jobs == nil {
+		page.Jobs = []swig.JobRecord{}
+	}
+	if len(jobs) == filter.Limit {
+		next := filter.Offset + len(jobs)
+		page.NextOffset = &next
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.client.GetJob(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) handleDeleteJob(w http.ResponseWriter, r *http.Request) {
+	if err := s.client.DeleteJob(r.Context(), r.PathValue("id")); err != nil {
+		writeJobError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleRestoreJob(w http.ResponseWriter, r *http.Request) {
+	if err := s.client.RestoreJob(r.Context(), r.PathValue("id")); err != nil {
+		writeJobError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// bumpRequest is the body of POST /jobs/{id}/bump
+type bumpRequest struct {
+	Priority int  `json:"priority"`
+	RunNow   bool `json:"run_now"`
+}
+
+func (s *Server) handleBumpJob(w http.ResponseWriter, r *http.Request) {
+	var req bumpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if err := s.client.BumpJob(r.Context(), r.PathValue("id"), req.Priority, req.RunNow); err != nil {
+		writeJobError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// noteRequest is the body of POST /jobs/{id}/notes
+type noteRequest struct {
+	Note string `json:"note"`
+}
+
+func (s *Server) handleAnnotateJob(w http.ResponseWriter, r *http.Request) {
+	var req noteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if strings.TrimSpace(req.Note) == "" {
+		writeError(w, http.StatusBadRequest, errors.New("note must not be empty"))
+		return
+	}
+	if err := s.client.AnnotateJob(r.Context(), r.PathValue("id"), req.Note); err != nil {
+		writeJobError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cloneRequest is the body of POST /jobs/{id}/clone. Without a payload the clone keeps
+// the original's.
+type cloneRequest struct {
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// cloneResponse identifies the job POST /jobs/{id}/clone enqueued
+type cloneResponse struct {
+	ID string `json:"id"`
+}
+
+func (s *Server) handleCloneJob(w http.ResponseWriter, r *http.Request) {
+	var req cloneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if string(req.Payload) == "null" {
+		req.Payload = nil
+	}
+	id, err := s.client.CloneJob(r.Context(), r.PathValue("id"), req.Payload)
+	if errors.Is(err, swig.ErrInvalidPayload) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, cloneResponse{ID: id})
+}
+
+// cancelRequest is the body of POST /jobs/cancel
+type cancelRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// cancelResponse reports how many jobs POST /jobs/cancel cancelled
+type cancelResponse struct {
+	Cancelled int `json:"cancelled"`
+}
+
+func (s *Server) handleCancelJobs(w http.ResponseWriter, r *http.Request) {
+	var req cancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	cancelled, err := s.client.CancelMany(r.Context(), req.IDs)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cancelResponse{Cancelled: cancelled})
+}
+
+func (s *Server) handleErrorCodes(w http.ResponseWriter, r *http.Request) {
+	counts, err := s.client.CountErrorCodes(r.Context(), r.URL.Query()["status"]...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, counts)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := s.client.Health(r.Context())
+	status := http.StatusOK
+	if !health.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, health)
+}
+
+// kindList is the body of GET /kinds
+type kindList struct {
+	Kinds []swig.KindSchema `json:"kinds"`
+}
+
+func (s *Server) handleListKinds(w http.ResponseWriter, r *http.Request) {
+	list := kindList{Kinds: s.client.ArgsSchemas()}
+	if list.Kinds == nil {
+		list.Kinds = []swig.KindSchema{}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleKindSchema(w http.ResponseWriter, r *http.Request) {
+	schema, ok := s.client.Workers.ArgsSchema(r.PathValue("kind"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no worker registered for %q", r.PathValue("kind")))
+		return
+	}
+	writeJSON(w, http.StatusOK, schema)
+}
+
+// orphanQueueList is the body of GET /queues/orphaned
+type orphanQueueList struct {
+	Queues []swig.OrphanQueue `json:"queues"`
+}
+
+func (s *Server) handleOrphanQueues(w http.ResponseWriter, r *http.Request) {
+	queues, err := s.client.OrphanQueues(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, orphanQueueList{Queues: queues})
+}
+
+// templateList is the body of GET /templates
+type templateList struct {
+	Templates []string `json:"templates"`
+}
+
+func (s *Server) handleListTemplates(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, templateList{Templates: s.client.Templates()})
+}
+
+// enqueueTemplateRequest is the body of POST /templates/{name}/enqueue
+type enqueueTemplateRequest struct {
+	Params map[string]interface{} `json:"params"`
+}
+
+func (s *Server) handleEnqueueTemplate(w http.ResponseWriter, r *http.Request) {
+	var req enqueueTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	err := s.client.EnqueueTemplate(r.Context(), r.PathValue("name"), req.Params)
+	switch {
+	case errors.Is(err, swig.ErrTemplateNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, swig.ErrMissingParam):
+		writeError(w, http.StatusBadRequest, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// eventList is a page of system events. NextOffset is set when there may be more.
+type eventList struct {
+	Events     []swig.SystemEvent `json:"events"`
+	NextOffset *int               `json:"next_offset,omitempty"`
+}
+
+func (s *Server) handleListEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := swig.SystemEventFilter{
+		Task:       query.Get("task"),
+		InstanceID: query.Get("instance_id"),
+	}
+	for _, t := range query["type"] {
+		filter.Types = append(filter.Types, swig.SystemEventType(t))
+	}
+
+	var err error
+	if filter.After, err = timeParam(query.Get("after")); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("after must be an RFC 3339 timestamp"))
+		return
+	}
+	if filter.Before, err = timeParam(query.Get("before")); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("before must be an RFC 3339 timestamp"))
+		return
+	}
+	if filter.Limit, err = intParam(query.Get("limit"), 100); err != nil || filter.Limit < 1 || filter.Limit > maxPageSize {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxPageSize))
+		return
+	}
+	if filter.Offset, err = intParam(query.Get("offset"), 0); err != nil || filter.Offset < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("offset must be a non-negative integer"))
+		return
+	}
+
+	events, err := s.client.ListSystemEvents(r.Context(), filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	page := eventList{Events: events}
+	if len(events) == filter.Limit {
+		next := filter.Offset + len(events)
+		page.NextOffset = &next
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// intParam parses an optional integer query parameter
+func intParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// timeParam parses an optional RFC 3339 query parameter, leaving the zero time when it's absent
+func timeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// writeJobError maps errors from single-job operations to responses
+func writeJobError(w http.ResponseWriter, err error) {
+	if errors.Is(err, swig.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+// errorResponse is the body of every error response
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
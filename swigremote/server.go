@@ -0,0 +1,293 @@
+// Package swigremote serves a long-poll HTTP API that lets workers outside the Go process,
+// written in any language, lease Swig jobs, heartbeat while they run them and report how
+// they went. Postgres stays the source of truth: leases are rows in swig_jobs, and a job
+// whose worker stops heartbeating goes back to the queue. The API is described by an
+// OpenAPI 3 spec served at /openapi.json.
+package swigremote
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/glamboyosa/swig"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Lease durations and long-poll waits used when a request doesn't give one, and the most
+// a request may ask for
+const (
+	defaultLeaseDuration = time.Minute
+	maxLeaseDuration     = time.Hour
+	defaultWait          = 30 * time.Second
+	maxWait              = time.Minute
+)
+
+// Server serves the external worker API for a Swig client. Every endpoint except the spec
+// requires one of the API keys given with WithAPIKeys, sent as "Authorization: Bearer <key>"
+// or in an X-API-Key header. A server without keys rejects every request.
+type Server struct {
+	client  *swig.Swig
+	apiKeys [][]byte
+	kinds   map[string]bool
+	mux     *http.ServeMux
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithAPIKeys sets the keys accepted by the server. Several keys allow rotating them
+// without downtime.
+func WithAPIKeys(keys ...string) Option {
+	return func(s *Server) {
+		for _, key := range keys {
+			if key != "" {
+				s.apiKeys = append(s.apiKeys, []byte(key))
+			}
+		}
+	}
+}
+
+// WithKinds restricts the kinds external workers may lease. Without it any kind can be
+// leased, including ones this process has workers for.
+func WithKinds(kinds ...string) Option {
+	return func(s *Server) {
+		if s.kinds == nil {
+			s.kinds = make(map[string]bool)
+		}
+		for _, kind := range kinds {
+			s.kinds[kind] = true
+		}
+	}
+}
+
+// NewServer creates an external worker server for client. Mount it under a prefix with
+// http.StripPrefix to serve it alongside other handlers. The client doesn't need to be
+// started, so a process can serve jobs to external workers without running any itself.
+//
+// Example:
+//
+//	remote := swigremote.NewServer(swigClient,
+//	    swigremote.WithAPIKeys(os.Getenv("SWIG_WORKER_KEY")),
+//	    swigremote.WithKinds("train_model", "render_pdf"),
+//	)
+//	http.Handle("/swig/remote/", http.StripPrefix("/swig/remote", remote))
+func NewServer(client *swig.Swig, opts ...Option) *Server {
+	s := &Server{client: client, mux: http.NewServeMux()}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.mux.HandleFunc("GET /openapi.json", s.handleSpec)
+	s.mux.Handle("POST /leases", s.authenticated(s.handleLease))
+	s.mux.Handle("POST /leases/{id}/heartbeat", s.authenticated(s.handleHeartbeat))
+	s.mux.Handle("POST /leases/{id}/complete", s.authenticated(s.handleComplete))
+	s.mux.Handle("POST /leases/{id}/fail", s.authenticated(s.handleFail))
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// authenticated wraps handler so it only runs for requests carrying a valid API key
+func (s *Server) authenticated(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if !s.validKey(key) {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid API key"))
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// validKey compares key against every configured key in constant time
+func (s *Server) validKey(key string) bool {
+	valid := false
+	for _, apiKey := range s.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), apiKey) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func (s *Server) handleSpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// leaseRequest is the body of POST /leases
+type leaseRequest struct {
+	Kinds        []string `json:"kinds"`
+	LeaseSeconds float64  `json:"lease_seconds"`
+	WaitSeconds  *float64 `json:"wait_seconds"`
+}
+
+func (s *Server) handleLease(w http.ResponseWriter, r *http.Request) {
+	var req leaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if len(req.Kinds) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("kinds must list at least one kind"))
+		return
+	}
+	for _, kind := range req.Kinds {
+		if s.kinds != nil && !s.kinds[kind] {
+			writeError(w, http.StatusForbidden, fmt.Errorf("kind %q can't be leased", kind))
+			return
+		}
+	}
+	duration, err := seconds(req.LeaseSeconds, defaultLeaseDuration, maxLeaseDuration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("lease_seconds %w", err))
+		return
+	}
+	wait := defaultWait
+	if req.WaitSeconds != nil {
+		if *req.WaitSeconds < 0 || time.Duration(*req.WaitSeconds*float64(time.Second)) > maxWait {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("wait_seconds must be between 0 and %d", int(maxWait.Seconds())))
+			return
+		}
+		wait = time.Duration(*req.WaitSeconds * float64(time.Second))
+	}
+
+	lease, err := s.client.LeaseJob(r.Context(), req.Kinds, duration, wait)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return // The worker hung up
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if lease == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, lease)
+}
+
+// heartbeatRequest is the body of POST /leases/{id}/heartbeat
+type heartbeatRequest struct {
+	Token        string  `json:"token"`
+	LeaseSeconds float64 `json:"lease_seconds"`
+}
+
+// heartbeatResponse says when an extended lease runs out
+type heartbeatResponse struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var req heartbeatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	duration, err := seconds(req.LeaseSeconds, defaultLeaseDuration, maxLeaseDuration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("lease_seconds %w", err))
+		return
+	}
+	expiresAt, err := s.client.ExtendLease(r.Context(), r.PathValue("id"), req.Token, duration)
+	if err != nil {
+		writeLeaseError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, heartbeatResponse{ExpiresAt: expiresAt})
+}
+
+// completeRequest is the body of POST /leases/{id}/complete
+type completeRequest struct {
+	Token  string          `json:"token"`
+	Result json.RawMessage `json:"result"`
+}
+
+func (s *Server) handleComplete(w http.ResponseWriter, r *http.Request) {
+	var req completeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if err := s.client.CompleteLease(r.Context(), r.PathValue("id"), req.Token, req.Result); err != nil {
+		writeLeaseError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// failRequest is the body of POST /leases/{id}/fail
+type failRequest struct {
+	Token   string `json:"token"`
+	Error   string `json:"error"`
+	Code    string `json:"code"`
+	NoRetry bool   `json:"no_retry"`
+}
+
+func (s *Server) handleFail(w http.ResponseWriter, r *http.Request) {
+	var req failRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	message := req.Error
+	if message == "" {
+		message = "external worker reported a failure"
+	}
+	jobErr := &swig.JobError{Code: req.Code, Err: errors.New(message), NoRetry: req.NoRetry}
+	if err := s.client.FailLease(r.Context(), r.PathValue("id"), req.Token, jobErr); err != nil {
+		writeLeaseError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// seconds converts a duration in seconds from a request, using fallback when it's zero
+func seconds(value float64, fallback, max time.Duration) (time.Duration, error) {
+	if value == 0 {
+		return fallback, nil
+	}
+	duration := time.Duration(value * float64(time.Second))
+	if duration <= 0 || duration > max {
+		return 0, fmt.Errorf("must be between 0 and %d", int(max.Seconds()))
+	}
+	return duration, nil
+}
+
+// writeLeaseError maps errors from lease operations to responses
+func writeLeaseError(w http.ResponseWriter, err error) {
+	if errors.Is(err, swig.ErrLeaseLost) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+// errorResponse is the body of every error response
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,210 @@
+package swigtest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/glamboyosa/swig"
+	"github.com/glamboyosa/swig/drivers"
+	"github.com/glamboyosa/swig/workers"
+)
+
+// JobBuilder describes a row of swig_jobs for a test to insert directly, in whatever state
+// the code under test should find it. Start one with NewJob; columns that aren't set keep
+// the table's defaults, or follow from the status where a real job in that status would
+// have them.
+type JobBuilder struct {
+	kind         string
+	queue        swig.QueueTypes
+	args         interface{}
+	status       string
+	priority     int
+	attempts     int
+	maxAttempts  int
+	scheduledFor time.Time
+	startedAt    time.Time
+	lockedAt     time.Time
+	finishedAt   time.Time
+	nextRetryAt  time.Time
+	lastError    string
+	uniqueKey    string
+	tenant       string
+}
+
+// NewJob starts a pending job of kind in the default queue, with empty args, due now.
+//
+// Example:
+//
+//	id, err := swigtest.NewJob("send_email").
+//	    WithArgs(&EmailWorker{To: "user@example.com"}).
+//	    WithStatus("failed").
+//	    WithAttempts(2).
+//	    Insert(ctx, driver)
+func NewJob(kind string) *JobBuilder {
+	return &JobBuilder{
+		kind:        kind,
+		queue:       swig.Default,
+		args:        struct{}{},
+		status:      "pending",
+		maxAttempts: 3,
+	}
+}
+
+// WithArgs sets the job's payload, serialized the way Swig serializes a worker's arguments
+func (b *JobBuilder) WithArgs(v interface{}) *JobBuilder {
+	b.args = v
+	return b
+}
+
+// WithQueue puts the job in queue
+func (b *JobBuilder) WithQueue(queue swig.QueueTypes) *JobBuilder {
+	b.queue = queue
+	return b
+}
+
+// WithStatus sets the job's status, such as "processing", "retryable" or "failed"
+func (b *JobBuilder) WithStatus(status string) *JobBuilder {
+	b.status = status
+	return b
+}
+
+// WithPriority sets the job's priority
+func (b *JobBuilder) WithPriority(priority int) *JobBuilder {
+	b.priority = priority
+	return b
+}
+
+// WithAttempts sets how many attempts the job has used
+func (b *JobBuilder) WithAttempts(attempts int) *JobBuilder {
+	b.attempts = attempts
+	return b
+}
+
+// WithMaxAttempts sets how many attempts the job gets
+func (b *JobBuilder) WithMaxAttempts(maxAttempts int) *JobBuilder {
+	b.maxAttempts = maxAttempts
+	return b
+}
+
+// WithScheduledFor sets when the job is due
+func (b *JobBuilder) WithScheduledFor(t time.Time) *JobBuilder {
+	b.scheduledFor = t
+	return b
+}
+
+// WithStartedAt sets when the job's first attempt started
+func (b *JobBuilder) WithStartedAt(t time.Time) *JobBuilder {
+	b.startedAt = t
+	return b
+}
+
+// WithLockedAt sets when the current attempt was claimed, so a processing job can look
+// stuck to the rescuer
+func (b *JobBuilder) WithLockedAt(t time.Time) *JobBuilder {
+	b.lockedAt = t
+	return b
+}
+
+// WithFinishedAt sets when a completed, failed or expired job finished
+func (b *JobBuilder) WithFinishedAt(t time.Time) *JobBuilder {
+	b.finishedAt = t
+	return b
+}
+
+// WithNextRetryAt sets when a retryable job is retried
+func (b *JobBuilder) WithNextRetryAt(t time.Time) *JobBuilder {
+	b.nextRetryAt = t
+	return b
+}
+
+// WithLastError sets the error of the job's last failed attempt
+func (b *JobBuilder) WithLastError(message string) *JobBuilder {
+	b.lastError = message
+	return b
+}
+
+// WithUniqueKey sets the job's unique key
+func (b *JobBuilder) WithUniqueKey(key string) *JobBuilder {
+	b.uniqueKey = key
+	return b
+}
+
+// WithTenant sets the tenant the job belongs to
+func (b *JobBuilder) WithTenant(tenant string) *JobBuilder {
+	b.tenant = tenant
+	return b
+}
+
+// Insert writes the job and returns its ID. It bypasses Swig entirely: no hooks,
+// validation or notifications run, so workers only find the job when they next poll.
+// Timestamps that weren't set default to now where the status implies them: locked_at
+// for processing jobs, started_at for jobs that have run, finished_at for finished jobs
+// and next_retry_at for retryable ones. q is a driver or a transaction.
+func (b *JobBuilder) Insert(ctx context.Context, q drivers.Transaction) (string, error) {
+	payload, err := workers.MarshalArgs(b.args)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal args: %w", err)
+	}
+
+	var lastErrorAt interface{}
+	if b.lastError != "" {
+		lastErrorAt = time.Now()
+	}
+
+	insertSQL := `
+		INSERT INTO swig_jobs (
+			kind, queue, payload, status, priority, attempts, max_attempts, scheduled_for,
+			started_at, locked_at, finished_at, next_retry_at, last_error, last_error_at,
+			unique_key, tenant
+		)
+		VALUES (
+			$1, $2, $3, $4::text::swig_job_status, $5, $6, $7, COALESCE($8, NOW()),
+			COALESCE($9, CASE WHEN $4 IN ('processing', 'retryable', 'completed', 'failed') THEN NOW() END),
+			COALESCE($10, CASE WHEN $4 = 'processing' THEN NOW() END),
+			COALESCE($11, CASE WHEN $4 IN ('completed', 'failed', 'expired') THEN NOW() END),
+			COALESCE($12, CASE WHEN $4 = 'retryable' THEN NOW() END),
+			$13, $14, $15, $16
+		)
+		RETURNING id::text`
+
+	var id string
+	err = q.QueryRow(ctx, insertSQL,
+		b.kind,
+		string(b.queue),
+		payload,
+		b.status,
+		b.priority,
+		b.attempts,
+		b.maxAttempts,
+		optionalTime(b.scheduledFor),
+		optionalTime(b.startedAt),
+		optionalTime(b.lockedAt),
+		optionalTime(b.finishedAt),
+		optionalTime(b.nextRetryAt),
+		optionalString(b.lastError),
+		lastErrorAt,
+		optionalString(b.uniqueKey),
+		optionalString(b.tenant),
+	).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert %s job fixture: %w", b.kind, err)
+	}
+	return id, nil
+}
+
+// optionalTime is NULL for the zero time
+func optionalTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// optionalString is NULL for the empty string
+func optionalString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+// Package swigtest has helpers for integration tests of code that enqueues and processes
+// Swig jobs against a real database.
+package swigtest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/glamboyosa/swig"
+)
+
+// How often WaitForCompletion checks the database when no events arrive, which catches
+// jobs processed by other instances
+const completionPollInterval = 250 * time.Millisecond
+
+// Statuses of jobs that haven't reached a terminal state
+var unfinishedStatuses = []string{"pending", "processing", "retryable", "scheduled"}
+
+// WaitForCompletion blocks until no job matching filter is unfinished, that is every
+// matching job has completed, failed, expired or been deleted. It rechecks whenever client
+// finishes an attempt at a job, through Subscribe, and every so often in case the jobs
+// are processed elsewhere. filter.Statuses, Limit and Offset are ignored. It returns an
+// error if ctx ends first.
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+//	defer cancel()
+//	if err := swigtest.WaitForCompletion(ctx, client, swig.JobFilter{Kind: "send_email"}); err != nil {
+//	    t.Fatal(err)
+//	}
+func WaitForCompletion(ctx context.Context, client *swig.Swig, filter swig.JobFilter) error {
+	events, unsubscribe := client.Subscribe()
+	defer unsubscribe()
+
+	ticker := time.NewTicker(completionPollInterval)
+	defer ticker.Stop()
+
+	filter.Statuses = unfinishedStatuses
+	filter.Limit = 1
+	filter.Offset = 0
+	for {
+		unfinished, err := client.ListJobs(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("failed to check for unfinished jobs: %w", err)
+		}
+		if len(unfinished) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("job %s (%s) is still %s: %w",
+				unfinished[0].ID, unfinished[0].Kind, unfinished[0].Status, ctx.Err())
+		case <-events:
+		case <-ticker.C:
+		}
+	}
+}
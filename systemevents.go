@@ -0,0 +1,187 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// How long system events are kept before the leader deletes them
+const systemEventRetention = 30 * 24 * time.Hour
+
+// How often the leader deletes system events past their retention
+const systemEventPruneInterval = time.Hour
+
+// SystemEventType is what a SystemEvent records
+type SystemEventType string
+
+const (
+	// EventLeaderElected is recorded by an instance that became leader
+	EventLeaderElected SystemEventType = "leader_elected"
+	// EventLeaderReleased is recorded by a leader that gave up the lease as it stopped
+	EventLeaderReleased SystemEventType = "leader_released"
+	// EventLeaderLost is recorded by a leader that failed to renew its lease and stepped down
+	EventLeaderLost SystemEventType = "leader_lost"
+	// EventMaintenance is recorded for a maintenance pass that changed rows or failed
+	EventMaintenance SystemEventType = "maintenance"
+)
+
+// SystemEvent is an entry in swig_events, the history of leadership and maintenance
+type SystemEvent struct {
+	ID           int64           `json:"id"`
+	Type         SystemEventType `json:"type"`
+	InstanceID   string          `json:"instance_id"`
+	InstanceName string          `json:"instance_name,omitempty"`
+	// Task, Rows and Duration describe a maintenance pass: its name, such as "retry" or
+	// "rescue", the rows it changed and how long it took
+	Task     string        `json:"task,omitempty"`
+	Rows     int           `json:"rows,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Error    string        `json:"error,omitempty"` // Why the pass failed or leadership was lost
+	At       time.Time     `json:"at"`
+}
+
+// SystemEventFilter narrows the events returned by ListSystemEvents. Zero fields don't
+// filter.
+type SystemEventFilter struct {
+	Types      []SystemEventType
+	Task       string // Only maintenance passes of this task
+	InstanceID string
+	// After is inclusive and Before exclusive, so consecutive ranges don't overlap
+	After  time.Time
+	Before time.Time
+	Limit  int // Defaults to 100
+	Offset int
+}
+
+// recordSystemEvent adds event to swig_events. Recording is best effort: a failure is
+// logged and never affects what's being recorded.
+func (s *Swig) recordSystemEvent(ctx context.Context, event SystemEvent) {
+	recordSQL := `
+		INSERT INTO swig_events (type, instance_id, instance_name, task, row_count, duration_ms, error)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, NULLIF($7, ''))`
+	err := s.driver.Exec(ctx, recordSQL, string(event.Type), s.workerID, s.instanceName,
+		event.Task, event.Rows, event.Duration.Milliseconds(), event.Error)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Failed to record %s event: %v", event.Type, err)
+	}
+}
+
+// recordMaintenancePass records the outcome of a pass that changed rows or failed.
+// Passes that found nothing to do aren't recorded, so the table doesn't fill up with
+// the ones run every few seconds, and neither are passes cut short by shutdown. Passes
+// that hit the maintenance timeout are.
+func (s *Swig) recordMaintenancePass(ctx context.Context, task string, stats MaintenanceStats) {
+	if ctx.Err() != nil {
+		return
+	}
+	if stats.Rows == 0 && stats.Err == nil {
+		return
+	}
+	event := SystemEvent{Type: EventMaintenance, Task: task, Rows: stats.Rows, Duration: stats.Duration}
+	if stats.Err != nil {
+		event.Error = stats.Err.Error()
+	}
+	s.recordSystemEvent(ctx, event)
+}
+
+// ListSystemEvents returns the recorded leader transitions and maintenance passes matching
+// filter, newest first, for piecing together what the cluster was doing during an
+// incident. Events are kept for 30 days.
+//
+// Example:
+//
+//	// Who was leader, and which retry passes ran, around 03:00?
+//	events, err := swig.ListSystemEvents(ctx, swig.SystemEventFilter{
+//	    After:  incident.Add(-time.Hour),
+//	    Before: incident.Add(time.Hour),
+//	})
+func (s *Swig) ListSystemEvents(ctx context.Context, filter SystemEventFilter) ([]SystemEvent, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if len(filter.Types) > 0 {
+		types := make([]string, len(filter.Types))
+		for i, t := range filter.Types {
+			types[i] = string(t)
+		}
+		add("type = ANY($%d)", types)
+	}
+	if filter.Task != "" {
+		add("task = $%d", filter.Task)
+	}
+	if filter.InstanceID != "" {
+		add("instance_id::text = $%d", filter.InstanceID)
+	}
+	if !filter.After.IsZero() {
+		add("created_at >= $%d", filter.After)
+	}
+	if !filter.Before.IsZero() {
+		add("created_at < $%d", filter.Before)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	args = append(args, limit, filter.Offset)
+
+	listSQL := fmt.Sprintf(`
+		SELECT id, type, instance_id::text, COALESCE(instance_name, ''), COALESCE(task, ''),
+			COALESCE(row_count, 0), COALESCE(duration_ms, 0), COALESCE(error, ''), created_at
+		FROM swig_events
+		%s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args))
+
+	rows, err := s.driver.Query(ctx, listSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list system events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []SystemEvent{}
+	for rows.Next() {
+		var event SystemEvent
+		var eventType string
+		var durationMs int64
+		if err := rows.Scan(&event.ID, &eventType, &event.InstanceID, &event.InstanceName, &event.Task,
+			&event.Rows, &durationMs, &event.Error, &event.At); err != nil {
+			return nil, fmt.Errorf("failed to scan system event: %w", err)
+		}
+		event.Type = SystemEventType(eventType)
+		event.Duration = time.Duration(durationMs) * time.Millisecond
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// pruneSystemEvents deletes system events past their retention
+func (s *Swig) pruneSystemEvents(ctx context.Context) error {
+	pruneSQL := `
+		DELETE FROM swig_events
+		WHERE id IN (
+			SELECT id
+			FROM swig_events
+			WHERE created_at <= NOW() - (interval '1 second' * $1)
+			LIMIT $2
+		)
+		RETURNING id::text`
+	pruned, err := s.countRows(ctx, pruneSQL, systemEventRetention.Seconds(), maintenanceBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to prune system events: %w", err)
+	}
+	if pruned > 0 {
+		log.Printf("Pruned %d system events past their retention", pruned)
+	}
+	return nil
+}
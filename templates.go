@@ -0,0 +1,106 @@
+package swig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrTemplateNotFound is returned when enqueueing a template that isn't registered
+var ErrTemplateNotFound = errors.New("template not found")
+
+// ErrMissingParam is returned when a template is enqueued without one of its required params
+var ErrMissingParam = errors.New("missing template param")
+
+// JobTemplate is a named job definition that can be enqueued with just its params. Payload
+// holds the payload's defaults, and the params given to EnqueueTemplate are set over its
+// top-level fields, so a template only needs the fields that stay the same between runs.
+type JobTemplate struct {
+	Kind     string                 // Kind of the jobs the template enqueues
+	Options  JobOptions             // Options of each job unless EnqueueTemplate is given others; Queue defaults to Default
+	Payload  map[string]interface{} // Default payload fields
+	Required []string               // Params EnqueueTemplate must be given
+}
+
+// RegisterTemplate registers tmpl under name, replacing any template already registered
+// with it. Templates keep the kind, options and fixed payload fields of jobs that are
+// enqueued often, such as periodic jobs or runs started by ops, in one place.
+//
+// Example:
+//
+//	err := swig.RegisterTemplate("monthly_invoice", swig.JobTemplate{
+//	    Kind:     "generate_invoices",
+//	    Options:  swig.JobOptions{Queue: swig.Default, Priority: 2},
+//	    Payload:  map[string]interface{}{"currency": "EUR", "dry_run": false},
+//	    Required: []string{"month"},
+//	})
+func (s *Swig) RegisterTemplate(name string, tmpl JobTemplate) error {
+	if name == "" {
+		return fmt.Errorf("template name is required")
+	}
+	if tmpl.Kind == "" {
+		return fmt.Errorf("template %s has no kind", name)
+	}
+	if _, err := json.Marshal(tmpl.Payload); err != nil {
+		return fmt.Errorf("failed to marshal payload of template %s: %w", name, err)
+	}
+	if tmpl.Options.Queue == "" {
+		tmpl.Options.Queue = Default
+	}
+
+	s.templatesMu.Lock()
+	defer s.templatesMu.Unlock()
+	if s.templates == nil {
+		s.templates = make(map[string]JobTemplate)
+	}
+	s.templates[name] = tmpl
+	return nil
+}
+
+// Templates returns the names of the registered templates, sorted
+func (s *Swig) Templates() []string {
+	s.templatesMu.RLock()
+	defer s.templatesMu.RUnlock()
+	return sortedKeys(s.templates)
+}
+
+// EnqueueTemplate enqueues a job from the template registered under name, with params set
+// over the template's payload. opts, when given, replace the template's options. Jobs go
+// through the same middleware and checks as EnqueueRaw.
+//
+// Example:
+//
+//	err := swig.EnqueueTemplate(ctx, "monthly_invoice", map[string]interface{}{"month": "2024-06"})
+func (s *Swig) EnqueueTemplate(ctx context.Context, name string, params map[string]interface{}, opts ...JobOptions) error {
+	s.templatesMu.RLock()
+	tmpl, ok := s.templates[name]
+	s.templatesMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	for _, param := range tmpl.Required {
+		if _, ok := params[param]; !ok {
+			return fmt.Errorf("%w %q for template %s", ErrMissingParam, param, name)
+		}
+	}
+
+	payload := make(map[string]interface{}, len(tmpl.Payload)+len(params))
+	for field, value := range tmpl.Payload {
+		payload[field] = value
+	}
+	for field, value := range params {
+		payload[field] = value
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload for template %s: %w", name, err)
+	}
+
+	jobOpts := tmpl.Options
+	if len(opts) > 0 {
+		jobOpts = opts[0]
+	}
+	return s.EnqueueRaw(ctx, tmpl.Kind, encoded, jobOpts)
+}
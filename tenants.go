@@ -0,0 +1,86 @@
+package swig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// ErrTenantQuotaExceeded is returned when enqueueing would take a tenant past its quota of
+// pending jobs in a queue
+var ErrTenantQuotaExceeded = errors.New("tenant quota exceeded")
+
+// Class for the advisory locks that serialise quota checks per tenant and queue
+const tenantQuotaLockClass = 3141
+
+// tenantQuotas caps the pending jobs each tenant may have in a queue
+type tenantQuotas struct {
+	defaultLimit int            // Applies to tenants without their own limit; 0 for none
+	limits       map[string]int // Per-tenant limits
+}
+
+// limit returns tenant's quota, 0 when it has none
+func (q *tenantQuotas) limit(tenant string) int {
+	if limit, ok := q.limits[tenant]; ok {
+		return limit
+	}
+	return q.defaultLimit
+}
+
+// checkTenantQuotas returns ErrTenantQuotaExceeded if inserting jobs through tx would take
+// a tenant past its quota in any queue. Each tenant and queue is counted under an advisory
+// lock held until tx ends, so concurrent producers can't both slip in under the limit,
+// unless the database has no advisory locks.
+func (s *Swig) checkTenantQuotas(ctx context.Context, tx drivers.Transaction, jobs []drivers.BatchJob) error {
+	if s.tenantQuotas == nil {
+		return nil
+	}
+
+	type tenantQueue struct{ tenant, queue string }
+	adding := make(map[tenantQueue]int)
+	for _, job := range jobs {
+		if job.Opts.Tenant != "" && s.tenantQuotas.limit(job.Opts.Tenant) > 0 {
+			adding[tenantQueue{job.Opts.Tenant, job.Opts.Queue}]++
+		}
+	}
+
+	// Lock in a consistent order so concurrent batches can't deadlock
+	keys := make([]tenantQueue, 0, len(adding))
+	for key := range adding {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].tenant != keys[j].tenant {
+			return keys[i].tenant < keys[j].tenant
+		}
+		return keys[i].queue < keys[j].queue
+	})
+
+	for _, key := range keys {
+		if s.driverCaps.AdvisoryLocks {
+			lockSQL := `SELECT pg_advisory_xact_lock($1, hashtext($2))`
+			if err := tx.Exec(ctx, lockSQL, tenantQuotaLockClass, key.tenant+"/"+key.queue); err != nil {
+				return fmt.Errorf("failed to lock quota for tenant %s: %w", key.tenant, err)
+			}
+		}
+
+		countSQL := `
+			SELECT COUNT(*)
+			FROM swig_jobs
+			WHERE tenant = $1
+				AND queue = $2
+				AND status = 'pending'`
+		var pending int
+		if err := tx.QueryRow(ctx, countSQL, key.tenant, key.queue).Scan(&pending); err != nil {
+			return fmt.Errorf("failed to count pending jobs for tenant %s: %w", key.tenant, err)
+		}
+		if limit := s.tenantQuotas.limit(key.tenant); pending+adding[key] > limit {
+			return fmt.Errorf("%w: tenant %s has %d pending jobs in queue %s, limit %d",
+				ErrTenantQuotaExceeded, key.tenant, pending, key.queue, limit)
+		}
+	}
+	return nil
+}
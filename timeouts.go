@@ -0,0 +1,50 @@
+package swig
+
+import (
+	"context"
+	"time"
+)
+
+// Default time limits on Swig's own queries, generous enough that they're only hit when
+// something is holding locks on swig_jobs
+const (
+	defaultAcquireTimeout     = 30 * time.Second
+	defaultMaintenanceTimeout = 5 * time.Minute
+)
+
+// QueryTimeouts caps how long Swig's own queries can run, so a lock pile-up on swig_jobs
+// makes them fail and free their connections instead of holding them indefinitely. When
+// a limit is hit the query is cancelled: a worker backs off as it would when the database
+// is unavailable, and a maintenance pass picks up where it stopped on its next run. Zero
+// keeps a limit's default and a negative value removes it.
+type QueryTimeouts struct {
+	// Acquire bounds each query a worker runs to claim a job. Defaults to 30 seconds.
+	Acquire time.Duration
+	// Maintenance bounds each maintenance pass the leader runs, such as promoting retries
+	// or pruning jobs, across all the batches it takes. Defaults to 5 minutes.
+	Maintenance time.Duration
+}
+
+// acquire returns the time limit on acquire queries, negative for none
+func (t QueryTimeouts) acquire() time.Duration {
+	if t.Acquire == 0 {
+		return defaultAcquireTimeout
+	}
+	return t.Acquire
+}
+
+// maintenance returns the time limit on maintenance passes, negative for none
+func (t QueryTimeouts) maintenance() time.Duration {
+	if t.Maintenance == 0 {
+		return defaultMaintenanceTimeout
+	}
+	return t.Maintenance
+}
+
+// withQueryTimeout bounds ctx by timeout, unless it's negative
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout < 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
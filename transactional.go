@@ -0,0 +1,94 @@
+package swig
+
+import (
+	"context"
+	"errors"
+
+	"github.com/glamboyosa/swig/drivers"
+	"github.com/glamboyosa/swig/workers"
+)
+
+// txContextKey is the context key for the transaction a transactional job runs in
+type txContextKey struct{}
+
+// errProcessFailed rolls back a transactional job whose Process returned an error
+var errProcessFailed = errors.New("job failed")
+
+// TxFromContext returns the transaction a job of a kind registered with
+// WithTransactionalKinds is running in. Writes made through it commit together with the
+// job's completion, or not at all.
+//
+// Example:
+//
+//	func (w *ChargeWorker) Process(ctx context.Context) error {
+//	    tx, _ := swig.TxFromContext(ctx)
+//	    return tx.Exec(ctx, `UPDATE invoices SET paid = true WHERE id = $1`, w.InvoiceID)
+//	}
+func TxFromContext(ctx context.Context) (drivers.Transaction, bool) {
+	tx, ok := ctx.Value(txContextKey{}).(drivers.Transaction)
+	return tx, ok
+}
+
+// acquireAndProcessInTx acquires a job inside a transaction. Jobs of transactional kinds
+// are processed and completed in that same transaction, with their row locked throughout,
+// so their effects and their completion commit together. If Process fails the transaction
+// is rolled back and the failure recorded afterwards; if the instance dies the job simply
+// becomes available again. Jobs of other kinds are committed as processing straight away
+// and processed as usual.
+func (s *Swig) acquireAndProcessInTx(ctx context.Context, queueType QueueTypes, specificJobID string) (bool, error) {
+	var job acquiredJob
+	var acquired, inTx bool
+	var worker interface{}
+	var processErr, releaseErr error
+
+	err := s.driver.WithTx(ctx, func(tx drivers.Transaction) error {
+		var err error
+		job, acquired, err = s.acquireJob(ctx, tx, queueType, specificJobID)
+		if err != nil || !acquired || !s.transactionalKinds[job.Kind] {
+			return err
+		}
+		inTx = true
+
+		cost := s.reserveCapacity(job.Kind, len(job.payload))
+		defer s.releaseCapacity(cost)
+
+		var processor interface{ Process(context.Context) error }
+		worker, processor, err = s.prepareWorker(job)
+		if err != nil {
+			releaseErr = s.releaseJob(ctx, tx, job.ID, err)
+			return nil
+		}
+
+		processErr = s.runJob(context.WithValue(ctx, txContextKey{}, tx), job, processor)
+		if processErr != nil {
+			return errProcessFailed
+		}
+		job.result = jobResult(job, worker)
+		return s.recordResult(ctx, tx, job, nil)
+	})
+
+	switch {
+	case !acquired:
+		return false, err
+	case !inTx:
+		if err != nil {
+			return false, err
+		}
+		return true, s.processJob(ctx, job)
+	case processErr != nil:
+		if handler, ok := worker.(workers.ErrorHandler); ok {
+			s.callErrorHandler(ctx, handler, job.JobInfo, processErr)
+		}
+		if err := s.recordResult(ctx, s.driver, job, processErr); err != nil {
+			return true, err
+		}
+		s.publishResult(job, processErr)
+		return true, nil
+	case err != nil:
+		return true, err
+	case releaseErr != nil:
+		return true, releaseErr
+	}
+	s.publishResult(job, nil)
+	return true, nil
+}
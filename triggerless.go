@@ -0,0 +1,95 @@
+package swig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// SQLSTATE Postgres reports when the role isn't allowed to do something, such as create
+// functions on a managed database
+const insufficientPrivilege = "42501"
+
+// sqlState returns the SQLSTATE of a database error from pgx or lib/pq, or "" for other errors
+func sqlState(err error) string {
+	var coded interface{ SQLState() string }
+	if errors.As(err, &coded) {
+		return coded.SQLState()
+	}
+	return ""
+}
+
+// checkNotifyTrigger switches to trigger-less mode when swig_jobs has no insert trigger,
+// because Swig wasn't allowed to create it or was told not to with WithoutTriggers. In
+// that mode Swig sends the notification for each job it inserts itself. It returns why
+// jobs inserted by other producers won't wake workers, if they won't.
+func (s *Swig) checkNotifyTrigger(ctx context.Context) error {
+	triggerSQL := `
+		SELECT EXISTS (
+			SELECT 1 FROM pg_trigger
+			WHERE tgrelid = 'swig_jobs'::regclass
+				AND tgname = 'swig_jobs_notify_trigger'
+		)`
+	var installed bool
+	if err := s.driver.QueryRow(ctx, triggerSQL).Scan(&installed); err != nil {
+		return fmt.Errorf("failed to check for the notification trigger: %w", err)
+	}
+	s.triggerless.Store(!installed)
+	if installed {
+		return nil
+	}
+	return fmt.Errorf("swig_jobs has no notification trigger, so Swig announces the jobs it inserts itself. " +
+		"Jobs inserted by other producers are found by the next poll, and WithNotifyDedup has no effect")
+}
+
+// announceJobs sends the notifications the insert trigger would have for jobs, through
+// the transaction that inserted them so they're only delivered once it commits. It does
+// nothing unless Swig is in trigger-less mode, or if the database can't notify at all.
+// Jobs must have IDs, see assignJobIDs.
+func (s *Swig) announceJobs(ctx context.Context, tx drivers.Transaction, jobs []drivers.BatchJob, origin string) error {
+	if !s.triggerless.Load() || !s.driverCaps.ListenNotify {
+		return nil
+	}
+
+	now := s.dbNow()
+	payloads := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		if job.Opts.StagingToken != "" {
+			continue // Announced when they're released
+		}
+		kind := job.Kind
+		if named, ok := job.Worker.(interface{ JobName() string }); ok {
+			kind = named.JobName()
+		}
+		scheduledFor := job.Opts.RunAt
+		if scheduledFor.IsZero() {
+			scheduledFor = now
+		}
+		payload, err := json.Marshal(jobNotification{
+			Version:      notifyVersion,
+			ID:           job.Opts.ID,
+			Queue:        job.Opts.Queue,
+			Kind:         kind,
+			ScheduledFor: scheduledFor.Add(job.Opts.RunIn).UTC().Format(time.RFC3339Nano),
+			Origin:       origin,
+			Claim:        claimToken(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode notification: %w", err)
+		}
+		payloads = append(payloads, string(payload))
+	}
+	if len(payloads) == 0 {
+		return nil
+	}
+
+	notifySQL := `SELECT pg_notify($1, payload) FROM unnest($2::text[]) AS payload`
+	if err := tx.Exec(ctx, notifySQL, jobsChannel, payloads); err != nil {
+		return fmt.Errorf("failed to announce jobs: %w", err)
+	}
+	return nil
+}
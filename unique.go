@@ -0,0 +1,85 @@
+package swig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// UniqueJob is the job a unique enqueue resolved to
+type UniqueJob struct {
+	ID       string // The new job, or the existing job with the same key
+	Inserted bool   // Whether a new job was added, rather than the conflict resolved
+}
+
+// EnqueueUnique enqueues a job with opts.UniqueKey and returns the job the key resolved to.
+// When a job with the key is already waiting to start, nothing is added and the existing
+// job is changed as opts.OnConflict says: kept as it is, given the new payload, or moved
+// earlier. AddJob and its siblings honor UniqueKey too, for producers that don't need to
+// know which job they got.
+//
+// Example:
+//
+//	job, err := swig.EnqueueUnique(ctx, &SyncAccountWorker{AccountID: 42}, swig.JobOptions{
+//	    Queue:      swig.Default,
+//	    RunIn:      time.Minute,
+//	    UniqueKey:  "sync_account:42",
+//	    OnConflict: swig.ConflictReplacePayload,
+//	})
+//	if err == nil && !job.Inserted {
+//	    log.Printf("account 42 already has sync %s queued", job.ID)
+//	}
+func (s *Swig) EnqueueUnique(ctx context.Context, workerWithArgs interface{}, opts JobOptions) (*UniqueJob, error) {
+	if _, ok := workerWithArgs.(interface{ JobName() string }); !ok {
+		return nil, fmt.Errorf("workerWithArgs must implement JobName() string")
+	}
+	if opts.UniqueKey == "" {
+		return nil, fmt.Errorf("unique key is required")
+	}
+
+	jobs, err := s.prepareJobs(ctx, []drivers.BatchJob{
+		{Worker: workerWithArgs, Opts: opts.driverOptions(s.workerID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var job UniqueJob
+	err = s.driver.WithTx(ctx, func(tx drivers.Transaction) error {
+		if err := tx.Exec(ctx, `SELECT set_config('swig.origin', $1, true)`, s.workerID); err != nil {
+			return fmt.Errorf("failed to tag job origin: %w", err)
+		}
+		if err := s.checkTenantQuotas(ctx, tx, jobs); err != nil {
+			return err
+		}
+		id, inserted, err := drivers.InsertUniqueJob(ctx, tx, jobs[0])
+		if err != nil {
+			return err
+		}
+		job = UniqueJob{ID: id, Inserted: inserted}
+		if s.dryRun {
+			return errDryRun
+		}
+		if !inserted {
+			return nil
+		}
+		return s.announceJobs(ctx, tx, jobs, s.workerID)
+	})
+	if errors.Is(err, errDryRun) {
+		return &job, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Our own workers are woken for a job that's due, whether it was just inserted or an
+	// existing one was moved up; other instances find a moved job on their next poll
+	prepared := jobs[0].Opts
+	now := s.dbNow().Add(notifyClockSkew)
+	if prepared.StagingToken == "" && prepared.RunIn <= notifyClockSkew && !prepared.RunAt.Add(prepared.RunIn).After(now) {
+		s.wakeWorkers(QueueTypes(prepared.Queue), jobNotification{Queue: prepared.Queue})
+	}
+	return &job, nil
+}
@@ -0,0 +1,174 @@
+package swig
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// Version is the version of the Swig library, recorded in swig_instances so mixed
+// versions can be spotted during a rolling upgrade
+const Version = "0.1.15-alpha"
+
+// How often each instance records that it's still running, and how long after an instance
+// was last seen it's assumed gone. Instances that crash aren't removed from swig_instances,
+// so only recently seen ones are compared.
+const (
+	instanceSeenInterval = 15 * time.Second
+	instanceStaleAfter   = time.Minute
+)
+
+// How often the leader compares the versions of running instances
+const versionCheckInterval = time.Minute
+
+// instanceVersion is what a running instance reported about itself
+type instanceVersion struct {
+	id            string
+	name          string
+	swigVersion   string
+	schemaVersion int
+	notifyVersion int
+}
+
+func (v instanceVersion) String() string {
+	if v.name != "" {
+		return fmt.Sprintf("%s (%s)", v.name, v.id)
+	}
+	return v.id
+}
+
+// latestSchemaVersion returns the latest migration this instance knows
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// liveInstanceVersions lists the instances seen recently. Instances of Swig versions that
+// didn't record versions aren't included, as they never refresh seen_at.
+func liveInstanceVersions(ctx context.Context, q interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (drivers.Rows, error)
+}) ([]instanceVersion, error) {
+	versionsSQL := `
+		SELECT id::text, COALESCE(name, ''), swig_version, schema_version, notify_version
+		FROM swig_instances
+		WHERE seen_at > NOW() - $1::interval
+			AND swig_version IS NOT NULL
+		ORDER BY id`
+	rows, err := q.Query(ctx, versionsSQL, instanceStaleAfter.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instance versions: %w", err)
+	}
+	defer rows.Close()
+
+	var instances []instanceVersion
+	for rows.Next() {
+		var instance instanceVersion
+		if err := rows.Scan(&instance.id, &instance.name, &instance.swigVersion, &instance.schemaVersion, &instance.notifyVersion); err != nil {
+			return nil, fmt.Errorf("failed to scan instance version: %w", err)
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// checkUpgradeSafety refuses a breaking migration while instances that don't know it are
+// still running, since they'd misread the schema once it's applied. Those instances have
+// to be stopped, or the deploy finished without this one, before the migration can apply.
+func checkUpgradeSafety(ctx context.Context, tx drivers.Transaction, version int, self string) error {
+	instances, err := liveInstanceVersions(ctx, tx)
+	if err != nil {
+		return err
+	}
+	var outdated []string
+	for _, instance := range instances {
+		if instance.id != self && instance.schemaVersion < version {
+			outdated = append(outdated, fmt.Sprintf("%s on Swig %s", instance, instance.swigVersion))
+		}
+	}
+	if len(outdated) > 0 {
+		return fmt.Errorf("migration %d breaks instances that don't know it, and these are still running: %s",
+			version, strings.Join(outdated, ", "))
+	}
+	return nil
+}
+
+// markInstanceSeen records that this instance is still running
+func (s *Swig) markInstanceSeen(ctx context.Context) error {
+	if err := s.driver.Exec(ctx, `UPDATE swig_instances SET seen_at = NOW() WHERE id = $1`, s.workerID); err != nil {
+		return fmt.Errorf("failed to mark instance as seen: %w", err)
+	}
+	return nil
+}
+
+// runInstanceHeartbeat keeps this instance's seen_at fresh until Swig shuts down
+func (s *Swig) runInstanceHeartbeat(ctx context.Context) {
+	ticker := time.NewTicker(instanceSeenInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+			if err := s.markInstanceSeen(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("%v", err)
+			}
+		}
+	}
+}
+
+// checkInstanceVersions warns when running instances disagree about versions. Different
+// library versions are expected mid-deploy and logged once per change; an instance that
+// predates a breaking migration the database has, or can't read the notifications others
+// send, is logged on every pass until it's upgraded or stopped.
+func (s *Swig) checkInstanceVersions(ctx context.Context) error {
+	instances, err := liveInstanceVersions(ctx, s.driver)
+	if err != nil {
+		return err
+	}
+	schema, err := s.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	breaking := 0
+	for _, m := range migrations {
+		if m.breaking && m.version <= schema {
+			breaking = m.version
+		}
+	}
+
+	byVersion := make(map[string][]string)
+	for _, instance := range instances {
+		byVersion[instance.swigVersion] = append(byVersion[instance.swigVersion], instance.String())
+		if instance.schemaVersion < breaking {
+			log.Printf("Instance %s runs Swig %s, which knows schema version %d but migration %d broke compatibility with it; upgrade or stop it",
+				instance, instance.swigVersion, instance.schemaVersion, breaking)
+		}
+		if instance.notifyVersion < notifyVersion {
+			log.Printf("Instance %s reads notifications up to version %d but this leader sends version %d; "+
+				"it treats them as wake-ups for their whole queue until it's upgraded", instance, instance.notifyVersion, notifyVersion)
+		}
+	}
+
+	versions := make([]string, 0, len(byVersion))
+	for version, names := range byVersion {
+		versions = append(versions, fmt.Sprintf("%s on %s", version, strings.Join(names, ", ")))
+	}
+	sort.Strings(versions)
+	mix := strings.Join(versions, "; ")
+
+	s.versionMu.Lock()
+	changed := mix != s.lastVersionMix
+	s.lastVersionMix = mix
+	s.versionMu.Unlock()
+	if changed && len(byVersion) > 1 {
+		log.Printf("Mixed Swig versions are running: %s", mix)
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+package swig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/glamboyosa/swig/drivers"
+)
+
+// errVerified rolls back the scratch schema VerifySchema builds once it has been compared
+var errVerified = errors.New("schema verified")
+
+// SchemaDiff is one way the live schema differs from what this version of Swig expects
+type SchemaDiff struct {
+	Object   string `json:"object"`  // Such as "column swig_jobs.status" or "index swig_jobs_queue_idx"
+	Problem  string `json:"problem"` // "missing", "unexpected" or "differs"
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	// Fix is the SQL, or the step, that brings the object in line. It's empty for objects
+	// Swig doesn't create, which may well be deliberate.
+	Fix string `json:"fix,omitempty"`
+}
+
+func (d SchemaDiff) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", d.Object, d.Problem)
+	if d.Expected != "" {
+		fmt.Fprintf(&b, "\n  expected: %s", d.Expected)
+	}
+	if d.Actual != "" {
+		fmt.Fprintf(&b, "\n  actual:   %s", d.Actual)
+	}
+	if d.Fix != "" {
+		fmt.Fprintf(&b, "\n  fix:      %s", strings.ReplaceAll(d.Fix, "\n", "\n            "))
+	}
+	return b.String()
+}
+
+// schemaColumn is a column of a swig_ table as Postgres describes it
+type schemaColumn struct {
+	table     string
+	name      string
+	typ       string
+	notNull   bool
+	def       string
+	generated bool
+}
+
+func (c schemaColumn) String() string {
+	def := c.typ
+	if c.notNull {
+		def += " NOT NULL"
+	}
+	if c.generated {
+		def += " GENERATED ALWAYS AS (" + c.def + ") STORED"
+	} else if c.def != "" {
+		def += " DEFAULT " + c.def
+	}
+	return def
+}
+
+// schemaObject is an index, constraint, trigger or function and its definition
+type schemaObject struct {
+	table string
+	name  string
+	def   string
+}
+
+// schemaObjects is everything Swig creates in one schema, keyed by name
+type schemaObjects struct {
+	tables      map[string]bool
+	columns     map[string]schemaColumn // table.column
+	indexes     map[string]schemaObject
+	constraints map[string]schemaObject // table.constraint
+	triggers    map[string]schemaObject // table.trigger
+	functions   map[string]schemaObject
+}
+
+// VerifySchema compares the live swig_ tables, columns, indexes, constraints, triggers and
+// functions against what this version of Swig expects, for schemas that were altered by
+// hand, restored from an old dump, or half-migrated by a tool that skipped Swig. The
+// expected schema is built by applying every migration to a scratch schema inside a
+// transaction that's rolled back, so the role needs to be allowed to create schemas. No
+// diffs means the schema matches; the cmd/swig doctor command prints them.
+//
+// Example:
+//
+//	diffs, err := swig.VerifySchema(ctx)
+//	for _, diff := range diffs {
+//	    log.Printf("%s", diff)
+//	}
+func (s *Swig) VerifySchema(ctx context.Context) ([]SchemaDiff, error) {
+	var diffs []SchemaDiff
+	err := s.driver.WithTx(ctx, func(tx drivers.Transaction) error {
+		var live string
+		if err := tx.QueryRow(ctx, `SELECT current_schema()`).Scan(&live); err != nil {
+			return fmt.Errorf("failed to read current schema: %w", err)
+		}
+		actual, err := introspectSchema(ctx, tx, live)
+		if err != nil {
+			return err
+		}
+		if actual.tables["swig_migrations"] {
+			var version int
+			if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM swig_migrations`).Scan(&version); err != nil {
+				return fmt.Errorf("failed to read schema version: %w", err)
+			}
+			if diff, ok := diffSchemaVersion(version); ok {
+				diffs = append(diffs, diff)
+			}
+		}
+
+		scratch := fmt.Sprintf("swig_verify_%d", time.Now().UnixNano())
+		if err := tx.Exec(ctx, `CREATE SCHEMA `+scratch); err != nil {
+			return fmt.Errorf("failed to create scratch schema, the role needs CREATE on the database: %w", err)
+		}
+		if err := tx.Exec(ctx, `SELECT set_config('search_path', $1, true)`, scratch); err != nil {
+			return fmt.Errorf("failed to switch to scratch schema: %w", err)
+		}
+		if err := tx.Exec(ctx, migrationsTableSQL); err != nil {
+			return fmt.Errorf("failed to create migrations table: %w", err)
+		}
+		for _, m := range migrations {
+			if m.sql != "" {
+				if err := tx.Exec(ctx, m.sql); err != nil {
+					return fmt.Errorf("failed to apply migration %d to scratch schema: %w", m.version, err)
+				}
+			}
+			if m.functions != "" && !s.withoutTriggers {
+				if err := tx.Exec(ctx, m.functions); err != nil {
+					return fmt.Errorf("failed to apply functions of migration %d to scratch schema: %w", m.version, err)
+				}
+			}
+		}
+		expected, err := introspectSchema(ctx, tx, scratch)
+		if err != nil {
+			return err
+		}
+
+		diffs = append(diffs, diffSchemas(expected, actual, s.withoutTriggers)...)
+		return errVerified
+	})
+	if err != nil && !errors.Is(err, errVerified) {
+		return nil, fmt.Errorf("failed to verify schema: %w", err)
+	}
+	return diffs, nil
+}
+
+// diffSchemaVersion reports a database that's behind or ahead of this version's migrations
+func diffSchemaVersion(version int) (SchemaDiff, bool) {
+	latest := latestSchemaVersion()
+	diff := SchemaDiff{
+		Object:   "schema version",
+		Problem:  "differs",
+		Expected: fmt.Sprint(latest),
+		Actual:   fmt.Sprint(version),
+	}
+	switch {
+	case version < latest:
+		diff.Fix = fmt.Sprintf("start Swig %s to apply migrations %d to %d", Version, version+1, latest)
+	case version > latest:
+		diff.Fix = "the database was migrated by a newer Swig; upgrade this instance"
+	default:
+		return SchemaDiff{}, false
+	}
+	return diff, true
+}
+
+// introspectSchema reads the swig_ objects in schema. The search path is switched to the
+// schema first, so names and types in definitions come out unqualified and definitions
+// from different schemas compare equal.
+func introspectSchema(ctx context.Context, tx drivers.Transaction, schema string) (*schemaObjects, error) {
+	if err := tx.Exec(ctx, `SELECT set_config('search_path', quote_ident($1), true)`, schema); err != nil {
+		return nil, fmt.Errorf("failed to switch to schema %s: %w", schema, err)
+	}
+	var quoted string
+	if err := tx.QueryRow(ctx, `SELECT quote_ident($1)`, schema).Scan(&quoted); err != nil {
+		return nil, fmt.Errorf("failed to quote schema %s: %w", schema, err)
+	}
+	unqualify := strings.NewReplacer(quoted+".", "", schema+".", "")
+
+	objects := &schemaObjects{
+		tables:      make(map[string]bool),
+		columns:     make(map[string]schemaColumn),
+		indexes:     make(map[string]schemaObject),
+		constraints: make(map[string]schemaObject),
+		triggers:    make(map[string]schemaObject),
+		functions:   make(map[string]schemaObject),
+	}
+
+	columnsSQL := `
+		SELECT c.relname, a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull,
+			COALESCE(pg_get_expr(d.adbin, d.adrelid), ''), a.attgenerated = 's'
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE n.nspname = $1 AND c.relkind IN ('r', 'p') AND c.relname LIKE 'swig\_%'
+			AND a.attnum > 0 AND NOT a.attisdropped`
+	rows, err := tx.Query(ctx, columnsSQL, schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of schema %s: %w", schema, err)
+	}
+	for rows.Next() {
+		var column schemaColumn
+		if err := rows.Scan(&column.table, &column.name, &column.typ, &column.notNull, &column.def, &column.generated); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan column: %w", err)
+		}
+		column.def = unqualify.Replace(column.def)
+		objects.tables[column.table] = true
+		objects.columns[column.table+"."+column.name] = column
+	}
+	rows.Close()
+
+	definitions := []struct {
+		kind   string
+		into   map[string]schemaObject
+		byName bool
+		sql    string
+	}{
+		{"indexes", objects.indexes, true, `
+			SELECT t.relname, i.relname, pg_get_indexdef(i.oid)
+			FROM pg_index x
+			JOIN pg_class i ON i.oid = x.indexrelid
+			JOIN pg_class t ON t.oid = x.indrelid
+			JOIN pg_namespace n ON n.oid = t.relnamespace
+			WHERE n.nspname = $1 AND t.relname LIKE 'swig\_%'`},
+		{"constraints", objects.constraints, false, `
+			SELECT t.relname, con.conname, pg_get_constraintdef(con.oid)
+			FROM pg_constraint con
+			JOIN pg_class t ON t.oid = con.conrelid
+			JOIN pg_namespace n ON n.oid = t.relnamespace
+			WHERE n.nspname = $1 AND t.relname LIKE 'swig\_%'`},
+		{"triggers", objects.triggers, false, `
+			SELECT t.relname, tg.tgname, pg_get_triggerdef(tg.oid)
+			FROM pg_trigger tg
+			JOIN pg_class t ON t.oid = tg.tgrelid
+			JOIN pg_namespace n ON n.oid = t.relnamespace
+			WHERE n.nspname = $1 AND t.relname LIKE 'swig\_%' AND NOT tg.tgisinternal`},
+		{"functions", objects.functions, true, `
+			SELECT '', p.proname, pg_get_functiondef(p.oid)
+			FROM pg_proc p
+			JOIN pg_namespace n ON n.oid = p.pronamespace
+			WHERE n.nspname = $1 AND p.prokind = 'f'`},
+	}
+	for _, definition := range definitions {
+		rows, err := tx.Query(ctx, definition.sql, schema)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s of schema %s: %w", definition.kind, schema, err)
+		}
+		for rows.Next() {
+			var object schemaObject
+			if err := rows.Scan(&object.table, &object.name, &object.def); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan %s: %w", definition.kind, err)
+			}
+			object.def = strings.TrimSpace(unqualify.Replace(object.def))
+			key := object.name
+			if !definition.byName {
+				key = object.table + "." + object.name
+			}
+			definition.into[key] = object
+		}
+		rows.Close()
+	}
+	return objects, nil
+}
+
+// diffSchemas lists how actual differs from expected. Functions and triggers are skipped
+// in trigger-less mode, and functions that aren't Swig's are ignored, as they share the
+// schema with the application's.
+func diffSchemas(expected, actual *schemaObjects, withoutTriggers bool) []SchemaDiff {
+	var diffs []SchemaDiff
+
+	for _, table := range sortedKeys(expected.tables) {
+		if !actual.tables[table] {
+			diffs = append(diffs, SchemaDiff{
+				Object:  "table " + table,
+				Problem: "missing",
+				Fix:     fmt.Sprintf("restore %s from a backup, or start Swig %s against an empty schema and copy its data over", table, Version),
+			})
+		}
+	}
+
+	for _, key := range sortedKeys(expected.columns) {
+		want := expected.columns[key]
+		if !actual.tables[want.table] {
+			continue
+		}
+		got, ok := actual.columns[key]
+		if !ok {
+			diffs = append(diffs, SchemaDiff{
+				Object:   "column " + key,
+				Problem:  "missing",
+				Expected: want.String(),
+				Fix:      fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", want.table, want.name, want),
+			})
+		} else if got.String() != want.String() {
+			diffs = append(diffs, SchemaDiff{
+				Object:   "column " + key,
+				Problem:  "differs",
+				Expected: want.String(),
+				Actual:   got.String(),
+				Fix:      columnFix(want, got),
+			})
+		}
+	}
+	for _, key := range sortedKeys(actual.columns) {
+		got := actual.columns[key]
+		if _, ok := expected.columns[key]; !ok && expected.tables[got.table] {
+			diffs = append(diffs, SchemaDiff{Object: "column " + key, Problem: "unexpected", Actual: got.String()})
+		}
+	}
+
+	diffs = append(diffs, diffObjects("index", expected.indexes, actual.indexes, actual.tables, func(o schemaObject) (string, string) {
+		return o.def + ";", fmt.Sprintf("DROP INDEX %s;", o.name)
+	})...)
+	diffs = append(diffs, diffObjects("constraint", expected.constraints, actual.constraints, actual.tables, func(o schemaObject) (string, string) {
+		return fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s;", o.table, o.name, o.def),
+			fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", o.table, o.name)
+	})...)
+	if withoutTriggers {
+		return diffs
+	}
+	diffs = append(diffs, diffObjects("trigger", expected.triggers, actual.triggers, actual.tables, func(o schemaObject) (string, string) {
+		return o.def + ";", fmt.Sprintf("DROP TRIGGER %s ON %s;", o.name, o.table)
+	})...)
+
+	functions := make(map[string]schemaObject)
+	for name := range expected.functions {
+		if function, ok := actual.functions[name]; ok {
+			functions[name] = function
+		}
+	}
+	diffs = append(diffs, diffObjects("function", expected.functions, functions, nil, func(o schemaObject) (string, string) {
+		return o.def + ";", ""
+	})...)
+	return diffs
+}
+
+// diffObjects compares objects of one kind. fix returns the statement creating an object
+// and the one dropping it. Objects on tables that are missing altogether aren't reported
+// one by one, and unexpected objects only on tables Swig creates.
+func diffObjects(kind string, expected, actual map[string]schemaObject, tables map[string]bool, fix func(schemaObject) (create, drop string)) []SchemaDiff {
+	var diffs []SchemaDiff
+	for _, key := range sortedKeys(expected) {
+		want := expected[key]
+		if tables != nil && !tables[want.table] {
+			continue
+		}
+		create, drop := fix(want)
+		got, ok := actual[key]
+		if !ok {
+			diffs = append(diffs, SchemaDiff{Object: kind + " " + key, Problem: "missing", Expected: want.def, Fix: create})
+		} else if got.def != want.def {
+			if drop != "" {
+				create = drop + "\n" + create
+			}
+			diffs = append(diffs, SchemaDiff{Object: kind + " " + key, Problem: "differs", Expected: want.def, Actual: got.def, Fix: create})
+		}
+	}
+	for _, key := range sortedKeys(actual) {
+		got := actual[key]
+		if _, ok := expected[key]; !ok {
+			diffs = append(diffs, SchemaDiff{Object: kind + " " + key, Problem: "unexpected", Actual: got.def})
+		}
+	}
+	return diffs
+}
+
+// columnFix returns the statements that turn column got into want
+func columnFix(want, got schemaColumn) string {
+	alter := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s", want.table, want.name)
+	if want.generated || got.generated {
+		return fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;\nALTER TABLE %s ADD COLUMN %s %s;", want.table, want.name, want.table, want.name, want)
+	}
+
+	var fixes []string
+	if got.def != want.def && got.def != "" {
+		fixes = append(fixes, alter+" DROP DEFAULT;")
+	}
+	if got.typ != want.typ {
+		fixes = append(fixes, fmt.Sprintf("%s TYPE %s USING %s::%s;", alter, want.typ, want.name, want.typ))
+	}
+	if got.def != want.def && want.def != "" {
+		fixes = append(fixes, fmt.Sprintf("%s SET DEFAULT %s;", alter, want.def))
+	}
+	if got.notNull != want.notNull {
+		if want.notNull {
+			fixes = append(fixes, alter+" SET NOT NULL;")
+		} else {
+			fixes = append(fixes, alter+" DROP NOT NULL;")
+		}
+	}
+	return strings.Join(fixes, "\n")
+}
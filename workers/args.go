@@ -0,0 +1,186 @@
+package workers
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ArgsProvider can be implemented by workers that hold more than their arguments, such as
+// dependencies or runtime state. Args returns what's serialized as the job's payload, which
+// is decoded back into the worker when the job runs, so its JSON fields must match the
+// worker's. Embedding an arguments struct in the worker keeps them in line:
+//
+//	type EmailWorker struct {
+//	    EmailArgs
+//	    SMTP *smtp.Client
+//	}
+//
+//	func (w *EmailWorker) Args() interface{} { return w.EmailArgs }
+type ArgsProvider interface {
+	Args() interface{}
+}
+
+// Fields tagged `swig:"-"`, by worker type
+var excludedFields sync.Map // reflect.Type -> []string
+
+// MarshalArgs serializes a worker's arguments into a job payload. Workers implementing
+// ArgsProvider are serialized through Args. Otherwise the worker is serialized as JSON,
+// leaving out its top-level fields tagged `swig:"-"`:
+//
+//	type EmailWorker struct {
+//	    To   string       `json:"to"`
+//	    SMTP *smtp.Client `swig:"-"`
+//	}
+func MarshalArgs(worker interface{}) ([]byte, error) {
+	if provider, ok := worker.(ArgsProvider); ok {
+		return json.Marshal(provider.Args())
+	}
+	payload, err := json.Marshal(worker)
+	if err != nil {
+		return nil, err
+	}
+	return withoutExcludedFields(worker, payload), nil
+}
+
+// UnmarshalArgs decodes a job payload into worker. Fields tagged `swig:"-"` are never
+// overwritten, even by payloads enqueued before they were tagged. Maps, slices and
+// pointers the payload sets are replaced rather than filled in, so a worker copied from
+// the registered one never writes into values shared with other jobs.
+func UnmarshalArgs(payload []byte, worker interface{}) error {
+	payload = withoutExcludedFields(worker, payload)
+	resetDecodedFields(worker, payload)
+	return json.Unmarshal(payload, worker)
+}
+
+// resetDecodedFields clears the fields of worker that payload sets and that JSON decoding
+// would fill in place: maps, slices and pointers, including those held in interfaces. A
+// pointer in an interface is replaced by a new zero value of its type, so the payload is
+// still decoded into that type. Embedded struct pointers are copied before their fields
+// are cleared.
+func resetDecodedFields(worker interface{}, payload []byte) {
+	v := reflect.ValueOf(worker)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil || len(fields) == 0 {
+		return
+	}
+	resetStructFields(v.Elem(), fields)
+}
+
+// resetStructFields clears the fields of v named in fields, see resetDecodedFields
+func resetStructFields(v reflect.Value, fields map[string]json.RawMessage) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+		if !value.CanSet() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		// Fields of embedded structs are decoded as if they were the worker's own
+		if field.Anonymous && name == "" {
+			switch {
+			case value.Kind() == reflect.Struct:
+				resetStructFields(value, fields)
+				continue
+			case value.Kind() == reflect.Ptr && value.Type().Elem().Kind() == reflect.Struct:
+				if !value.IsNil() {
+					copied := reflect.New(value.Type().Elem())
+					copied.Elem().Set(value.Elem())
+					value.Set(copied)
+					resetStructFields(copied.Elem(), fields)
+				}
+				continue
+			}
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		if !hasField(fields, name) {
+			continue
+		}
+		switch value.Kind() {
+		case reflect.Map, reflect.Slice, reflect.Ptr:
+			value.Set(reflect.Zero(value.Type()))
+		case reflect.Interface:
+			if held := value.Elem(); held.Kind() == reflect.Ptr && !held.IsNil() {
+				value.Set(reflect.New(held.Type().Elem()))
+			}
+		}
+	}
+}
+
+// hasField reports whether fields has name, matching keys case-insensitively as JSON
+// decoding does
+func hasField(fields map[string]json.RawMessage, name string) bool {
+	if _, ok := fields[name]; ok {
+		return true
+	}
+	for key := range fields {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutExcludedFields removes the fields of worker tagged `swig:"-"` from its JSON payload
+func withoutExcludedFields(worker interface{}, payload []byte) []byte {
+	excluded := excludedFieldNames(reflect.TypeOf(worker))
+	if len(excluded) == 0 {
+		return payload
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil || fields == nil {
+		// Not a JSON object; decoding will report the problem
+		return payload
+	}
+	for _, name := range excluded {
+		delete(fields, name)
+	}
+	stripped, err := json.Marshal(fields)
+	if err != nil {
+		return payload
+	}
+	return stripped
+}
+
+// excludedFieldNames returns the JSON names of t's fields tagged `swig:"-"`
+func excludedFieldNames(t reflect.Type) []string {
+	if t == nil {
+		return nil
+	}
+	if cached, ok := excludedFields.Load(t); ok {
+		return cached.([]string)
+	}
+
+	var names []string
+	structType := t
+	for structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() == reflect.Struct {
+		for i := 0; i < structType.NumField(); i++ {
+			field := structType.Field(i)
+			if field.Tag.Get("swig") != "-" {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" {
+				name = field.Name
+			}
+			names = append(names, name)
+		}
+	}
+	excludedFields.Store(t, names)
+	return names
+}
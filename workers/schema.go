@@ -0,0 +1,129 @@
+package workers
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ArgsSchemer can be implemented by workers to describe their arguments with an explicit
+// JSON Schema, instead of the one ArgsSchema generates from the worker's fields
+type ArgsSchemer interface {
+	ArgsSchema() map[string]interface{}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// ArgsSchema returns a JSON Schema for a worker's payload, so tools and producers in other
+// languages can build valid jobs. Workers implementing ArgsSchemer describe themselves;
+// for the rest the schema is generated from the fields that are serialized, following
+// their json tags. Fields without omitempty are required.
+func ArgsSchema(worker interface{}) map[string]interface{} {
+	if schemer, ok := worker.(ArgsSchemer); ok {
+		return schemer.ArgsSchema()
+	}
+	if provider, ok := worker.(ArgsProvider); ok {
+		worker = provider.Args()
+	}
+	return typeSchema(reflect.TypeOf(worker), make(map[reflect.Type]bool))
+}
+
+// ArgsSchema returns the payload schema of the worker registered for jobName or an alias of it
+func (wr *WorkerRegistry) ArgsSchema(jobName string) (map[string]interface{}, bool) {
+	worker, ok := wr.GetWorker(jobName)
+	if !ok {
+		return nil, false
+	}
+	return ArgsSchema(worker), true
+}
+
+// typeSchema describes t as JSON Schema. seen guards against recursive types, which are
+// left open.
+func typeSchema(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json writes byte slices as base64 strings
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]interface{}{"type": "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		properties := make(map[string]interface{})
+		var required []string
+		addStructFields(t, properties, &required, seen)
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// addStructFields adds the serialized fields of struct t to properties, flattening
+// embedded structs the way encoding/json does
+func addStructFields(t reflect.Type, properties map[string]interface{}, required *[]string, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || field.Tag.Get("swig") == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			addStructFields(fieldType, properties, required, seen)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type, seen)
+		if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+}
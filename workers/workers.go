@@ -2,10 +2,17 @@ package workers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"sort"
+	"sync"
 	"time"
 )
 
+// ErrDuplicateWorker is returned when registering a worker whose JobName is already taken
+var ErrDuplicateWorker = errors.New("worker already registered for job name")
+
 type Job[T any] struct {
 	ID        string
 	Kind      string
@@ -24,8 +31,15 @@ type Job[T any] struct {
 //
 // TODO: Implement polling fallback for environments where LISTEN/NOTIFY
 // is not available or configured.
+//
+// A registry is safe for concurrent use. Swig holds it by value, so the lock is
+// shared through a pointer and copies of a registry see the same workers.
 type WorkerRegistry struct {
-	workers map[string]interface{} // stores Worker[T] instances
+	mu           *sync.RWMutex
+	workers      map[string]interface{}              // stores Worker[T] instances
+	constructors map[string]func() interface{}       // job name -> constructor, for workers registered with RegisterWorkerFunc
+	aliases      map[string]string                   // alias -> registered job name
+	migrations   map[string]map[int]PayloadMigration // job name -> from version -> migration
 }
 
 type Worker[T any] interface {
@@ -33,27 +47,279 @@ type Worker[T any] interface {
 	Process(ctx context.Context, job Job[T]) error
 }
 
+// JobInfo describes the job a worker is executing, without its arguments
+// (those live on the worker itself)
+type JobInfo struct {
+	ID          string
+	Kind        string
+	Queue       string
+	Attempts    int // Attempts so far, including the current one
+	MaxAttempts int
+}
+
+// ErrorHandler can be implemented by workers that want to capture their own failures,
+// for example to emit domain-specific telemetry or run compensating actions.
+// OnError is called after each failed attempt, before the retry is scheduled.
+type ErrorHandler interface {
+	OnError(ctx context.Context, job JobInfo, err error)
+}
+
+// Setuper can be implemented by workers that need something prepared before they run
+// jobs, like a gRPC connection or a loaded model. Setup is called once per instance, on
+// the registered worker, when Swig starts. Workers registered without a constructor are
+// copied for each job, so fields Setup sets are seen by every job; with RegisterWorkerFunc,
+// share what Setup prepares through the constructor instead.
+type Setuper interface {
+	Setup(ctx context.Context) error
+}
+
+// Teardowner can be implemented by workers to release what Setup prepared. Teardown is
+// called once when Swig stops, on workers whose Setup succeeded or that have none.
+type Teardowner interface {
+	Teardown(ctx context.Context)
+}
+
+// ResultProvider can be implemented by workers that produce output. Result is called after
+// Process succeeds, and what it returns is stored with the job as JSON, where GetJob and
+// shadow comparisons can read it.
+type ResultProvider interface {
+	Result() interface{}
+}
+
+// PayloadVersioner can be implemented by workers whose arguments change shape over time.
+// PayloadVersion is stored with every enqueued job, and jobs enqueued with an older version
+// are brought up to date with the migrations registered through RegisterPayloadMigration
+// before they're decoded. Workers that don't implement it are at version 1.
+type PayloadVersioner interface {
+	PayloadVersion() int
+}
+
+// PayloadMigration rewrites a payload from one version to the next
+type PayloadMigration func(payload []byte) ([]byte, error)
+
 func NewWorkerRegistry() *WorkerRegistry {
 	return &WorkerRegistry{
-		workers: make(map[string]interface{}),
+		mu:           &sync.RWMutex{},
+		workers:      make(map[string]interface{}),
+		constructors: make(map[string]func() interface{}),
+		aliases:      make(map[string]string),
+		migrations:   make(map[string]map[int]PayloadMigration),
 	}
 }
 
 // RegisterWorker adds a worker implementation to the registry.
 // It accepts any type that implements the Worker interface and performs
 // runtime type checking to ensure the worker is properly implemented.
+// Registering two workers with the same JobName returns ErrDuplicateWorker,
+// since it almost always means a copy-pasted JobName.
+//
+// Each job runs on its own copy of worker, with the job's payload decoded into it, so
+// concurrent jobs never share arguments. Fields set on worker before registering it are
+// copied into every job; to build dependencies per job, use RegisterWorkerFunc.
 func (wr *WorkerRegistry) RegisterWorker(worker interface{}) error {
+	return wr.register(worker, nil)
+}
+
+// RegisterWorkerFunc registers a worker through a constructor, a function taking no
+// arguments that returns a new worker. The constructor is called for every job before its
+// payload is decoded, which makes it the place to inject dependencies like database handles
+// and API clients. Tag dependency fields with `swig:"-"`, or implement ArgsProvider, so
+// they're never serialized into the payload when the job is enqueued.
+//
+//	registry.RegisterWorkerFunc(func() *EmailWorker {
+//	    return &EmailWorker{SMTP: smtpClient}
+//	})
+func (wr *WorkerRegistry) RegisterWorkerFunc(constructor interface{}) error {
+	fn := reflect.ValueOf(constructor)
+	if fn.Kind() != reflect.Func || fn.IsNil() || fn.Type().NumIn() != 0 || fn.Type().NumOut() != 1 {
+		return fmt.Errorf("constructor must be a function taking no arguments and returning a worker, got %T", constructor)
+	}
+	newWorker := func() interface{} {
+		return fn.Call(nil)[0].Interface()
+	}
+	return wr.register(newWorker(), newWorker)
+}
+
+// register adds worker under its JobName, along with its constructor if it has one
+func (wr *WorkerRegistry) register(worker interface{}, newWorker func() interface{}) error {
 	// Type assert to check if it implements required methods
-	if w, ok := worker.(interface{ JobName() string }); !ok {
+	w, ok := worker.(interface{ JobName() string })
+	if !ok {
 		return fmt.Errorf("worker must implement JobName() string")
-	} else {
-		wr.workers[w.JobName()] = worker
-		return nil
 	}
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	if existing, exists := wr.workers[w.JobName()]; exists {
+		return fmt.Errorf("%w: %q is already handled by %T", ErrDuplicateWorker, w.JobName(), existing)
+	}
+	if target, exists := wr.aliases[w.JobName()]; exists {
+		return fmt.Errorf("%w: %q is already an alias for %q", ErrDuplicateWorker, w.JobName(), target)
+	}
+	wr.workers[w.JobName()] = worker
+	if newWorker != nil {
+		wr.constructors[w.JobName()] = newWorker
+	}
+	return nil
+}
+
+// MustRegister is like RegisterWorker but panics if the worker can't be registered,
+// including when its JobName is already taken. It's intended for registering workers
+// during program initialisation.
+func (wr *WorkerRegistry) MustRegister(worker interface{}) {
+	if err := wr.RegisterWorker(worker); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterAlias makes jobs of kind alias run on the worker registered for jobName.
+// Use it when renaming a job or replacing a worker, so jobs enqueued under the old name
+// before a deploy are still processed:
+//
+//	registry.MustRegister(&EmailWorkerV2{}) // JobName() == "send_email"
+//	registry.RegisterAlias("send_email_v1", "send_email")
+func (wr *WorkerRegistry) RegisterAlias(alias, jobName string) error {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	if _, exists := wr.workers[jobName]; !exists {
+		return fmt.Errorf("cannot alias %q: no worker registered for %q", alias, jobName)
+	}
+	if existing, exists := wr.workers[alias]; exists {
+		return fmt.Errorf("%w: %q is already handled by %T", ErrDuplicateWorker, alias, existing)
+	}
+	if target, exists := wr.aliases[alias]; exists {
+		return fmt.Errorf("%w: %q is already an alias for %q", ErrDuplicateWorker, alias, target)
+	}
+	wr.aliases[alias] = jobName
+	return nil
 }
 
-// GetWorker retrieves a worker implementation by its job name
+// RegisterPayloadMigration registers how to upgrade jobName payloads from fromVersion to
+// fromVersion+1. Jobs enqueued at older versions run through each migration in turn until
+// they reach the worker's PayloadVersion.
+//
+//	registry.RegisterPayloadMigration("send_email", 1, func(p []byte) ([]byte, error) {
+//	    // v1 had a single "to" address, v2 has a list of recipients
+//	    ...
+//	})
+func (wr *WorkerRegistry) RegisterPayloadMigration(jobName string, fromVersion int, migrate PayloadMigration) error {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	if wr.migrations[jobName] == nil {
+		wr.migrations[jobName] = make(map[int]PayloadMigration)
+	}
+	if _, exists := wr.migrations[jobName][fromVersion]; exists {
+		return fmt.Errorf("payload migration for %q from version %d is already registered", jobName, fromVersion)
+	}
+	wr.migrations[jobName][fromVersion] = migrate
+	return nil
+}
+
+// UpgradePayload runs the migrations registered for jobName to bring a payload at
+// version up to toVersion
+func (wr *WorkerRegistry) UpgradePayload(jobName string, version, toVersion int, payload []byte) ([]byte, error) {
+	for ; version < toVersion; version++ {
+		wr.mu.RLock()
+		migrate, exists := wr.migrations[jobName][version]
+		wr.mu.RUnlock()
+		if !exists {
+			return nil, fmt.Errorf("no payload migration registered for %q from version %d", jobName, version)
+		}
+
+		var err error
+		payload, err = migrate(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate %q payload from version %d: %w", jobName, version, err)
+		}
+	}
+	return payload, nil
+}
+
+// PayloadVersion returns the payload version a worker expects
+func PayloadVersion(worker interface{}) int {
+	if v, ok := worker.(PayloadVersioner); ok {
+		return v.PayloadVersion()
+	}
+	return 1
+}
+
+// GetWorker retrieves a worker implementation by its job name or an alias of it
 func (wr *WorkerRegistry) GetWorker(jobName string) (interface{}, bool) {
+	wr.mu.RLock()
+	defer wr.mu.RUnlock()
+	if target, isAlias := wr.aliases[jobName]; isAlias {
+		jobName = target
+	}
 	worker, exists := wr.workers[jobName]
 	return worker, exists
 }
+
+// NewWorker returns a fresh worker for a job of jobName or an alias of it, ready for the
+// job's payload to be decoded into. It's built by the worker's constructor, or copied from
+// the registered worker when it was registered without one.
+func (wr *WorkerRegistry) NewWorker(jobName string) (interface{}, bool) {
+	wr.mu.RLock()
+	if target, isAlias := wr.aliases[jobName]; isAlias {
+		jobName = target
+	}
+	worker, exists := wr.workers[jobName]
+	newWorker := wr.constructors[jobName]
+	wr.mu.RUnlock()
+
+	if !exists {
+		return nil, false
+	}
+	if newWorker != nil {
+		return newWorker(), true
+	}
+	return copyWorker(worker), true
+}
+
+// copyWorker returns a shallow copy of a worker held by pointer. Other workers can't have
+// a payload decoded into them, so they're returned as they are. The copy shares maps,
+// slices and pointers with worker until UnmarshalArgs replaces the ones the payload sets;
+// the rest, such as dependencies, stay shared.
+func copyWorker(worker interface{}) interface{} {
+	v := reflect.ValueOf(worker)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return worker
+	}
+	copied := reflect.New(v.Elem().Type())
+	copied.Elem().Set(v.Elem())
+	return copied.Interface()
+}
+
+// Resolve returns the job name alias was registered for, or alias itself when it isn't one
+func (wr *WorkerRegistry) Resolve(alias string) string {
+	wr.mu.RLock()
+	defer wr.mu.RUnlock()
+	if target, isAlias := wr.aliases[alias]; isAlias {
+		return target
+	}
+	return alias
+}
+
+// Registered returns the registered workers keyed by job name, without aliases
+func (wr *WorkerRegistry) Registered() map[string]interface{} {
+	wr.mu.RLock()
+	defer wr.mu.RUnlock()
+	registered := make(map[string]interface{}, len(wr.workers))
+	for jobName, worker := range wr.workers {
+		registered[jobName] = worker
+	}
+	return registered
+}
+
+// Kinds returns the job names of all registered workers and their aliases in sorted order
+func (wr *WorkerRegistry) Kinds() []string {
+	wr.mu.RLock()
+	defer wr.mu.RUnlock()
+	kinds := make([]string, 0, len(wr.workers)+len(wr.aliases))
+	for kind := range wr.workers {
+		kinds = append(kinds, kind)
+	}
+	for alias := range wr.aliases {
+		kinds = append(kinds, alias)
+	}
+	sort.Strings(kinds)
+	return kinds
+}